@@ -0,0 +1,1206 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.33.0
+// 	protoc        (unknown)
+// source: api/stayrtr.proto
+
+package api
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type ValidateResponse_State int32
+
+const (
+	ValidateResponse_NOT_FOUND ValidateResponse_State = 0
+	ValidateResponse_VALID     ValidateResponse_State = 1
+	ValidateResponse_INVALID   ValidateResponse_State = 2
+)
+
+// Enum value maps for ValidateResponse_State.
+var (
+	ValidateResponse_State_name = map[int32]string{
+		0: "NOT_FOUND",
+		1: "VALID",
+		2: "INVALID",
+	}
+	ValidateResponse_State_value = map[string]int32{
+		"NOT_FOUND": 0,
+		"VALID":     1,
+		"INVALID":   2,
+	}
+)
+
+func (x ValidateResponse_State) Enum() *ValidateResponse_State {
+	p := new(ValidateResponse_State)
+	*p = x
+	return p
+}
+
+func (x ValidateResponse_State) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ValidateResponse_State) Descriptor() protoreflect.EnumDescriptor {
+	return file_api_stayrtr_proto_enumTypes[0].Descriptor()
+}
+
+func (ValidateResponse_State) Type() protoreflect.EnumType {
+	return &file_api_stayrtr_proto_enumTypes[0]
+}
+
+func (x ValidateResponse_State) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ValidateResponse_State.Descriptor instead.
+func (ValidateResponse_State) EnumDescriptor() ([]byte, []int) {
+	return file_api_stayrtr_proto_rawDescGZIP(), []int{6, 0}
+}
+
+type VRP struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Prefix    string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	MaxLength uint32 `protobuf:"varint,2,opt,name=max_length,json=maxLength,proto3" json:"max_length,omitempty"`
+	Asn       uint32 `protobuf:"varint,3,opt,name=asn,proto3" json:"asn,omitempty"`
+}
+
+func (x *VRP) Reset() {
+	*x = VRP{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_stayrtr_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *VRP) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VRP) ProtoMessage() {}
+
+func (x *VRP) ProtoReflect() protoreflect.Message {
+	mi := &file_api_stayrtr_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VRP.ProtoReflect.Descriptor instead.
+func (*VRP) Descriptor() ([]byte, []int) {
+	return file_api_stayrtr_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *VRP) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *VRP) GetMaxLength() uint32 {
+	if x != nil {
+		return x.MaxLength
+	}
+	return 0
+}
+
+func (x *VRP) GetAsn() uint32 {
+	if x != nil {
+		return x.Asn
+	}
+	return 0
+}
+
+type GetVRPsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *GetVRPsRequest) Reset() {
+	*x = GetVRPsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_stayrtr_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetVRPsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetVRPsRequest) ProtoMessage() {}
+
+func (x *GetVRPsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_stayrtr_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetVRPsRequest.ProtoReflect.Descriptor instead.
+func (*GetVRPsRequest) Descriptor() ([]byte, []int) {
+	return file_api_stayrtr_proto_rawDescGZIP(), []int{1}
+}
+
+type VRPSet struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	SessionId  uint32       `protobuf:"varint,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	Serial     uint32       `protobuf:"varint,2,opt,name=serial,proto3" json:"serial,omitempty"`
+	Vrps       []*VRP       `protobuf:"bytes,3,rep,name=vrps,proto3" json:"vrps,omitempty"`
+	BgpsecKeys []*RouterKey `protobuf:"bytes,4,rep,name=bgpsec_keys,json=bgpsecKeys,proto3" json:"bgpsec_keys,omitempty"`
+	Aspas      []*ASPA      `protobuf:"bytes,5,rep,name=aspas,proto3" json:"aspas,omitempty"`
+}
+
+func (x *VRPSet) Reset() {
+	*x = VRPSet{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_stayrtr_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *VRPSet) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VRPSet) ProtoMessage() {}
+
+func (x *VRPSet) ProtoReflect() protoreflect.Message {
+	mi := &file_api_stayrtr_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VRPSet.ProtoReflect.Descriptor instead.
+func (*VRPSet) Descriptor() ([]byte, []int) {
+	return file_api_stayrtr_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *VRPSet) GetSessionId() uint32 {
+	if x != nil {
+		return x.SessionId
+	}
+	return 0
+}
+
+func (x *VRPSet) GetSerial() uint32 {
+	if x != nil {
+		return x.Serial
+	}
+	return 0
+}
+
+func (x *VRPSet) GetVrps() []*VRP {
+	if x != nil {
+		return x.Vrps
+	}
+	return nil
+}
+
+func (x *VRPSet) GetBgpsecKeys() []*RouterKey {
+	if x != nil {
+		return x.BgpsecKeys
+	}
+	return nil
+}
+
+func (x *VRPSet) GetAspas() []*ASPA {
+	if x != nil {
+		return x.Aspas
+	}
+	return nil
+}
+
+type WatchVRPsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Only receive the changes after this serial (of the same session).
+	Incremental bool   `protobuf:"varint,1,opt,name=incremental,proto3" json:"incremental,omitempty"`
+	SessionId   uint32 `protobuf:"varint,2,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	Serial      uint32 `protobuf:"varint,3,opt,name=serial,proto3" json:"serial,omitempty"`
+}
+
+func (x *WatchVRPsRequest) Reset() {
+	*x = WatchVRPsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_stayrtr_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchVRPsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchVRPsRequest) ProtoMessage() {}
+
+func (x *WatchVRPsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_stayrtr_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchVRPsRequest.ProtoReflect.Descriptor instead.
+func (*WatchVRPsRequest) Descriptor() ([]byte, []int) {
+	return file_api_stayrtr_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *WatchVRPsRequest) GetIncremental() bool {
+	if x != nil {
+		return x.Incremental
+	}
+	return false
+}
+
+func (x *WatchVRPsRequest) GetSessionId() uint32 {
+	if x != nil {
+		return x.SessionId
+	}
+	return 0
+}
+
+func (x *WatchVRPsRequest) GetSerial() uint32 {
+	if x != nil {
+		return x.Serial
+	}
+	return 0
+}
+
+type VRPUpdate struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	SessionId uint32 `protobuf:"varint,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	Serial    uint32 `protobuf:"varint,2,opt,name=serial,proto3" json:"serial,omitempty"`
+	// The announced VRPs are the complete set: any previous one is withdrawn.
+	Snapshot            bool         `protobuf:"varint,3,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	Announced           []*VRP       `protobuf:"bytes,4,rep,name=announced,proto3" json:"announced,omitempty"`
+	Withdrawn           []*VRP       `protobuf:"bytes,5,rep,name=withdrawn,proto3" json:"withdrawn,omitempty"`
+	AnnouncedBgpsecKeys []*RouterKey `protobuf:"bytes,6,rep,name=announced_bgpsec_keys,json=announcedBgpsecKeys,proto3" json:"announced_bgpsec_keys,omitempty"`
+	WithdrawnBgpsecKeys []*RouterKey `protobuf:"bytes,7,rep,name=withdrawn_bgpsec_keys,json=withdrawnBgpsecKeys,proto3" json:"withdrawn_bgpsec_keys,omitempty"`
+	// An announced ASPA replaces the previous one of its customer. The withdrawn ASPAs
+	// only have their customer.
+	AnnouncedAspas []*ASPA `protobuf:"bytes,8,rep,name=announced_aspas,json=announcedAspas,proto3" json:"announced_aspas,omitempty"`
+	WithdrawnAspas []*ASPA `protobuf:"bytes,9,rep,name=withdrawn_aspas,json=withdrawnAspas,proto3" json:"withdrawn_aspas,omitempty"`
+}
+
+func (x *VRPUpdate) Reset() {
+	*x = VRPUpdate{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_stayrtr_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *VRPUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VRPUpdate) ProtoMessage() {}
+
+func (x *VRPUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_api_stayrtr_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VRPUpdate.ProtoReflect.Descriptor instead.
+func (*VRPUpdate) Descriptor() ([]byte, []int) {
+	return file_api_stayrtr_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *VRPUpdate) GetSessionId() uint32 {
+	if x != nil {
+		return x.SessionId
+	}
+	return 0
+}
+
+func (x *VRPUpdate) GetSerial() uint32 {
+	if x != nil {
+		return x.Serial
+	}
+	return 0
+}
+
+func (x *VRPUpdate) GetSnapshot() bool {
+	if x != nil {
+		return x.Snapshot
+	}
+	return false
+}
+
+func (x *VRPUpdate) GetAnnounced() []*VRP {
+	if x != nil {
+		return x.Announced
+	}
+	return nil
+}
+
+func (x *VRPUpdate) GetWithdrawn() []*VRP {
+	if x != nil {
+		return x.Withdrawn
+	}
+	return nil
+}
+
+func (x *VRPUpdate) GetAnnouncedBgpsecKeys() []*RouterKey {
+	if x != nil {
+		return x.AnnouncedBgpsecKeys
+	}
+	return nil
+}
+
+func (x *VRPUpdate) GetWithdrawnBgpsecKeys() []*RouterKey {
+	if x != nil {
+		return x.WithdrawnBgpsecKeys
+	}
+	return nil
+}
+
+func (x *VRPUpdate) GetAnnouncedAspas() []*ASPA {
+	if x != nil {
+		return x.AnnouncedAspas
+	}
+	return nil
+}
+
+func (x *VRPUpdate) GetWithdrawnAspas() []*ASPA {
+	if x != nil {
+		return x.WithdrawnAspas
+	}
+	return nil
+}
+
+type ValidateRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Prefix string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	Asn    uint32 `protobuf:"varint,2,opt,name=asn,proto3" json:"asn,omitempty"`
+}
+
+func (x *ValidateRequest) Reset() {
+	*x = ValidateRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_stayrtr_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ValidateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ValidateRequest) ProtoMessage() {}
+
+func (x *ValidateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_stayrtr_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ValidateRequest.ProtoReflect.Descriptor instead.
+func (*ValidateRequest) Descriptor() ([]byte, []int) {
+	return file_api_stayrtr_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ValidateRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *ValidateRequest) GetAsn() uint32 {
+	if x != nil {
+		return x.Asn
+	}
+	return 0
+}
+
+type ValidateResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	State ValidateResponse_State `protobuf:"varint,1,opt,name=state,proto3,enum=stayrtr.v1.ValidateResponse_State" json:"state,omitempty"`
+	// Covering VRPs matching the route
+	Matched []*VRP `protobuf:"bytes,2,rep,name=matched,proto3" json:"matched,omitempty"`
+	// Covering VRPs with another ASN
+	UnmatchedAs []*VRP `protobuf:"bytes,3,rep,name=unmatched_as,json=unmatchedAs,proto3" json:"unmatched_as,omitempty"`
+	// Covering VRPs with the same ASN and a shorter maxLength
+	UnmatchedLength []*VRP `protobuf:"bytes,4,rep,name=unmatched_length,json=unmatchedLength,proto3" json:"unmatched_length,omitempty"`
+}
+
+func (x *ValidateResponse) Reset() {
+	*x = ValidateResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_stayrtr_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ValidateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ValidateResponse) ProtoMessage() {}
+
+func (x *ValidateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_stayrtr_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ValidateResponse.ProtoReflect.Descriptor instead.
+func (*ValidateResponse) Descriptor() ([]byte, []int) {
+	return file_api_stayrtr_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ValidateResponse) GetState() ValidateResponse_State {
+	if x != nil {
+		return x.State
+	}
+	return ValidateResponse_NOT_FOUND
+}
+
+func (x *ValidateResponse) GetMatched() []*VRP {
+	if x != nil {
+		return x.Matched
+	}
+	return nil
+}
+
+func (x *ValidateResponse) GetUnmatchedAs() []*VRP {
+	if x != nil {
+		return x.UnmatchedAs
+	}
+	return nil
+}
+
+func (x *ValidateResponse) GetUnmatchedLength() []*VRP {
+	if x != nil {
+		return x.UnmatchedLength
+	}
+	return nil
+}
+
+// Export is the content of the export path in the application/x-protobuf format,
+// the same as the JSON export.
+type Export struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// RFC 3339 time the data was built by the validator
+	Buildtime  string         `protobuf:"bytes,1,opt,name=buildtime,proto3" json:"buildtime,omitempty"`
+	Vrps       []*ExportedVRP `protobuf:"bytes,2,rep,name=vrps,proto3" json:"vrps,omitempty"`
+	BgpsecKeys []*RouterKey   `protobuf:"bytes,3,rep,name=bgpsec_keys,json=bgpsecKeys,proto3" json:"bgpsec_keys,omitempty"`
+	Aspas      []*ASPA        `protobuf:"bytes,4,rep,name=aspas,proto3" json:"aspas,omitempty"`
+}
+
+func (x *Export) Reset() {
+	*x = Export{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_stayrtr_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Export) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Export) ProtoMessage() {}
+
+func (x *Export) ProtoReflect() protoreflect.Message {
+	mi := &file_api_stayrtr_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Export.ProtoReflect.Descriptor instead.
+func (*Export) Descriptor() ([]byte, []int) {
+	return file_api_stayrtr_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Export) GetBuildtime() string {
+	if x != nil {
+		return x.Buildtime
+	}
+	return ""
+}
+
+func (x *Export) GetVrps() []*ExportedVRP {
+	if x != nil {
+		return x.Vrps
+	}
+	return nil
+}
+
+func (x *Export) GetBgpsecKeys() []*RouterKey {
+	if x != nil {
+		return x.BgpsecKeys
+	}
+	return nil
+}
+
+func (x *Export) GetAspas() []*ASPA {
+	if x != nil {
+		return x.Aspas
+	}
+	return nil
+}
+
+type ExportedVRP struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Prefix    string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	MaxLength uint32 `protobuf:"varint,2,opt,name=max_length,json=maxLength,proto3" json:"max_length,omitempty"`
+	Asn       uint32 `protobuf:"varint,3,opt,name=asn,proto3" json:"asn,omitempty"`
+	// Trust anchor
+	Ta string `protobuf:"bytes,4,opt,name=ta,proto3" json:"ta,omitempty"`
+	// Unix time the VRP expires (0 if unknown)
+	Expires int64 `protobuf:"varint,5,opt,name=expires,proto3" json:"expires,omitempty"`
+}
+
+func (x *ExportedVRP) Reset() {
+	*x = ExportedVRP{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_stayrtr_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ExportedVRP) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportedVRP) ProtoMessage() {}
+
+func (x *ExportedVRP) ProtoReflect() protoreflect.Message {
+	mi := &file_api_stayrtr_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportedVRP.ProtoReflect.Descriptor instead.
+func (*ExportedVRP) Descriptor() ([]byte, []int) {
+	return file_api_stayrtr_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ExportedVRP) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *ExportedVRP) GetMaxLength() uint32 {
+	if x != nil {
+		return x.MaxLength
+	}
+	return 0
+}
+
+func (x *ExportedVRP) GetAsn() uint32 {
+	if x != nil {
+		return x.Asn
+	}
+	return 0
+}
+
+func (x *ExportedVRP) GetTa() string {
+	if x != nil {
+		return x.Ta
+	}
+	return ""
+}
+
+func (x *ExportedVRP) GetExpires() int64 {
+	if x != nil {
+		return x.Expires
+	}
+	return 0
+}
+
+type RouterKey struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Asn uint32 `protobuf:"varint,1,opt,name=asn,proto3" json:"asn,omitempty"`
+	// Subject Key Identifier
+	Ski []byte `protobuf:"bytes,2,opt,name=ski,proto3" json:"ski,omitempty"`
+	// DER encoded SubjectPublicKeyInfo
+	Pubkey  []byte `protobuf:"bytes,3,opt,name=pubkey,proto3" json:"pubkey,omitempty"`
+	Ta      string `protobuf:"bytes,4,opt,name=ta,proto3" json:"ta,omitempty"`
+	Expires int64  `protobuf:"varint,5,opt,name=expires,proto3" json:"expires,omitempty"`
+}
+
+func (x *RouterKey) Reset() {
+	*x = RouterKey{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_stayrtr_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RouterKey) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RouterKey) ProtoMessage() {}
+
+func (x *RouterKey) ProtoReflect() protoreflect.Message {
+	mi := &file_api_stayrtr_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RouterKey.ProtoReflect.Descriptor instead.
+func (*RouterKey) Descriptor() ([]byte, []int) {
+	return file_api_stayrtr_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *RouterKey) GetAsn() uint32 {
+	if x != nil {
+		return x.Asn
+	}
+	return 0
+}
+
+func (x *RouterKey) GetSki() []byte {
+	if x != nil {
+		return x.Ski
+	}
+	return nil
+}
+
+func (x *RouterKey) GetPubkey() []byte {
+	if x != nil {
+		return x.Pubkey
+	}
+	return nil
+}
+
+func (x *RouterKey) GetTa() string {
+	if x != nil {
+		return x.Ta
+	}
+	return ""
+}
+
+func (x *RouterKey) GetExpires() int64 {
+	if x != nil {
+		return x.Expires
+	}
+	return 0
+}
+
+type ASPA struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	CustomerAsid uint32   `protobuf:"varint,1,opt,name=customer_asid,json=customerAsid,proto3" json:"customer_asid,omitempty"`
+	Providers    []uint32 `protobuf:"varint,2,rep,packed,name=providers,proto3" json:"providers,omitempty"`
+	Expires      int64    `protobuf:"varint,3,opt,name=expires,proto3" json:"expires,omitempty"`
+}
+
+func (x *ASPA) Reset() {
+	*x = ASPA{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_stayrtr_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ASPA) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ASPA) ProtoMessage() {}
+
+func (x *ASPA) ProtoReflect() protoreflect.Message {
+	mi := &file_api_stayrtr_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ASPA.ProtoReflect.Descriptor instead.
+func (*ASPA) Descriptor() ([]byte, []int) {
+	return file_api_stayrtr_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ASPA) GetCustomerAsid() uint32 {
+	if x != nil {
+		return x.CustomerAsid
+	}
+	return 0
+}
+
+func (x *ASPA) GetProviders() []uint32 {
+	if x != nil {
+		return x.Providers
+	}
+	return nil
+}
+
+func (x *ASPA) GetExpires() int64 {
+	if x != nil {
+		return x.Expires
+	}
+	return 0
+}
+
+var File_api_stayrtr_proto protoreflect.FileDescriptor
+
+var file_api_stayrtr_proto_rawDesc = []byte{
+	0x0a, 0x11, 0x61, 0x70, 0x69, 0x2f, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x12, 0x0a, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x22,
+	0x4e, 0x0a, 0x03, 0x56, 0x52, 0x50, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x72, 0x65, 0x66, 0x69, 0x78,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x70, 0x72, 0x65, 0x66, 0x69, 0x78, 0x12, 0x1d,
+	0x0a, 0x0a, 0x6d, 0x61, 0x78, 0x5f, 0x6c, 0x65, 0x6e, 0x67, 0x74, 0x68, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x09, 0x6d, 0x61, 0x78, 0x4c, 0x65, 0x6e, 0x67, 0x74, 0x68, 0x12, 0x10, 0x0a,
+	0x03, 0x61, 0x73, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x61, 0x73, 0x6e, 0x22,
+	0x10, 0x0a, 0x0e, 0x47, 0x65, 0x74, 0x56, 0x52, 0x50, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x22, 0xc4, 0x01, 0x0a, 0x06, 0x56, 0x52, 0x50, 0x53, 0x65, 0x74, 0x12, 0x1d, 0x0a, 0x0a,
+	0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x73,
+	0x65, 0x72, 0x69, 0x61, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x73, 0x65, 0x72,
+	0x69, 0x61, 0x6c, 0x12, 0x23, 0x0a, 0x04, 0x76, 0x72, 0x70, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x0f, 0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x56,
+	0x52, 0x50, 0x52, 0x04, 0x76, 0x72, 0x70, 0x73, 0x12, 0x36, 0x0a, 0x0b, 0x62, 0x67, 0x70, 0x73,
+	0x65, 0x63, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x15, 0x2e,
+	0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x6f, 0x75, 0x74, 0x65,
+	0x72, 0x4b, 0x65, 0x79, 0x52, 0x0a, 0x62, 0x67, 0x70, 0x73, 0x65, 0x63, 0x4b, 0x65, 0x79, 0x73,
+	0x12, 0x26, 0x0a, 0x05, 0x61, 0x73, 0x70, 0x61, 0x73, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x10, 0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x53, 0x50,
+	0x41, 0x52, 0x05, 0x61, 0x73, 0x70, 0x61, 0x73, 0x22, 0x6b, 0x0a, 0x10, 0x57, 0x61, 0x74, 0x63,
+	0x68, 0x56, 0x52, 0x50, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x20, 0x0a, 0x0b,
+	0x69, 0x6e, 0x63, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x61, 0x6c, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x0b, 0x69, 0x6e, 0x63, 0x72, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x61, 0x6c, 0x12, 0x1d,
+	0x0a, 0x0a, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x16, 0x0a,
+	0x06, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x73,
+	0x65, 0x72, 0x69, 0x61, 0x6c, 0x22, 0xc8, 0x03, 0x0a, 0x09, 0x56, 0x52, 0x50, 0x55, 0x70, 0x64,
+	0x61, 0x74, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x69,
+	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
+	0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x06, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x6e,
+	0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x73, 0x6e,
+	0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x12, 0x2d, 0x0a, 0x09, 0x61, 0x6e, 0x6e, 0x6f, 0x75, 0x6e,
+	0x63, 0x65, 0x64, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x73, 0x74, 0x61, 0x79,
+	0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x56, 0x52, 0x50, 0x52, 0x09, 0x61, 0x6e, 0x6e, 0x6f,
+	0x75, 0x6e, 0x63, 0x65, 0x64, 0x12, 0x2d, 0x0a, 0x09, 0x77, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61,
+	0x77, 0x6e, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x73, 0x74, 0x61, 0x79, 0x72,
+	0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x56, 0x52, 0x50, 0x52, 0x09, 0x77, 0x69, 0x74, 0x68, 0x64,
+	0x72, 0x61, 0x77, 0x6e, 0x12, 0x49, 0x0a, 0x15, 0x61, 0x6e, 0x6e, 0x6f, 0x75, 0x6e, 0x63, 0x65,
+	0x64, 0x5f, 0x62, 0x67, 0x70, 0x73, 0x65, 0x63, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x06, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x15, 0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31,
+	0x2e, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x4b, 0x65, 0x79, 0x52, 0x13, 0x61, 0x6e, 0x6e, 0x6f,
+	0x75, 0x6e, 0x63, 0x65, 0x64, 0x42, 0x67, 0x70, 0x73, 0x65, 0x63, 0x4b, 0x65, 0x79, 0x73, 0x12,
+	0x49, 0x0a, 0x15, 0x77, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x6e, 0x5f, 0x62, 0x67, 0x70,
+	0x73, 0x65, 0x63, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x07, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x15,
+	0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x6f, 0x75, 0x74,
+	0x65, 0x72, 0x4b, 0x65, 0x79, 0x52, 0x13, 0x77, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x6e,
+	0x42, 0x67, 0x70, 0x73, 0x65, 0x63, 0x4b, 0x65, 0x79, 0x73, 0x12, 0x39, 0x0a, 0x0f, 0x61, 0x6e,
+	0x6e, 0x6f, 0x75, 0x6e, 0x63, 0x65, 0x64, 0x5f, 0x61, 0x73, 0x70, 0x61, 0x73, 0x18, 0x08, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31,
+	0x2e, 0x41, 0x53, 0x50, 0x41, 0x52, 0x0e, 0x61, 0x6e, 0x6e, 0x6f, 0x75, 0x6e, 0x63, 0x65, 0x64,
+	0x41, 0x73, 0x70, 0x61, 0x73, 0x12, 0x39, 0x0a, 0x0f, 0x77, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61,
+	0x77, 0x6e, 0x5f, 0x61, 0x73, 0x70, 0x61, 0x73, 0x18, 0x09, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x10,
+	0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x41, 0x53, 0x50, 0x41,
+	0x52, 0x0e, 0x77, 0x69, 0x74, 0x68, 0x64, 0x72, 0x61, 0x77, 0x6e, 0x41, 0x73, 0x70, 0x61, 0x73,
+	0x22, 0x3b, 0x0a, 0x0f, 0x56, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x72, 0x65, 0x66, 0x69, 0x78, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x06, 0x70, 0x72, 0x65, 0x66, 0x69, 0x78, 0x12, 0x10, 0x0a, 0x03, 0x61,
+	0x73, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x61, 0x73, 0x6e, 0x22, 0x97, 0x02,
+	0x0a, 0x10, 0x56, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x12, 0x38, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0e, 0x32, 0x22, 0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x56,
+	0x61, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x2e,
+	0x53, 0x74, 0x61, 0x74, 0x65, 0x52, 0x05, 0x73, 0x74, 0x61, 0x74, 0x65, 0x12, 0x29, 0x0a, 0x07,
+	0x6d, 0x61, 0x74, 0x63, 0x68, 0x65, 0x64, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e,
+	0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x56, 0x52, 0x50, 0x52, 0x07,
+	0x6d, 0x61, 0x74, 0x63, 0x68, 0x65, 0x64, 0x12, 0x32, 0x0a, 0x0c, 0x75, 0x6e, 0x6d, 0x61, 0x74,
+	0x63, 0x68, 0x65, 0x64, 0x5f, 0x61, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e,
+	0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x56, 0x52, 0x50, 0x52, 0x0b,
+	0x75, 0x6e, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x65, 0x64, 0x41, 0x73, 0x12, 0x3a, 0x0a, 0x10, 0x75,
+	0x6e, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x65, 0x64, 0x5f, 0x6c, 0x65, 0x6e, 0x67, 0x74, 0x68, 0x18,
+	0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e,
+	0x76, 0x31, 0x2e, 0x56, 0x52, 0x50, 0x52, 0x0f, 0x75, 0x6e, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x65,
+	0x64, 0x4c, 0x65, 0x6e, 0x67, 0x74, 0x68, 0x22, 0x2e, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x74, 0x65,
+	0x12, 0x0d, 0x0a, 0x09, 0x4e, 0x4f, 0x54, 0x5f, 0x46, 0x4f, 0x55, 0x4e, 0x44, 0x10, 0x00, 0x12,
+	0x09, 0x0a, 0x05, 0x56, 0x41, 0x4c, 0x49, 0x44, 0x10, 0x01, 0x12, 0x0b, 0x0a, 0x07, 0x49, 0x4e,
+	0x56, 0x41, 0x4c, 0x49, 0x44, 0x10, 0x02, 0x22, 0xb3, 0x01, 0x0a, 0x06, 0x45, 0x78, 0x70, 0x6f,
+	0x72, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x74, 0x69, 0x6d, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x74, 0x69, 0x6d, 0x65,
+	0x12, 0x2b, 0x0a, 0x04, 0x76, 0x72, 0x70, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x17,
+	0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x45, 0x78, 0x70, 0x6f,
+	0x72, 0x74, 0x65, 0x64, 0x56, 0x52, 0x50, 0x52, 0x04, 0x76, 0x72, 0x70, 0x73, 0x12, 0x36, 0x0a,
+	0x0b, 0x62, 0x67, 0x70, 0x73, 0x65, 0x63, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x03, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x15, 0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e,
+	0x52, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x4b, 0x65, 0x79, 0x52, 0x0a, 0x62, 0x67, 0x70, 0x73, 0x65,
+	0x63, 0x4b, 0x65, 0x79, 0x73, 0x12, 0x26, 0x0a, 0x05, 0x61, 0x73, 0x70, 0x61, 0x73, 0x18, 0x04,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76,
+	0x31, 0x2e, 0x41, 0x53, 0x50, 0x41, 0x52, 0x05, 0x61, 0x73, 0x70, 0x61, 0x73, 0x22, 0x80, 0x01,
+	0x0a, 0x0b, 0x45, 0x78, 0x70, 0x6f, 0x72, 0x74, 0x65, 0x64, 0x56, 0x52, 0x50, 0x12, 0x16, 0x0a,
+	0x06, 0x70, 0x72, 0x65, 0x66, 0x69, 0x78, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x70,
+	0x72, 0x65, 0x66, 0x69, 0x78, 0x12, 0x1d, 0x0a, 0x0a, 0x6d, 0x61, 0x78, 0x5f, 0x6c, 0x65, 0x6e,
+	0x67, 0x74, 0x68, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x6d, 0x61, 0x78, 0x4c, 0x65,
+	0x6e, 0x67, 0x74, 0x68, 0x12, 0x10, 0x0a, 0x03, 0x61, 0x73, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x03, 0x61, 0x73, 0x6e, 0x12, 0x0e, 0x0a, 0x02, 0x74, 0x61, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x02, 0x74, 0x61, 0x12, 0x18, 0x0a, 0x07, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65,
+	0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x73,
+	0x22, 0x71, 0x0a, 0x09, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x4b, 0x65, 0x79, 0x12, 0x10, 0x0a,
+	0x03, 0x61, 0x73, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x61, 0x73, 0x6e, 0x12,
+	0x10, 0x0a, 0x03, 0x73, 0x6b, 0x69, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x73, 0x6b,
+	0x69, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x75, 0x62, 0x6b, 0x65, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x06, 0x70, 0x75, 0x62, 0x6b, 0x65, 0x79, 0x12, 0x0e, 0x0a, 0x02, 0x74, 0x61, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x74, 0x61, 0x12, 0x18, 0x0a, 0x07, 0x65, 0x78, 0x70,
+	0x69, 0x72, 0x65, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x65, 0x78, 0x70, 0x69,
+	0x72, 0x65, 0x73, 0x22, 0x63, 0x0a, 0x04, 0x41, 0x53, 0x50, 0x41, 0x12, 0x23, 0x0a, 0x0d, 0x63,
+	0x75, 0x73, 0x74, 0x6f, 0x6d, 0x65, 0x72, 0x5f, 0x61, 0x73, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x0c, 0x63, 0x75, 0x73, 0x74, 0x6f, 0x6d, 0x65, 0x72, 0x41, 0x73, 0x69, 0x64,
+	0x12, 0x1c, 0x0a, 0x09, 0x70, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x73, 0x18, 0x02, 0x20,
+	0x03, 0x28, 0x0d, 0x52, 0x09, 0x70, 0x72, 0x6f, 0x76, 0x69, 0x64, 0x65, 0x72, 0x73, 0x12, 0x18,
+	0x0a, 0x07, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x07, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x73, 0x32, 0xd2, 0x01, 0x0a, 0x0a, 0x56, 0x52, 0x50,
+	0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x39, 0x0a, 0x07, 0x47, 0x65, 0x74, 0x56, 0x52,
+	0x50, 0x73, 0x12, 0x1a, 0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e,
+	0x47, 0x65, 0x74, 0x56, 0x52, 0x50, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x12,
+	0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x56, 0x52, 0x50, 0x53,
+	0x65, 0x74, 0x12, 0x42, 0x0a, 0x09, 0x57, 0x61, 0x74, 0x63, 0x68, 0x56, 0x52, 0x50, 0x73, 0x12,
+	0x1c, 0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x57, 0x61, 0x74,
+	0x63, 0x68, 0x56, 0x52, 0x50, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e,
+	0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x56, 0x52, 0x50, 0x55, 0x70,
+	0x64, 0x61, 0x74, 0x65, 0x30, 0x01, 0x12, 0x45, 0x0a, 0x08, 0x56, 0x61, 0x6c, 0x69, 0x64, 0x61,
+	0x74, 0x65, 0x12, 0x1b, 0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e,
+	0x56, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x1c, 0x2e, 0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2e, 0x76, 0x31, 0x2e, 0x56, 0x61, 0x6c,
+	0x69, 0x64, 0x61, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x1c, 0x5a,
+	0x1a, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x62, 0x67, 0x70, 0x2f,
+	0x73, 0x74, 0x61, 0x79, 0x72, 0x74, 0x72, 0x2f, 0x61, 0x70, 0x69, 0x62, 0x06, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x33,
+}
+
+var (
+	file_api_stayrtr_proto_rawDescOnce sync.Once
+	file_api_stayrtr_proto_rawDescData = file_api_stayrtr_proto_rawDesc
+)
+
+func file_api_stayrtr_proto_rawDescGZIP() []byte {
+	file_api_stayrtr_proto_rawDescOnce.Do(func() {
+		file_api_stayrtr_proto_rawDescData = protoimpl.X.CompressGZIP(file_api_stayrtr_proto_rawDescData)
+	})
+	return file_api_stayrtr_proto_rawDescData
+}
+
+var file_api_stayrtr_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_api_stayrtr_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_api_stayrtr_proto_goTypes = []interface{}{
+	(ValidateResponse_State)(0), // 0: stayrtr.v1.ValidateResponse.State
+	(*VRP)(nil),                 // 1: stayrtr.v1.VRP
+	(*GetVRPsRequest)(nil),      // 2: stayrtr.v1.GetVRPsRequest
+	(*VRPSet)(nil),              // 3: stayrtr.v1.VRPSet
+	(*WatchVRPsRequest)(nil),    // 4: stayrtr.v1.WatchVRPsRequest
+	(*VRPUpdate)(nil),           // 5: stayrtr.v1.VRPUpdate
+	(*ValidateRequest)(nil),     // 6: stayrtr.v1.ValidateRequest
+	(*ValidateResponse)(nil),    // 7: stayrtr.v1.ValidateResponse
+	(*Export)(nil),              // 8: stayrtr.v1.Export
+	(*ExportedVRP)(nil),         // 9: stayrtr.v1.ExportedVRP
+	(*RouterKey)(nil),           // 10: stayrtr.v1.RouterKey
+	(*ASPA)(nil),                // 11: stayrtr.v1.ASPA
+}
+var file_api_stayrtr_proto_depIdxs = []int32{
+	1,  // 0: stayrtr.v1.VRPSet.vrps:type_name -> stayrtr.v1.VRP
+	10, // 1: stayrtr.v1.VRPSet.bgpsec_keys:type_name -> stayrtr.v1.RouterKey
+	11, // 2: stayrtr.v1.VRPSet.aspas:type_name -> stayrtr.v1.ASPA
+	1,  // 3: stayrtr.v1.VRPUpdate.announced:type_name -> stayrtr.v1.VRP
+	1,  // 4: stayrtr.v1.VRPUpdate.withdrawn:type_name -> stayrtr.v1.VRP
+	10, // 5: stayrtr.v1.VRPUpdate.announced_bgpsec_keys:type_name -> stayrtr.v1.RouterKey
+	10, // 6: stayrtr.v1.VRPUpdate.withdrawn_bgpsec_keys:type_name -> stayrtr.v1.RouterKey
+	11, // 7: stayrtr.v1.VRPUpdate.announced_aspas:type_name -> stayrtr.v1.ASPA
+	11, // 8: stayrtr.v1.VRPUpdate.withdrawn_aspas:type_name -> stayrtr.v1.ASPA
+	0,  // 9: stayrtr.v1.ValidateResponse.state:type_name -> stayrtr.v1.ValidateResponse.State
+	1,  // 10: stayrtr.v1.ValidateResponse.matched:type_name -> stayrtr.v1.VRP
+	1,  // 11: stayrtr.v1.ValidateResponse.unmatched_as:type_name -> stayrtr.v1.VRP
+	1,  // 12: stayrtr.v1.ValidateResponse.unmatched_length:type_name -> stayrtr.v1.VRP
+	9,  // 13: stayrtr.v1.Export.vrps:type_name -> stayrtr.v1.ExportedVRP
+	10, // 14: stayrtr.v1.Export.bgpsec_keys:type_name -> stayrtr.v1.RouterKey
+	11, // 15: stayrtr.v1.Export.aspas:type_name -> stayrtr.v1.ASPA
+	2,  // 16: stayrtr.v1.VRPService.GetVRPs:input_type -> stayrtr.v1.GetVRPsRequest
+	4,  // 17: stayrtr.v1.VRPService.WatchVRPs:input_type -> stayrtr.v1.WatchVRPsRequest
+	6,  // 18: stayrtr.v1.VRPService.Validate:input_type -> stayrtr.v1.ValidateRequest
+	3,  // 19: stayrtr.v1.VRPService.GetVRPs:output_type -> stayrtr.v1.VRPSet
+	5,  // 20: stayrtr.v1.VRPService.WatchVRPs:output_type -> stayrtr.v1.VRPUpdate
+	7,  // 21: stayrtr.v1.VRPService.Validate:output_type -> stayrtr.v1.ValidateResponse
+	19, // [19:22] is the sub-list for method output_type
+	16, // [16:19] is the sub-list for method input_type
+	16, // [16:16] is the sub-list for extension type_name
+	16, // [16:16] is the sub-list for extension extendee
+	0,  // [0:16] is the sub-list for field type_name
+}
+
+func init() { file_api_stayrtr_proto_init() }
+func file_api_stayrtr_proto_init() {
+	if File_api_stayrtr_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_api_stayrtr_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*VRP); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_stayrtr_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetVRPsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_stayrtr_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*VRPSet); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_stayrtr_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchVRPsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_stayrtr_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*VRPUpdate); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_stayrtr_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ValidateRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_stayrtr_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ValidateResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_stayrtr_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Export); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_stayrtr_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ExportedVRP); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_stayrtr_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RouterKey); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_stayrtr_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ASPA); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_api_stayrtr_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_api_stayrtr_proto_goTypes,
+		DependencyIndexes: file_api_stayrtr_proto_depIdxs,
+		EnumInfos:         file_api_stayrtr_proto_enumTypes,
+		MessageInfos:      file_api_stayrtr_proto_msgTypes,
+	}.Build()
+	File_api_stayrtr_proto = out.File
+	file_api_stayrtr_proto_rawDesc = nil
+	file_api_stayrtr_proto_goTypes = nil
+	file_api_stayrtr_proto_depIdxs = nil
+}
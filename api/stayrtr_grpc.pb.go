@@ -0,0 +1,219 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: api/stayrtr.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	VRPService_GetVRPs_FullMethodName   = "/stayrtr.v1.VRPService/GetVRPs"
+	VRPService_WatchVRPs_FullMethodName = "/stayrtr.v1.VRPService/WatchVRPs"
+	VRPService_Validate_FullMethodName  = "/stayrtr.v1.VRPService/Validate"
+)
+
+// VRPServiceClient is the client API for VRPService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type VRPServiceClient interface {
+	// GetVRPs returns the current set of VRPs, router keys and ASPAs.
+	GetVRPs(ctx context.Context, in *GetVRPsRequest, opts ...grpc.CallOption) (*VRPSet, error)
+	// WatchVRPs streams the changes each time the serial advances.
+	// The first message contains the complete set, unless a known serial is given.
+	WatchVRPs(ctx context.Context, in *WatchVRPsRequest, opts ...grpc.CallOption) (VRPService_WatchVRPsClient, error)
+	// Validate returns the route origin validation state (RFC 6811) of a route.
+	Validate(ctx context.Context, in *ValidateRequest, opts ...grpc.CallOption) (*ValidateResponse, error)
+}
+
+type vRPServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVRPServiceClient(cc grpc.ClientConnInterface) VRPServiceClient {
+	return &vRPServiceClient{cc}
+}
+
+func (c *vRPServiceClient) GetVRPs(ctx context.Context, in *GetVRPsRequest, opts ...grpc.CallOption) (*VRPSet, error) {
+	out := new(VRPSet)
+	err := c.cc.Invoke(ctx, VRPService_GetVRPs_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *vRPServiceClient) WatchVRPs(ctx context.Context, in *WatchVRPsRequest, opts ...grpc.CallOption) (VRPService_WatchVRPsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &VRPService_ServiceDesc.Streams[0], VRPService_WatchVRPs_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &vRPServiceWatchVRPsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type VRPService_WatchVRPsClient interface {
+	Recv() (*VRPUpdate, error)
+	grpc.ClientStream
+}
+
+type vRPServiceWatchVRPsClient struct {
+	grpc.ClientStream
+}
+
+func (x *vRPServiceWatchVRPsClient) Recv() (*VRPUpdate, error) {
+	m := new(VRPUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *vRPServiceClient) Validate(ctx context.Context, in *ValidateRequest, opts ...grpc.CallOption) (*ValidateResponse, error) {
+	out := new(ValidateResponse)
+	err := c.cc.Invoke(ctx, VRPService_Validate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// VRPServiceServer is the server API for VRPService service.
+// All implementations must embed UnimplementedVRPServiceServer
+// for forward compatibility
+type VRPServiceServer interface {
+	// GetVRPs returns the current set of VRPs, router keys and ASPAs.
+	GetVRPs(context.Context, *GetVRPsRequest) (*VRPSet, error)
+	// WatchVRPs streams the changes each time the serial advances.
+	// The first message contains the complete set, unless a known serial is given.
+	WatchVRPs(*WatchVRPsRequest, VRPService_WatchVRPsServer) error
+	// Validate returns the route origin validation state (RFC 6811) of a route.
+	Validate(context.Context, *ValidateRequest) (*ValidateResponse, error)
+	mustEmbedUnimplementedVRPServiceServer()
+}
+
+// UnimplementedVRPServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedVRPServiceServer struct {
+}
+
+func (UnimplementedVRPServiceServer) GetVRPs(context.Context, *GetVRPsRequest) (*VRPSet, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetVRPs not implemented")
+}
+func (UnimplementedVRPServiceServer) WatchVRPs(*WatchVRPsRequest, VRPService_WatchVRPsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchVRPs not implemented")
+}
+func (UnimplementedVRPServiceServer) Validate(context.Context, *ValidateRequest) (*ValidateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Validate not implemented")
+}
+func (UnimplementedVRPServiceServer) mustEmbedUnimplementedVRPServiceServer() {}
+
+// UnsafeVRPServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VRPServiceServer will
+// result in compilation errors.
+type UnsafeVRPServiceServer interface {
+	mustEmbedUnimplementedVRPServiceServer()
+}
+
+func RegisterVRPServiceServer(s grpc.ServiceRegistrar, srv VRPServiceServer) {
+	s.RegisterService(&VRPService_ServiceDesc, srv)
+}
+
+func _VRPService_GetVRPs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetVRPsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VRPServiceServer).GetVRPs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VRPService_GetVRPs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VRPServiceServer).GetVRPs(ctx, req.(*GetVRPsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VRPService_WatchVRPs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchVRPsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(VRPServiceServer).WatchVRPs(m, &vRPServiceWatchVRPsServer{stream})
+}
+
+type VRPService_WatchVRPsServer interface {
+	Send(*VRPUpdate) error
+	grpc.ServerStream
+}
+
+type vRPServiceWatchVRPsServer struct {
+	grpc.ServerStream
+}
+
+func (x *vRPServiceWatchVRPsServer) Send(m *VRPUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _VRPService_Validate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ValidateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VRPServiceServer).Validate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VRPService_Validate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VRPServiceServer).Validate(ctx, req.(*ValidateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// VRPService_ServiceDesc is the grpc.ServiceDesc for VRPService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var VRPService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "stayrtr.v1.VRPService",
+	HandlerType: (*VRPServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetVRPs",
+			Handler:    _VRPService_GetVRPs_Handler,
+		},
+		{
+			MethodName: "Validate",
+			Handler:    _VRPService_Validate_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchVRPs",
+			Handler:       _VRPService_WatchVRPs_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/stayrtr.proto",
+}
@@ -11,6 +11,8 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	rtr "github.com/bgp/stayrtr/lib"
 	"github.com/bgp/stayrtr/prefixfile"
@@ -27,6 +29,12 @@ const (
 	METHOD_KEY
 )
 
+// Output formats, see -format
+const (
+	FORMAT_JSON = iota
+	FORMAT_SLURM
+)
+
 var (
 	version    = ""
 	buildinfos = ""
@@ -34,6 +42,7 @@ var (
 
 	Connect = flag.String("connect", "127.0.0.1:8282", "Connection address")
 	OutFile = flag.String("file", "output.json", "Output file")
+	Format  = flag.String("format", "json", "Output format: json or slurm (the VRPs as the prefix assertions of a SLURM file)")
 
 	InitSerial = flag.Bool("serial", false, "Send serial query instead of reset")
 	Serial     = flag.Int("serial.value", 0, "Serial number")
@@ -50,6 +59,7 @@ var (
 	SSHAuthKey      = flag.String("ssh.auth.key", "id_rsa", fmt.Sprintf("SSH key file (if blank, will use envvar %v)", ENV_SSH_KEY))
 
 	RefreshInterval = flag.Int("refresh", 600, "Refresh interval in seconds")
+	Watch           = flag.Bool("watch", false, "Keep the session open and print the announcements and withdrawals as they arrive")
 
 	LogLevel   = flag.String("loglevel", "info", "Log level")
 	LogDataPDU = flag.Bool("datapdu", false, "Log data PDU")
@@ -65,6 +75,10 @@ var (
 		"password": METHOD_PASSWORD,
 		"key":      METHOD_KEY,
 	}
+	formatToId = map[string]int{
+		"json":  FORMAT_JSON,
+		"slurm": FORMAT_SLURM,
+	}
 )
 
 type Client struct {
@@ -73,9 +87,21 @@ type Client struct {
 	InitSerial bool
 	Serial     uint32
 	SessionID  uint16
+
+	// Watch mode (-watch): the changes of the current response, printed once complete
+	Watch   bool
+	Out     io.Writer
+	lock    sync.Mutex
+	reset   bool
+	changes []string
+	err     error
 }
 
 func (c *Client) HandlePDU(cs *rtr.ClientSession, pdu rtr.PDU) {
+	if c.Watch {
+		c.handleWatch(cs, pdu)
+		return
+	}
 	switch pdu := pdu.(type) {
 	case *rtr.PDUIPv4Prefix:
 		rj := prefixfile.VRPJson{
@@ -112,10 +138,80 @@ func (c *Client) HandlePDU(cs *rtr.ClientSession, pdu rtr.PDU) {
 	}
 }
 
+func (c *Client) change(pdu rtr.PDU, flags uint8, format string, a ...interface{}) {
+	sign := "-"
+	if flags&1 == rtr.FLAG_ADDED {
+		sign = "+"
+	}
+	c.changes = append(c.changes, sign+" "+fmt.Sprintf(format, a...))
+	if *LogDataPDU {
+		log.Debugf("Received: %v", pdu)
+	}
+}
+
+func (c *Client) handleWatch(cs *rtr.ClientSession, pdu rtr.PDU) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch pdu := pdu.(type) {
+	case *rtr.PDUIPv4Prefix:
+		c.change(pdu, pdu.Flags, "%v-%d AS%d", pdu.Prefix.String(), pdu.MaxLen, pdu.ASN)
+		return
+	case *rtr.PDUIPv6Prefix:
+		c.change(pdu, pdu.Flags, "%v-%d AS%d", pdu.Prefix.String(), pdu.MaxLen, pdu.ASN)
+		return
+	case *rtr.PDURouterKey:
+		c.change(pdu, pdu.Flags, "router key AS%d SKI %x", pdu.ASN, pdu.SubjectKeyIdentifier)
+		return
+	case *rtr.PDUASPA:
+		c.change(pdu, pdu.Flags, "ASPA AS%d providers %v", pdu.CustomerASN, pdu.Providers)
+		return
+	case *rtr.PDUCacheResponse:
+		c.SessionID = pdu.SessionId
+		c.changes = c.changes[:0]
+	case *rtr.PDUEndOfData:
+		now := time.Now().UTC().Format(time.RFC3339)
+		if c.reset {
+			// A full set is summarized rather than printed
+			fmt.Fprintf(c.Out, "%v serial %d session %d: %d objects\n", now, pdu.SerialNumber, pdu.SessionId, len(c.changes))
+		} else {
+			for _, change := range c.changes {
+				fmt.Fprintf(c.Out, "%v serial %d %v\n", now, pdu.SerialNumber, change)
+			}
+		}
+		c.Serial = pdu.SerialNumber
+		c.SessionID = pdu.SessionId
+		c.InitSerial = true
+		c.reset = false
+		c.changes = c.changes[:0]
+	case *rtr.PDUSerialNotify:
+		if c.InitSerial && pdu.SerialNumber != c.Serial {
+			cs.SendSerialQuery(c.SessionID, c.Serial)
+		}
+	case *rtr.PDUCacheReset:
+		log.Infof("Cache reset, downloading the full set")
+		c.reset = true
+		cs.SendResetQuery()
+	case *rtr.PDUErrorReport:
+		c.err = fmt.Errorf("error report from the RTR server (code %d): %v", pdu.ErrorCode, strings.TrimRight(pdu.ErrorMsg, "\x00"))
+		cs.Disconnect()
+	}
+	log.Debugf("Received: %v", pdu)
+}
+
+// refresh sends a Serial Query, for the caches that do not notify their clients.
+func (c *Client) refresh(cs *rtr.ClientSession) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.InitSerial {
+		cs.SendSerialQuery(c.SessionID, c.Serial)
+	}
+}
+
 func (c *Client) ClientConnected(cs *rtr.ClientSession) {
 	if c.InitSerial {
 		cs.SendSerialQuery(c.SessionID, c.Serial)
 	} else {
+		c.reset = true
 		cs.SendResetQuery()
 	}
 }
@@ -140,6 +236,11 @@ func main() {
 	lvl, _ := log.ParseLevel(*LogLevel)
 	log.SetLevel(lvl)
 
+	format, ok := formatToId[*Format]
+	if !ok {
+		log.Fatalf("Output format %v unknown", *Format)
+	}
+
 	cc := rtr.ClientConfiguration{
 		ProtocolVersion: rtr.PROTOCOL_VERSION_1,
 		Log:             log.StandardLogger(),
@@ -153,6 +254,8 @@ func main() {
 		InitSerial: *InitSerial,
 		Serial:     uint32(*Serial),
 		SessionID:  uint16(*Session),
+		Watch:      *Watch,
+		Out:        os.Stdout,
 	}
 
 	clientSession := rtr.NewClientSession(cc, client)
@@ -203,11 +306,35 @@ func main() {
 		log.Fatalf("Auth type %v unknown", *SSHAuth)
 	}
 
+	if *Watch {
+		// The changes go to the standard output, unless a file is explicitly set
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "file" && *OutFile != "" {
+				ff, err := os.OpenFile(*OutFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+				if err != nil {
+					log.Fatal(err)
+				}
+				client.Out = ff
+			}
+		})
+		go func() {
+			for range time.Tick(time.Duration(*RefreshInterval) * time.Second) {
+				client.refresh(clientSession)
+			}
+		}()
+	}
+
 	log.Infof("Connecting with %v to %v", *ConnType, *Connect)
 	err := clientSession.Start(*Connect, typeToId[*ConnType], configTLS, configSSH)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *Watch {
+		if client.err != nil {
+			log.Fatal(client.err)
+		}
+		log.Fatal("Session closed by the RTR server")
+	}
 
 	var f io.Writer
 	if *OutFile != "" {
@@ -222,7 +349,14 @@ func main() {
 	}
 
 	enc := json.NewEncoder(f)
-	err = enc.Encode(client.Data)
+	if format == FORMAT_SLURM {
+		// To be edited as a local policy
+		enc.SetIndent("", "  ")
+		comment := fmt.Sprintf("Dumped from %v at %v", *Connect, time.Now().UTC().Format(time.RFC3339))
+		err = enc.Encode(prefixfile.NewSlurmAssertions(client.Data.Data, comment))
+	} else {
+		err = enc.Encode(client.Data)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type divergenceEntry struct {
+	*VRPJsonSimple
+	OnlyIn          string `json:"only-in"`
+	MissingFrom     string `json:"missing-from"`
+	DivergedSince   int64  `json:"diverged-since"`
+	DivergedSeconds int64  `json:"diverged-seconds"`
+}
+
+type divergenceExport struct {
+	MetadataPrimary   *diffMetadata      `json:"metadata-primary"`
+	MetadataSecondary *diffMetadata      `json:"metadata-secondary"`
+	Divergences       []*divergenceEntry `json:"divergences"`
+}
+
+func vrpKey(vrp *VRPJsonSimple) string {
+	return fmt.Sprintf("%s-%d-%d", vrp.Prefix, vrp.Length, vrp.ASN)
+}
+
+// Track since when the VRPs are in one source but not in the other:
+//   - VRPs already diverging keep their timestamp
+//   - VRPs in sync again are forgotten, they start over if they diverge again later.
+func UpdateDivergence(divergedSince map[string]int64, onlyIn []*VRPJsonSimple, now time.Time) map[string]int64 {
+	res := make(map[string]int64, len(onlyIn))
+	for _, vrp := range onlyIn {
+		key := vrpKey(vrp)
+		if since, ok := divergedSince[key]; ok {
+			res[key] = since
+		} else {
+			res[key] = now.Unix()
+		}
+	}
+	return res
+}
+
+func divergenceEntries(onlyIn []*VRPJsonSimple, divergedSince map[string]int64, source string, md *diffMetadata, now int64, minDuration time.Duration) []*divergenceEntry {
+	entries := make([]*divergenceEntry, 0)
+	for _, vrp := range onlyIn {
+		since, ok := divergedSince[vrpKey(vrp)]
+		if !ok {
+			since = now
+		}
+		if now-since < int64(minDuration.Seconds()) {
+			continue
+		}
+		missingFrom := ""
+		if md != nil {
+			missingFrom = md.URL
+		}
+		entries = append(entries, &divergenceEntry{
+			VRPJsonSimple:   vrp,
+			OnlyIn:          source,
+			MissingFrom:     missingFrom,
+			DivergedSince:   since,
+			DivergedSeconds: now - since,
+		})
+	}
+	return entries
+}
+
+// ServeDivergence lists the VRPs in one source but not in the other, the ones diverging
+// for the longest time first. The list can be restricted to a source (?source=primary or
+// ?source=secondary), and to the VRPs diverging for at least a duration (?min-duration=1h).
+func (c *Comparator) ServeDivergence(wr http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	source := query.Get("source")
+	if source != "" && source != "primary" && source != "secondary" {
+		http.Error(wr, fmt.Sprintf("unknown source %q", source), http.StatusBadRequest)
+		return
+	}
+	var minDuration time.Duration
+	if value := query.Get("min-duration"); value != "" {
+		var err error
+		minDuration, err = time.ParseDuration(value)
+		if err != nil {
+			http.Error(wr, fmt.Sprintf("invalid min-duration: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now().Unix()
+	c.diffLock.RLock()
+	export := divergenceExport{
+		MetadataPrimary:   c.md1,
+		MetadataSecondary: c.md2,
+		Divergences:       make([]*divergenceEntry, 0),
+	}
+	if source != "secondary" {
+		export.Divergences = append(export.Divergences, divergenceEntries(c.onlyIn1, c.divergedSince1, "primary", c.md2, now, minDuration)...)
+	}
+	if source != "primary" {
+		export.Divergences = append(export.Divergences, divergenceEntries(c.onlyIn2, c.divergedSince2, "secondary", c.md1, now, minDuration)...)
+	}
+	c.diffLock.RUnlock()
+
+	sort.SliceStable(export.Divergences, func(i, j int) bool {
+		a, b := export.Divergences[i], export.Divergences[j]
+		if a.DivergedSince != b.DivergedSince {
+			return a.DivergedSince < b.DivergedSince
+		}
+		return vrpKey(a.VRPJsonSimple) < vrpKey(b.VRPJsonSimple)
+	})
+
+	wr.Header().Add("content-type", "application/json")
+	json.NewEncoder(wr).Encode(export)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	OBJECT_ROUTER_KEY = "router-key"
+	OBJECT_ASPA       = "aspa"
+)
+
+// ObjectJsonSimple is a router key or an ASPA compared between the sources, alongside the VRPs.
+type ObjectJsonSimple struct {
+	Type      string   `json:"type"`
+	ASN       uint32   `json:"asn"`
+	SKI       string   `json:"ski,omitempty"`
+	Pubkey    string   `json:"pubkey,omitempty"`
+	Providers []uint32 `json:"providers,omitempty"`
+	FirstSeen int64    `json:"first-seen"`
+	LastSeen  int64    `json:"last-seen"`
+}
+type ObjectMap map[string]*ObjectJsonSimple
+
+// Key identifying the object: the providers of an ASPA are part of it, so that a change of
+// the providers is a difference.
+func (o *ObjectJsonSimple) key() string {
+	if o.Type == OBJECT_ASPA {
+		return fmt.Sprintf("%s-%d-%v", o.Type, o.ASN, o.Providers)
+	}
+	return fmt.Sprintf("%s-%d-%s-%s", o.Type, o.ASN, o.SKI, o.Pubkey)
+}
+
+// Key under which the object is updated by the RTR PDUs: an ASPA replaces the previous one of
+// the customer, and its withdrawal has no providers.
+func (o *ObjectJsonSimple) rtrKey() string {
+	if o.Type == OBJECT_ASPA {
+		return fmt.Sprintf("%s-%d", o.Type, o.ASN)
+	}
+	return o.key()
+}
+
+func newRouterKey(asn uint32, ski []byte, pubkey []byte) *ObjectJsonSimple {
+	return &ObjectJsonSimple{
+		Type:   OBJECT_ROUTER_KEY,
+		ASN:    asn,
+		SKI:    fmt.Sprintf("%x", ski),
+		Pubkey: base64.StdEncoding.EncodeToString(pubkey),
+	}
+}
+
+func newASPA(customer uint32, providers []uint32) *ObjectJsonSimple {
+	sorted := make([]uint32, len(providers))
+	copy(sorted, providers)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return &ObjectJsonSimple{
+		Type:      OBJECT_ASPA,
+		ASN:       customer,
+		Providers: sorted,
+	}
+}
+
+// Sources encode the public keys differently (padding, alphabet): they are compared decoded.
+func decodePubkey(pubkey string) ([]byte, error) {
+	var err error
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		var decoded []byte
+		decoded, err = enc.DecodeString(pubkey)
+		if err == nil {
+			return decoded, nil
+		}
+	}
+	return nil, err
+}
+
+// Build the new objectMap from the router keys and the ASPAs of a JSON file, like the vrpMap.
+func BuildNewObjectMap(log *log.Entry, currentObjects ObjectMap, keys []prefixfile.BgpsecKeyJson, aspas []prefixfile.ASPAJson, now time.Time) (ObjectMap, int) {
+	tCurrentUpdate := now.Unix()
+	newObjects := make([]*ObjectJsonSimple, 0, len(keys)+len(aspas))
+	for _, key := range keys {
+		pubkey, err := decodePubkey(key.Pubkey)
+		if err != nil {
+			log.Errorf("exploration error for %v pubkey: %v", key, err)
+			continue
+		}
+		ski, err := hex.DecodeString(key.SKI)
+		if err != nil {
+			log.Errorf("exploration error for %v ski: %v", key, err)
+			continue
+		}
+		newObjects = append(newObjects, newRouterKey(key.ASN, ski, pubkey))
+	}
+	for _, aspa := range aspas {
+		newObjects = append(newObjects, newASPA(aspa.CustomerASID, aspa.Providers))
+	}
+
+	res := make(ObjectMap)
+	for _, object := range newObjects {
+		key := object.key()
+		object.FirstSeen = tCurrentUpdate
+		if currentEntry, ok := currentObjects[key]; ok {
+			object.FirstSeen = currentEntry.FirstSeen
+		}
+		object.LastSeen = tCurrentUpdate
+		res[key] = object
+	}
+
+	// Copy objects that are within the grace period to the new map
+	gracePeriodEnds := tCurrentUpdate - int64(GracePeriod.Seconds())
+	inGracePeriod := 0
+	for k, entry := range currentObjects {
+		if _, ok := res[k]; !ok && entry.LastSeen >= gracePeriodEnds {
+			res[k] = entry
+			inGracePeriod++
+		}
+	}
+
+	return res, inGracePeriod
+}
+
+func DiffObjects(a, b ObjectMap) []*ObjectJsonSimple {
+	onlyInA := make([]*ObjectJsonSimple, 0)
+	for key, object := range a {
+		if _, ok := b[key]; !ok {
+			onlyInA = append(onlyInA, object)
+		}
+	}
+	return onlyInA
+}
+
+func filterObjects(objects []*ObjectJsonSimple, objectType string) []*ObjectJsonSimple {
+	res := make([]*ObjectJsonSimple, 0)
+	for _, object := range objects {
+		if object.Type == objectType {
+			res = append(res, object)
+		}
+	}
+	return res
+}
+
+// RTR version from which the objects are sent
+var objectVersions = map[string]uint8{
+	OBJECT_ROUTER_KEY: rtr.PROTOCOL_VERSION_1,
+	OBJECT_ASPA:       rtr.PROTOCOL_VERSION_2,
+}
+
+// Objects that the RTR version of a source does not carry are not a difference.
+func comparableObjects(objects []*ObjectJsonSimple, mds ...*diffMetadata) []*ObjectJsonSimple {
+	res := make([]*ObjectJsonSimple, 0, len(objects))
+	for _, object := range objects {
+		comparable := true
+		for _, md := range mds {
+			if md.RTRVersion != nil && *md.RTRVersion < objectVersions[object.Type] {
+				comparable = false
+			}
+		}
+		if comparable {
+			res = append(res, object)
+		}
+	}
+	return res
+}
+
+func countObjects(objects ObjectMap, objectType string) int {
+	count := 0
+	for _, object := range objects {
+		if object.Type == objectType {
+			count++
+		}
+	}
+	return count
+}
+
+func setObjectCounts(server, url, objectType string, objects ObjectMap, onlyIn []*ObjectJsonSimple) {
+	ObjectCount.With(
+		prometheus.Labels{
+			"server": server,
+			"url":    url,
+			"type":   "total",
+			"object": objectType,
+		}).Set(float64(countObjects(objects, objectType)))
+
+	ObjectCount.With(
+		prometheus.Labels{
+			"server": server,
+			"url":    url,
+			"type":   "diff",
+			"object": objectType,
+		}).Set(float64(len(filterObjects(onlyIn, objectType))))
+}
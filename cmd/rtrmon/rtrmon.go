@@ -53,6 +53,8 @@ var (
 	MetricsPath = flag.String("metrics", "/metrics", "Metrics path")
 	OutFile     = flag.String("file", "diff.json", "Diff file (or URL path without /)")
 
+	DivergenceFile = flag.String("divergence.file", "divergence.json", "URL path without / of the detail of the VRPs diverging between the sources")
+
 	UserAgent                  = flag.String("useragent", fmt.Sprintf("StayRTR-%v (+https://github.com/bgp/stayrtr)", AppVersion), "User-Agent header")
 	DisableConditionalRequests = flag.Bool("disable.conditional.requests", false, "Disable conditional requests (using If-None-Match/If-Modified-Since headers)")
 	GracePeriod                = flag.Duration("grace.period", time.Minute*20, "Grace period during which objects removed from a source are not considered for the diff")
@@ -100,6 +102,13 @@ var (
 		},
 		[]string{"server", "url", "type"},
 	)
+	ObjectCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpki_objects",
+			Help: "Total number of current router keys/ASPAs in primary/secondary and current difference between primary and secondary.",
+		},
+		[]string{"server", "url", "type", "object"},
+	)
 	VRPDifferenceForDuration = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "vrp_diff",
@@ -154,6 +163,7 @@ var (
 
 func init() {
 	prometheus.MustRegister(VRPCount)
+	prometheus.MustRegister(ObjectCount)
 	prometheus.MustRegister(VRPDifferenceForDuration)
 	prometheus.MustRegister(VRPInGracePeriod)
 	prometheus.MustRegister(RTRState)
@@ -232,8 +242,10 @@ type Client struct {
 
 	compLock    *sync.RWMutex
 	vrps        VRPMap
+	objects     ObjectMap
 	compRtrLock *sync.RWMutex
 	vrpsRtr     VRPMap
+	objectsRtr  ObjectMap
 
 	unlock chan bool
 	ch     chan int
@@ -242,14 +254,19 @@ type Client struct {
 	rtrRefresh uint32
 	rtrRetry   uint32
 	rtrExpire  uint32
+	rtrVersion uint8
+	rtr        bool
 }
 
 func NewClient() *Client {
 	return &Client{
 		compLock:    &sync.RWMutex{},
 		vrps:        make(VRPMap),
+		objects:     make(ObjectMap),
 		compRtrLock: &sync.RWMutex{},
 		vrpsRtr:     make(VRPMap),
+		objectsRtr:  make(ObjectMap),
+		rtrVersion:  rtr.PROTOCOL_VERSION_2,
 	}
 }
 
@@ -276,9 +293,11 @@ func (c *Client) Start(id int, ch chan int) {
 		bypass = false
 
 		if connType == "ssh" || connType == "tcp" || connType == "tls" {
+			c.rtr = true
 
+			version := c.getRTRVersion()
 			cc := rtr.ClientConfiguration{
-				ProtocolVersion: rtr.PROTOCOL_VERSION_1,
+				ProtocolVersion: version,
 				Log:             log.StandardLogger(),
 			}
 
@@ -321,6 +340,11 @@ func (c *Client) Start(id int, ch chan int) {
 			}
 
 			err := clientSession.Start(rtrAddr, typeToId[connType], configTLS, configSSH)
+			if serverVersion := c.getRTRVersion(); serverVersion < version {
+				log.Infof("%d: Server only supports version %d, connecting again", id, serverVersion)
+				bypass = true
+				continue
+			}
 			if err != nil {
 				log.Fatal(err)
 			}
@@ -346,9 +370,11 @@ func (c *Client) Start(id int, ch chan int) {
 			tCurrentUpdate := time.Now().UTC()
 			updatedVrpMap, inGracePeriod := BuildNewVrpMap(log.WithField("client", c.id), c.vrps, decoded.Data, tCurrentUpdate)
 			VRPInGracePeriod.With(prometheus.Labels{"url": c.Path}).Set(float64(inGracePeriod))
+			updatedObjectMap, _ := BuildNewObjectMap(log.WithField("client", c.id), c.objects, decoded.BgpsecKeys, decoded.ASPA, tCurrentUpdate)
 
 			c.compLock.Lock()
 			c.vrps = updatedVrpMap
+			c.objects = updatedObjectMap
 			c.lastUpdate = tCurrentUpdate
 			c.compLock.Unlock()
 			if ch != nil {
@@ -452,6 +478,12 @@ func (c *Client) HandlePDU(cs *rtr.ClientSession, pdu rtr.PDU) {
 		}
 
 		c.compRtrLock.Unlock()
+	case *rtr.PDURouterKey:
+		object := newRouterKey(pdu.ASN, pdu.SubjectKeyIdentifier[:], pdu.SubjectPublicKeyInfo)
+		c.handleObject(object, pdu.Flags)
+	case *rtr.PDUASPA:
+		object := newASPA(pdu.CustomerASN, pdu.Providers)
+		c.handleObject(object, pdu.Flags)
 	case *rtr.PDUEndOfData:
 		log.Infof("%d: Received: %v", c.id, pdu)
 
@@ -461,10 +493,15 @@ func (c *Client) HandlePDU(cs *rtr.ClientSession, pdu rtr.PDU) {
 		for key, vrp := range c.vrpsRtr {
 			tmpVrpMap[key] = vrp
 		}
+		tmpObjectMap := make(ObjectMap, len(c.objectsRtr))
+		for _, object := range c.objectsRtr {
+			tmpObjectMap[object.key()] = object
+		}
 		c.compRtrLock.Unlock()
 
 		c.compLock.Lock()
 		c.vrps = tmpVrpMap
+		c.objects = tmpObjectMap
 
 		c.rtrRefresh = pdu.RefreshInterval
 		c.rtrRetry = pdu.RetryInterval
@@ -486,12 +523,34 @@ func (c *Client) HandlePDU(cs *rtr.ClientSession, pdu rtr.PDU) {
 		log.Infof("%d: Received: %v", c.id, pdu)
 	case *rtr.PDUSerialNotify:
 		log.Infof("%d: Received: %v", c.id, pdu)
+	case *rtr.PDUErrorReport:
+		log.Infof("%d: Received: %v", c.id, pdu)
+		// Connecting again with the version of the server
+		c.compLock.Lock()
+		if pdu.ErrorCode == rtr.PDU_ERROR_BADPROTOVERSION && pdu.Version < c.rtrVersion {
+			c.rtrVersion = pdu.Version
+		}
+		c.compLock.Unlock()
+		cs.Disconnect()
 	default:
 		log.Infof("%d: Received: %v", c.id, pdu)
 		cs.Disconnect()
 	}
 }
 
+// Router keys and ASPAs are compared with the VRPs when the RTR version supports them.
+func (c *Client) handleObject(object *ObjectJsonSimple, flags uint8) {
+	object.FirstSeen = time.Now().Unix()
+
+	c.compRtrLock.Lock()
+	if flags&1 == rtr.FLAG_ADDED {
+		c.objectsRtr[object.rtrKey()] = object
+	} else {
+		delete(c.objectsRtr, object.rtrKey())
+	}
+	c.compRtrLock.Unlock()
+}
+
 func (c *Client) ClientConnected(cs *rtr.ClientSession) {
 	close(c.unlock)
 	cs.SendResetQuery()
@@ -538,10 +597,19 @@ func (c *Client) continuousRTR(cs *rtr.ClientSession) {
 	}
 }
 
-func (c *Client) GetData() (VRPMap, *diffMetadata) {
+// getRTRVersion returns the protocol version to connect with, lowered to the version of
+// the server when it does not support it.
+func (c *Client) getRTRVersion() uint8 {
+	c.compLock.RLock()
+	defer c.compLock.RUnlock()
+	return c.rtrVersion
+}
+
+func (c *Client) GetData() (VRPMap, ObjectMap, *diffMetadata) {
 	c.compLock.RLock()
 	defer c.compLock.RUnlock()
 	vrps := c.vrps
+	objects := c.objects
 
 	md := &diffMetadata{
 		URL:       c.Path,
@@ -549,6 +617,9 @@ func (c *Client) GetData() (VRPMap, *diffMetadata) {
 		SessionID: c.sessionID,
 		Count:     len(vrps),
 
+		CountRouterKeys: countObjects(objects, OBJECT_ROUTER_KEY),
+		CountASPAs:      countObjects(objects, OBJECT_ASPA),
+
 		RTRRefresh: c.rtrRefresh,
 		RTRRetry:   c.rtrRetry,
 		RTRExpire:  c.rtrExpire,
@@ -556,7 +627,12 @@ func (c *Client) GetData() (VRPMap, *diffMetadata) {
 		LastFetch: c.lastUpdate.UnixNano() / 1e9,
 	}
 
-	return vrps, md
+	if c.rtr {
+		version := c.rtrVersion
+		md.RTRVersion = &version
+	}
+
+	return vrps, objects, md
 }
 
 type Comparator struct {
@@ -571,6 +647,11 @@ type Comparator struct {
 	onlyIn1, onlyIn2 []*VRPJsonSimple
 	md1              *diffMetadata
 	md2              *diffMetadata
+
+	onlyInObjects1, onlyInObjects2 []*ObjectJsonSimple
+
+	// Since when the VRPs only in the primary/secondary are diverging
+	divergedSince1, divergedSince2 map[string]int64
 }
 
 func NewComparator(c1, c2 *Client) *Comparator {
@@ -614,9 +695,14 @@ type diffMetadata struct {
 	SessionID uint16 `json:"session-id"`
 	Count     int    `json:"count"`
 
+	CountRouterKeys int `json:"count-router-keys"`
+	CountASPAs      int `json:"count-aspas"`
+
 	RTRRefresh uint32 `json:"rtr-refresh"`
 	RTRRetry   uint32 `json:"rtr-retry"`
 	RTRExpire  uint32 `json:"rtr-expire"`
+
+	RTRVersion *uint8 `json:"rtr-version,omitempty"`
 }
 
 type VRPJsonSimple struct {
@@ -633,6 +719,11 @@ type diffExport struct {
 	MetadataSecondary *diffMetadata    `json:"metadata-secondary"`
 	OnlyInPrimary     []*VRPJsonSimple `json:"only-primary"`
 	OnlyInSecondary   []*VRPJsonSimple `json:"only-secondary"`
+
+	RouterKeysOnlyInPrimary   []*ObjectJsonSimple `json:"only-primary-router-keys"`
+	RouterKeysOnlyInSecondary []*ObjectJsonSimple `json:"only-secondary-router-keys"`
+	ASPAsOnlyInPrimary        []*ObjectJsonSimple `json:"only-primary-aspas"`
+	ASPAsOnlyInSecondary      []*ObjectJsonSimple `json:"only-secondary-aspas"`
 }
 
 func (c *Comparator) ServeDiff(wr http.ResponseWriter, req *http.Request) {
@@ -641,6 +732,8 @@ func (c *Comparator) ServeDiff(wr http.ResponseWriter, req *http.Request) {
 	c.diffLock.RLock()
 	d1 := c.onlyIn1
 	d2 := c.onlyIn2
+	o1 := c.onlyInObjects1
+	o2 := c.onlyInObjects2
 
 	md1 := c.md1
 	md2 := c.md2
@@ -650,6 +743,11 @@ func (c *Comparator) ServeDiff(wr http.ResponseWriter, req *http.Request) {
 		MetadataSecondary: md2,
 		OnlyInPrimary:     d1,
 		OnlyInSecondary:   d2,
+
+		RouterKeysOnlyInPrimary:   filterObjects(o1, OBJECT_ROUTER_KEY),
+		RouterKeysOnlyInSecondary: filterObjects(o2, OBJECT_ROUTER_KEY),
+		ASPAsOnlyInPrimary:        filterObjects(o1, OBJECT_ASPA),
+		ASPAsOnlyInSecondary:      filterObjects(o2, OBJECT_ASPA),
 	}
 
 	wr.Header().Add("content-type", "application/json")
@@ -669,15 +767,21 @@ func (c *Comparator) Compare() {
 		case id := <-c.comp:
 			log.Infof("Worker %d finished: comparison", id)
 
-			vrps1, md1 := c.PrimaryClient.GetData()
-			vrps2, md2 := c.SecondaryClient.GetData()
+			vrps1, objects1, md1 := c.PrimaryClient.GetData()
+			vrps2, objects2, md2 := c.SecondaryClient.GetData()
 
 			onlyIn1 := Diff(vrps1, vrps2)
 			onlyIn2 := Diff(vrps2, vrps1)
+			onlyInObjects1 := comparableObjects(DiffObjects(objects1, objects2), md1, md2)
+			onlyInObjects2 := comparableObjects(DiffObjects(objects2, objects1), md1, md2)
 
 			c.diffLock.Lock()
 			c.onlyIn1 = onlyIn1
 			c.onlyIn2 = onlyIn2
+			c.divergedSince1 = UpdateDivergence(c.divergedSince1, onlyIn1, time.Now())
+			c.divergedSince2 = UpdateDivergence(c.divergedSince2, onlyIn2, time.Now())
+			c.onlyInObjects1 = onlyInObjects1
+			c.onlyInObjects2 = onlyInObjects2
 
 			c.md1 = md1
 			c.md2 = md2
@@ -710,6 +814,11 @@ func (c *Comparator) Compare() {
 					"type":   "diff",
 				}).Set(float64(len(onlyIn2)))
 
+			for _, object := range []string{OBJECT_ROUTER_KEY, OBJECT_ASPA} {
+				setObjectCounts("primary", md1.URL, object, objects1, onlyInObjects1)
+				setObjectCounts("secondary", md2.URL, object, objects2, onlyInObjects2)
+			}
+
 			for _, visibleFor := range visibilityThresholds {
 				thresholdTimestamp := time.Now().Unix() - visibleFor
 				// Prevent differences with value 0 appearing if the process has not
@@ -898,6 +1007,7 @@ func main() {
 
 	go func() {
 		http.HandleFunc(fmt.Sprintf("/%s", *OutFile), cmp.ServeDiff)
+		http.HandleFunc(fmt.Sprintf("/%s", *DivergenceFile), cmp.ServeDivergence)
 		http.Handle(*MetricsPath, promhttp.Handler())
 		http.HandleFunc("/", ServeIndex)
 
@@ -909,15 +1019,16 @@ func main() {
 }
 
 type IndexTemplateVars struct {
-	MetricsPath string
-	OutFile     string
-	Addr        string
+	MetricsPath    string
+	OutFile        string
+	DivergenceFile string
+	Addr           string
 }
 
 func ServeIndex(wr http.ResponseWriter, req *http.Request) {
 	tmpl, err := template.New("index").Parse(IndexTemplate)
 	if err == nil {
-		err = tmpl.Execute(wr, IndexTemplateVars{*MetricsPath, *OutFile, *Addr})
+		err = tmpl.Execute(wr, IndexTemplateVars{*MetricsPath, *OutFile, *DivergenceFile, *Addr})
 	}
 
 	if err != nil {
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
 	"testing"
 	"time"
 
+	rtr "github.com/bgp/stayrtr/lib"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/bgp/stayrtr/prefixfile"
@@ -84,6 +88,144 @@ func TestBuildNewVrpMap_firsSeen_lastSeen(t *testing.T) {
 	assertLastSeenMatchesTimeCount(t, res, t2, len(otherStuff))
 }
 
+func TestBuildNewObjectMap(t *testing.T) {
+	t0 := time.Now()
+	log := log.WithField("client", "TestBuildNewObjectMap")
+	keys := []prefixfile.BgpsecKeyJson{
+		{ASN: 64496, SKI: "E2F075EC50E9F2EFCED506026BF6E4D1BD5A1A0F", Pubkey: "MFkwEwYHKoZIzj0CAQ"},
+	}
+	aspas := []prefixfile.ASPAJson{
+		{CustomerASID: 64497, Providers: []uint32{64499, 64498}},
+	}
+
+	res, _ := BuildNewObjectMap(log, make(ObjectMap), keys, aspas, t0)
+	if countObjects(res, OBJECT_ROUTER_KEY) != 1 || countObjects(res, OBJECT_ASPA) != 1 {
+		t.Fatalf("Expected a router key and an ASPA, actual: %v", res)
+	}
+
+	// The same objects received over RTR, the providers in another order
+	client := NewClient()
+	ski := [20]byte{0xe2, 0xf0, 0x75, 0xec, 0x50, 0xe9, 0xf2, 0xef, 0xce, 0xd5, 0x06, 0x02, 0x6b, 0xf6, 0xe4, 0xd1, 0xbd, 0x5a, 0x1a, 0x0f}
+	pubkey, _ := decodePubkey("MFkwEwYHKoZIzj0CAQ==")
+	client.HandlePDU(nil, &rtr.PDURouterKey{Flags: rtr.FLAG_ADDED, ASN: 64496, SubjectKeyIdentifier: ski, SubjectPublicKeyInfo: pubkey})
+	client.HandlePDU(nil, &rtr.PDUASPA{Flags: rtr.FLAG_ADDED, CustomerASN: 64497, Providers: []uint32{64498, 64499}})
+	client.HandlePDU(nil, &rtr.PDUEndOfData{})
+	_, objects, md := client.GetData()
+	if md.CountRouterKeys != 1 || md.CountASPAs != 1 {
+		t.Errorf("Expected a router key and an ASPA, actual: %d and %d", md.CountRouterKeys, md.CountASPAs)
+	}
+	if diff := DiffObjects(res, objects); len(diff) != 0 {
+		t.Errorf("Expected no difference, actual: %v", diff)
+	}
+
+	// The providers of the ASPA change, its withdrawal has no providers
+	client.HandlePDU(nil, &rtr.PDUASPA{Flags: rtr.FLAG_ADDED, CustomerASN: 64497, Providers: []uint32{64498}})
+	client.HandlePDU(nil, &rtr.PDUEndOfData{})
+	_, objects, _ = client.GetData()
+	if diff := DiffObjects(res, objects); len(diff) != 1 || diff[0].Type != OBJECT_ASPA {
+		t.Errorf("Expected the ASPA to differ, actual: %v", diff)
+	}
+	client.HandlePDU(nil, &rtr.PDUASPA{Flags: rtr.FLAG_REMOVED, CustomerASN: 64497})
+	client.HandlePDU(nil, &rtr.PDUEndOfData{})
+	_, _, md = client.GetData()
+	if md.CountASPAs != 0 {
+		t.Errorf("Expected the ASPA to be withdrawn, actual: %d", md.CountASPAs)
+	}
+
+	// ASPAs are not a difference with a source using RTR version 1
+	version := uint8(rtr.PROTOCOL_VERSION_1)
+	diff := comparableObjects(DiffObjects(res, make(ObjectMap)), &diffMetadata{}, &diffMetadata{RTRVersion: &version})
+	if len(diff) != 1 || diff[0].Type != OBJECT_ROUTER_KEY {
+		t.Errorf("Expected the router key only, actual: %v", diff)
+	}
+
+	// Objects are kept in grace period
+	res, inGracePeriod := BuildNewObjectMap(log, res, nil, nil, t0.Add(time.Minute))
+	if inGracePeriod != 2 || len(res) != 2 {
+		t.Errorf("Expected 2 objects in grace period, actual: %d", inGracePeriod)
+	}
+}
+
+func TestUpdateDivergence(t *testing.T) {
+	t0 := time.Now()
+	a := &VRPJsonSimple{Prefix: "192.168.0.0/24", Length: 24, ASN: 65536}
+	b := &VRPJsonSimple{Prefix: "2001:db8::/32", Length: 48, ASN: 65537}
+
+	res := UpdateDivergence(nil, []*VRPJsonSimple{a}, t0)
+	if res[vrpKey(a)] != t0.Unix() {
+		t.Errorf("Expected %v to diverge since %d, actual: %d", a, t0.Unix(), res[vrpKey(a)])
+	}
+
+	// A keeps diverging, B starts diverging
+	t1 := t0.Add(time.Minute * 10)
+	res = UpdateDivergence(res, []*VRPJsonSimple{a, b}, t1)
+	if res[vrpKey(a)] != t0.Unix() || res[vrpKey(b)] != t1.Unix() {
+		t.Errorf("Unexpected divergence: %v", res)
+	}
+
+	// A is in sync again, then diverges again
+	t2 := t1.Add(time.Minute * 10)
+	res = UpdateDivergence(res, []*VRPJsonSimple{b}, t2)
+	res = UpdateDivergence(res, []*VRPJsonSimple{a, b}, t2.Add(time.Minute))
+	if res[vrpKey(a)] != t2.Add(time.Minute).Unix() || res[vrpKey(b)] != t1.Unix() {
+		t.Errorf("Unexpected divergence: %v", res)
+	}
+}
+
+func TestServeDivergence(t *testing.T) {
+	now := time.Now()
+	a := &VRPJsonSimple{Prefix: "192.168.0.0/24", Length: 24, ASN: 65536}
+	b := &VRPJsonSimple{Prefix: "2001:db8::/32", Length: 48, ASN: 65537}
+	c := &Comparator{
+		diffLock:       &sync.RWMutex{},
+		onlyIn1:        []*VRPJsonSimple{a},
+		onlyIn2:        []*VRPJsonSimple{b},
+		md1:            &diffMetadata{URL: "tcp://primary:8282"},
+		md2:            &diffMetadata{URL: "https://secondary/rpki.json"},
+		divergedSince1: map[string]int64{vrpKey(a): now.Add(-time.Minute).Unix()},
+		divergedSince2: map[string]int64{vrpKey(b): now.Add(-time.Hour * 2).Unix()},
+	}
+
+	get := func(url string) (int, divergenceExport) {
+		wr := httptest.NewRecorder()
+		c.ServeDivergence(wr, httptest.NewRequest("GET", url, nil))
+		var export divergenceExport
+		if wr.Code == 200 {
+			if err := json.NewDecoder(wr.Body).Decode(&export); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return wr.Code, export
+	}
+
+	// Longest divergence first
+	_, export := get("/divergence.json")
+	if len(export.Divergences) != 2 {
+		t.Fatalf("Expected 2 divergences, actual: %d", len(export.Divergences))
+	}
+	first := export.Divergences[0]
+	if first.Prefix != b.Prefix || first.OnlyIn != "secondary" || first.MissingFrom != "tcp://primary:8282" {
+		t.Errorf("Unexpected first divergence: %+v", first)
+	}
+	if first.DivergedSeconds < 7200 {
+		t.Errorf("Expected a divergence of at least 2h, actual: %ds", first.DivergedSeconds)
+	}
+
+	_, export = get("/divergence.json?source=primary")
+	if len(export.Divergences) != 1 || export.Divergences[0].Prefix != a.Prefix {
+		t.Errorf("Expected the divergence of the primary only, actual: %v", export.Divergences)
+	}
+
+	_, export = get("/divergence.json?min-duration=1h")
+	if len(export.Divergences) != 1 || export.Divergences[0].Prefix != b.Prefix {
+		t.Errorf("Expected the divergence of more than 1h only, actual: %v", export.Divergences)
+	}
+
+	if code, _ := get("/divergence.json?source=other"); code != 400 {
+		t.Errorf("Expected a bad request for an unknown source, actual: %d", code)
+	}
+}
+
 func assertFirstSeenMatchesTimeCount(t *testing.T, vrps VRPMap, pit time.Time, expected int) {
 	actual := countMatches(vrps, func(vrp *VRPJsonSimple) bool { return vrp.FirstSeen == pit.Unix() })
 	if actual != expected {
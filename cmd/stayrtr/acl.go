@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+// sourceACL is the allowlist of the prefixes the RTR clients may connect from. When no ACL
+// is configured (nil prefixes), any address is allowed; an empty ACL allows none.
+type sourceACL struct {
+	lock     *sync.RWMutex
+	prefixes []*net.IPNet
+}
+
+func newSourceACL() *sourceACL {
+	return &sourceACL{
+		lock: &sync.RWMutex{},
+	}
+}
+
+// parseACL returns the prefixes of a comma-separated list and of a file with a prefix per
+// line, or nil if neither is set. A single address is a /32 or /128.
+func parseACL(list string, file string) ([]*net.IPNet, error) {
+	if list == "" && file == "" {
+		return nil, nil
+	}
+	values := strings.Split(list, ",")
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+			values = append(values, line)
+		}
+	}
+
+	prefixes := make([]*net.IPNet, 0)
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		prefix, err := parseAddressOrPrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func (a *sourceACL) Set(prefixes []*net.IPNet) {
+	a.lock.Lock()
+	a.prefixes = prefixes
+	a.lock.Unlock()
+}
+
+func (a *sourceACL) Count() int {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return len(a.prefixes)
+}
+
+// Allowed returns whether a client may connect from an address.
+func (a *sourceACL) Allowed(addr net.Addr) bool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	if a.prefixes == nil {
+		return true
+	}
+	ip := addrIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, prefix := range a.prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// listenerTransports is the transport of the clients of the RTR listeners, by flag.
+var listenerTransports = map[string]string{
+	"bind":     rtr.TransportPlain,
+	"tls.bind": rtr.TransportTLS,
+	"ssh.bind": rtr.TransportSSH,
+}
+
+// aclListener closes the connections from the addresses the ACL does not allow, before
+// any TLS or SSH handshake.
+type aclListener struct {
+	net.Listener
+	name string
+	acl  *sourceACL
+}
+
+func (l *aclListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.acl.Allowed(conn.RemoteAddr()) {
+			return conn, nil
+		}
+		log.Warnf("Rejected connection on %v from %v (not in the ACL)", l.name, conn.RemoteAddr())
+		ACLRejected.WithLabelValues(conn.LocalAddr().String(), listenerLabel(listenerTransports[l.name], conn.LocalAddr())).Inc()
+		conn.Close()
+	}
+}
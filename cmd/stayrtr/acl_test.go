@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseACL(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acl")
+	if err := os.WriteFile(file, []byte("# Routers\n198.51.100.0/24\n2001:db8::1 # rtr3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prefixes, err := parseACL("192.0.2.1, 10.0.0.0/8", file)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var values []string
+	for _, prefix := range prefixes {
+		values = append(values, prefix.String())
+	}
+	assert.Equal(t, []string{"192.0.2.1/32", "10.0.0.0/8", "198.51.100.0/24", "2001:db8::1/128"}, values)
+
+	prefixes, err = parseACL("", "")
+	assert.NoError(t, err)
+	assert.Nil(t, prefixes)
+	empty := filepath.Join(t.TempDir(), "empty")
+	assert.NoError(t, os.WriteFile(empty, []byte("# no router\n"), 0644))
+	prefixes, err = parseACL("", empty)
+	assert.NoError(t, err)
+	assert.NotNil(t, prefixes)
+	assert.Empty(t, prefixes)
+	_, err = parseACL("router1", "")
+	assert.Error(t, err)
+	_, err = parseACL("", filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestSourceACL(t *testing.T) {
+	acl := newSourceACL()
+	assert.True(t, acl.Allowed(&net.TCPAddr{IP: net.ParseIP("203.0.113.1")}))
+
+	prefixes, _ := parseACL("192.0.2.0/24,2001:db8::/32", "")
+	acl.Set(prefixes)
+	assert.True(t, acl.Allowed(&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}))
+	// On a dual-stack bind
+	assert.True(t, acl.Allowed(&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1")}))
+	assert.True(t, acl.Allowed(&net.TCPAddr{IP: net.ParseIP("2001:db8::1")}))
+	assert.False(t, acl.Allowed(&net.TCPAddr{IP: net.ParseIP("203.0.113.1")}))
+	assert.False(t, acl.Allowed(&net.TCPAddr{IP: net.ParseIP("2001:db9::1")}))
+
+	// An ACL emptied on reload allows none, and no ACL any address
+	acl.Set([]*net.IPNet{})
+	assert.False(t, acl.Allowed(&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}))
+	acl.Set(nil)
+	assert.True(t, acl.Allowed(&net.TCPAddr{IP: net.ParseIP("203.0.113.1")}))
+}
+
+func TestACLListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acl := newSourceACL()
+	prefixes, _ := parseACL("192.0.2.0/24", "")
+	acl.Set(prefixes)
+	aclln := &aclListener{Listener: ln, name: "bind", acl: acl}
+	defer aclln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		for {
+			conn, err := aclln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	dial := func() (net.Conn, error) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		select {
+		case server := <-accepted:
+			return server, nil
+		case <-time.After(200 * time.Millisecond):
+			return nil, os.ErrDeadlineExceeded
+		}
+	}
+
+	rejected := testutil.ToFloat64(ACLRejected.WithLabelValues(ln.Addr().String(), "plain://"+ln.Addr().String()))
+	_, err = dial()
+	assert.Error(t, err)
+	assert.Equal(t, rejected+1, testutil.ToFloat64(ACLRejected.WithLabelValues(ln.Addr().String(), "plain://"+ln.Addr().String())))
+
+	prefixes, _ = parseACL("127.0.0.0/8", "")
+	acl.Set(prefixes)
+	conn, err := dial()
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+}
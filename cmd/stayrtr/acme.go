@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeDomains returns the domains of a comma-separated list.
+func acmeDomains(list string) []string {
+	domains := make([]string, 0)
+	for _, domain := range strings.Split(list, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// newACMEManager returns the manager obtaining and renewing the certificates of the
+// domains from an ACME CA (Let's Encrypt unless directory is set), stored in cacheDir. The
+// terms of service of the CA must have been accepted by the operator.
+func newACMEManager(domains []string, email, cacheDir, directory string, acceptTOS bool) (*autocert.Manager, error) {
+	if len(domains) == 0 {
+		return nil, errors.New("no ACME domain")
+	}
+	if !acceptTOS {
+		return nil, errors.New("the terms of service of the ACME CA must be accepted with -tls.acme.accept-tos")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      email,
+	}
+	if cacheDir != "" {
+		m.Cache = autocert.DirCache(cacheDir)
+	}
+	if directory != "" {
+		m.Client = &acme.Client{DirectoryURL: directory}
+	}
+	return m, nil
+}
+
+// acmeTLSConfig returns the TLS configuration presenting the certificates of the manager.
+// The routers often connect by address, without a server name (SNI): they are presented
+// the certificate of the first domain.
+func acmeTLSConfig(m *autocert.Manager, domains []string) *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == "" {
+				named := *hello
+				named.ServerName = domains[0]
+				hello = &named
+			}
+			return m.GetCertificate(hello)
+		},
+		// Answers the tls-alpn-01 challenges when the TLS bind is on port 443
+		NextProtos: []string{acme.ALPNProto},
+	}
+}
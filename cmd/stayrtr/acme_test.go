@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACMEDomains(t *testing.T) {
+	assert.Equal(t, []string{"rtr1.example.net", "rtr2.example.net"}, acmeDomains(" rtr1.example.net, rtr2.example.net,"))
+	assert.Empty(t, acmeDomains(""))
+
+	_, err := newACMEManager(nil, "", "", "", true)
+	assert.Error(t, err)
+	_, err = newACMEManager([]string{"rtr.example.net"}, "", "", "", false)
+	assert.Error(t, err)
+}
+
+func TestACMETLSConfig(t *testing.T) {
+	// A certificate previously obtained, in the cache
+	dir := t.TempDir()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rtr.example.net"},
+		DNSNames:     []string{"rtr.example.net"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	if err := os.WriteFile(filepath.Join(dir, "rtr.example.net"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	domains := []string{"rtr.example.net"}
+	manager, err := newACMEManager(domains, "", dir, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := acmeTLSConfig(manager, domains)
+
+	// A router connecting by address, without a server name
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	go tls.Server(serverConn, config).Handshake()
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	defer clientConn.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if assert.NoError(t, client.Handshake()) {
+		assert.Equal(t, "rtr.example.net", client.ConnectionState().PeerCertificates[0].Subject.CommonName)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+type adminClient struct {
+	RemoteAddress string    `json:"remoteAddress"`
+	LocalAddress  string    `json:"localAddress"`
+	View          string    `json:"view"`
+	Version       uint8     `json:"version"`
+	Synced        bool      `json:"synced"`
+	Serial        uint32    `json:"serial"`
+	ConnectedAt   time.Time `json:"connectedAt"`
+	Uptime        float64   `json:"uptime"`
+}
+
+type adminServerState struct {
+	View       string `json:"view"`
+	SessionId  uint16 `json:"sessionId"`
+	Serial     uint32 `json:"serial"`
+	Valid      bool   `json:"valid"`
+	VRPs       int    `json:"vrps"`
+	BgpsecKeys int    `json:"bgpsecKeys"`
+	ASPAs      int    `json:"aspas"`
+}
+
+type adminState struct {
+	adminServerState
+	ActiveCache string             `json:"activeCache"`
+	LastChange  *time.Time         `json:"lastChange,omitempty"`
+	Views       []adminServerState `json:"views,omitempty"`
+}
+
+type viewServer struct {
+	view   string
+	server *rtr.Server
+}
+
+// rtrServers returns the default server followed by the servers of the views.
+func (s *state) rtrServers() []viewServer {
+	servers := []viewServer{{view: "default", server: s.server}}
+	for _, view := range s.views {
+		servers = append(servers, viewServer{view: view.name, server: view.server})
+	}
+	return servers
+}
+
+// adminClients returns the clients connected to the servers of all the views.
+func (s *state) adminClients(now time.Time) []adminClient {
+	clients := make([]adminClient, 0)
+	for _, view := range s.rtrServers() {
+		for _, c := range view.server.GetClientList() {
+			serial, synced := c.GetSyncedSerial()
+			clients = append(clients, adminClient{
+				RemoteAddress: c.GetRemoteAddress().String(),
+				LocalAddress:  c.GetLocalAddress().String(),
+				View:          view.view,
+				Version:       c.GetVersion(),
+				Synced:        synced,
+				Serial:        serial,
+				ConnectedAt:   c.GetConnectedAt().UTC(),
+				Uptime:        now.Sub(c.GetConnectedAt()).Seconds(),
+			})
+		}
+	}
+	return clients
+}
+
+func newAdminServerState(view viewServer) adminServerState {
+	vrps, serial, valid := view.server.GetCurrentVRPsSerial()
+	return adminServerState{
+		View:       view.view,
+		SessionId:  view.server.GetSessionId(),
+		Serial:     serial,
+		Valid:      valid,
+		VRPs:       len(vrps),
+		BgpsecKeys: len(view.server.GetCurrentBgpsecKeys()),
+		ASPAs:      len(view.server.GetCurrentASPAs()),
+	}
+}
+
+func writeAdminJSON(wr http.ResponseWriter, v interface{}) {
+	wr.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(wr)
+	enc.Encode(v)
+}
+
+// newAdminHandler serves the admin API, authenticated with a bearer token:
+//
+//	GET /clients: the connected clients
+//	POST /clients/disconnect?address=<ip:port>: disconnects a client
+//	POST /refresh: refreshes the cache and the Slurm file
+//	POST /slurm/reload: reloads the Slurm files now, without refreshing the cache
+//	GET /state: the session, serial and number of objects served
+func newAdminHandler(s *state, token string, refresh func()) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/clients", func(wr http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeAdminJSON(wr, s.adminClients(time.Now()))
+	})
+	mux.HandleFunc("/clients/disconnect", func(wr http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		address := r.URL.Query().Get("address")
+		if address == "" {
+			http.Error(wr, "Missing address", http.StatusBadRequest)
+			return
+		}
+		for _, view := range s.rtrServers() {
+			for _, c := range view.server.GetClientList() {
+				if c.GetRemoteAddress().String() == address {
+					log.Infof("Admin: disconnecting client %v", address)
+					c.Disconnect()
+					wr.WriteHeader(http.StatusNoContent)
+					return
+				}
+			}
+		}
+		http.Error(wr, "Client not found", http.StatusNotFound)
+	})
+	mux.HandleFunc("/refresh", func(wr http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		log.Info("Admin: refreshing the cache and the Slurm file")
+		refresh()
+		wr.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/slurm/reload", func(wr http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		log.Info("Admin: reloading the Slurm files")
+		if err := s.reloadSlurm(); err != nil {
+			log.Errorf("Slurm: %v", err)
+			http.Error(wr, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		wr.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/state", func(wr http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(wr, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		servers := s.rtrServers()
+		res := adminState{
+			adminServerState: newAdminServerState(servers[0]),
+		}
+		for _, view := range servers[1:] {
+			res.Views = append(res.Views, newAdminServerState(view))
+		}
+		s.lockJson.RLock()
+		res.ActiveCache = s.activeCache
+		if !s.lastchange.IsZero() {
+			lastchange := s.lastchange
+			res.LastChange = &lastchange
+		}
+		s.lockJson.RUnlock()
+		writeAdminJSON(wr, res)
+	})
+
+	return http.HandlerFunc(func(wr http.ResponseWriter, r *http.Request) {
+		if !checkBearerToken(r, token) {
+			wr.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(wr, r)
+	})
+}
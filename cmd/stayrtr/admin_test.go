@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/bgp/stayrtr/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func adminRequest(handler http.Handler, method string, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminHandler(t *testing.T) {
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 42}, nil, nil)
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496},
+	}, nil, []rtr.ASPA{{CustomerASN: 64496, Providers: []uint32{64500}}})
+	s := &state{
+		server:      server,
+		lockJson:    &sync.RWMutex{},
+		lockUpdate:  &sync.Mutex{},
+		activeCache: "https://a.example/vrps.json",
+		fetchConfig: utils.NewFetchConfig(),
+	}
+	refreshed := 0
+	handler := newAdminHandler(s, "secret", func() { refreshed++ })
+
+	req := httptest.NewRequest("GET", "/state", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = adminRequest(handler, "GET", "/state")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var st adminState
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &st))
+	assert.Equal(t, adminState{
+		adminServerState: adminServerState{View: "default", SessionId: 42, Serial: 0, Valid: true, VRPs: 1, ASPAs: 1},
+		ActiveCache:      "https://a.example/vrps.json",
+	}, st)
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	client := rtr.ClientFromConn(conn, server, nil)
+	server.ClientConnected(client)
+
+	rec = adminRequest(handler, "GET", "/clients")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var clients []adminClient
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &clients))
+	if assert.Len(t, clients, 1) {
+		assert.Equal(t, "pipe", clients[0].RemoteAddress)
+		assert.Equal(t, "default", clients[0].View)
+		assert.False(t, clients[0].Synced)
+	}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, adminRequest(handler, "GET", "/clients/disconnect?address=pipe").Code)
+	assert.Equal(t, http.StatusNotFound, adminRequest(handler, "POST", "/clients/disconnect?address=192.0.2.1:1234").Code)
+	assert.Equal(t, http.StatusNoContent, adminRequest(handler, "POST", "/clients/disconnect?address=pipe").Code)
+	assert.Len(t, server.GetClientList(), 0)
+
+	assert.Equal(t, http.StatusAccepted, adminRequest(handler, "POST", "/refresh").Code)
+	assert.Equal(t, 1, refreshed)
+
+	assert.Equal(t, http.StatusNoContent, adminRequest(handler, "POST", "/slurm/reload").Code)
+	s.slurmPaths = []string{filepath.Join(t.TempDir(), "missing.json")}
+	assert.Equal(t, http.StatusInternalServerError, adminRequest(handler, "POST", "/slurm/reload").Code)
+}
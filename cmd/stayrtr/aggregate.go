@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/netip"
+	"sort"
+
+	"github.com/bgp/stayrtr/prefixfile"
+)
+
+type aggregateVRP struct {
+	index  int
+	vrp    prefixfile.VRPJson
+	prefix netip.Prefix
+	asn    uint32
+	plen   int
+}
+
+type aggregateKey struct {
+	asn    uint32
+	prefix netip.Prefix
+}
+
+// aggregateVRPs removes the VRPs covered by another VRP of the same ASN with a maxLength
+// at least as long. The routes matched by a removed VRP are matched by the covering one and
+// the routes it covers are covered as well: the validation results are unchanged.
+// Contiguous VRPs are not merged, since the aggregate would also match the covering prefix.
+// Entries that are not valid VRPs are kept as they are, the order is preserved.
+func aggregateVRPs(vrps []prefixfile.VRPJson) []prefixfile.VRPJson {
+	parsed := make([]aggregateVRP, 0, len(vrps))
+	for i, vrp := range vrps {
+		prefix, err := vrp.GetNetipPrefix()
+		if err != nil {
+			continue
+		}
+		prefix = prefix.Masked()
+		asn, err := vrp.GetASN2()
+		if err != nil {
+			continue
+		}
+		plen := prefix.Bits()
+		parsed = append(parsed, aggregateVRP{
+			index:  i,
+			vrp:    vrp,
+			prefix: prefix,
+			asn:    asn,
+			plen:   plen,
+		})
+	}
+
+	// Less specifics and longer maxLengths first, so that covering VRPs are kept before the covered ones
+	sort.SliceStable(parsed, func(i, j int) bool {
+		if parsed[i].plen != parsed[j].plen {
+			return parsed[i].plen < parsed[j].plen
+		}
+		return parsed[i].vrp.Length > parsed[j].vrp.Length
+	})
+
+	// Longest maxLength kept per ASN and prefix
+	kept := make(map[aggregateKey]uint8)
+	removed := make(map[int]bool)
+	for _, v := range parsed {
+		covered := false
+		for l := v.plen; l >= 0 && !covered; l-- {
+			covering, _ := v.prefix.Addr().Prefix(l)
+			if maxLen, ok := kept[aggregateKey{v.asn, covering}]; ok && maxLen >= v.vrp.Length {
+				covered = true
+			}
+		}
+		if covered {
+			removed[v.index] = true
+			continue
+		}
+		kept[aggregateKey{v.asn, v.prefix}] = v.vrp.Length
+	}
+
+	res := make([]prefixfile.VRPJson, 0, len(vrps)-len(removed))
+	for i, vrp := range vrps {
+		if !removed[i] {
+			res = append(res, vrp)
+		}
+	}
+	return res
+}
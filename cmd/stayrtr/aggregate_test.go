@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateVRPs(t *testing.T) {
+	vrps := []prefixfile.VRPJson{
+		{Prefix: "192.0.2.0/24", Length: 26, ASN: uint32(64496)},
+		// Covered by the previous one
+		{Prefix: "192.0.2.128/25", Length: 26, ASN: uint32(64496)},
+		{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64496"},
+		// maxLength longer than the covering VRP
+		{Prefix: "192.0.2.0/25", Length: 27, ASN: uint32(64496)},
+		// Another ASN
+		{Prefix: "192.0.2.0/25", Length: 25, ASN: uint32(64497)},
+		// Contiguous VRPs are kept
+		{Prefix: "198.51.100.0/25", Length: 25, ASN: uint32(64496)},
+		{Prefix: "198.51.100.128/25", Length: 25, ASN: uint32(64496)},
+		{Prefix: "2001:db8::/32", Length: 48, ASN: uint32(64496)},
+		{Prefix: "2001:db8:1::/48", Length: 48, ASN: uint32(64496)},
+		{Prefix: "not a prefix", Length: 24, ASN: uint32(64496)},
+	}
+	got := aggregateVRPs(vrps)
+	want := []prefixfile.VRPJson{vrps[0], vrps[3], vrps[4], vrps[5], vrps[6], vrps[7], vrps[9]}
+	assert.Equal(t, want, got)
+}
+
+// TestAggregateVRPsValidation checks that the validation of random routes is the same
+// against random VRPs and their aggregation.
+func TestAggregateVRPsValidation(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+
+	// Small ranges so that VRPs overlap
+	randomPrefix := func(minLen, maxLen int) (string, int) {
+		plen := minLen + r.Intn(maxLen-minLen+1)
+		ip := net.IPv4(10, byte(r.Intn(4)), byte(r.Intn(256)), 0)
+		_, prefix, _ := net.ParseCIDR(fmt.Sprintf("%s/%d", ip, plen))
+		return prefix.String(), plen
+	}
+
+	vrps := make([]prefixfile.VRPJson, 0)
+	for i := 0; i < 2000; i++ {
+		prefix, plen := randomPrefix(14, 24)
+		vrps = append(vrps, prefixfile.VRPJson{
+			Prefix: prefix,
+			Length: uint8(plen + r.Intn(25-plen)),
+			ASN:    uint32(64496 + r.Intn(3)),
+		})
+	}
+	aggregated := aggregateVRPs(vrps)
+	assert.Less(t, len(aggregated), len(vrps))
+
+	all, _ := processData(vrps, false)
+	reduced, _ := processData(aggregated, false)
+	for i := 0; i < 5000; i++ {
+		prefix, _ := randomPrefix(12, 26)
+		asn := uint32(64496 + r.Intn(4))
+		route := mustParsePrefix(prefix)
+		want := validateOrigin(all, route, asn).State
+		got := validateOrigin(reduced, route, asn).State
+		if want != got {
+			t.Fatalf("%v AS%d: state %d before aggregation, %d after", prefix, asn, want, got)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"strings"
+)
+
+// stringList is the value of a flag with several values (addresses, files or URLs),
+// repeated and/or comma-separated (e.g. -bind 192.0.2.1:323 -bind [2001:db8::1]:323).
+// Setting it replaces the default value, and an empty value disables it.
+type stringList struct {
+	values []string
+	set    bool
+}
+
+func stringListFlag(name string, value string, usage string) *stringList {
+	l := &stringList{}
+	l.add(value)
+	flag.Var(l, name, usage)
+	return l
+}
+
+func (l *stringList) add(value string) {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l.values = append(l.values, v)
+		}
+	}
+}
+
+func (l *stringList) String() string {
+	return strings.Join(l.values, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	if !l.set {
+		l.values = nil
+		l.set = true
+	}
+	l.add(value)
+	return nil
+}
+
+func (l *stringList) Values() []string {
+	return l.values
+}
+
+// flagAliases maps the other names of the flags to their name.
+var flagAliases = make(map[string]string)
+
+// aliasFlag registers another name for a flag, sharing its value.
+func aliasFlag(alias string, name string) flag.Value {
+	value := flag.Lookup(name).Value
+	flag.Var(value, alias, "Alias of -"+name)
+	flagAliases[alias] = name
+	return value
+}
+
+// flagName returns the name of a flag, given its name or one of its aliases.
+func flagName(name string) string {
+	if aliased, ok := flagAliases[name]; ok {
+		return aliased
+	}
+	return name
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringList(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	bind := &stringList{}
+	bind.add(":8282")
+	fs.Var(bind, "bind", "")
+	assert.Equal(t, ":8282", fs.Lookup("bind").DefValue)
+
+	assert.NoError(t, fs.Parse([]string{"-bind", "192.0.2.1:323", "-bind", "[2001:db8::1]:323, 127.0.0.1:323"}))
+	assert.Equal(t, []string{"192.0.2.1:323", "[2001:db8::1]:323", "127.0.0.1:323"}, bind.Values())
+	assert.Equal(t, "192.0.2.1:323,[2001:db8::1]:323,127.0.0.1:323", bind.String())
+
+	disabled := &stringList{}
+	disabled.add(":8282")
+	assert.NoError(t, disabled.Set(""))
+	assert.Empty(t, disabled.Values())
+}
+
+func TestListenersSameName(t *testing.T) {
+	l := newListeners(false)
+	defer l.Close()
+	first, err := l.Listen("bind", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := l.Listen("bind", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, names, err := l.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeFiles(files)
+	assert.Equal(t, []string{"bind", "bind"}, names)
+
+	// Inherited in the same order
+	next := newListeners(false)
+	for _, f := range files {
+		ln, err := net.FileListener(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next.inherited["bind"] = append(next.inherited["bind"], ln)
+	}
+	defer next.Close()
+	ln, err := next.Listen("bind", "")
+	assert.NoError(t, err)
+	assert.Equal(t, first.Addr().String(), ln.Addr().String())
+	ln, err = next.Listen("bind", "")
+	assert.NoError(t, err)
+	assert.Equal(t, second.Addr().String(), ln.Addr().String())
+	assert.Empty(t, next.inherited)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/bgp/stayrtr/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// splitList returns the files of a comma-separated flag, such as the caches of -cache in
+// priority order.
+func splitList(list string) []string {
+	var files []string
+	for _, file := range strings.Split(list, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// checkStale returns an error if the buildtime of the data is too old (see checkBuildtime),
+// when the buildtime is checked. With the warn policy, stale data is only logged.
+func (s *state) checkStale(data *prefixfile.VRPList) error {
+	if !s.checktime {
+		return nil
+	}
+	buildtime, err := time.Parse(time.RFC3339, data.Metadata.Buildtime)
+	if err != nil {
+		return err
+	}
+	err = checkBuildtime(buildtime, time.Now().UTC(), s.checktimeThreshold, s.checktimeSkew)
+	if err != nil && !s.stale {
+		s.webhooks.fire(webhookEvent{Event: WEBHOOK_STALE, Error: err.Error()}, time.Now())
+	}
+	s.stale = err != nil
+	if err != nil && s.stalePolicy == STALE_POLICY_WARN {
+		log.Warnf("%v, serving it anyway", err)
+		return nil
+	}
+	return err
+}
+
+// expireStale withdraws the data served once it is stale with the expire stale policy, or
+// once the cache was not refreshed for the expire interval of the routers with the
+// withdraw and reset expire policies, so that the routers stop using it rather than keep
+// it for ever. It is served again with the next refresh which is not stale.
+func (s *state) expireStale() {
+	if s.expired || s.lastdata.Data == nil {
+		return
+	}
+	var reason error
+	if s.stalePolicy == STALE_POLICY_EXPIRE {
+		reason = s.checkStale(s.lastdata)
+	}
+	if reason == nil && (s.expirePolicy == EXPIRE_POLICY_WITHDRAW || s.expirePolicy == EXPIRE_POLICY_RESET) && s.expire > 0 {
+		s.lockJson.RLock()
+		refreshed := s.refreshed
+		s.lockJson.RUnlock()
+		if age := time.Since(refreshed); !refreshed.IsZero() && age > s.expire {
+			reason = fmt.Errorf("cache not refreshed for %v", age.Round(time.Second))
+		}
+	}
+	if reason == nil {
+		return
+	}
+
+	log.Errorf("%v, withdrawing the data served", reason)
+	for _, view := range s.rtrServers() {
+		view.server.AddData(nil, nil, nil)
+		if s.expirePolicy == EXPIRE_POLICY_RESET {
+			// The clients download the data again, without any object
+			for _, c := range view.server.GetClientList() {
+				c.SendCacheReset()
+			}
+		}
+		if s.sendNotifs && s.expirePolicy != EXPIRE_POLICY_RESET {
+			view.server.NotifyClientsLatest()
+		} else {
+			serial, _ := view.server.GetCurrentSerial(view.server.GetSessionId())
+			view.server.NotifySubscribers(serial)
+		}
+	}
+	s.expired = true
+}
+
+// getActiveCache returns the cache the data is served from.
+func (s *state) getActiveCache() string {
+	s.lockJson.RLock()
+	defer s.lockJson.RUnlock()
+	return s.activeCache
+}
+
+// setActiveCache records the cache the data was refreshed from, and when.
+func (s *state) setActiveCache(file string) {
+	s.lockJson.Lock()
+	previous := s.activeCache
+	s.activeCache = file
+	s.refreshed = time.Now()
+	s.lockJson.Unlock()
+
+	if previous != file {
+		if previous != "" {
+			log.Warnf("Serving the data of cache %v instead of %v", file, previous)
+		}
+		for _, cache := range s.caches {
+			active := 0.0
+			if cache == file {
+				active = 1
+			}
+			CacheActive.WithLabelValues(cache).Set(active)
+		}
+	}
+}
+
+// updateCaches refreshes the data from the first cache, in priority order, which can be
+// fetched and is not stale. A cache which is not in use is downloaded in full, as its
+// data must be loaded even if it did not change since it was last fetched.
+func (s *state) updateCaches() (bool, error) {
+	err := errors.New("no cache configured")
+	for i, file := range s.caches {
+		active := file == s.getActiveCache()
+		if !active {
+			s.fetchConfig.Forget(file)
+		}
+
+		var updated bool
+		updated, err = s.updateFile(file)
+		switch err.(type) {
+		case nil:
+		case utils.HttpNotModified, utils.IdenticalEtag, IdenticalFile:
+			log.Info(err)
+			// The data served did not change, but it may have become stale
+			err = s.checkStale(s.lastdata)
+		}
+		if err == nil {
+			s.setActiveCache(file)
+			return updated, nil
+		}
+		if i < len(s.caches)-1 {
+			log.WithField("source", file).Warnf("Error updating from %v, trying %v: %v", file, s.caches[i+1], err)
+		}
+	}
+	return false, err
+}
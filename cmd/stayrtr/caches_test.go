@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/bgp/stayrtr/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitList(t *testing.T) {
+	assert.Equal(t, []string{"https://a.example/vrps.json", "b.json"}, splitList(" https://a.example/vrps.json,,b.json "))
+	assert.Nil(t, splitList(""))
+}
+
+func TestUpdateCaches(t *testing.T) {
+	fresh := time.Now().UTC().Format(time.RFC3339)
+	stale := time.Now().UTC().Add(-48 * time.Hour).Format(time.RFC3339)
+	cacheJSON := func(buildtime string, asn int) string {
+		return fmt.Sprintf(`{"metadata": {"buildtime": %q}, "roas": [{"prefix": "192.0.2.0/24", "maxLength": 24, "asn": %d}]}`, buildtime, asn)
+	}
+
+	var lock sync.Mutex
+	primaryStatus := http.StatusInternalServerError
+	primaryData := cacheJSON(fresh, 64496)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		w.Header().Set("ETag", `"primary"`)
+		if primaryStatus != http.StatusOK {
+			w.WriteHeader(primaryStatus)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"primary"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(primaryData))
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(cacheJSON(fresh, 64497)))
+	}))
+	defer secondary.Close()
+
+	s := &state{
+		caches:      []string{primary.URL, secondary.URL},
+		lastdata:    &prefixfile.VRPList{},
+		checktime:   true,
+		lockJson:    &sync.RWMutex{},
+		fetchConfig: utils.NewFetchConfig(),
+
+		checktimeThreshold: 24 * time.Hour,
+	}
+	s.fetchConfig.EnableEtags = true
+	asn := func() interface{} {
+		return s.lastdata.Data[0].ASN
+	}
+
+	// The primary fails
+	updated, err := s.updateCaches()
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, secondary.URL, s.getActiveCache())
+	assert.Equal(t, float64(64497), asn())
+	assert.Equal(t, 0.0, testutil.ToFloat64(CacheActive.WithLabelValues(primary.URL)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(CacheActive.WithLabelValues(secondary.URL)))
+
+	// The primary is back
+	lock.Lock()
+	primaryStatus = http.StatusOK
+	lock.Unlock()
+	updated, err = s.updateCaches()
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, primary.URL, s.getActiveCache())
+	assert.Equal(t, float64(64496), asn())
+
+	// The primary is not modified
+	updated, err = s.updateCaches()
+	assert.NoError(t, err)
+	assert.False(t, updated)
+	assert.Equal(t, primary.URL, s.getActiveCache())
+
+	// The primary is stale
+	lock.Lock()
+	primaryData = cacheJSON(stale, 64498)
+	lock.Unlock()
+	s.fetchConfig.Forget(primary.URL)
+	updated, err = s.updateCaches()
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, secondary.URL, s.getActiveCache())
+	assert.Equal(t, float64(64497), asn())
+
+	// All the caches fail
+	s.caches = []string{primary.URL}
+	_, err = s.updateCaches()
+	assert.Error(t, err)
+	assert.Equal(t, secondary.URL, s.getActiveCache())
+}
+
+func TestStalePolicy(t *testing.T) {
+	stale := &prefixfile.VRPList{
+		Metadata: prefixfile.MetaData{Buildtime: time.Now().UTC().Add(-2 * time.Hour).Format(time.RFC3339)},
+		Data:     []prefixfile.VRPJson{{Prefix: "192.0.2.0/24", Length: 24, ASN: 64496}},
+	}
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3}, nil, nil)
+	server.AddData([]rtr.VRP{{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496}}, nil, nil)
+	s := &state{
+		server:             server,
+		lastdata:           stale,
+		checktime:          true,
+		checktimeThreshold: time.Hour,
+		lockJson:           &sync.RWMutex{},
+	}
+
+	assert.Error(t, s.checkStale(stale))
+	s.expireStale()
+	vrps, _ := server.GetCurrentVRPs()
+	assert.Len(t, vrps, 1)
+
+	s.stalePolicy = STALE_POLICY_WARN
+	assert.NoError(t, s.checkStale(stale))
+
+	s.stalePolicy = STALE_POLICY_EXPIRE
+	s.expireStale()
+	vrps, _ = server.GetCurrentVRPs()
+	assert.Empty(t, vrps)
+	assert.True(t, s.expired)
+}
+
+func TestExpirePolicy(t *testing.T) {
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3}, nil, nil)
+	server.AddData([]rtr.VRP{{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496}}, nil, nil)
+	s := &state{
+		server: server,
+		lastdata: &prefixfile.VRPList{
+			Data: []prefixfile.VRPJson{{Prefix: "192.0.2.0/24", Length: 24, ASN: 64496}},
+		},
+		lockJson: &sync.RWMutex{},
+		expire:   time.Hour,
+	}
+	s.refreshed = time.Now().Add(-2 * time.Hour)
+
+	s.expireStale()
+	vrps, _ := server.GetCurrentVRPs()
+	assert.Len(t, vrps, 1)
+
+	s.expirePolicy = EXPIRE_POLICY_WITHDRAW
+	s.refreshed = time.Now()
+	s.expireStale()
+	assert.False(t, s.expired)
+
+	s.refreshed = time.Now().Add(-2 * time.Hour)
+	s.expireStale()
+	vrps, _ = server.GetCurrentVRPs()
+	assert.Empty(t, vrps)
+	assert.True(t, s.expired)
+}
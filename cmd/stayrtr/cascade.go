@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+// Delay before connecting again to the RTR cache to receive its notifications.
+const cascadeRetry = 30 * time.Second
+
+// rtrCascade follows the RTR cache the data was last downloaded from (-cache.rtr.cascade):
+// its session and serial, served as is where possible, and its notifications.
+type rtrCascade struct {
+	lock *sync.Mutex
+
+	cache   string
+	version uint8
+	session uint16
+	serial  uint32
+	// Time of the first Serial Notify of the cache not propagated to the clients yet
+	notified time.Time
+}
+
+func newRTRCascade() *rtrCascade {
+	return &rtrCascade{lock: &sync.Mutex{}}
+}
+
+// downloaded records the session and the serial of the data downloaded from a cache.
+// When the data did not change, there is no notification to propagate.
+func (c *rtrCascade) downloaded(cache string, client *rtrCacheClient, changed bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.cache = cache
+	c.version = client.version
+	c.session = client.sessionId
+	c.serial = client.serial
+	if !changed {
+		c.notified = time.Time{}
+	}
+	CascadeSerial.WithLabelValues(cache).Set(float64(client.serial))
+}
+
+// upstream returns the cache the data was downloaded from, with its version, session and serial.
+func (c *rtrCascade) upstream() (string, uint8, uint16, uint32) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.cache, c.version, c.session, c.serial
+}
+
+// notify records a Serial Notify of a cache, and returns whether it announces new data.
+func (c *rtrCascade) notify(cache string, session uint16, serial uint32, now time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cache != c.cache || (session == c.session && serial == c.serial) {
+		return false
+	}
+	if c.notified.IsZero() {
+		c.notified = now
+	}
+	return true
+}
+
+// propagated reports the time elapsed since the notification of the cache, once the
+// clients are notified in turn.
+func (c *rtrCascade) propagated(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.notified.IsZero() {
+		CascadePropagation.Observe(now.Sub(c.notified).Seconds())
+		c.notified = time.Time{}
+	}
+}
+
+// cascadeSerial returns the serial to serve the data of the RTR cache under, after taking its
+// session ID. The serials diverge when the data changes without a newer serial of the cache,
+// for instance after a change of the Slurm files: the next serial is used instead.
+func (s *state) cascadeSerial() (uint32, bool) {
+	if s.cascade == nil {
+		return 0, false
+	}
+	cache, _, session, serial := s.cascade.upstream()
+	if cache == "" || cache != s.getActiveCache() {
+		return 0, false
+	}
+	if session != s.server.GetSessionId() {
+		log.WithField("source", cache).Infof("Using the session ID %d of the RTR cache", session)
+		s.server.SetSessionId(session)
+	}
+	current, valid := s.server.GetCurrentSerial(session)
+	// Serial number arithmetic (RFC 1982)
+	if valid && int32(serial-current) <= 0 {
+		log.WithField("source", cache).Warnf("Serial %d of the RTR cache is not newer than %d, using the next serial", serial, current)
+		return 0, false
+	}
+	return serial, true
+}
+
+// rtrNotifyClient waits for the Serial Notify of a cache.
+type rtrNotifyClient struct {
+	cache   string
+	session uint16
+	serial  uint32
+	cascade *rtrCascade
+	trigger func()
+	err     error
+}
+
+func (c *rtrNotifyClient) HandlePDU(cs *rtr.ClientSession, pdu rtr.PDU) {
+	if cache, _, _, _ := c.cascade.upstream(); cache != c.cache {
+		c.err = fmt.Errorf("the data is now served from %v", cache)
+		cs.Disconnect()
+		return
+	}
+	switch pdu := pdu.(type) {
+	case *rtr.PDUSerialNotify:
+		log.WithField("source", c.cache).Debugf("Serial Notify of the RTR cache: %v", pdu)
+		if c.cascade.notify(c.cache, pdu.SessionId, pdu.SerialNumber, time.Now()) {
+			c.trigger()
+		}
+	case *rtr.PDUErrorReport:
+		c.err = fmt.Errorf("error report from the RTR server (code %d)", pdu.ErrorCode)
+		cs.Disconnect()
+	}
+}
+
+func (c *rtrNotifyClient) ClientConnected(cs *rtr.ClientSession) {
+	// A query makes the session known to the cache, the answer is ignored: the data is
+	// downloaded again by the refresh triggered by the notifications
+	cs.SendSerialQuery(c.session, c.serial)
+}
+
+func (c *rtrNotifyClient) ClientDisconnected(cs *rtr.ClientSession) {
+}
+
+// watchRTR keeps a session to the RTR cache the data is served from, until it is closed.
+func (s *state) watchRTR(trigger func()) (string, error) {
+	cache, version, session, serial := s.cascade.upstream()
+	if cache == "" {
+		return "", nil
+	}
+	conn, err := s.dialRTR(cache)
+	if err != nil {
+		return cache, err
+	}
+	client := &rtrNotifyClient{
+		cache:   cache,
+		session: session,
+		serial:  serial,
+		cascade: s.cascade,
+		trigger: trigger,
+	}
+	err = rtr.NewClientSession(rtr.ClientConfiguration{ProtocolVersion: version}, client).StartWithConn(conn)
+	if client.err != nil {
+		err = client.err
+	}
+	return cache, err
+}
+
+// routineCascade updates as soon as the RTR cache the data is served from sends a Serial
+// Notify, rather than on the next refresh.
+func (s *state) routineCascade(trigger func()) {
+	for {
+		cache, err := s.watchRTR(trigger)
+		if cache != "" {
+			log.WithField("source", cache).Warnf("Session to the RTR cache closed, reconnecting in %v: %v", cascadeRetry, err)
+		}
+		time.Sleep(cascadeRetry)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/bgp/stayrtr/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCascade(t *testing.T) {
+	upstream, cache := startRTRCache(t, rtr.PROTOCOL_VERSION_2)
+	upstream.AddDataSerial([]rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496},
+	}, nil, nil, 100)
+
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 7}, nil, nil)
+	s := &state{
+		server:      server,
+		caches:      []string{cache},
+		lastdata:    &prefixfile.VRPList{},
+		lockJson:    &sync.RWMutex{},
+		fetchConfig: utils.NewFetchConfig(),
+		rtrTimeout:  5 * time.Second,
+		cascade:     newRTRCascade(),
+	}
+	update := func() {
+		updated, err := s.updateCaches()
+		assert.NoError(t, err)
+		assert.True(t, updated)
+		assert.NoError(t, s.updateFromNewState())
+	}
+	serial := func() uint32 {
+		serial, _ := server.GetCurrentSerial(server.GetSessionId())
+		return serial
+	}
+	notified := func() bool {
+		s.cascade.lock.Lock()
+		defer s.cascade.lock.Unlock()
+		return !s.cascade.notified.IsZero()
+	}
+
+	update()
+	assert.Equal(t, uint16(42), server.GetSessionId())
+	assert.Equal(t, uint32(100), serial())
+	assert.Equal(t, 100.0, testutil.ToFloat64(CascadeSerial.WithLabelValues(cache)))
+
+	triggered := make(chan struct{}, 1)
+	go s.routineCascade(func() {
+		triggered <- struct{}{}
+	})
+	// Wait for the session to the cache
+	assert.Eventually(t, func() bool { return len(upstream.GetClientList()) == 1 }, time.Second, 10*time.Millisecond)
+
+	upstream.AddDataSerial([]rtr.VRP{
+		{Prefix: mustParsePrefix("198.51.100.0/24"), MaxLen: 24, ASN: 64497},
+	}, nil, nil, 105)
+	upstream.NotifyClientsLatest()
+	select {
+	case <-triggered:
+	case <-time.After(time.Second):
+		t.Fatal("no update triggered by the Serial Notify")
+	}
+	assert.True(t, notified())
+
+	update()
+	assert.Equal(t, uint32(105), serial())
+	assert.False(t, notified())
+
+	// A local change, without a new serial of the cache
+	assert.NoError(t, s.updateFromNewState())
+	assert.Equal(t, uint32(106), serial())
+}
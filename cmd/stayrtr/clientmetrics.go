@@ -0,0 +1,110 @@
+package main
+
+import (
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// clientsCollector reports the state of the connected clients, labeled by their IP address
+// (without the port, which changes with every connection) and view. The series are built
+// from the clients connected at each scrape, so that those of a router disappear when it
+// disconnects.
+type clientsCollector struct {
+	s *state
+
+	serial    *prometheus.Desc
+	version   *prometheus.Desc
+	uptime    *prometheus.Desc
+	pdusSent  *prometheus.Desc
+	bytesSent *prometheus.Desc
+}
+
+func newClientsCollector(s *state) *clientsCollector {
+	labels := []string{"address", "view"}
+	return &clientsCollector{
+		s:         s,
+		serial:    prometheus.NewDesc("rtr_client_serial", "Serial of the last End of Data sent to the client.", labels, nil),
+		version:   prometheus.NewDesc("rtr_client_version", "Version of the RTR protocol negotiated with the client.", labels, nil),
+		uptime:    prometheus.NewDesc("rtr_client_uptime_seconds", "Seconds since the client connected.", labels, nil),
+		pdusSent:  prometheus.NewDesc("rtr_client_pdus_sent_total", "Total number of PDUs sent to the client.", labels, nil),
+		bytesSent: prometheus.NewDesc("rtr_client_sent_bytes_total", "Total number of bytes sent to the client.", labels, nil),
+	}
+}
+
+func (c *clientsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.serial
+	ch <- c.version
+	ch <- c.uptime
+	ch <- c.pdusSent
+	ch <- c.bytesSent
+}
+
+// clientAddressLabel returns the IP address of a client, or its address when it has none
+// (e.g. over a unix socket).
+func clientAddressLabel(client *rtr.Client) string {
+	addr := client.GetRemoteAddress()
+	if ip := addrIP(addr); ip != nil {
+		return ip.String()
+	}
+	return addr.String()
+}
+
+// routerSessions is the state of the sessions of a router (the clients with the same address
+// and view): the lowest serial and version, the oldest session and the sum of the counters.
+type routerSessions struct {
+	sessions  int
+	serial    uint32
+	synced    bool
+	version   uint8
+	connected time.Time
+	pdusSent  uint64
+	bytesSent uint64
+}
+
+func (r *routerSessions) add(client *rtr.Client) {
+	// A client which did not complete a query yet has no serial
+	if serial, synced := client.GetSyncedSerial(); synced && (!r.synced || serial < r.serial) {
+		r.serial, r.synced = serial, true
+	}
+	if version := client.GetVersion(); r.sessions == 0 || version < r.version {
+		r.version = version
+	}
+	if connected := client.GetConnectedAt(); r.sessions == 0 || connected.Before(r.connected) {
+		r.connected = connected
+	}
+	r.pdusSent += client.GetPDUsSent()
+	r.bytesSent += client.GetBytesSent()
+	r.sessions++
+}
+
+func (c *clientsCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for _, view := range c.s.rtrServers() {
+		// A router can have several sessions, e.g. while it reconnects
+		routers := make(map[string]*routerSessions)
+		var addresses []string
+		for _, client := range view.server.GetClientList() {
+			address := clientAddressLabel(client)
+			router, ok := routers[address]
+			if !ok {
+				router = &routerSessions{}
+				routers[address] = router
+				addresses = append(addresses, address)
+			}
+			router.add(client)
+		}
+		for _, address := range addresses {
+			router := routers[address]
+			labels := []string{address, view.view}
+			if router.synced {
+				ch <- prometheus.MustNewConstMetric(c.serial, prometheus.GaugeValue, float64(router.serial), labels...)
+			}
+			ch <- prometheus.MustNewConstMetric(c.version, prometheus.GaugeValue, float64(router.version), labels...)
+			ch <- prometheus.MustNewConstMetric(c.uptime, prometheus.GaugeValue, now.Sub(router.connected).Seconds(), labels...)
+			ch <- prometheus.MustNewConstMetric(c.pdusSent, prometheus.CounterValue, float64(router.pdusSent), labels...)
+			ch <- prometheus.MustNewConstMetric(c.bytesSent, prometheus.CounterValue, float64(router.bytesSent), labels...)
+		}
+	}
+}
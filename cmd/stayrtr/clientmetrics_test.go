@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientsCollector(t *testing.T) {
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 42}, nil, nil)
+	s := &state{server: server}
+	collector := newClientsCollector(s)
+	assert.Equal(t, 0, testutil.CollectAndCount(collector))
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	client := rtr.ClientFromConn(conn, server, nil)
+	client.SetVersion(rtr.PROTOCOL_VERSION_1)
+	server.ClientConnected(client)
+	defer client.Disconnect()
+
+	// Not synced yet: no serial
+	assert.Equal(t, 4, testutil.CollectAndCount(collector))
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP rtr_client_pdus_sent_total Total number of PDUs sent to the client.
+# TYPE rtr_client_pdus_sent_total counter
+rtr_client_pdus_sent_total{address="pipe",view="default"} 0
+# HELP rtr_client_version Version of the RTR protocol negotiated with the client.
+# TYPE rtr_client_version gauge
+rtr_client_version{address="pipe",view="default"} 1
+`), "rtr_client_pdus_sent_total", "rtr_client_version"))
+
+	// Another session of the same router: a single series, with the lowest version
+	conn2, peer2 := net.Pipe()
+	defer peer2.Close()
+	client2 := rtr.ClientFromConn(conn2, server, nil)
+	client2.SetVersion(rtr.PROTOCOL_VERSION_0)
+	server.ClientConnected(client2)
+	defer client2.Disconnect()
+	assert.Equal(t, 4, testutil.CollectAndCount(collector))
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP rtr_client_version Version of the RTR protocol negotiated with the client.
+# TYPE rtr_client_version gauge
+rtr_client_version{address="pipe",view="default"} 0
+`), "rtr_client_version"))
+}
+
+func TestClientAddressLabel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err == nil {
+			defer conn.Close()
+			conn.Read(make([]byte, 1))
+		}
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Without the ephemeral port of the router
+	assert.Equal(t, "127.0.0.1", clientAddressLabel(rtr.ClientFromConn(conn, nil, nil)))
+}
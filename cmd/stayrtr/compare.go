@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/bgp/stayrtr/prefixfile"
+)
+
+// compareKey identifies a VRP independently of its JSON representation
+// (ASN as a number or a string, non-canonical prefix).
+type compareKey struct {
+	Prefix string
+	ASN    uint32
+	MaxLen uint8
+}
+
+// compareResult is the difference between the served VRPs and a posted set:
+// Added are only in the posted set, Removed are only served.
+type compareResult struct {
+	Added   []prefixfile.VRPJson `json:"added"`
+	Removed []prefixfile.VRPJson `json:"removed"`
+	// Entries of the posted set that are not valid VRPs
+	Invalid int `json:"invalid"`
+}
+
+// compareSet indexes a list of VRPs, skipping the invalid ones and returns how many were skipped.
+func compareSet(vrps []prefixfile.VRPJson) (map[compareKey]prefixfile.VRPJson, int) {
+	set := make(map[compareKey]prefixfile.VRPJson, len(vrps))
+	var invalid int
+	for _, v := range vrps {
+		prefix, err := v.GetPrefix2()
+		if err != nil {
+			invalid++
+			continue
+		}
+		asn, err := v.GetASN2()
+		if err != nil {
+			invalid++
+			continue
+		}
+		key := compareKey{
+			Prefix: prefix.String(),
+			ASN:    asn,
+			MaxLen: v.Length,
+		}
+		set[key] = prefixfile.VRPJson{
+			Prefix: key.Prefix,
+			Length: key.MaxLen,
+			ASN:    key.ASN,
+			TA:     v.TA,
+		}
+	}
+	return set, invalid
+}
+
+// onlyIn returns the VRPs of a missing from b, sorted.
+func onlyIn(a, b map[compareKey]prefixfile.VRPJson) []prefixfile.VRPJson {
+	res := make([]prefixfile.VRPJson, 0)
+	for key, v := range a {
+		if _, ok := b[key]; !ok {
+			res = append(res, v)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Prefix != res[j].Prefix {
+			return res[i].Prefix < res[j].Prefix
+		}
+		if res[i].Length != res[j].Length {
+			return res[i].Length < res[j].Length
+		}
+		return res[i].GetASN() < res[j].GetASN()
+	})
+	return res
+}
+
+func compareVRPs(served, candidate []prefixfile.VRPJson) compareResult {
+	servedSet, _ := compareSet(served)
+	candidateSet, invalid := compareSet(candidate)
+	return compareResult{
+		Added:   onlyIn(candidateSet, servedSet),
+		Removed: onlyIn(servedSet, candidateSet),
+		Invalid: invalid,
+	}
+}
+
+func (s *state) compare(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		wr.Header().Set("Allow", http.MethodPost)
+		http.Error(wr, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var candidate prefixfile.VRPList
+	body := http.MaxBytesReader(wr, r.Body, s.compareMaxBytes)
+	if err := json.NewDecoder(body).Decode(&candidate); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(wr, fmt.Sprintf("Request body larger than %d bytes", s.compareMaxBytes), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(wr, fmt.Sprintf("Could not decode VRPs: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	s.lockJson.RLock()
+	served := s.exported.Data
+	s.lockJson.RUnlock()
+
+	wr.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(wr)
+	enc.Encode(compareVRPs(served, candidate.Data))
+}
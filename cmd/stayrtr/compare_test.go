@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	s := &state{
+		lockJson: &sync.RWMutex{},
+		exported: prefixfile.VRPList{
+			Data: []prefixfile.VRPJson{
+				{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496), TA: "testrir"},
+				{Prefix: "2001:db8::/32", Length: 48, ASN: uint32(64497), TA: "testrir"},
+			},
+		},
+		compareMaxBytes: 1024,
+	}
+
+	candidate := `{"roas": [
+		{"prefix": "192.0.2.0/24", "maxLength": 24, "asn": "AS64496"},
+		{"prefix": "198.51.100.0/24", "maxLength": 24, "asn": 64498},
+		{"prefix": "not a prefix", "maxLength": 24, "asn": 64498}
+	]}`
+	req := httptest.NewRequest("POST", "/compare", strings.NewReader(candidate))
+	rec := httptest.NewRecorder()
+	s.compare(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var got struct {
+		Added   []prefixfile.VRPJson `json:"added"`
+		Removed []prefixfile.VRPJson `json:"removed"`
+		Invalid int                  `json:"invalid"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, 1, got.Invalid)
+	if assert.Len(t, got.Added, 1) {
+		assert.Equal(t, "198.51.100.0/24", got.Added[0].Prefix)
+		assert.Equal(t, uint32(64498), got.Added[0].GetASN())
+	}
+	if assert.Len(t, got.Removed, 1) {
+		assert.Equal(t, "2001:db8::/32", got.Removed[0].Prefix)
+		assert.Equal(t, uint8(48), got.Removed[0].Length)
+	}
+
+	req = httptest.NewRequest("GET", "/compare", nil)
+	rec = httptest.NewRecorder()
+	s.compare(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	req = httptest.NewRequest("POST", "/compare", strings.NewReader("{"))
+	rec = httptest.NewRecorder()
+	s.compare(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	req = httptest.NewRequest("POST", "/compare", strings.NewReader(`{"roas": [`+strings.Repeat(`{"prefix": "192.0.2.0/24", "maxLength": 24, "asn": 64496},`, 100)+`]}`))
+	rec = httptest.NewRecorder()
+	s.compare(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// decodeConfig reads a YAML (.yaml, .yml) or TOML (.toml) configuration into the values
+// of the flags, by name. Nested keys are joined with dots, so that `tls: {bind: ...}`
+// sets -tls.bind, and lists are joined with commas.
+func decodeConfig(file string, data []byte) (map[string]string, error) {
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		if err := toml.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown format of %v, use .yaml, .yml or .toml", file)
+	}
+
+	flat := make(map[string]string)
+	if err := flattenConfig("", values, flat); err != nil {
+		return nil, err
+	}
+	return flat, nil
+}
+
+func flattenConfig(prefix string, values map[string]interface{}, flat map[string]string) error {
+	for key, value := range values {
+		name := key
+		if prefix != "" {
+			name = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			if err := flattenConfig(name, nested, flat); err != nil {
+				return err
+			}
+			continue
+		}
+		str, err := configValue(value)
+		if err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+		if _, ok := flat[name]; ok {
+			return fmt.Errorf("%v is set twice", name)
+		}
+		flat[name] = str
+	}
+	return nil
+}
+
+func configValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			str, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = str
+		}
+		return strings.Join(items, ","), nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// loadConfig sets the flags from a configuration file (see decodeConfig). The flags
+// set on the command line are kept.
+func loadConfig(fs *flag.FlagSet, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	values, err := decodeConfig(file, data)
+	if err != nil {
+		return fmt.Errorf("%v: %v", file, err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[flagName(f.Name)] = true
+	})
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "config" {
+			return fmt.Errorf("%v: config cannot be set in the configuration file", file)
+		}
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%v: unknown flag %v", file, name)
+		}
+		if set[flagName(name)] {
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return fmt.Errorf("%v: %v: %v", file, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeConfig(t *testing.T) {
+	want := map[string]string{
+		"bind":           "",
+		"tls.bind":       "127.0.0.1:8282",
+		"cache":          "https://a.example/vrps.json,https://b.example/vrps.json",
+		"refresh":        "600",
+		"checktime":      "false",
+		"checktime.skew": "10m",
+		"slurm":          "slurm.json",
+		"slurm.refresh":  "true",
+	}
+
+	yamlConfig := `
+bind: ""
+tls:
+  bind: 127.0.0.1:8282
+cache:
+  - https://a.example/vrps.json
+  - https://b.example/vrps.json
+refresh: 600
+checktime: false
+checktime.skew: 10m
+slurm: slurm.json
+slurm.refresh: true
+`
+	got, err := decodeConfig("stayrtr.yaml", []byte(yamlConfig))
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	tomlConfig := `
+bind = ""
+cache = ["https://a.example/vrps.json", "https://b.example/vrps.json"]
+refresh = 600
+checktime = false
+"checktime.skew" = "10m"
+slurm = "slurm.json"
+"slurm.refresh" = true
+
+[tls]
+bind = "127.0.0.1:8282"
+`
+	got, err = decodeConfig("stayrtr.toml", []byte(tomlConfig))
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	_, err = decodeConfig("stayrtr.yaml", []byte("tls:\n  bind: a\ntls.bind: b\n"))
+	assert.Error(t, err)
+	_, err = decodeConfig("stayrtr.json", []byte("{}"))
+	assert.Error(t, err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "stayrtr.yaml")
+	if err := os.WriteFile(file, []byte("bind: 192.0.2.1:323\nrefresh: 600\nchecktime.skew: 10m\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("stayrtr", flag.ContinueOnError)
+	bind := fs.String("bind", ":8282", "")
+	refresh := fs.Int("refresh", 300, "")
+	skew := fs.Duration("checktime.skew", 5*time.Minute, "")
+	fs.String("config", "", "")
+	assert.NoError(t, fs.Parse([]string{"-refresh", "60"}))
+
+	assert.NoError(t, loadConfig(fs, file))
+	assert.Equal(t, "192.0.2.1:323", *bind)
+	// The command line takes precedence
+	assert.Equal(t, 60, *refresh)
+	assert.Equal(t, 10*time.Minute, *skew)
+
+	invalid := map[string]string{
+		"unknown": "unknown: 1\n",
+		"config":  "config: other.yaml\n",
+		"value":   "checktime.skew: often\n",
+	}
+	for name, data := range invalid {
+		file := filepath.Join(dir, name+".yaml")
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		fs := flag.NewFlagSet("stayrtr", flag.ContinueOnError)
+		fs.Duration("checktime.skew", 5*time.Minute, "")
+		fs.String("config", "", "")
+		assert.Error(t, loadConfig(fs, file), name)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	rtr "github.com/bgp/stayrtr/lib"
+)
+
+type debugVRP struct {
+	Prefix      string `json:"prefix"`
+	MaxLength   uint8  `json:"maxLength"`
+	ASN         uint32 `json:"asn"`
+	Flags       uint8  `json:"flags"`
+	SerialAdded uint32 `json:"serialAdded"`
+}
+
+type debugVRPs struct {
+	SessionId uint16     `json:"sessionId"`
+	Serial    uint32     `json:"serial"`
+	Valid     bool       `json:"valid"`
+	Serials   []uint32   `json:"serials"`
+	Count     int        `json:"count"`
+	VRPs      []debugVRP `json:"vrps"`
+}
+
+// checkBearerToken returns whether the request carries the token as a bearer token.
+func checkBearerToken(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
+// debugVRPsHandler dumps the VRPs held by the RTR server, along with the serial they were added at.
+func debugVRPsHandler(server *rtr.Server, token string) http.HandlerFunc {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		if !checkBearerToken(r, token) {
+			wr.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(wr, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		state := server.GetDebugState()
+		res := debugVRPs{
+			SessionId: state.SessionId,
+			Serial:    state.Serial,
+			Valid:     state.Valid,
+			Serials:   state.Serials,
+			Count:     len(state.VRPs),
+			VRPs:      make([]debugVRP, len(state.VRPs)),
+		}
+		for i, vrp := range state.VRPs {
+			res.VRPs[i] = debugVRP{
+				Prefix:      vrp.Prefix.String(),
+				MaxLength:   vrp.MaxLen,
+				ASN:         vrp.ASN,
+				Flags:       vrp.Flags,
+				SerialAdded: vrp.SerialAdded,
+			}
+		}
+
+		wr.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(wr)
+		enc.Encode(res)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDebugVRPsHandler(t *testing.T) {
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3}, nil, nil)
+	server.AddVRPs([]rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496},
+	})
+	handler := debugVRPsHandler(server, "secret")
+
+	for _, auth := range []string{"", "Bearer wrong", "secret"} {
+		req := httptest.NewRequest("GET", "/debug/vrps", nil)
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, auth)
+	}
+
+	req := httptest.NewRequest("GET", "/debug/vrps", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var got debugVRPs
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, 1, got.Count)
+	if assert.Len(t, got.VRPs, 1) {
+		assert.Equal(t, debugVRP{Prefix: "192.0.2.0/24", MaxLength: 24, ASN: 64496, Flags: rtr.FLAG_ADDED}, got.VRPs[0])
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+// diffLog logs the VRPs announced and withdrawn by every new serial, computed against the
+// VRPs of the serial last logged, like the eventFeed.
+type diffLog struct {
+	server *rtr.Server
+	logger *log.Logger
+	// Number of VRPs logged at most per serial, the others are only counted
+	max int
+
+	started bool
+	serial  uint32
+	vrps    []rtr.VRP
+}
+
+// newDiffLogger returns the logger of the changes: the standard log, or a logger appending
+// to a file in the same format.
+func newDiffLogger(file string) (*log.Logger, error) {
+	if file == "" {
+		return log.StandardLogger(), nil
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.New()
+	logger.SetOutput(f)
+	logger.SetFormatter(log.StandardLogger().Formatter)
+	return logger, nil
+}
+
+// update logs the changes since the serial last logged. The VRPs served at the start are
+// the reference: they are not logged.
+func (d *diffLog) update() {
+	vrps, serial, valid := d.server.GetCurrentVRPsSerial()
+	if !valid || (d.started && serial == d.serial) {
+		return
+	}
+	if d.started {
+		d.log(serial, vrps)
+	}
+	d.started = true
+	d.serial, d.vrps = serial, vrps
+}
+
+func (d *diffLog) log(serial uint32, vrps []rtr.VRP) {
+	added, removed, _ := rtr.ComputeDiff(vrps, d.vrps)
+	entry := d.logger.WithFields(log.Fields{"serial": serial, "previous": d.serial})
+	entry.Infof("Serial %d: %d VRPs announced, %d withdrawn since serial %d", serial, len(added), len(removed), d.serial)
+
+	logged := 0
+	for _, vrps := range [][]rtr.VRP{added, removed} {
+		for _, vrp := range vrps {
+			if logged == d.max {
+				entry.Infof("%d more changes not logged", len(added)+len(removed)-logged)
+				return
+			}
+			vrpJson := vrpToJson(vrp)
+			entry.Infof("%s %s", changeType(vrp.Flags), vrpJson.String())
+			logged++
+		}
+	}
+}
+
+func (d *diffLog) run() {
+	notifications := d.server.Subscribe()
+	defer d.server.Unsubscribe(notifications)
+	for {
+		d.update()
+		<-notifications
+	}
+}
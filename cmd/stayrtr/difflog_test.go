@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffLog(t *testing.T) {
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 42}, nil, nil)
+	var out bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&log.TextFormatter{DisableTimestamp: true})
+	d := &diffLog{server: server, logger: logger, max: 2}
+
+	// The reference is not logged
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496},
+	}, nil, nil)
+	d.update()
+	assert.Empty(t, out.String())
+	previous, _ := server.GetCurrentSerial(42)
+
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("198.51.100.0/24"), MaxLen: 24, ASN: 64497},
+	}, nil, nil)
+	d.update()
+	serial, _ := server.GetCurrentSerial(42)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 3) {
+		assert.Contains(t, lines[0], "1 VRPs announced, 1 withdrawn")
+		assert.Contains(t, lines[1], `msg="announce 198.51.100.0/24/24/64497"`)
+		assert.Contains(t, lines[2], `msg="withdraw 192.0.2.0/24/24/64496"`)
+		assert.Contains(t, lines[2], "previous="+strconv.FormatUint(uint64(previous), 10))
+		assert.Contains(t, lines[2], "serial="+strconv.FormatUint(uint64(serial), 10))
+	}
+
+	// Capped
+	out.Reset()
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("203.0.113.0/24"), MaxLen: 24, ASN: 64498},
+		{Prefix: mustParsePrefix("203.0.113.0/25"), MaxLen: 25, ASN: 64498},
+	}, nil, nil)
+	d.update()
+	lines = strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Len(t, lines, 4) {
+		assert.Contains(t, lines[3], "1 more changes not logged")
+	}
+}
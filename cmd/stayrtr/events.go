@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	kafkaEventsScheme = "kafka://"
+	natsEventsScheme  = "nats://"
+
+	// Delay before publishing again the events the broker did not accept.
+	eventsRetry = 30 * time.Second
+)
+
+// changeEvent is the announcement or the withdrawal of an object by a new serial.
+type changeEvent struct {
+	Type      string                    `json:"type"`
+	Serial    uint32                    `json:"serial"`
+	SessionID uint16                    `json:"session_id"`
+	Time      string                    `json:"time"`
+	VRP       *prefixfile.VRPJson       `json:"vrp,omitempty"`
+	RouterKey *prefixfile.BgpsecKeyJson `json:"router_key,omitempty"`
+	ASPA      *prefixfile.ASPAJson      `json:"aspa,omitempty"`
+}
+
+// key orders the events of an object: they are sent to the same Kafka partition.
+func (e changeEvent) key() string {
+	switch {
+	case e.VRP != nil:
+		return e.VRP.Prefix
+	case e.RouterKey != nil:
+		return fmt.Sprintf("AS%d", e.RouterKey.ASN)
+	case e.ASPA != nil:
+		return fmt.Sprintf("AS%d", e.ASPA.CustomerASID)
+	}
+	return ""
+}
+
+func vrpToJson(vrp rtr.VRP) prefixfile.VRPJson {
+	return prefixfile.VRPJson{
+		Prefix: vrp.Prefix.String(),
+		Length: vrp.MaxLen,
+		ASN:    vrp.ASN,
+	}
+}
+
+func bgpsecKeyToJson(key rtr.BgpsecKey) prefixfile.BgpsecKeyJson {
+	return prefixfile.BgpsecKeyJson{
+		ASN:    key.ASN,
+		SKI:    hex.EncodeToString(key.SKI[:]),
+		Pubkey: base64.StdEncoding.EncodeToString(key.Pubkey),
+	}
+}
+
+func aspaToJson(aspa rtr.ASPA) prefixfile.ASPAJson {
+	return prefixfile.ASPAJson{
+		CustomerASID: aspa.CustomerASN,
+		Providers:    aspa.Providers,
+	}
+}
+
+func changeType(flags uint8) string {
+	if flags == rtr.FLAG_ADDED {
+		return "announce"
+	}
+	return "withdraw"
+}
+
+// eventPublisher sends the events to a message broker.
+type eventPublisher interface {
+	publish(ctx context.Context, events []changeEvent) error
+	close() error
+}
+
+type kafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+func (p *kafkaPublisher) publish(ctx context.Context, events []changeEvent) error {
+	msgs := make([]kafka.Message, len(events))
+	for i, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		msgs[i] = kafka.Message{Key: []byte(event.key()), Value: value}
+	}
+	return p.writer.WriteMessages(ctx, msgs...)
+}
+
+func (p *kafkaPublisher) close() error {
+	return p.writer.Close()
+}
+
+type natsPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func (p *natsPublisher) publish(ctx context.Context, events []changeEvent) error {
+	for _, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if err := p.conn.Publish(p.subject, value); err != nil {
+			return err
+		}
+	}
+	return p.conn.FlushWithContext(ctx)
+}
+
+func (p *natsPublisher) close() error {
+	p.conn.Close()
+	return nil
+}
+
+// newEventPublisher connects to kafka://broker[,broker]/topic or nats://server[,server]/subject.
+func newEventPublisher(target string) (eventPublisher, error) {
+	var scheme string
+	for _, s := range []string{kafkaEventsScheme, natsEventsScheme} {
+		if strings.HasPrefix(target, s) {
+			scheme = s
+		}
+	}
+	if scheme == "" {
+		return nil, fmt.Errorf("events: unknown broker %q, expected %vbroker/topic or %vserver/subject", target, kafkaEventsScheme, natsEventsScheme)
+	}
+	hosts := strings.TrimPrefix(target, scheme)
+	var destination string
+	if i := strings.Index(hosts, "/"); i >= 0 {
+		hosts, destination = hosts[:i], hosts[i+1:]
+	}
+	if hosts == "" || destination == "" {
+		return nil, fmt.Errorf("events: %q has no brokers or no topic", target)
+	}
+
+	if scheme == kafkaEventsScheme {
+		return &kafkaPublisher{
+			writer: kafka.NewWriter(kafka.WriterConfig{
+				Brokers: strings.Split(hosts, ","),
+				Topic:   destination,
+				// The events of an object stay in order
+				Balancer:     &kafka.Hash{},
+				BatchTimeout: 10 * time.Millisecond,
+			}),
+		}, nil
+	}
+	servers := strings.Split(hosts, ",")
+	for i := range servers {
+		servers[i] = natsEventsScheme + servers[i]
+	}
+	conn, err := nats.Connect(strings.Join(servers, ","), nats.Name("stayrtr"), nats.MaxReconnects(-1), nats.RetryOnFailedConnect(true))
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{conn: conn, subject: destination}, nil
+}
+
+// eventFeed publishes the changes of every new serial, computed against the objects of the
+// serial last published: the changes are not lost when serials are missed or the broker is down.
+type eventFeed struct {
+	server    *rtr.Server
+	publisher eventPublisher
+	timeout   time.Duration
+
+	started bool
+	serial  uint32
+	vrps    []rtr.VRP
+	keys    []rtr.BgpsecKey
+	aspas   []rtr.ASPA
+}
+
+func (f *eventFeed) events(serial uint32, vrps []rtr.VRP, keys []rtr.BgpsecKey, aspas []rtr.ASPA, now time.Time) []changeEvent {
+	session := f.server.GetSessionId()
+	timestamp := now.UTC().Format(time.RFC3339)
+	events := make([]changeEvent, 0)
+	add := func(flags uint8, event changeEvent) {
+		event.Type = changeType(flags)
+		event.Serial = serial
+		event.SessionID = session
+		event.Time = timestamp
+		events = append(events, event)
+	}
+
+	added, removed, _ := rtr.ComputeDiff(vrps, f.vrps)
+	for _, vrps := range [][]rtr.VRP{added, removed} {
+		for _, vrp := range vrps {
+			vrpJson := vrpToJson(vrp)
+			add(vrp.Flags, changeEvent{VRP: &vrpJson})
+		}
+	}
+	for _, key := range rtr.ComputeBgpsecKeyDiff(keys, f.keys) {
+		keyJson := bgpsecKeyToJson(key)
+		add(key.Flags, changeEvent{RouterKey: &keyJson})
+	}
+	for _, aspa := range rtr.ComputeASPADiff(aspas, f.aspas) {
+		aspaJson := aspaToJson(aspa)
+		add(aspa.Flags, changeEvent{ASPA: &aspaJson})
+	}
+	return events
+}
+
+// update publishes the changes since the serial last published. The objects served at
+// the start are the reference: they are not published.
+func (f *eventFeed) update(now time.Time) error {
+	vrps, keys, aspas, serial, valid := f.server.GetCurrentDataSerial()
+	if !valid || (f.started && serial == f.serial) {
+		return nil
+	}
+	if f.started {
+		events := f.events(serial, vrps, keys, aspas, now)
+		if len(events) > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+			err := f.publisher.publish(ctx, events)
+			cancel()
+			if err != nil {
+				EventsErrors.Inc()
+				return err
+			}
+			EventsPublished.Add(float64(len(events)))
+			log.WithField("serial", serial).Debugf("Published %d change events", len(events))
+		}
+	}
+	f.started = true
+	f.serial, f.vrps, f.keys, f.aspas = serial, vrps, keys, aspas
+	return nil
+}
+
+func (f *eventFeed) run() {
+	notifications := f.server.Subscribe()
+	defer f.server.Unsubscribe(notifications)
+	var retry <-chan time.Time
+	for {
+		retry = nil
+		if err := f.update(time.Now()); err != nil {
+			log.Errorf("Error publishing the change events, retrying in %v: %v", eventsRetry, err)
+			retry = time.After(eventsRetry)
+		}
+		select {
+		case <-notifications:
+		case <-retry:
+		}
+	}
+}
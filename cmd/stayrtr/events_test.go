@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+type testPublisher struct {
+	events []changeEvent
+	err    error
+}
+
+func (p *testPublisher) publish(ctx context.Context, events []changeEvent) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, events...)
+	return nil
+}
+
+func (p *testPublisher) close() error {
+	return nil
+}
+
+func TestEventFeed(t *testing.T) {
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 42}, nil, nil)
+	publisher := &testPublisher{}
+	feed := &eventFeed{server: server, publisher: publisher, timeout: time.Second}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	// No data yet, then the reference
+	assert.NoError(t, feed.update(now))
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496},
+	}, nil, nil)
+	assert.NoError(t, feed.update(now))
+	assert.Empty(t, publisher.events)
+
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("198.51.100.0/24"), MaxLen: 24, ASN: 64497},
+	}, nil, []rtr.ASPA{
+		{CustomerASN: 64498, Providers: []uint32{64499}},
+	})
+	// The broker is down: the changes are kept for the next attempt
+	publisher.err = errors.New("broker down")
+	assert.Error(t, feed.update(now))
+	publisher.err = nil
+	assert.NoError(t, feed.update(now))
+
+	serial, _ := server.GetCurrentSerial(42)
+	assert.Equal(t, []changeEvent{
+		{Type: "announce", Serial: serial, SessionID: 42, Time: "2026-10-16T12:00:00Z", VRP: &prefixfile.VRPJson{Prefix: "198.51.100.0/24", Length: 24, ASN: uint32(64497)}},
+		{Type: "withdraw", Serial: serial, SessionID: 42, Time: "2026-10-16T12:00:00Z", VRP: &prefixfile.VRPJson{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496)}},
+		{Type: "announce", Serial: serial, SessionID: 42, Time: "2026-10-16T12:00:00Z", ASPA: &prefixfile.ASPAJson{CustomerASID: 64498, Providers: []uint32{64499}}},
+	}, publisher.events)
+	assert.Equal(t, "198.51.100.0/24", publisher.events[0].key())
+	assert.Equal(t, "AS64498", publisher.events[2].key())
+
+	// Already published
+	assert.NoError(t, feed.update(now))
+	assert.Len(t, publisher.events, 3)
+}
+
+func TestNewEventPublisher(t *testing.T) {
+	publisher, err := newEventPublisher("kafka://192.0.2.1:9092,192.0.2.2:9092/rpki")
+	assert.NoError(t, err)
+	if assert.IsType(t, &kafkaPublisher{}, publisher) {
+		assert.Equal(t, "rpki", publisher.(*kafkaPublisher).writer.Stats().Topic)
+	}
+	publisher.close()
+
+	_, err = newEventPublisher("kafka://192.0.2.1:9092")
+	assert.EqualError(t, err, `events: "kafka://192.0.2.1:9092" has no brokers or no topic`)
+	_, err = newEventPublisher("amqp://192.0.2.1/rpki")
+	assert.Error(t, err)
+}
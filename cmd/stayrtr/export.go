@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bgp/stayrtr/api"
+	"github.com/bgp/stayrtr/prefixfile"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+type exportFormat struct {
+	// Name of the format in the format query parameter
+	Name        string
+	ContentType string
+	// Other media types accepted for this format, wildcards do not match them
+	Aliases []string
+	Write   func(io.Writer, prefixfile.VRPList) error
+}
+
+// exportFormats lists the formats available on the export path.
+// The first one is used when the client does not express a preference.
+var exportFormats = []*exportFormat{
+	{
+		Name:        "json",
+		ContentType: "application/json",
+		Aliases:     []string{"text/json"},
+		Write:       writeExportJSON,
+	},
+	{
+		Name:        "csv",
+		ContentType: "text/csv",
+		Write:       writeExportCSV,
+	},
+	exportFormatRoaSet,
+	{
+		Name:        "protobuf",
+		ContentType: "application/x-protobuf",
+		Aliases:     []string{"application/protobuf", "application/vnd.google.protobuf"},
+		Write:       writeExportProtobuf,
+	},
+}
+
+var exportFormatRoaSet = &exportFormat{
+	Name:        "roa-set",
+	ContentType: "text/x-openbgpd-roa-set",
+	Write:       writeExportRoaSet,
+}
+
+func writeExportJSON(wr io.Writer, vrplist prefixfile.VRPList) error {
+	enc := json.NewEncoder(wr)
+	return enc.Encode(vrplist)
+}
+
+// writeExportCSV uses the same columns as the rpki-client and Routinator CSV outputs.
+func writeExportCSV(wr io.Writer, vrplist prefixfile.VRPList) error {
+	w := csv.NewWriter(wr)
+	w.Write([]string{"ASN", "IP Prefix", "Max Length", "Trust Anchor"})
+	for _, vrp := range vrplist.Data {
+		w.Write([]string{
+			fmt.Sprintf("AS%d", vrp.GetASN()),
+			vrp.Prefix,
+			strconv.Itoa(vrp.GetMaxLen()),
+			vrp.TA,
+		})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// writeExportRoaSet writes an OpenBGPD roa-set, which can be included in bgpd.conf:
+//
+//	roa-set {
+//		192.0.2.0/24 maxlen 26 source-as 64496
+//	}
+func writeExportRoaSet(wr io.Writer, vrplist prefixfile.VRPList) error {
+	w := bufio.NewWriter(wr)
+	if vrplist.Metadata.Buildtime != "" {
+		fmt.Fprintf(w, "# Generated by StayRTR, buildtime %v\n", vrplist.Metadata.Buildtime)
+	}
+	w.WriteString("roa-set {\n")
+	for _, vrp := range vrplist.Data {
+		prefix := vrp.GetPrefix()
+		if prefix == nil {
+			continue
+		}
+		plen, _ := prefix.Mask.Size()
+		if vrp.GetMaxLen() > plen {
+			fmt.Fprintf(w, "\t%v maxlen %d source-as %d\n", prefix, vrp.GetMaxLen(), vrp.GetASN())
+		} else {
+			fmt.Fprintf(w, "\t%v source-as %d\n", prefix, vrp.GetASN())
+		}
+	}
+	w.WriteString("}\n")
+	return w.Flush()
+}
+
+// writeExportProtobuf writes the Export message of api/stayrtr.proto. The router keys
+// which cannot be decoded are left out.
+func writeExportProtobuf(wr io.Writer, vrplist prefixfile.VRPList) error {
+	export := &api.Export{
+		Buildtime:  vrplist.Metadata.Buildtime,
+		Vrps:       make([]*api.ExportedVRP, len(vrplist.Data)),
+		BgpsecKeys: make([]*api.RouterKey, 0, len(vrplist.BgpsecKeys)),
+		Aspas:      make([]*api.ASPA, len(vrplist.ASPA)),
+	}
+	for i, vrp := range vrplist.Data {
+		export.Vrps[i] = &api.ExportedVRP{
+			Prefix:    vrp.Prefix,
+			MaxLength: uint32(vrp.GetMaxLen()),
+			Asn:       vrp.GetASN(),
+			Ta:        vrp.TA,
+			Expires:   int64(vrp.Expires),
+		}
+	}
+	for _, key := range vrplist.BgpsecKeys {
+		ski, err := hex.DecodeString(key.SKI)
+		if err != nil {
+			continue
+		}
+		pubkey, err := base64.StdEncoding.DecodeString(key.Pubkey)
+		if err != nil {
+			continue
+		}
+		export.BgpsecKeys = append(export.BgpsecKeys, &api.RouterKey{
+			Asn:     key.ASN,
+			Ski:     ski,
+			Pubkey:  pubkey,
+			Ta:      key.TA,
+			Expires: int64(key.Expires),
+		})
+	}
+	for i, aspa := range vrplist.ASPA {
+		export.Aspas[i] = &api.ASPA{
+			CustomerAsid: aspa.CustomerASID,
+			Providers:    aspa.Providers,
+			Expires:      int64(aspa.Expires),
+		}
+	}
+	data, err := proto.Marshal(export)
+	if err != nil {
+		return err
+	}
+	_, err = wr.Write(data)
+	return err
+}
+
+// matchMediaRange returns how specific a media range (from an Accept header) is when it
+// matches the media type: 3 for an exact match, 2 for type/*, 1 for */* and 0 otherwise.
+func matchMediaRange(mediaRange string, mediaType string) int {
+	if mediaRange == mediaType {
+		return 3
+	}
+	if mediaRange == "*/*" {
+		return 1
+	}
+	if strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")) {
+		return 2
+	}
+	return 0
+}
+
+// negotiateExportFormat picks the format with the highest quality in the Accept header.
+// Returns nil when none of the formats is acceptable.
+func negotiateExportFormat(accept string) *exportFormat {
+	if strings.TrimSpace(accept) == "" {
+		return exportFormats[0]
+	}
+
+	var best *exportFormat
+	bestQuality := 0.0
+	for _, format := range exportFormats {
+		// The quality of a format is given by the most specific range matching it
+		quality := 0.0
+		specificity := 0
+		for _, part := range strings.Split(accept, ",") {
+			params := strings.Split(part, ";")
+			mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+			q := 1.0
+			for _, param := range params[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+					if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+						q = v
+					}
+				}
+			}
+			if m := matchMediaRange(mediaRange, format.ContentType); m > specificity {
+				specificity = m
+				quality = q
+			}
+			// Aliases are only matched explicitly
+			for _, alias := range format.Aliases {
+				if mediaRange == alias && specificity < 3 {
+					specificity = 3
+					quality = q
+				}
+			}
+		}
+		if quality > bestQuality {
+			best = format
+			bestQuality = quality
+		}
+	}
+	return best
+}
+
+// exportFormatByName returns the format of a format query parameter, or nil.
+func exportFormatByName(name string) *exportFormat {
+	for _, format := range exportFormats {
+		if format.Name == name {
+			return format
+		}
+	}
+	return nil
+}
+
+func (s *state) exporter(wr http.ResponseWriter, r *http.Request) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		format := exportFormatByName(name)
+		if format == nil {
+			http.Error(wr, fmt.Sprintf("Unknown format %q", name), http.StatusBadRequest)
+			return
+		}
+		s.export(wr, r, format)
+		return
+	}
+
+	wr.Header().Add("Vary", "Accept")
+	format := negotiateExportFormat(r.Header.Get("Accept"))
+	if format == nil {
+		http.Error(wr, "Not Acceptable", http.StatusNotAcceptable)
+		return
+	}
+	s.export(wr, r, format)
+}
+
+// formatExporter serves the export in a format, whatever the Accept header.
+func (s *state) formatExporter(format *exportFormat) http.HandlerFunc {
+	return func(wr http.ResponseWriter, r *http.Request) {
+		s.export(wr, r, format)
+	}
+}
+
+// exportTag returns the ETag of the data of an export: the hash of its JSON encoding.
+func exportTag(vrplist prefixfile.VRPList) string {
+	hash := sha256.New()
+	if err := json.NewEncoder(hash).Encode(vrplist); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// notModified returns whether the conditional headers of a request match the ETag
+// and Last-Modified of the export. If-Modified-Since is ignored when there is an
+// If-None-Match (RFC 7232).
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, value := range strings.Split(inm, ",") {
+			value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+			if value == "*" || value == etag {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.Truncate(time.Second).After(since)
+}
+
+func (s *state) export(wr http.ResponseWriter, r *http.Request, format *exportFormat) {
+	filter, err := parseExportFilter(r.URL.Query())
+	if err != nil {
+		http.Error(wr, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.lockJson.RLock()
+	toExport := s.exported
+	tag := s.exportedTag
+	modified := s.exportedModified
+	s.lockJson.RUnlock()
+
+	if filter != nil {
+		toExport = filter.Filter(toExport)
+		// Neither validated nor cached
+		tag = ""
+	}
+
+	aggregate, _ := strconv.ParseBool(r.URL.Query().Get("aggregate"))
+	gzipped := false
+	if s.exportCache != nil {
+		wr.Header().Add("Vary", "Accept-Encoding")
+		gzipped = tag != "" && acceptsGzip(r.Header.Get("Accept-Encoding"))
+	}
+	etag := ""
+	if tag != "" {
+		// Each format and encoding is a different representation
+		etag = fmt.Sprintf(`"%s-%s`, tag, format.Name)
+		if aggregate {
+			etag += "-aggregate"
+		}
+		if gzipped {
+			etag += "-gzip"
+		}
+		etag += `"`
+		wr.Header().Set("ETag", etag)
+		wr.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if notModified(r, etag, modified) {
+				wr.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+
+	if aggregate {
+		data := aggregateVRPs(toExport.Data)
+		toExport = prefixfile.VRPList{
+			Metadata:   toExport.Metadata,
+			Data:       data,
+			BgpsecKeys: toExport.BgpsecKeys,
+			ASPA:       toExport.ASPA,
+		}
+		toExport.Metadata.Counts = len(data)
+	}
+
+	wr.Header().Set("Content-Type", format.ContentType)
+	if gzipped {
+		data, err := s.exportCache.Get(tag, etag, func() ([]byte, error) {
+			buf := bytes.NewBuffer(nil)
+			gz := gzip.NewWriter(buf)
+			if err := format.Write(gz, toExport); err != nil {
+				return nil, err
+			}
+			if err := gz.Close(); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		})
+		if err != nil {
+			s.exportFailed(r, format, 0, err)
+			http.Error(wr, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		wr.Header().Set("Content-Encoding", "gzip")
+		wr.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		wr.Write(data)
+		return
+	}
+	if s.exportBuffer {
+		// The export is complete or replaced by an error
+		buf := bytes.NewBuffer(nil)
+		if err := format.Write(buf, toExport); err != nil {
+			s.exportFailed(r, format, 0, err)
+			http.Error(wr, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		wr.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		wr.Write(buf.Bytes())
+		return
+	}
+
+	cw := &countingWriter{w: wr}
+	if err := format.Write(cw, toExport); err != nil {
+		s.exportFailed(r, format, cw.n, err)
+		if cw.n > 0 {
+			// The status was sent: abort the response so that the client sees it is truncated
+			panic(http.ErrAbortHandler)
+		}
+		http.Error(wr, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// acceptsGzip returns whether an Accept-Encoding header allows gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding != "gzip" && coding != "x-gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "q" {
+				if v, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// exportCache keeps the gzipped exports of the current data by ETag, so that each
+// representation is compressed once per update whatever the number of clients.
+type exportCache struct {
+	lock    *sync.Mutex
+	tag     string
+	entries map[string][]byte
+}
+
+func newExportCache() *exportCache {
+	return &exportCache{
+		lock:    &sync.Mutex{},
+		entries: make(map[string][]byte),
+	}
+}
+
+// Get returns the export with an ETag, encoding it if needed. The exports of a previous
+// tag of the data are dropped.
+func (c *exportCache) Get(tag string, etag string, encode func() ([]byte, error)) ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if tag != c.tag {
+		c.tag = tag
+		c.entries = make(map[string][]byte)
+	}
+	if data, ok := c.entries[etag]; ok {
+		return data, nil
+	}
+	data, err := encode()
+	if err != nil {
+		return nil, err
+	}
+	c.entries[etag] = data
+	return data, nil
+}
+
+func (s *state) exportFailed(r *http.Request, format *exportFormat, written int64, err error) {
+	log.Errorf("Export to %v failed after %d bytes: %v", r.RemoteAddr, written, err)
+	ExportErrors.WithLabelValues(format.ContentType).Inc()
+}
+
+// countingWriter counts the bytes written.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bgp/stayrtr/api"
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNegotiateExportFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/json", "application/json"},
+		{"text/json", "application/json"},
+		{"text/csv", "text/csv"},
+		{"text/*", "text/csv"},
+		{"text/x-openbgpd-roa-set", "text/x-openbgpd-roa-set"},
+		{"application/x-protobuf", "application/x-protobuf"},
+		{"application/protobuf, application/json;q=0.5", "application/x-protobuf"},
+		{"application/*", "application/json"},
+		{"text/html, text/csv;q=0.5, */*;q=0.1", "text/csv"},
+		{"application/json;q=0.2, text/csv", "text/csv"},
+		{"text/csv;q=0, */*", "application/json"},
+		{"text/html", ""},
+		{"application/json;q=0", ""},
+	}
+	for _, tc := range tests {
+		var got string
+		if format := negotiateExportFormat(tc.accept); format != nil {
+			got = format.ContentType
+		}
+		if got != tc.want {
+			t.Errorf("Accept %q: wanted %q, got %q", tc.accept, tc.want, got)
+		}
+	}
+}
+
+func TestExporter(t *testing.T) {
+	s := &state{
+		lockJson: &sync.RWMutex{},
+		exported: prefixfile.VRPList{
+			Metadata: prefixfile.MetaData{Counts: 1},
+			Data: []prefixfile.VRPJson{
+				{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496), TA: "testrir"},
+			},
+		},
+	}
+
+	req := httptest.NewRequest("GET", "/rpki.json", nil)
+	req.Header.Set("Accept", "text/csv")
+	rec := httptest.NewRecorder()
+	s.exporter(rec, req)
+	want := "ASN,IP Prefix,Max Length,Trust Anchor\nAS64496,192.0.2.0/24,24,testrir\n"
+	if rec.Body.String() != want {
+		t.Errorf("Wanted %q, got %q", want, rec.Body.String())
+	}
+
+	req.Header.Set("Accept", "application/xml")
+	rec = httptest.NewRecorder()
+	s.exporter(rec, req)
+	if rec.Code != http.StatusNotAcceptable {
+		t.Errorf("Wanted status %d, got %d", http.StatusNotAcceptable, rec.Code)
+	}
+
+	req.Header.Del("Accept")
+	rec = httptest.NewRecorder()
+	s.exporter(rec, req)
+	if rec.Header().Get("Content-Type") != "application/json" || !bytes.HasPrefix(rec.Body.Bytes(), []byte("{")) {
+		t.Errorf("Wanted JSON, got %q (%v)", rec.Body.String(), rec.Header().Get("Content-Type"))
+	}
+}
+
+// failingResponseWriter fails after writing limit bytes.
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (f *failingResponseWriter) Write(p []byte) (int, error) {
+	if len(p) > f.limit {
+		n, _ := f.ResponseRecorder.Write(p[:f.limit])
+		f.limit = 0
+		return n, errors.New("connection reset by peer")
+	}
+	f.limit -= len(p)
+	return f.ResponseRecorder.Write(p)
+}
+
+func TestExporterErrors(t *testing.T) {
+	vrps := make([]prefixfile.VRPJson, 1000)
+	for i := range vrps {
+		vrps[i] = prefixfile.VRPJson{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496), TA: "testrir"}
+	}
+	s := &state{
+		lockJson: &sync.RWMutex{},
+		exported: prefixfile.VRPList{
+			Metadata: prefixfile.MetaData{Counts: len(vrps)},
+			Data:     vrps,
+		},
+	}
+	before := testutil.ToFloat64(ExportErrors.WithLabelValues("text/csv"))
+
+	// The connection fails after the status is sent: the response is aborted
+	req := httptest.NewRequest("GET", "/rpki.json", nil)
+	req.Header.Set("Accept", "text/csv")
+	rec := &failingResponseWriter{ResponseRecorder: httptest.NewRecorder(), limit: 5000}
+	assert.PanicsWithError(t, http.ErrAbortHandler.Error(), func() {
+		s.exporter(rec, req)
+	})
+	assert.Equal(t, before+1, testutil.ToFloat64(ExportErrors.WithLabelValues("text/csv")))
+
+	// The JSON cannot be encoded
+	s.exported.Data = []prefixfile.VRPJson{{Prefix: "192.0.2.0/24", Length: 24, ASN: make(chan int)}}
+	for _, buffer := range []bool{false, true} {
+		s.exportBuffer = buffer
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		s.exporter(rec, req)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.False(t, bytes.HasPrefix(rec.Body.Bytes(), []byte("{")))
+	}
+
+	// Buffered: the export is sent with its length
+	s.exported.Data = vrps
+	rec2 := httptest.NewRecorder()
+	s.exporter(rec2, req)
+	assert.Equal(t, http.StatusOK, rec2.Code)
+	assert.Equal(t, strconv.Itoa(rec2.Body.Len()), rec2.Header().Get("Content-Length"))
+}
+
+func TestExportRoaSet(t *testing.T) {
+	s := &state{
+		lockJson: &sync.RWMutex{},
+		exported: prefixfile.VRPList{
+			Metadata: prefixfile.MetaData{Counts: 2, Buildtime: "2021-07-27T18:56:02Z"},
+			Data: []prefixfile.VRPJson{
+				{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496)},
+				{Prefix: "2001:db8::/32", Length: 48, ASN: "AS64497"},
+			},
+		},
+	}
+	want := "# Generated by StayRTR, buildtime 2021-07-27T18:56:02Z\n" +
+		"roa-set {\n" +
+		"\t192.0.2.0/24 source-as 64496\n" +
+		"\t2001:db8::/32 maxlen 48 source-as 64497\n" +
+		"}\n"
+
+	// Whatever the Accept header
+	req := httptest.NewRequest("GET", "/rpki.roa-set", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	s.formatExporter(exportFormatRoaSet)(rec, req)
+	assert.Equal(t, want, rec.Body.String())
+	assert.Equal(t, "text/x-openbgpd-roa-set", rec.Header().Get("Content-Type"))
+
+	req = httptest.NewRequest("GET", "/rpki.json", nil)
+	req.Header.Set("Accept", "text/x-openbgpd-roa-set")
+	rec = httptest.NewRecorder()
+	s.exporter(rec, req)
+	assert.Equal(t, want, rec.Body.String())
+}
+
+func TestExportFormatParameter(t *testing.T) {
+	s := &state{
+		lockJson: &sync.RWMutex{},
+		exported: prefixfile.VRPList{
+			Metadata: prefixfile.MetaData{Counts: 1, Buildtime: "2021-07-27T18:56:02Z"},
+			Data: []prefixfile.VRPJson{
+				{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496), TA: "testrir", Expires: 1690000000},
+			},
+			BgpsecKeys: []prefixfile.BgpsecKeyJson{
+				{ASN: 64496, SKI: "0102", Pubkey: "AwQ="},
+				// Not decodable
+				{ASN: 64497, SKI: "xx", Pubkey: "AwQ="},
+			},
+			ASPA: []prefixfile.ASPAJson{
+				{CustomerASID: 64496, Providers: []uint32{64497, 64498}},
+			},
+		},
+	}
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/rpki.json?"+query, nil)
+		// The parameter takes precedence
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		s.exporter(rec, req)
+		return rec
+	}
+
+	rec := get("format=csv")
+	assert.Equal(t, "text/csv", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "ASN,IP Prefix,Max Length,Trust Anchor\nAS64496,192.0.2.0/24,24,testrir\n", rec.Body.String())
+
+	rec = get("format=protobuf")
+	assert.Equal(t, "application/x-protobuf", rec.Header().Get("Content-Type"))
+	var export api.Export
+	assert.NoError(t, proto.Unmarshal(rec.Body.Bytes(), &export))
+	assert.Equal(t, "2021-07-27T18:56:02Z", export.Buildtime)
+	assert.Len(t, export.Vrps, 1)
+	assert.Equal(t, "192.0.2.0/24", export.Vrps[0].Prefix)
+	assert.Equal(t, uint32(24), export.Vrps[0].MaxLength)
+	assert.Equal(t, uint32(64496), export.Vrps[0].Asn)
+	assert.Equal(t, "testrir", export.Vrps[0].Ta)
+	assert.Equal(t, int64(1690000000), export.Vrps[0].Expires)
+	assert.Len(t, export.BgpsecKeys, 1)
+	assert.Equal(t, []byte{1, 2}, export.BgpsecKeys[0].Ski)
+	assert.Equal(t, []byte{3, 4}, export.BgpsecKeys[0].Pubkey)
+	assert.Len(t, export.Aspas, 1)
+	assert.Equal(t, []uint32{64497, 64498}, export.Aspas[0].Providers)
+
+	rec = get("format=roa-set&aggregate=true")
+	assert.Equal(t, "text/x-openbgpd-roa-set", rec.Header().Get("Content-Type"))
+
+	rec = get("format=xml")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestExportConditional(t *testing.T) {
+	exported := prefixfile.VRPList{
+		Metadata: prefixfile.MetaData{Counts: 1, Buildtime: "2021-07-27T18:56:02Z"},
+		Data: []prefixfile.VRPJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496)},
+		},
+	}
+	modified := time.Date(2021, 7, 27, 19, 0, 0, 500, time.UTC)
+	s := &state{
+		lockJson:         &sync.RWMutex{},
+		exported:         exported,
+		exportedTag:      exportTag(exported),
+		exportedModified: modified,
+	}
+	get := func(url string, header string, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		s.exporter(rec, req)
+		return rec
+	}
+
+	rec := get("/rpki.json", "", "")
+	etag := rec.Header().Get("ETag")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"`+s.exportedTag+`-json"`, etag)
+	assert.Equal(t, "Tue, 27 Jul 2021 19:00:00 GMT", rec.Header().Get("Last-Modified"))
+
+	rec = get("/rpki.json", "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	rec = get("/rpki.json", "If-Modified-Since", "Tue, 27 Jul 2021 19:00:00 GMT")
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	rec = get("/rpki.json", "If-Modified-Since", "Tue, 27 Jul 2021 18:59:59 GMT")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Another representation
+	rec = get("/rpki.json?format=csv", "If-None-Match", etag)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = get("/rpki.json?aggregate=true", "If-None-Match", etag)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+
+	// The data changed
+	exported.Metadata.Buildtime = "2021-07-27T19:56:02Z"
+	assert.NotEqual(t, s.exportedTag, exportTag(exported))
+}
+
+func TestExportGzip(t *testing.T) {
+	exported := prefixfile.VRPList{
+		Metadata: prefixfile.MetaData{Counts: 1, Buildtime: "2021-07-27T18:56:02Z"},
+		Data: []prefixfile.VRPJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496)},
+		},
+	}
+	s := &state{
+		lockJson:         &sync.RWMutex{},
+		exported:         exported,
+		exportedTag:      exportTag(exported),
+		exportedModified: time.Now(),
+		exportCache:      newExportCache(),
+	}
+	get := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/rpki.json", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		s.exporter(rec, req)
+		return rec
+	}
+
+	plain := get("")
+	assert.Empty(t, plain.Header().Get("Content-Encoding"))
+	assert.Contains(t, plain.Header().Values("Vary"), "Accept-Encoding")
+
+	rec := get("br, gzip;q=0.8")
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.NotEqual(t, plain.Header().Get("ETag"), rec.Header().Get("ETag"))
+	gz, err := gzip.NewReader(rec.Body)
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(gz)
+	assert.Equal(t, plain.Body.String(), string(data))
+
+	// Compressed once
+	cached := s.exportCache.entries[rec.Header().Get("ETag")]
+	assert.NotEmpty(t, cached)
+	assert.Equal(t, cached, get("gzip").Body.Bytes())
+
+	assert.Empty(t, get("gzip;q=0").Header().Get("Content-Encoding"))
+	assert.Equal(t, "gzip", get("*").Header().Get("Content-Encoding"))
+
+	// The cache is dropped when the data changes
+	s.exportedTag = "other"
+	get("gzip")
+	assert.Len(t, s.exportCache.entries, 1)
+	assert.NotContains(t, s.exportCache.entries, rec.Header().Get("ETag"))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/bgp/stayrtr/prefixfile"
+)
+
+// exportFilter selects the VRPs of the export with the asn, prefix and ta parameters.
+// Each parameter can be repeated or hold comma-separated values, a VRP matches when
+// it matches one of the values of each parameter given.
+type exportFilter struct {
+	asns     map[uint32]bool
+	prefixes []netip.Prefix
+	tas      map[string]bool
+}
+
+// queryValues returns the values of a parameter, repeated and/or comma-separated.
+func queryValues(query url.Values, name string) []string {
+	values := make([]string, 0)
+	for _, param := range query[name] {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// parsePrefixFilter parses a prefix, or an address as the prefix of its length.
+func parsePrefixFilter(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parseExportFilter returns the filter of the parameters of a request, or nil if there
+// is none.
+func parseExportFilter(query url.Values) (*exportFilter, error) {
+	asns := queryValues(query, "asn")
+	prefixes := queryValues(query, "prefix")
+	tas := queryValues(query, "ta")
+	if len(asns) == 0 && len(prefixes) == 0 && len(tas) == 0 {
+		return nil, nil
+	}
+
+	filter := &exportFilter{}
+	if len(asns) > 0 {
+		filter.asns = make(map[uint32]bool)
+		for _, value := range asns {
+			asnParam := prefixfile.VRPJson{ASN: value}
+			asn, err := asnParam.GetASN2()
+			if err != nil {
+				return nil, fmt.Errorf("invalid asn: %v", err)
+			}
+			filter.asns[asn] = true
+		}
+	}
+	for _, value := range prefixes {
+		prefix, err := parsePrefixFilter(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix: %v", err)
+		}
+		filter.prefixes = append(filter.prefixes, prefix)
+	}
+	if len(tas) > 0 {
+		filter.tas = make(map[string]bool)
+		for _, value := range tas {
+			filter.tas[strings.ToLower(value)] = true
+		}
+	}
+	return filter, nil
+}
+
+// Match returns whether a VRP matches the filter. A prefix matches the VRPs covering it
+// and the VRPs it covers.
+func (f *exportFilter) Match(vrp prefixfile.VRPJson) bool {
+	if f.asns != nil {
+		asn, err := vrp.GetASN2()
+		if err != nil || !f.asns[asn] {
+			return false
+		}
+	}
+	if f.tas != nil && !f.tas[strings.ToLower(vrp.TA)] {
+		return false
+	}
+	if len(f.prefixes) > 0 {
+		vrpPrefix, err := vrp.GetNetipPrefix()
+		if err != nil {
+			return false
+		}
+		vrpPrefix = vrpPrefix.Masked()
+		for _, prefix := range f.prefixes {
+			if covers(vrpPrefix, prefix) || covers(prefix, vrpPrefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Filter returns the VRPs of a list matching the filter. The router keys and the ASPAs
+// are left out.
+func (f *exportFilter) Filter(vrplist prefixfile.VRPList) prefixfile.VRPList {
+	data := make([]prefixfile.VRPJson, 0)
+	for _, vrp := range vrplist.Data {
+		if f.Match(vrp) {
+			data = append(data, vrp)
+		}
+	}
+	filtered := prefixfile.VRPList{
+		Metadata: vrplist.Metadata,
+		Data:     data,
+	}
+	filtered.Metadata.Counts = len(data)
+	return filtered
+}
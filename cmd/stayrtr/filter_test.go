@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportFilter(t *testing.T) {
+	vrps := []prefixfile.VRPJson{
+		{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496), TA: "ripe"},
+		{Prefix: "192.0.2.128/25", Length: 25, ASN: uint32(64497), TA: "arin"},
+		{Prefix: "198.51.100.0/24", Length: 24, ASN: "AS64496", TA: "RIPE"},
+		{Prefix: "2001:db8::/32", Length: 48, ASN: uint32(64498), TA: "apnic"},
+	}
+	filter := func(query string) []string {
+		values, _ := url.ParseQuery(query)
+		f, err := parseExportFilter(values)
+		assert.NoError(t, err, query)
+		if f == nil {
+			return nil
+		}
+		prefixes := make([]string, 0)
+		for _, vrp := range f.Filter(prefixfile.VRPList{Data: vrps}).Data {
+			prefixes = append(prefixes, vrp.Prefix)
+		}
+		return prefixes
+	}
+
+	assert.Nil(t, filter("aggregate=true"))
+	assert.Equal(t, []string{"192.0.2.0/24", "198.51.100.0/24"}, filter("asn=AS64496"))
+	assert.Equal(t, []string{"192.0.2.0/24", "192.0.2.128/25", "198.51.100.0/24"}, filter("asn=64496,64497"))
+	assert.Equal(t, []string{"192.0.2.0/24", "192.0.2.128/25", "198.51.100.0/24"}, filter("asn=64496&asn=64497"))
+	// Covering and covered VRPs
+	assert.Equal(t, []string{"192.0.2.0/24", "192.0.2.128/25"}, filter("prefix=192.0.2.128/26"))
+	assert.Equal(t, []string{"192.0.2.0/24", "192.0.2.128/25"}, filter("prefix=192.0.2.0/23"))
+	assert.Equal(t, []string{"192.0.2.0/24"}, filter("prefix=192.0.2.1"))
+	assert.Equal(t, []string{"2001:db8::/32"}, filter("prefix=2001:db8:1::/48"))
+	assert.Equal(t, []string{"192.0.2.0/24", "198.51.100.0/24"}, filter("ta=ripe"))
+	assert.Equal(t, []string{"198.51.100.0/24"}, filter("ta=ripe&prefix=198.51.100.0/22"))
+	assert.Equal(t, []string{}, filter("asn=64498&ta=ripe"))
+
+	for _, query := range []string{"asn=ASx", "prefix=192.0.2.0/33", "prefix=x"} {
+		values, _ := url.ParseQuery(query)
+		_, err := parseExportFilter(values)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestExportFiltered(t *testing.T) {
+	exported := prefixfile.VRPList{
+		Metadata: prefixfile.MetaData{Counts: 2, Buildtime: "2021-07-27T18:56:02Z"},
+		Data: []prefixfile.VRPJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496)},
+			{Prefix: "198.51.100.0/24", Length: 24, ASN: uint32(64497)},
+		},
+		ASPA: []prefixfile.ASPAJson{{CustomerASID: 64496, Providers: []uint32{64497}}},
+	}
+	s := &state{
+		lockJson:    &sync.RWMutex{},
+		exported:    exported,
+		exportedTag: exportTag(exported),
+		exportCache: newExportCache(),
+	}
+
+	req := httptest.NewRequest("GET", "/rpki.json?asn=64497", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	s.exporter(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("ETag"))
+	assert.Empty(t, rec.Header().Get("Content-Encoding"))
+	var decoded prefixfile.VRPList
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &decoded))
+	assert.Equal(t, 1, decoded.Metadata.Counts)
+	assert.Len(t, decoded.Data, 1)
+	assert.Equal(t, "198.51.100.0/24", decoded.Data[0].Prefix)
+	assert.Empty(t, decoded.ASPA)
+
+	req = httptest.NewRequest("GET", "/rpki.json?prefix=x", nil)
+	rec = httptest.NewRecorder()
+	s.exporter(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/bgp/stayrtr/prefixfile"
+)
+
+// decodeCache decodes the data of a cache in a format, or in the format given by its
+// Content-Type or its content with CACHE_FORMAT_AUTO.
+func decodeCache(data []byte, format int, contentType string) (*prefixfile.VRPList, error) {
+	if format == CACHE_FORMAT_AUTO {
+		format = CACHE_FORMAT_JSON
+		if strings.HasSuffix(contentType, "/csv") || (!strings.HasSuffix(contentType, "json") && prefixfile.IsCSV(data)) {
+			format = CACHE_FORMAT_CSV
+		}
+	}
+	if format != CACHE_FORMAT_CSV {
+		return decodeJSON(data)
+	}
+	vrplist, err := prefixfile.DecodeCSV(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	// The CSV has no buildtime: it is considered built when it was fetched
+	vrplist.Metadata.Buildtime = time.Now().UTC().Format(time.RFC3339)
+	return vrplist, nil
+}
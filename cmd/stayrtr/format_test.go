@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCache(t *testing.T) {
+	csv := []byte("ASN,IP Prefix,Max Length,Trust Anchor,Expires\nAS64496,192.0.2.0/24,24,ripe,1690000000\n")
+	json := []byte(`{"metadata": {"buildtime": "2021-07-27T18:56:02Z"}, "roas": [{"prefix": "192.0.2.0/24", "maxLength": 24, "asn": 64496}]}`)
+
+	for _, contentType := range []string{"", "text/csv", "text/plain"} {
+		decoded, err := decodeCache(csv, CACHE_FORMAT_AUTO, contentType)
+		assert.NoError(t, err, contentType)
+		assert.Len(t, decoded.Data, 1, contentType)
+		assert.NotEmpty(t, decoded.Metadata.Buildtime)
+	}
+	decoded, err := decodeCache(json, CACHE_FORMAT_AUTO, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "2021-07-27T18:56:02Z", decoded.Metadata.Buildtime)
+
+	_, err = decodeCache(csv, CACHE_FORMAT_AUTO, "application/json")
+	assert.Error(t, err)
+	_, err = decodeCache(csv, CACHE_FORMAT_JSON, "")
+	assert.Error(t, err)
+	_, err = decodeCache(json, CACHE_FORMAT_CSV, "")
+	assert.Error(t, err)
+	decoded, err = decodeCache(csv, CACHE_FORMAT_CSV, "application/json")
+	assert.NoError(t, err)
+	assert.Len(t, decoded.Data, 1)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+
+	"github.com/bgp/stayrtr/api"
+	rtr "github.com/bgp/stayrtr/lib"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer exposes the VRPs, router keys and ASPAs served over RTR to gRPC clients.
+type grpcServer struct {
+	api.UnimplementedVRPServiceServer
+
+	server *rtr.Server
+}
+
+func newGRPCServer(server *rtr.Server) *grpc.Server {
+	gs := grpc.NewServer()
+	api.RegisterVRPServiceServer(gs, &grpcServer{
+		server: server,
+	})
+	return gs
+}
+
+func vrpsToAPI(vrps []rtr.VRP) []*api.VRP {
+	res := make([]*api.VRP, len(vrps))
+	for i, vrp := range vrps {
+		res[i] = &api.VRP{
+			Prefix:    vrp.Prefix.String(),
+			MaxLength: uint32(vrp.MaxLen),
+			Asn:       vrp.ASN,
+		}
+	}
+	return res
+}
+
+func keysToAPI(keys []rtr.BgpsecKey) []*api.RouterKey {
+	res := make([]*api.RouterKey, len(keys))
+	for i, key := range keys {
+		res[i] = &api.RouterKey{
+			Asn:    key.ASN,
+			Ski:    append([]byte(nil), key.SKI[:]...),
+			Pubkey: key.Pubkey,
+		}
+	}
+	return res
+}
+
+func aspasToAPI(aspas []rtr.ASPA) []*api.ASPA {
+	res := make([]*api.ASPA, len(aspas))
+	for i, aspa := range aspas {
+		res[i] = &api.ASPA{
+			CustomerAsid: aspa.CustomerASN,
+			Providers:    aspa.Providers,
+		}
+	}
+	return res
+}
+
+func (g *grpcServer) GetVRPs(ctx context.Context, req *api.GetVRPsRequest) (*api.VRPSet, error) {
+	vrps, keys, aspas, serial, valid := g.server.GetCurrentDataSerial()
+	if !valid {
+		return nil, status.Error(codes.Unavailable, "no data available yet")
+	}
+	return &api.VRPSet{
+		SessionId:  uint32(g.server.GetSessionId()),
+		Serial:     serial,
+		Vrps:       vrpsToAPI(vrps),
+		BgpsecKeys: keysToAPI(keys),
+		Aspas:      aspasToAPI(aspas),
+	}, nil
+}
+
+// update returns the changes since a serial or the complete set if the serial is too old.
+func (g *grpcServer) update(serial uint32, snapshot bool) *api.VRPUpdate {
+	update := &api.VRPUpdate{
+		SessionId: uint32(g.server.GetSessionId()),
+	}
+	if !snapshot {
+		diff, diffKeys, diffAspas, current, ok := g.server.GetDataSerialDiffCurrent(serial)
+		if ok {
+			var announced, withdrawn []rtr.VRP
+			for _, vrp := range diff {
+				if vrp.Flags == rtr.FLAG_ADDED {
+					announced = append(announced, vrp)
+				} else {
+					withdrawn = append(withdrawn, vrp)
+				}
+			}
+			var announcedKeys, withdrawnKeys []rtr.BgpsecKey
+			for _, key := range diffKeys {
+				if key.Flags == rtr.FLAG_ADDED {
+					announcedKeys = append(announcedKeys, key)
+				} else {
+					withdrawnKeys = append(withdrawnKeys, key)
+				}
+			}
+			var announcedAspas, withdrawnAspas []rtr.ASPA
+			for _, aspa := range diffAspas {
+				if aspa.Flags == rtr.FLAG_ADDED {
+					announcedAspas = append(announcedAspas, aspa)
+				} else {
+					withdrawnAspas = append(withdrawnAspas, aspa)
+				}
+			}
+			update.Serial = current
+			update.Announced = vrpsToAPI(announced)
+			update.Withdrawn = vrpsToAPI(withdrawn)
+			update.AnnouncedBgpsecKeys = keysToAPI(announcedKeys)
+			update.WithdrawnBgpsecKeys = keysToAPI(withdrawnKeys)
+			update.AnnouncedAspas = aspasToAPI(announcedAspas)
+			update.WithdrawnAspas = aspasToAPI(withdrawnAspas)
+			return update
+		}
+	}
+	vrps, keys, aspas, current, _ := g.server.GetCurrentDataSerial()
+	update.Serial = current
+	update.Snapshot = true
+	update.Announced = vrpsToAPI(vrps)
+	update.AnnouncedBgpsecKeys = keysToAPI(keys)
+	update.AnnouncedAspas = aspasToAPI(aspas)
+	return update
+}
+
+func hasChanges(update *api.VRPUpdate) bool {
+	return len(update.Announced) > 0 || len(update.Withdrawn) > 0 ||
+		len(update.AnnouncedBgpsecKeys) > 0 || len(update.WithdrawnBgpsecKeys) > 0 ||
+		len(update.AnnouncedAspas) > 0 || len(update.WithdrawnAspas) > 0
+}
+
+func (g *grpcServer) WatchVRPs(req *api.WatchVRPsRequest, stream api.VRPService_WatchVRPsServer) error {
+	// Subscribe before the first update so that no serial is missed
+	notifications := g.server.Subscribe()
+	defer g.server.Unsubscribe(notifications)
+
+	var serial uint32
+	var started bool
+	if req.Incremental && req.SessionId == uint32(g.server.GetSessionId()) {
+		serial = req.Serial
+		started = true
+	}
+	for {
+		if _, valid := g.server.GetCurrentSerial(g.server.GetSessionId()); valid {
+			update := g.update(serial, !started)
+			if update.Snapshot || hasChanges(update) {
+				if err := stream.Send(update); err != nil {
+					return err
+				}
+			}
+			serial = update.Serial
+			started = true
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-notifications:
+		}
+	}
+}
+
+func (g *grpcServer) Validate(ctx context.Context, req *api.ValidateRequest) (*api.ValidateResponse, error) {
+	prefix, err := netip.ParsePrefix(req.Prefix)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid prefix: %v", err)
+	}
+	prefix = prefix.Masked()
+	vrps, _ := g.server.GetCurrentVRPs()
+	res := validateOrigin(vrps, prefix, req.Asn)
+
+	resp := &api.ValidateResponse{
+		Matched:         vrpsToAPI(res.Matched),
+		UnmatchedAs:     vrpsToAPI(res.UnmatchedAS),
+		UnmatchedLength: vrpsToAPI(res.UnmatchedLength),
+	}
+	switch res.State {
+	case VALIDATION_VALID:
+		resp.State = api.ValidateResponse_VALID
+	case VALIDATION_INVALID:
+		resp.State = api.ValidateResponse_INVALID
+	default:
+		resp.State = api.ValidateResponse_NOT_FOUND
+	}
+	return resp, nil
+}
+
+func startGRPC(listener net.Listener, server *rtr.Server) error {
+	return newGRPCServer(server).Serve(listener)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bgp/stayrtr/api"
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestValidateOrigin(t *testing.T) {
+	vrps := []rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496},
+		{Prefix: mustParsePrefix("198.51.100.0/22"), MaxLen: 23, ASN: 64497},
+		{Prefix: mustParsePrefix("203.0.113.0/24"), MaxLen: 24, ASN: 0},
+		{Prefix: mustParsePrefix("2001:db8::/32"), MaxLen: 48, ASN: 64498},
+	}
+	tests := []struct {
+		prefix string
+		asn    uint32
+		state  int
+	}{
+		{"192.0.2.0/24", 64496, VALIDATION_VALID},
+		{"192.0.2.0/24", 64499, VALIDATION_INVALID},
+		{"192.0.2.0/25", 64496, VALIDATION_INVALID},
+		{"192.0.0.0/16", 64496, VALIDATION_NOT_FOUND},
+		{"198.51.100.0/23", 64497, VALIDATION_VALID},
+		{"198.51.100.0/24", 64497, VALIDATION_INVALID},
+		{"203.0.113.0/24", 0, VALIDATION_INVALID},
+		{"2001:db8:1::/48", 64498, VALIDATION_VALID},
+		{"2001:db8::/32", 64496, VALIDATION_INVALID},
+		{"2001:db9::/32", 64498, VALIDATION_NOT_FOUND},
+	}
+	for _, tc := range tests {
+		prefix := mustParsePrefix(tc.prefix)
+		res := validateOrigin(vrps, prefix, tc.asn)
+		if res.State != tc.state {
+			t.Errorf("%v AS%d: wanted state %d, got %d", tc.prefix, tc.asn, tc.state, res.State)
+		}
+	}
+}
+
+func TestGRPCServer(t *testing.T) {
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3}, nil, nil)
+	server.AddVRPs([]rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496},
+	})
+
+	listener := bufconn.Listen(1024 * 1024)
+	gs := newGRPCServer(server)
+	go gs.Serve(listener)
+	defer gs.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := api.NewVRPServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	set, err := client.GetVRPs(ctx, &api.GetVRPsRequest{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint32(server.GetSessionId()), set.SessionId)
+		if assert.Len(t, set.Vrps, 1) {
+			assert.Equal(t, "192.0.2.0/24", set.Vrps[0].Prefix)
+		}
+	}
+
+	validation, err := client.Validate(ctx, &api.ValidateRequest{Prefix: "192.0.2.0/24", Asn: 64497})
+	if assert.NoError(t, err) {
+		assert.Equal(t, api.ValidateResponse_INVALID, validation.State)
+		assert.Len(t, validation.UnmatchedAs, 1)
+	}
+	_, err = client.Validate(ctx, &api.ValidateRequest{Prefix: "192.0.2.0", Asn: 64497})
+	assert.Error(t, err)
+
+	stream, err := client.WatchVRPs(ctx, &api.WatchVRPsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update, err := stream.Recv()
+	if assert.NoError(t, err) {
+		assert.True(t, update.Snapshot)
+		assert.Len(t, update.Announced, 1)
+	}
+
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("198.51.100.0/24"), MaxLen: 24, ASN: 64497},
+	}, nil, []rtr.ASPA{
+		{CustomerASN: 64498, Providers: []uint32{64499}},
+	})
+	server.NotifyClientsLatest()
+	update, err = stream.Recv()
+	if assert.NoError(t, err) {
+		assert.False(t, update.Snapshot)
+		if assert.Len(t, update.Announced, 1) {
+			assert.Equal(t, "198.51.100.0/24", update.Announced[0].Prefix)
+		}
+		if assert.Len(t, update.Withdrawn, 1) {
+			assert.Equal(t, "192.0.2.0/24", update.Withdrawn[0].Prefix)
+		}
+		if assert.Len(t, update.AnnouncedAspas, 1) {
+			assert.Equal(t, uint32(64498), update.AnnouncedAspas[0].CustomerAsid)
+			assert.Equal(t, []uint32{64499}, update.AnnouncedAspas[0].Providers)
+		}
+	}
+
+	// Only the router keys and the ASPAs change
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("198.51.100.0/24"), MaxLen: 24, ASN: 64497},
+	}, []rtr.BgpsecKey{
+		{ASN: 64500, SKI: [20]byte{1, 2, 3}, Pubkey: []byte{4, 5, 6}},
+	}, nil)
+	server.NotifyClientsLatest()
+	update, err = stream.Recv()
+	if assert.NoError(t, err) {
+		assert.Empty(t, update.Announced)
+		if assert.Len(t, update.AnnouncedBgpsecKeys, 1) {
+			assert.Equal(t, uint32(64500), update.AnnouncedBgpsecKeys[0].Asn)
+		}
+		if assert.Len(t, update.WithdrawnAspas, 1) {
+			assert.Equal(t, uint32(64498), update.WithdrawnAspas[0].CustomerAsid)
+		}
+	}
+
+	set, err = client.GetVRPs(ctx, &api.GetVRPsRequest{})
+	if assert.NoError(t, err) {
+		assert.Len(t, set.Vrps, 1)
+		assert.Len(t, set.BgpsecKeys, 1)
+		assert.Empty(t, set.Aspas)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// handoverState is passed by a process handing over its sockets to the one it starts. The
+// listening sockets are the files starting at descriptor 3, followed by the pipe on which
+// the new process tells it is ready.
+type handoverState struct {
+	Listeners []string          `json:"listeners"`
+	Sessions  []handoverSession `json:"sessions"`
+}
+
+// handoverSession is the RTR session of a view, resumed by the new process.
+type handoverSession struct {
+	View      string `json:"view"`
+	SessionId uint16 `json:"sessionId"`
+	Serial    uint32 `json:"serial"`
+	Hash      []byte `json:"hash"`
+}
+
+func (s *state) handoverSessions() []handoverSession {
+	sessions := make([]handoverSession, 0)
+	for _, view := range s.rtrServers() {
+		serial, valid := view.server.GetCurrentSerial(view.server.GetSessionId())
+		if !valid {
+			continue
+		}
+		sessions = append(sessions, handoverSession{
+			View:      view.view,
+			SessionId: view.server.GetSessionId(),
+			Serial:    serial,
+			Hash:      view.server.GetDataHash(),
+		})
+	}
+	return sessions
+}
+
+// resumeSessions continues the RTR sessions of the previous process, so that the routers
+// reconnecting do not need a Cache Reset.
+func (s *state) resumeSessions(sessions []handoverSession) {
+	byView := make(map[string]handoverSession)
+	for _, session := range sessions {
+		byView[session.View] = session
+	}
+	for _, view := range s.rtrServers() {
+		session, ok := byView[view.view]
+		if !ok {
+			continue
+		}
+		log.Infof("Resuming session %d of view %v at serial %d", session.SessionId, view.view, session.Serial)
+		view.server.ResumeSession(session.SessionId, session.Serial, session.Hash)
+	}
+}
+
+// handoverChild is the side of the process the sockets are handed over to.
+type handoverChild struct {
+	ready *os.File
+	once  *sync.Once
+}
+
+// inheritHandover returns the state passed by the process handing over its sockets, if
+// any, after adding its sockets to l.
+func inheritHandover(l *listeners) (*handoverChild, *handoverState, error) {
+	value := os.Getenv(ENV_HANDOVER)
+	if value == "" {
+		return nil, nil, nil
+	}
+	os.Unsetenv(ENV_HANDOVER)
+
+	var state handoverState
+	if err := json.Unmarshal([]byte(value), &state); err != nil {
+		return nil, nil, fmt.Errorf("%v: %v", ENV_HANDOVER, err)
+	}
+	if err := l.Inherit(state.Listeners); err != nil {
+		return nil, nil, err
+	}
+	child := &handoverChild{
+		ready: os.NewFile(uintptr(3+len(state.Listeners)), "handover"),
+		once:  &sync.Once{},
+	}
+	return child, &state, nil
+}
+
+// Ready tells the previous process that this one is serving, so that it can exit.
+func (c *handoverChild) Ready() {
+	c.once.Do(func() {
+		log.Info("Ready, ending the handover")
+		if _, err := c.ready.Write([]byte("ready\n")); err != nil {
+			log.Errorf("Error ending the handover: %v", err)
+		}
+		c.ready.Close()
+	})
+}
+
+// handover starts a new process of the executable with the same arguments, passing it the
+// listening sockets and the RTR sessions. It returns once the new process is ready.
+func (s *state) handover(l *listeners, timeout time.Duration) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	files, names, err := l.Files()
+	if err != nil {
+		return err
+	}
+	defer closeFiles(files)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	state, err := json.Marshal(handoverState{
+		Listeners: names,
+		Sessions:  s.handoverSessions(),
+	})
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = []string{fmt.Sprintf("%v=%s", ENV_HANDOVER, state)}
+	for _, env := range os.Environ() {
+		// The new process becomes the main process of the systemd service
+		if !strings.HasPrefix(env, "WATCHDOG_PID=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	log.Infof("Started %v (pid %d), waiting for it to be ready", executable, cmd.Process.Pid)
+
+	r.SetReadDeadline(time.Now().Add(timeout))
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err == nil && strings.TrimSpace(line) != "ready" {
+		err = fmt.Errorf("unexpected %q", line)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return fmt.Errorf("new process not ready after %v", timeout)
+		}
+		return fmt.Errorf("new process not ready: %v", err)
+	}
+	return nil
+}
+
+// routineHandover hands the sockets over to a new process on SIGUSR2, then exits.
+func (s *state) routineHandover(l *listeners, timeout time.Duration) {
+	if handoverSignal == nil {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, handoverSignal)
+	for range signals {
+		log.Info("Received handover signal")
+		if err := s.handover(l, timeout); err != nil {
+			log.Errorf("Handover failed, still serving: %v", err)
+			continue
+		}
+
+		// The routers reconnect to the new process, at the same session and serial
+		l.Close()
+		for _, view := range s.rtrServers() {
+			for _, c := range view.server.GetClientList() {
+				c.Disconnect()
+			}
+		}
+		log.Info("Handover complete, exiting")
+		os.Exit(0)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenersFiles(t *testing.T) {
+	l := newListeners(false)
+	ln, err := l.Listen("bind", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	files, names, err := l.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"bind"}, names)
+	inherited, err := net.FileListener(files[0])
+	closeFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+	assert.Equal(t, ln.Addr().String(), inherited.Addr().String())
+
+	// The copy accepts the connections once the original is closed
+	ln.Close()
+	go func() {
+		conn, err := net.Dial("tcp", inherited.Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := inherited.Accept()
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+}
+
+func TestKeepAliveListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	for _, period := range []time.Duration{time.Minute, -1} {
+		kl := &keepAliveListener{Listener: ln, period: period}
+		go func() {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err == nil {
+				conn.Close()
+			}
+		}()
+		conn, err := kl.Accept()
+		if assert.NoError(t, err) {
+			assert.IsType(t, &net.TCPConn{}, conn)
+			conn.Close()
+		}
+	}
+}
+
+func TestHandoverSessions(t *testing.T) {
+	vrps := []rtr.VRP{{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496}}
+	previous := &state{server: rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 42}, nil, nil)}
+	previous.server.AddVRPs(nil)
+	previous.server.AddVRPs(vrps)
+	sessions := previous.handoverSessions()
+	assert.Equal(t, []handoverSession{
+		{View: "default", SessionId: 42, Serial: 1, Hash: previous.server.GetDataHash()},
+	}, sessions)
+
+	s := &state{server: rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: -1}, nil, nil)}
+	s.resumeSessions(sessions)
+	s.server.AddVRPs(vrps)
+	assert.Equal(t, uint16(42), s.server.GetSessionId())
+	serial, _ := s.server.GetCurrentSerial(42)
+	assert.Equal(t, uint32(1), serial)
+
+	// Nothing to resume before the first data
+	empty := &state{server: rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 42}, nil, nil)}
+	assert.Empty(t, empty.handoverSessions())
+}
+
+func TestListenersUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rtr.sock")
+	l := newListeners(false)
+	ln, err := l.ListenUnix("bind.unix", path, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0660), info.Mode().Perm())
+	}
+
+	// Served over RTR
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3}, nil, nil)
+	go server.Serve(ln)
+	conn, err := net.Dial("unix", path)
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+
+	// In use by another process
+	_, err = newListeners(false).ListenUnix("bind.unix", path, 0660)
+	assert.Error(t, err)
+
+	files, names, err := l.Files()
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"bind.unix"}, names)
+		closeFiles(files)
+	}
+
+	// Left for the next process, which replaces it once stale
+	l.Close()
+	_, err = os.Stat(path)
+	assert.NoError(t, err)
+	next := newListeners(false)
+	_, err = next.ListenUnix("bind.unix", path, 0600)
+	assert.NoError(t, err)
+	next.Close()
+}
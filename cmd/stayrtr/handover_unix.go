@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var handoverSignal os.Signal = syscall.SIGUSR2
@@ -0,0 +1,8 @@
+package main
+
+import (
+	"os"
+)
+
+// The sockets cannot be handed over on Windows
+var handoverSignal os.Signal
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// serveRTR serves the routers on a listener, which counts as up for /readyz until it is closed.
+func (s *state) serveRTR(serve func() error) {
+	atomic.AddInt32(&s.listening, 1)
+	err := serve()
+	atomic.AddInt32(&s.listening, -1)
+	fatalServe(err)
+}
+
+// readiness returns why the routers should not be sent to this instance, if they should
+// not: no data was served yet, the last refresh of the cache is older than the expire
+// interval the routers keep the data for, or no RTR listener is up.
+func (s *state) readiness(now time.Time) error {
+	s.lockJson.RLock()
+	refreshed, applied := s.refreshed, s.applied
+	s.lockJson.RUnlock()
+	if !applied {
+		return errors.New("initial sync not complete")
+	}
+	if s.expire > 0 && now.Sub(refreshed) > s.expire {
+		return fmt.Errorf("data not refreshed for %v", now.Sub(refreshed).Round(time.Second))
+	}
+	if atomic.LoadInt32(&s.listening) == 0 {
+		return errors.New("no RTR listener up")
+	}
+	return nil
+}
+
+// healthz tells the process is alive.
+func healthz(wr http.ResponseWriter, r *http.Request) {
+	wr.Header().Set("Content-Type", "text/plain")
+	wr.Write([]byte("ok\n"))
+}
+
+// readyz tells whether the instance is ready to serve the routers, see readiness.
+func (s *state) readyz(wr http.ResponseWriter, r *http.Request) {
+	if err := s.readiness(time.Now()); err != nil {
+		http.Error(wr, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	wr.Header().Set("Content-Type", "text/plain")
+	wr.Write([]byte("ok\n"))
+}
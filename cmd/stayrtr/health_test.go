@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	healthz(rec, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestReadyz(t *testing.T) {
+	s := &state{
+		lockJson: &sync.RWMutex{},
+		expire:   time.Hour,
+	}
+	readyz := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.readyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+		return rec
+	}
+
+	rec := readyz()
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "initial sync not complete\n", rec.Body.String())
+
+	// A fetched cache whose data is not served yet does not make the instance ready
+	s.setActiveCache("https://a.example/vrps.json")
+	assert.EqualError(t, s.readiness(time.Now()), "initial sync not complete")
+
+	s.applied = true
+	assert.EqualError(t, s.readiness(time.Now()), "no RTR listener up")
+
+	closed := make(chan struct{})
+	go s.serveRTR(func() error {
+		<-closed
+		return nil
+	})
+	assert.Eventually(t, func() bool { return readyz().Code == http.StatusOK }, time.Second, 10*time.Millisecond)
+	assert.EqualError(t, s.readiness(time.Now().Add(2*time.Hour)), "data not refreshed for 2h0m0s")
+
+	close(closed)
+	assert.Eventually(t, func() bool { return readyz().Code == http.StatusServiceUnavailable }, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+// journalEntry records a serial in the journal: the one served at the start ("start"),
+// then each new serial ("serial"), followed by the change events since the previous one.
+type journalEntry struct {
+	Type      string  `json:"type"`
+	Serial    uint32  `json:"serial"`
+	Previous  *uint32 `json:"previous,omitempty"`
+	SessionID uint16  `json:"session_id"`
+	Time      string  `json:"time"`
+	// Objects served at the serial
+	VRPs       int `json:"vrps"`
+	RouterKeys int `json:"router_keys"`
+	ASPAs      int `json:"aspas"`
+	// Number of change events following the entry
+	Changes int `json:"changes"`
+}
+
+// rotatingFile is an append-only file renamed to file.1 (file.1 to file.2, and so on up to
+// keep files) once it would grow beyond maxBytes.
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	keep     int
+
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxBytes int64, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxBytes: maxBytes, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// rotate renames the file and opens a new one. If it cannot be renamed, it is opened
+// again to be appended to.
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	var err error
+	if r.keep > 0 {
+		for i := r.keep - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		err = os.Rename(r.path, r.path+".1")
+	} else {
+		err = os.Remove(r.path)
+	}
+	if openErr := r.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// writeLine appends a line, the file is rotated before if it would exceed maxBytes. A line
+// is never split: a line larger than maxBytes is written to a file of its own. A line
+// partly written is truncated, so that it can be written again.
+func (r *rotatingFile) writeLine(line []byte) error {
+	if r.maxBytes > 0 && r.size > 0 && r.size+int64(len(line)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.f.Write(line)
+	if err != nil && n > 0 {
+		if truncErr := r.f.Truncate(r.size); truncErr != nil {
+			r.size += int64(n)
+		}
+		return err
+	}
+	r.size += int64(n)
+	return err
+}
+
+func (r *rotatingFile) Sync() error {
+	return r.f.Sync()
+}
+
+// journal writes each serial and its announcements and withdrawals to a JSON lines file, as
+// an audit trail of the data served. The changes are computed as for the eventFeed.
+type journal struct {
+	feed *eventFeed
+	file *rotatingFile
+}
+
+// write appends the values as JSON lines in a single write, so that the lines of a serial
+// are not left in the file by a failed attempt and then written again.
+func (j *journal) write(values ...interface{}) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, value := range values {
+		if err := encoder.Encode(value); err != nil {
+			return err
+		}
+	}
+	return j.file.writeLine(buf.Bytes())
+}
+
+// update records the serial served if it was not yet. When the file cannot be written, the
+// changes are kept for the next attempt.
+func (j *journal) update(now time.Time) error {
+	f := j.feed
+	vrps, keys, aspas, serial, valid := f.server.GetCurrentDataSerial()
+	if !valid || (f.started && serial == f.serial) {
+		return nil
+	}
+	entry := journalEntry{
+		Type:       "start",
+		Serial:     serial,
+		SessionID:  f.server.GetSessionId(),
+		Time:       now.UTC().Format(time.RFC3339),
+		VRPs:       len(vrps),
+		RouterKeys: len(keys),
+		ASPAs:      len(aspas),
+	}
+	var events []changeEvent
+	if f.started {
+		events = f.events(serial, vrps, keys, aspas, now)
+		previous := f.serial
+		entry.Type = "serial"
+		entry.Previous = &previous
+		entry.Changes = len(events)
+	}
+	values := make([]interface{}, 0, len(events)+1)
+	values = append(values, entry)
+	for _, event := range events {
+		values = append(values, event)
+	}
+	if err := j.write(values...); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	f.started = true
+	f.serial, f.vrps, f.keys, f.aspas = serial, vrps, keys, aspas
+	return nil
+}
+
+func (j *journal) run() {
+	notifications := j.feed.server.Subscribe()
+	defer j.feed.server.Unsubscribe(notifications)
+	var retry <-chan time.Time
+	for {
+		retry = nil
+		if err := j.update(time.Now()); err != nil {
+			log.Errorf("Error writing the journal, retrying in %v: %v", eventsRetry, err)
+			retry = time.After(eventsRetry)
+		}
+		select {
+		case <-notifications:
+		case <-retry:
+		}
+	}
+}
+
+// newJournal opens the journal of the serials of a server.
+func newJournal(server *rtr.Server, path string, maxBytes int64, keep int) (*journal, error) {
+	file, err := openRotatingFile(path, maxBytes, keep)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{
+		feed: &eventFeed{server: server},
+		file: file,
+	}, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 42}, nil, nil)
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := newJournal(server, path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	// No data yet
+	assert.NoError(t, j.update(now))
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496},
+	}, nil, nil)
+	assert.NoError(t, j.update(now))
+	start, _ := server.GetCurrentSerial(42)
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("198.51.100.0/24"), MaxLen: 24, ASN: 64497},
+	}, nil, nil)
+	assert.NoError(t, j.update(now))
+	// Already recorded
+	assert.NoError(t, j.update(now))
+	serial, _ := server.GetCurrentSerial(42)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if !assert.Len(t, lines, 4) {
+		return
+	}
+	var entry journalEntry
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, journalEntry{Type: "start", Serial: start, SessionID: 42, Time: "2026-10-16T12:00:00Z", VRPs: 1}, entry)
+	entry = journalEntry{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, journalEntry{Type: "serial", Serial: serial, Previous: &start, SessionID: 42, Time: "2026-10-16T12:00:00Z", VRPs: 1, Changes: 2}, entry)
+	var event changeEvent
+	assert.NoError(t, json.Unmarshal([]byte(lines[2]), &event))
+	assert.Equal(t, "announce", event.Type)
+	assert.Equal(t, "198.51.100.0/24", event.VRP.Prefix)
+	assert.NoError(t, json.Unmarshal([]byte(lines[3]), &event))
+	assert.Equal(t, "withdraw", event.Type)
+	assert.Equal(t, "192.0.2.0/24", event.VRP.Prefix)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	r, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"line1\n", "line2\n", "line3\n", "line4\n"} {
+		assert.NoError(t, r.writeLine([]byte(line)))
+	}
+	for file, expected := range map[string]string{path: "line4\n", path + ".1": "line3\n", path + ".2": "line2\n"} {
+		data, err := os.ReadFile(file)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(data), file)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	// Appended to after a restart
+	r, err = openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, r.writeLine([]byte("l5\n")))
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "line4\nl5\n", string(data))
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// listeners are the listening sockets, by the name of the flag of their address (a flag
+// with several addresses has a socket of the same name for each). They can be inherited
+// from the process handing over its sockets, and handed over to the next one.
+type listeners struct {
+	reusePort bool
+
+	lock      *sync.Mutex
+	inherited map[string][]net.Listener
+	names     []string
+	open      []net.Listener
+}
+
+func newListeners(reusePort bool) *listeners {
+	return &listeners{
+		reusePort: reusePort,
+		lock:      &sync.Mutex{},
+		inherited: make(map[string][]net.Listener),
+	}
+}
+
+// Inherit adds the listening sockets passed as the files starting at descriptor 3, in
+// the order of names.
+func (l *listeners) Inherit(names []string) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for i, name := range names {
+		f := os.NewFile(uintptr(3+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("inherited listener %v: %v", name, err)
+		}
+		l.inherited[name] = append(l.inherited[name], ln)
+	}
+	return nil
+}
+
+// takeInherited returns the next inherited listener of a flag, in the order they were passed.
+func (l *listeners) takeInherited(name string) (net.Listener, bool) {
+	inherited := l.inherited[name]
+	if len(inherited) == 0 {
+		return nil, false
+	}
+	l.inherited[name] = inherited[1:]
+	if len(l.inherited[name]) == 0 {
+		delete(l.inherited, name)
+	}
+	log.Infof("Using the inherited listener %v on %v", name, inherited[0].Addr())
+	return inherited[0], true
+}
+
+// Listen returns the inherited listener of a flag, or listens on its address.
+func (l *listeners) Listen(name string, addr string) (net.Listener, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	ln, ok := l.takeInherited(name)
+	if !ok {
+		var lc net.ListenConfig
+		if l.reusePort {
+			lc.Control = reusePortControl
+		}
+		var err error
+		ln, err = lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	l.names = append(l.names, name)
+	l.open = append(l.open, ln)
+	return ln, nil
+}
+
+// keepAliveListener sets the period of the TCP keepalives of the accepted connections, they
+// are disabled if it is negative. It also applies to the inherited listeners.
+type keepAliveListener struct {
+	net.Listener
+	period time.Duration
+}
+
+func (l *keepAliveListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		if l.period < 0 {
+			tc.SetKeepAlive(false)
+		} else {
+			tc.SetKeepAlive(true)
+			tc.SetKeepAlivePeriod(l.period)
+		}
+	}
+	return conn, nil
+}
+
+// ListenUnix returns the inherited listener of a flag, or listens on a unix socket. A stale
+// socket file left by a previous process is removed.
+func (l *listeners) ListenUnix(name string, path string, mode os.FileMode) (net.Listener, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	ln, ok := l.takeInherited(name)
+	if !ok {
+		if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", path); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("%v is in use", path)
+			}
+			os.Remove(path)
+		}
+		var err error
+		ln, err = net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	l.names = append(l.names, name)
+	l.open = append(l.open, ln)
+	return ln, nil
+}
+
+// CloseInherited closes the inherited listeners not used, e.g. after a flag was removed.
+func (l *listeners) CloseInherited() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for name, inherited := range l.inherited {
+		for _, ln := range inherited {
+			log.Infof("Closing the inherited listener %v on %v", name, ln.Addr())
+			ln.Close()
+		}
+		delete(l.inherited, name)
+	}
+}
+
+// Files returns a copy of the listening sockets and their names, to pass them to another process.
+func (l *listeners) Files() ([]*os.File, []string, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	files := make([]*os.File, 0, len(l.names))
+	for i, name := range l.names {
+		var f *os.File
+		var err error
+		switch ln := l.open[i].(type) {
+		case *net.TCPListener:
+			f, err = ln.File()
+		case *net.UnixListener:
+			f, err = ln.File()
+		default:
+			err = fmt.Errorf("listener %v cannot be handed over", name)
+		}
+		if err != nil {
+			closeFiles(files)
+			return nil, nil, err
+		}
+		files = append(files, f)
+	}
+	names := make([]string, len(l.names))
+	copy(names, l.names)
+	return files, names, nil
+}
+
+// Close stops accepting connections. The unix sockets are left for the process they are
+// handed over to.
+func (l *listeners) Close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for _, ln := range l.open {
+		if unixln, ok := ln.(*net.UnixListener); ok {
+			unixln.SetUnlinkOnClose(false)
+		}
+		ln.Close()
+	}
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// fatalServe exits on the error of a server, unless its listener was closed for a handover.
+func fatalServe(err error) {
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	rtr "github.com/bgp/stayrtr/lib"
+	log "github.com/sirupsen/logrus"
+)
+
+// rtrLogger logs the messages of the RTR servers with their structured fields (client
+// address, serial, PDU type), which are kept as fields in the JSON format.
+type rtrLogger struct {
+	*log.Entry
+}
+
+func newRTRLogger(logger *log.Logger) rtrLogger {
+	return rtrLogger{log.NewEntry(logger)}
+}
+
+func (l rtrLogger) WithFields(fields rtr.Fields) rtr.Logger {
+	return rtrLogger{l.Entry.WithFields(log.Fields(fields))}
+}
+
+// setLogFormat sets the format of the logs, one of LOG_FORMAT_*.
+func setLogFormat(format int) {
+	switch format {
+	case LOG_FORMAT_JSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.SetFormatter(&log.TextFormatter{})
+	}
+}
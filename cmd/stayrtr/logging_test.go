@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRTRLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&log.JSONFormatter{})
+
+	var l rtr.Logger = newRTRLogger(logger)
+	l.(rtr.FieldLogger).WithFields(rtr.Fields{"client": "192.0.2.1:1234", "serial": 42}).Infof("Sent %d VRPs", 10)
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+	assert.Equal(t, "Sent 10 VRPs", entry["msg"])
+	assert.Equal(t, "192.0.2.1:1234", entry["client"])
+	assert.Equal(t, float64(42), entry["serial"])
+}
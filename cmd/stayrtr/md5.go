@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tcpMD5MaxKeyLen is the maximum length of a TCP MD5 key (TCP_MD5SIG_MAXKEYLEN).
+const tcpMD5MaxKeyLen = 80
+
+// tcpMD5Keys are the TCP MD5 keys (RFC 2385) of the routers, set on the RTR listeners.
+// They are read from a file with a router address or prefix and its key on each line.
+type tcpMD5Keys struct {
+	lock      *sync.Mutex
+	path      string
+	listeners []net.Listener
+	// Keys by prefix
+	keys map[string]tcpMD5Key
+}
+
+type tcpMD5Key struct {
+	Prefix *net.IPNet
+	Key    string
+}
+
+func newTCPMD5Keys(path string) *tcpMD5Keys {
+	return &tcpMD5Keys{
+		lock: &sync.Mutex{},
+		path: path,
+		keys: make(map[string]tcpMD5Key),
+	}
+}
+
+// parseTCPMD5Keys returns the keys by prefix, a single address being a /32 or /128.
+func parseTCPMD5Keys(data string, source string) (map[string]tcpMD5Key, error) {
+	keys := make(map[string]tcpMD5Key)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%v:%d: expected an address or prefix and a key", source, number)
+		}
+		prefix, err := parseAddressOrPrefix(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%v:%d: %v", source, number, err)
+		}
+		if len(fields[1]) > tcpMD5MaxKeyLen {
+			return nil, fmt.Errorf("%v:%d: key longer than %d bytes", source, number, tcpMD5MaxKeyLen)
+		}
+		keys[prefix.String()] = tcpMD5Key{Prefix: prefix, Key: fields[1]}
+	}
+	return keys, scanner.Err()
+}
+
+func parseAddressOrPrefix(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, prefix, err := net.ParseCIDR(value)
+		return prefix, err
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", value)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// Add sets the keys on a listener, and on each reload.
+func (k *tcpMD5Keys) Add(ln net.Listener) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	for prefix, key := range k.keys {
+		if err := setTCPMD5(ln, key.Prefix, key.Key); err != nil {
+			return fmt.Errorf("TCP MD5 key of %v on %v: %v", prefix, ln.Addr(), err)
+		}
+	}
+	k.listeners = append(k.listeners, ln)
+	return nil
+}
+
+// Load reads the file again and updates the keys of the listeners, removing the keys of
+// the prefixes no longer in the file. On error reading it, the previous keys are kept.
+func (k *tcpMD5Keys) Load() error {
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+	keys, err := parseTCPMD5Keys(string(data), k.path)
+	if err != nil {
+		return err
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	for _, ln := range k.listeners {
+		for prefix, key := range keys {
+			if k.keys[prefix].Key == key.Key {
+				continue
+			}
+			if err := setTCPMD5(ln, key.Prefix, key.Key); err != nil {
+				log.Errorf("Error setting the TCP MD5 key of %v on %v: %v", prefix, ln.Addr(), err)
+			}
+		}
+		for prefix, key := range k.keys {
+			if _, ok := keys[prefix]; ok {
+				continue
+			}
+			if err := setTCPMD5(ln, key.Prefix, ""); err != nil {
+				log.Errorf("Error removing the TCP MD5 key of %v on %v: %v", prefix, ln.Addr(), err)
+			}
+		}
+	}
+	k.keys = keys
+	return nil
+}
+
+func (k *tcpMD5Keys) Count() int {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return len(k.keys)
+}
+
+// routineReload loads the keys again after each reload of the configuration.
+func (k *tcpMD5Keys) routineReload(reloads <-chan struct{}) {
+	for range reloads {
+		if err := k.Load(); err != nil {
+			log.Errorf("Error reloading the TCP MD5 keys, keeping the previous ones: %v", err)
+			continue
+		}
+		log.Infof("Reloaded %d TCP MD5 key(s)", k.Count())
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// setTCPMD5 sets the TCP MD5 key of the peers of a prefix on a listener, the connections
+// accepted inherit it. An empty key removes it.
+func setTCPMD5(ln net.Listener, prefix *net.IPNet, key string) error {
+	tcpln, ok := ln.(*net.TCPListener)
+	if !ok {
+		return errors.New("not a TCP listener")
+	}
+	rc, err := tcpln.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		sockErr = setTCPMD5Socket(int(fd), prefix, key)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+func setTCPMD5Socket(fd int, prefix *net.IPNet, key string) error {
+	domain, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_DOMAIN)
+	if err != nil {
+		return err
+	}
+	sig, opt, ok := tcpMD5Sig(domain, prefix, key)
+	if !ok {
+		// No IPv6 peer on an IPv4 socket
+		return nil
+	}
+	return unix.SetsockoptTCPMD5Sig(fd, unix.IPPROTO_TCP, opt, sig)
+}
+
+// tcpMD5Sig returns the TCP_MD5SIG(_EXT) option setting the key of a prefix on a socket of
+// a domain, false if the peers of the prefix cannot connect to the socket.
+func tcpMD5Sig(domain int, prefix *net.IPNet, key string) (*unix.TCPMD5Sig, int, bool) {
+	sig := &unix.TCPMD5Sig{Keylen: uint16(len(key))}
+	copy(sig.Key[:], key)
+	ones, bits := prefix.Mask.Size()
+	ip4 := prefix.IP.To4()
+	switch {
+	case domain == unix.AF_INET && ip4 != nil:
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(&sig.Addr))
+		sa.Family = unix.AF_INET
+		copy(sa.Addr[:], ip4)
+	case domain == unix.AF_INET6:
+		// The IPv4 peers of an IPv6 socket have IPv4-mapped addresses (::ffff:0:0/96)
+		sa := (*unix.RawSockaddrInet6)(unsafe.Pointer(&sig.Addr))
+		sa.Family = unix.AF_INET6
+		copy(sa.Addr[:], prefix.IP.To16())
+		if ip4 != nil && bits == 8*net.IPv4len {
+			ones, bits = ones+96, 8*net.IPv6len
+		}
+	default:
+		return nil, 0, false
+	}
+
+	opt := unix.TCP_MD5SIG
+	if ones != bits {
+		opt = unix.TCP_MD5SIG_EXT
+		sig.Flags = unix.TCP_MD5SIG_FLAG_PREFIX
+		sig.Prefixlen = uint8(ones)
+	}
+	return sig, opt, true
+}
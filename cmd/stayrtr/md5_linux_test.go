@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestTCPMD5Keys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "md5.keys")
+	if err := os.WriteFile(file, []byte("127.0.0.1 secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys := newTCPMD5Keys(file)
+	if err := keys.Load(); err != nil {
+		t.Fatal(err)
+	}
+	listen := func(addr string) net.Listener {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keys.Add(ln); err != nil {
+			ln.Close()
+			t.Skipf("TCP MD5 not supported: %v", err)
+		}
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+		return ln
+	}
+	ln := listen("127.0.0.1:0")
+	defer ln.Close()
+	// The IPv4 routers connecting to a dual-stack bind
+	dualStack := listen(":0")
+	defer dualStack.Close()
+
+	dial := func(port int, key string) error {
+		dialer := net.Dialer{
+			Timeout: 500 * time.Millisecond,
+			Control: func(network, address string, c syscall.RawConn) error {
+				if key == "" {
+					return nil
+				}
+				var err error
+				c.Control(func(fd uintptr) {
+					err = setTCPMD5Socket(int(fd), &net.IPNet{IP: net.IPv4(127, 0, 0, 1), Mask: net.CIDRMask(32, 32)}, key)
+				})
+				return err
+			},
+		}
+		conn, err := dialer.DialContext(context.Background(), "tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	assert.NoError(t, dial(port, "secret"))
+	// The segments without the key are dropped
+	assert.Error(t, dial(port, ""))
+	assert.Error(t, dial(port, "wrong"))
+	assert.NoError(t, dial(dualStack.Addr().(*net.TCPAddr).Port, "secret"))
+	assert.Error(t, dial(dualStack.Addr().(*net.TCPAddr).Port, ""))
+
+	// Removed on reload
+	if err := os.WriteFile(file, []byte("# none\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, keys.Load())
+	assert.NoError(t, dial(port, ""))
+}
+
+func TestTCPMD5SigPrefix(t *testing.T) {
+	mustParseCIDR := func(s string) *net.IPNet {
+		_, prefix, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return prefix
+	}
+	tests := []struct {
+		domain    int
+		prefix    string
+		opt       int
+		prefixlen uint8
+	}{
+		{unix.AF_INET, "192.0.2.0/24", unix.TCP_MD5SIG_EXT, 24},
+		{unix.AF_INET, "192.0.2.1/32", unix.TCP_MD5SIG, 0},
+		// IPv4-mapped on a dual-stack socket
+		{unix.AF_INET6, "192.0.2.0/24", unix.TCP_MD5SIG_EXT, 120},
+		{unix.AF_INET6, "192.0.2.1/32", unix.TCP_MD5SIG, 0},
+		{unix.AF_INET6, "2001:db8::/32", unix.TCP_MD5SIG_EXT, 32},
+	}
+	for _, tc := range tests {
+		sig, opt, ok := tcpMD5Sig(tc.domain, mustParseCIDR(tc.prefix), "secret")
+		if !assert.True(t, ok, tc.prefix) {
+			continue
+		}
+		assert.Equal(t, tc.opt, opt, tc.prefix)
+		assert.Equal(t, tc.prefixlen, sig.Prefixlen, tc.prefix)
+	}
+	_, _, ok := tcpMD5Sig(unix.AF_INET, mustParseCIDR("2001:db8::/32"), "secret")
+	assert.False(t, ok)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func setTCPMD5(ln net.Listener, prefix *net.IPNet, key string) error {
+	return errors.New("TCP MD5 is only supported on Linux")
+}
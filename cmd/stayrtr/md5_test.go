@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTCPMD5Keys(t *testing.T) {
+	keys, err := parseTCPMD5Keys(`
+# Routers
+192.0.2.1 secret1
+2001:db8::1   secret2
+198.51.100.0/24 secret3
+`, "md5.keys")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, keys, 3)
+	assert.Equal(t, "secret1", keys["192.0.2.1/32"].Key)
+	assert.Equal(t, "secret2", keys["2001:db8::1/128"].Key)
+	assert.Equal(t, "secret3", keys["198.51.100.0/24"].Key)
+
+	_, err = parseTCPMD5Keys("192.0.2.1", "md5.keys")
+	assert.EqualError(t, err, "md5.keys:1: expected an address or prefix and a key")
+	_, err = parseTCPMD5Keys("router1 secret", "md5.keys")
+	assert.Error(t, err)
+	_, err = parseTCPMD5Keys("192.0.2.0/33 secret", "md5.keys")
+	assert.Error(t, err)
+	_, err = parseTCPMD5Keys("192.0.2.1 "+string(make([]byte, tcpMD5MaxKeyLen+1)), "md5.keys")
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/bgp/stayrtr/prefixfile"
+)
+
+// reportOnce fetches the cache and the Slurm files as at startup, processes the data and
+// prints what would be served, without starting the listeners. It returns an error if
+// the files cannot be used, e.g. when the cache is stale.
+func (s *state) reportOnce(w io.Writer) error {
+	if len(s.slurmPaths) > 0 {
+		if _, err := s.updateSlurms(s.slurmPaths); err != nil {
+			return err
+		}
+	}
+	if _, err := s.updateCaches(); err != nil {
+		return err
+	}
+
+	data := s.lastdata
+	buildtime := data.Metadata.Buildtime
+	if buildtime == "" {
+		buildtime = "unknown"
+	}
+	fmt.Fprintf(w, "cache %s (built %s): %d VRPs, %d router keys, %d ASPAs\n",
+		s.getActiveCache(), buildtime, len(data.Data), len(data.BgpsecKeys), len(data.ASPA))
+
+	vrpsjson := data.Data
+	keysjson := data.BgpsecKeys
+	aspasjson := data.ASPA
+	if s.slurm != nil {
+		kept, removed := s.slurm.FilterOnVRPs(vrpsjson)
+		assertedVRPs := make([]prefixfile.VRPJson, 0)
+		for _, file := range s.slurmFiles {
+			assertedVRPs = append(assertedVRPs, s.slurmConfigs[file].AssertVRPs()...)
+		}
+		vrpsjson = append(kept, assertedVRPs...)
+		_, removedKeys := s.slurm.FilterOnBgpsecKeys(keysjson)
+		assertedKeys := s.slurm.AssertBgpsecKeys()
+		keysjson = s.slurm.FilterAssertBgpsecKeys(keysjson)
+		// An asserted ASPA replaces the one of its customer
+		_, removedASPAs := s.slurm.FilterOnASPAs(aspasjson)
+		assertedASPAs := s.slurm.AssertASPAs()
+		aspasjson = s.slurm.FilterAssertASPAs(aspasjson)
+		fmt.Fprintf(w, "slurm %s: %d VRPs filtered, %d asserted; %d router keys filtered, %d asserted; %d ASPAs filtered, %d asserted\n",
+			strings.Join(s.slurmFiles, ","), len(removed), len(assertedVRPs), len(removedKeys), len(assertedKeys), len(removedASPAs), len(assertedASPAs))
+	}
+
+	vrps, stats := processData(vrpsjson, s.strict)
+	keys := processBgpsecKeys(keysjson)
+	aspas := processASPAs(aspasjson)
+	if len(stats.Invalid) > 0 {
+		reasons := make([]string, 0, len(stats.Invalid))
+		for reason := range stats.Invalid {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "invalid VRPs (%s): %d\n", reason, stats.Invalid[reason])
+		}
+	}
+	if ignored := len(keysjson) - len(keys); ignored > 0 {
+		fmt.Fprintf(w, "invalid router keys: %d\n", ignored)
+	}
+	if ignored := len(aspasjson) - len(aspas); ignored > 0 {
+		fmt.Fprintf(w, "invalid ASPAs: %d\n", ignored)
+	}
+	fmt.Fprintf(w, "%d VRPs (%d IPv4, %d IPv6, %d unique), %d ASNs, %d router keys, %d ASPAs\n",
+		stats.Count, stats.CountV4, stats.CountV6, len(vrps), stats.ASNs, len(keys), len(aspas))
+	return nil
+}
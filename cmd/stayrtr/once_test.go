@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/bgp/stayrtr/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportOnce(t *testing.T) {
+	cache := filepath.Join(t.TempDir(), "vrps.json")
+	data := `{
+  "metadata": {"buildtime": "2021-07-27T18:56:02Z"},
+  "roas": [
+    {"prefix": "192.0.2.0/24", "maxLength": 24, "asn": 64496},
+    {"prefix": "192.0.2.0/24", "maxLength": 24, "asn": 64496},
+    {"prefix": "198.51.100.0/24", "maxLength": 16, "asn": 64497},
+    {"prefix": "2001:db8::/32", "maxLength": 48, "asn": "AS64498"}
+  ]
+}`
+	if err := os.WriteFile(cache, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &state{
+		caches:      []string{cache},
+		slurmPaths:  []string{"test.slurm.json"},
+		lastdata:    &prefixfile.VRPList{},
+		lockJson:    &sync.RWMutex{},
+		fetchConfig: utils.NewFetchConfig(),
+	}
+	var out bytes.Buffer
+	assert.NoError(t, s.reportOnce(&out))
+	assert.Equal(t, "cache "+cache+" (built 2021-07-27T18:56:02Z): 4 VRPs, 0 router keys, 0 ASPAs\n"+
+		"slurm test.slurm.json: 0 VRPs filtered, 1 asserted; 0 router keys filtered, 0 asserted; 0 ASPAs filtered, 0 asserted\n"+
+		"invalid VRPs (maxlength_too_short): 1\n"+
+		"4 VRPs (3 IPv4, 1 IPv6, 3 unique), 3 ASNs, 0 router keys, 0 ASPAs\n", out.String())
+
+	s = &state{
+		caches:      []string{filepath.Join(t.TempDir(), "missing.json")},
+		lastdata:    &prefixfile.VRPList{},
+		lockJson:    &sync.RWMutex{},
+		fetchConfig: utils.NewFetchConfig(),
+	}
+	assert.Error(t, s.reportOnce(&out))
+}
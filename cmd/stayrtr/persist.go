@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/bgp/stayrtr/prefixfile"
+	log "github.com/sirupsen/logrus"
+)
+
+// persist saves the data of the cache once it is served, unless it is already saved or
+// was itself loaded from the file.
+// The file is written to a temporary file renamed over it, so that a crash cannot leave
+// a truncated file behind.
+func (s *state) persist() error {
+	if s.persistFile == "" || s.lasthash == nil || bytes.Equal(s.persistedHash, s.lasthash) {
+		return nil
+	}
+	data, err := json.Marshal(s.lastdata)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.persistFile, data); err != nil {
+		return err
+	}
+
+	s.persistedHash = s.lasthash
+	log.Debugf("Saved %d VRPs to %v", len(s.lastdata.Data), s.persistFile)
+	return nil
+}
+
+// writeFileAtomic writes a temporary file renamed over the file.
+func writeFileAtomic(file string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// sessionState is saved next to the persisted data with a seeded session ID, so that the
+// sessions are resumed at their serial after a restart instead of counting again from 0
+// under the same session ID.
+type sessionState struct {
+	// Random part of the seed of the session ID, drawn again when the serials are lost
+	Epoch    string            `json:"epoch"`
+	Sessions []handoverSession `json:"sessions"`
+}
+
+func sessionStateFile(persistFile string) string {
+	return persistFile + ".session"
+}
+
+// newSessionState returns the state of sessions starting from scratch, with a new epoch.
+func newSessionState() (*sessionState, error) {
+	epoch := make([]byte, 8)
+	if _, err := rand.Read(epoch); err != nil {
+		return nil, err
+	}
+	return &sessionState{Epoch: hex.EncodeToString(epoch)}, nil
+}
+
+// loadSessionState reads the state saved by persistSession.
+func loadSessionState(file string) (*sessionState, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var session sessionState
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	if session.Epoch == "" {
+		return nil, errors.New("no epoch")
+	}
+	return &session, nil
+}
+
+// resumable returns the saved sessions which have the session ID.
+func (st *sessionState) resumable(sessionID uint16) []handoverSession {
+	sessions := make([]handoverSession, 0)
+	for _, session := range st.Sessions {
+		if session.SessionId == sessionID {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
+// persistSession saves the serials of the sessions after an update.
+func (s *state) persistSession() error {
+	if s.session == nil {
+		return nil
+	}
+	s.session.Sessions = s.handoverSessions()
+	data, err := json.Marshal(s.session)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(sessionStateFile(s.persistFile), data)
+}
+
+// loadPersisted reads the data saved by persist.
+func loadPersisted(file string) (*prefixfile.VRPList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	vrplistjson, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	for i := range vrplistjson.Data {
+		vrplistjson.Data[i].Source = file
+	}
+	return vrplistjson, nil
+}
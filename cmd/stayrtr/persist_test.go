@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersist(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vrps.json")
+	s := &state{
+		lockJson:    &sync.RWMutex{},
+		persistFile: file,
+		lastdata: &prefixfile.VRPList{
+			Metadata: prefixfile.MetaData{Counts: 1, Buildtime: "2021-07-27T18:56:02Z"},
+			Data: []prefixfile.VRPJson{
+				{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64496", TA: "ripe", Source: "https://a.example/vrps.json"},
+			},
+			ASPA: []prefixfile.ASPAJson{{CustomerASID: 64496, Providers: []uint32{64500}}},
+		},
+	}
+
+	// Data loaded from the file is not saved again
+	assert.NoError(t, s.persist())
+	_, err := os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+
+	s.lasthash = []byte{1}
+	assert.NoError(t, s.persist())
+	persisted, err := loadPersisted(file)
+	if assert.NoError(t, err) {
+		assert.Equal(t, s.lastdata.Metadata, persisted.Metadata)
+		assert.Equal(t, s.lastdata.ASPA, persisted.ASPA)
+		assert.Equal(t, []prefixfile.VRPJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: "AS64496", TA: "ripe", Source: file},
+		}, persisted.Data)
+	}
+
+	// Not saved again until the data changes
+	assert.NoError(t, os.Remove(file))
+	assert.NoError(t, s.persist())
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+
+	s.lasthash = []byte{2}
+	assert.NoError(t, s.persist())
+	_, err = os.Stat(file)
+	assert.NoError(t, err)
+	entries, _ := os.ReadDir(filepath.Dir(file))
+	assert.Len(t, entries, 1)
+}
+
+func TestPersistSession(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vrps.json")
+	_, err := loadSessionState(sessionStateFile(file))
+	assert.True(t, os.IsNotExist(err))
+	session, err := newSessionState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vrps := []rtr.VRP{{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496}}
+	previous := &state{
+		server:      rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 42}, nil, nil),
+		persistFile: file,
+		session:     session,
+	}
+	previous.server.AddVRPs(nil)
+	previous.server.AddVRPs(vrps)
+	assert.NoError(t, previous.persistSession())
+
+	// The sessions of the same ID are resumed at their serial after a restart
+	loaded, err := loadSessionState(sessionStateFile(file))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, session.Epoch, loaded.Epoch)
+	assert.Empty(t, loaded.resumable(43))
+	s := &state{server: rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 42}, nil, nil)}
+	s.resumeSessions(loaded.resumable(42))
+	s.server.AddVRPs(vrps)
+	serial, _ := s.server.GetCurrentSerial(42)
+	assert.Equal(t, uint32(1), serial)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Time a connection has to send its PROXY protocol header.
+const proxyHeaderTimeout = 10 * time.Second
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// readProxyHeader reads a PROXY protocol header (version 1 or 2) and returns the address
+// of the client, or nil if the connection was not proxied (LOCAL or UNKNOWN).
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	signature, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(signature, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(signature, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+	return nil, errors.New("no PROXY protocol header")
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	// At most 107 bytes
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= 107 {
+			return nil, errors.New("PROXY protocol header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY protocol header %q", strings.TrimSpace(string(line)))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid PROXY protocol source %v %v", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", header[12]>>4)
+	}
+	data := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	command, family := header[12]&0xf, header[13]
+	if command == 0 {
+		// LOCAL, e.g. a health check of the load balancer
+		return nil, nil
+	}
+	if command != 1 {
+		return nil, fmt.Errorf("unsupported PROXY protocol command %d", command)
+	}
+	switch family {
+	case 0x11:
+		// TCP over IPv4: source, destination, source port, destination port
+		if len(data) < 12 {
+			return nil, errors.New("PROXY protocol addresses too short")
+		}
+		return &net.TCPAddr{IP: net.IP(data[0:4]), Port: int(binary.BigEndian.Uint16(data[8:10]))}, nil
+	case 0x21:
+		// TCP over IPv6
+		if len(data) < 36 {
+			return nil, errors.New("PROXY protocol addresses too short")
+		}
+		return &net.TCPAddr{IP: net.IP(data[0:16]), Port: int(binary.BigEndian.Uint16(data[32:34]))}, nil
+	default:
+		// Other protocols, the address is not used
+		return nil, nil
+	}
+}
+
+// proxyConn is a connection with the address of the client given by the load balancer.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// proxyListener reads the PROXY protocol header of the connections from the trusted
+// sources (none if empty), the other connections are used as is. The headers are read
+// in their own goroutine so that a slow client does not block the others.
+type proxyListener struct {
+	net.Listener
+	name    string
+	trusted []*net.IPNet
+
+	conns     chan net.Conn
+	errs      chan error
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+func newProxyListener(ln net.Listener, name string, trusted []*net.IPNet) *proxyListener {
+	l := &proxyListener{
+		Listener:  ln,
+		name:      name,
+		trusted:   trusted,
+		conns:     make(chan net.Conn),
+		errs:      make(chan error),
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *proxyListener) isTrusted(addr net.Addr) bool {
+	ip := addrIP(addr)
+	for _, prefix := range l.trusted {
+		if ip != nil && prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *proxyListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.closed:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if !l.isTrusted(conn.RemoteAddr()) {
+			l.deliver(conn)
+			continue
+		}
+		go func() {
+			proxied, err := readProxyConn(conn)
+			if err != nil {
+				log.Warnf("Closing connection on %v from %v: %v", l.name, conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			l.deliver(proxied)
+		}()
+	}
+}
+
+// readProxyConn reads the header of a connection and returns it with the address of the client.
+func readProxyConn(conn net.Conn) (net.Conn, error) {
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	remote, err := readProxyHeader(r)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		remote = conn.RemoteAddr()
+	}
+	return &proxyConn{Conn: conn, r: r, remote: remote}, nil
+}
+
+func (l *proxyListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *proxyListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	return l.Listener.Close()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func proxyV2Header(command byte, family byte, addresses []byte) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	read := func(data string) (net.Addr, string, error) {
+		r := bufio.NewReader(strings.NewReader(data))
+		addr, err := readProxyHeader(r)
+		rest, _ := io.ReadAll(r)
+		return addr, string(rest), err
+	}
+
+	addr, rest, err := read("PROXY TCP4 192.0.2.1 198.51.100.1 40000 323\r\nRTR")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:40000", addr.String())
+	assert.Equal(t, "RTR", rest)
+	addr, _, err = read("PROXY TCP6 2001:db8::1 2001:db8::2 40000 323\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:40000", addr.String())
+	addr, rest, err = read("PROXY UNKNOWN\r\nRTR")
+	assert.NoError(t, err)
+	assert.Nil(t, addr)
+	assert.Equal(t, "RTR", rest)
+
+	_, _, err = read("PROXY TCP4 2001:db8::1 198.51.100.1 40000 323\r\n")
+	assert.Error(t, err)
+	_, _, err = read("PROXY TCP4 192.0.2.1 198.51.100.1 40000\r\n")
+	assert.Error(t, err)
+	_, _, err = read("PROXY " + strings.Repeat("x", 200))
+	assert.Error(t, err)
+	_, _, err = read("\x02\x00\x00\x00\x00\x00\x00\x00\x0c\x00\x00\x00\x01")
+	assert.Error(t, err)
+
+	v4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0x9c, 0x40, 0x01, 0x43}
+	addr, rest, err = read(string(proxyV2Header(1, 0x11, v4)) + "RTR")
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:40000", addr.String())
+	assert.Equal(t, "RTR", rest)
+
+	v6 := append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...)
+	v6 = append(v6, 0x9c, 0x40, 0x01, 0x43)
+	// With a TLV after the addresses
+	v6 = append(v6, 0x04, 0x00, 0x01, 0x00)
+	addr, _, err = read(string(proxyV2Header(1, 0x21, v6)))
+	assert.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:40000", addr.String())
+
+	// Health check of the load balancer
+	addr, _, err = read(string(proxyV2Header(0, 0, nil)))
+	assert.NoError(t, err)
+	assert.Nil(t, addr)
+
+	_, _, err = read(string(proxyV2Header(1, 0x11, v4[:8])))
+	assert.Error(t, err)
+}
+
+func TestProxyListener(t *testing.T) {
+	listen := func(trusted string) *proxyListener {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefixes, _ := parseACL(trusted, "")
+		return newProxyListener(ln, "bind", prefixes)
+	}
+	proxied := listen("127.0.0.0/8")
+	defer proxied.Close()
+
+	send := func(header string) {
+		conn, err := net.Dial("tcp", proxied.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(header + "RTR"))
+		// Closed with the listener
+		go func() {
+			io.Copy(io.Discard, conn)
+			conn.Close()
+		}()
+	}
+	accept := func() (net.Conn, string) {
+		conn, err := proxied.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		data := make([]byte, 3)
+		io.ReadFull(conn, data)
+		return conn, string(data)
+	}
+
+	// A connection without a header does not block the next ones
+	send("")
+	send("PROXY TCP4 192.0.2.1 198.51.100.1 40000 323\r\n")
+	conn, data := accept()
+	assert.Equal(t, "192.0.2.1:40000", conn.RemoteAddr().String())
+	assert.Equal(t, "RTR", data)
+	conn.Close()
+
+	proxied.Close()
+	_, err := proxied.Accept()
+	assert.ErrorIs(t, err, net.ErrClosed)
+
+	// Untrusted sources send no header, nothing is trusted by default
+	proxied = listen("")
+	defer proxied.Close()
+	send("")
+	conn, data = accept()
+	assert.Equal(t, "127.0.0.1", addrIP(conn.RemoteAddr()).String())
+	assert.Equal(t, "RTR", data)
+	conn.Close()
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// reloadableConfig is the part of the configuration applied again on SIGHUP.
+type reloadableConfig struct {
+	LogLevel      string
+	Refresh       int
+	Slurm         string
+	SlurmInterval int
+	KeepDiff      int
+	ACL           string
+	ACLFile       string
+
+	TLSCert         string
+	TLSKey          string
+	SSHKey          string
+	SSHAuthUser     string
+	SSHAuthPassword string
+}
+
+func currentReloadableConfig() reloadableConfig {
+	return reloadableConfig{
+		LogLevel:        *LogLevel,
+		Refresh:         *RefreshInterval,
+		Slurm:           Slurm.String(),
+		SlurmInterval:   *SlurmInterval,
+		KeepDiff:        *KeepDiff,
+		ACL:             *ACL,
+		ACLFile:         *ACLFile,
+		TLSCert:         *TLSCert,
+		TLSKey:          *TLSKey,
+		SSHKey:          *SSHKey,
+		SSHAuthUser:     *SSHAuthUser,
+		SSHAuthPassword: *SSHAuthPassword,
+	}
+}
+
+// readReloadableConfig reads the configuration file again. The flags set on the command
+// line keep their value, the ones absent from the file get their default value.
+func readReloadableConfig(file string, cli map[string]bool) (reloadableConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return reloadableConfig{}, err
+	}
+	values, err := decodeConfig(file, data)
+	if err != nil {
+		return reloadableConfig{}, fmt.Errorf("%v: %v", file, err)
+	}
+	for name, v := range values {
+		if name == "config" {
+			return reloadableConfig{}, fmt.Errorf("%v: config cannot be set in the configuration file", file)
+		}
+		if flag.Lookup(name) == nil {
+			return reloadableConfig{}, fmt.Errorf("%v: unknown flag %v", file, name)
+		}
+		if aliased := flagName(name); aliased != name {
+			delete(values, name)
+			values[aliased] = v
+		}
+	}
+
+	value := func(name string) string {
+		f := flag.Lookup(name)
+		if cli[name] {
+			return f.Value.String()
+		}
+		if v, ok := values[name]; ok {
+			return v
+		}
+		return f.DefValue
+	}
+	intValue := func(name string) (int, error) {
+		v, err := strconv.Atoi(value(name))
+		if err != nil {
+			return 0, fmt.Errorf("%v: %v: %v", file, name, err)
+		}
+		return v, nil
+	}
+
+	config := reloadableConfig{
+		LogLevel:        value("loglevel"),
+		Slurm:           value("slurm"),
+		ACL:             value("acl"),
+		ACLFile:         value("acl.file"),
+		TLSCert:         value("tls.cert"),
+		TLSKey:          value("tls.key"),
+		SSHKey:          value("ssh.key"),
+		SSHAuthUser:     value("ssh.auth.user"),
+		SSHAuthPassword: value("ssh.auth.password"),
+	}
+	if _, err := log.ParseLevel(config.LogLevel); err != nil {
+		return reloadableConfig{}, fmt.Errorf("%v: loglevel: %v", file, err)
+	}
+	if config.Refresh, err = intValue("refresh"); err != nil {
+		return reloadableConfig{}, err
+	}
+	if config.SlurmInterval, err = intValue("slurm.interval"); err != nil {
+		return reloadableConfig{}, err
+	}
+	if config.KeepDiff, err = intValue("rtr.keepdiff"); err != nil {
+		return reloadableConfig{}, err
+	}
+	if config.KeepDiff < 1 {
+		return reloadableConfig{}, fmt.Errorf("%v: rtr.keepdiff must be at least 1", file)
+	}
+	return config, nil
+}
+
+// reloader applies the configuration again on SIGHUP, then triggers the refreshes of
+// the cache and the Slurm file. The RTR sessions are kept.
+type reloader struct {
+	configFile string
+	// Flags set on the command line, which the configuration file does not override
+	cli     map[string]bool
+	current reloadableConfig
+
+	acl *sourceACL
+
+	// Set when serving over TLS or SSH
+	tlsCert   *tlsCertificate
+	server    *rtr.Server
+	sshConfig func(reloadableConfig) (*ssh.ServerConfig, error)
+
+	lock        *sync.Mutex
+	subscribers []chan struct{}
+}
+
+func newReloader(configFile string, cli map[string]bool) *reloader {
+	return &reloader{
+		configFile: configFile,
+		cli:        cli,
+		current:    currentReloadableConfig(),
+		lock:       &sync.Mutex{},
+	}
+}
+
+// Subscribe returns a channel receiving a value after each reload.
+func (r *reloader) Subscribe() <-chan struct{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	ch := make(chan struct{}, 1)
+	r.subscribers = append(r.subscribers, ch)
+	return ch
+}
+
+func (r *reloader) broadcast() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, ch := range r.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *reloader) reload(s *state) {
+	config := r.current
+	if r.configFile != "" {
+		var err error
+		config, err = readReloadableConfig(r.configFile, r.cli)
+		if err != nil {
+			log.Errorf("Error reloading the configuration, keeping the previous one: %v", err)
+			config = r.current
+		}
+	}
+
+	if config.LogLevel != r.current.LogLevel {
+		lvl, _ := log.ParseLevel(config.LogLevel)
+		log.SetLevel(lvl)
+		log.Infof("Log level set to %v", lvl)
+	}
+	s.applyConfig(config)
+	if config.KeepDiff != r.current.KeepDiff {
+		for _, view := range s.rtrServers() {
+			view.server.SetKeepDifference(config.KeepDiff)
+		}
+		log.Infof("Keeping the changes from %d serials", config.KeepDiff)
+	}
+
+	if r.acl != nil {
+		if prefixes, err := parseACL(config.ACL, config.ACLFile); err != nil {
+			log.Errorf("Error reloading the ACL, keeping the previous one: %v", err)
+		} else {
+			r.acl.Set(prefixes)
+			if prefixes != nil && len(prefixes) == 0 {
+				log.Warn("Reloaded an empty ACL, rejecting all the RTR clients")
+			} else {
+				log.Infof("Reloaded the ACL (%d prefix(es))", len(prefixes))
+			}
+		}
+	}
+	if r.tlsCert != nil {
+		if err := r.tlsCert.Load(config.TLSCert, config.TLSKey); err != nil {
+			log.Errorf("Error reloading the TLS certificate, keeping the previous one: %v", err)
+		} else {
+			log.Infof("Reloaded the TLS certificate %v", config.TLSCert)
+		}
+	}
+	if r.sshConfig != nil {
+		sshConfig, err := r.sshConfig(config)
+		if err != nil {
+			log.Errorf("Error reloading the SSH configuration, keeping the previous one: %v", err)
+		} else {
+			r.server.SetSSHConfig(sshConfig)
+			log.Infof("Reloaded the SSH host key %v", config.SSHKey)
+		}
+	}
+
+	r.current = config
+	r.broadcast()
+}
+
+func (r *reloader) routineReload(s *state) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		log.Debug("Received HUP signal")
+		r.reload(s)
+	}
+}
+
+// applyConfig applies the intervals and the Slurm file of the configuration. A new Slurm
+// file is loaded immediately, the previous one is kept if it cannot be loaded.
+func (s *state) applyConfig(config reloadableConfig) {
+	s.lockUpdate.Lock()
+	defer s.lockUpdate.Unlock()
+	s.refreshInterval = config.Refresh
+	s.slurmInterval = config.SlurmInterval
+
+	paths := splitList(config.Slurm)
+	if strings.Join(paths, ",") == strings.Join(s.slurmPaths, ",") {
+		return
+	}
+	defer s.traceUpdate("reload")()
+	if len(paths) == 0 {
+		s.slurm = nil
+		s.slurmFiles = nil
+		s.slurmConfigs = nil
+		log.Infof("Slurm file removed")
+	} else if _, err := s.updateSlurms(paths); err != nil {
+		log.Errorf("Slurm: %v, keeping %v", err, strings.Join(s.slurmPaths, ","))
+		return
+	} else {
+		log.Infof("Slurm file set to %v", strings.Join(paths, ","))
+	}
+	s.slurmPaths = paths
+	s.watchFiles()
+	if err := s.updateFromNewState(); err != nil {
+		log.Errorf("Error updating from new state: %v", err)
+	}
+}
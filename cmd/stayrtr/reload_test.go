@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadReloadableConfig(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "stayrtr.yaml")
+	data := "loglevel: debug\nrefresh: 60\nslurm: slurm.json\nslurm.interval: 30\ntls:\n  cert: new.pem\n"
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The command line takes precedence, the flags absent from the file get their default
+	config, err := readReloadableConfig(file, map[string]bool{"refresh": true})
+	assert.NoError(t, err)
+	assert.Equal(t, reloadableConfig{
+		LogLevel:        "debug",
+		Refresh:         *RefreshInterval,
+		Slurm:           "slurm.json",
+		SlurmInterval:   30,
+		KeepDiff:        3,
+		TLSCert:         "new.pem",
+		TLSKey:          "",
+		SSHKey:          "private.pem",
+		SSHAuthUser:     "rpki",
+		SSHAuthPassword: "",
+	}, config)
+
+	// -slurm.refresh.interval is an alias of -slurm.interval
+	alias := filepath.Join(dir, "alias.yaml")
+	if err := os.WriteFile(alias, []byte("slurm:\n  refresh:\n    interval: 45\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err = readReloadableConfig(alias, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 45, config.SlurmInterval)
+	config, err = readReloadableConfig(alias, map[string]bool{"slurm.interval": true})
+	assert.NoError(t, err)
+	assert.Equal(t, *SlurmInterval, config.SlurmInterval)
+
+	invalid := map[string]string{
+		"unknown":  "unknown: 1\n",
+		"config":   "config: other.yaml\n",
+		"loglevel": "loglevel: loud\n",
+		"refresh":  "refresh: often\n",
+		"keepdiff": "rtr:\n  keepdiff: 0\n",
+	}
+	for name, data := range invalid {
+		file := filepath.Join(dir, name+".yaml")
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := readReloadableConfig(file, nil)
+		assert.Error(t, err, name)
+	}
+	_, err = readReloadableConfig(filepath.Join(dir, "missing.yaml"), nil)
+	assert.Error(t, err)
+}
+
+func TestReloaderSubscribe(t *testing.T) {
+	r := newReloader("", nil)
+	a := r.Subscribe()
+	b := r.Subscribe()
+
+	// A pending reload is not queued twice
+	r.broadcast()
+	r.broadcast()
+	assert.Len(t, a, 1)
+	assert.Len(t, b, 1)
+	<-a
+	assert.Len(t, a, 0)
+	assert.Len(t, b, 1)
+}
+
+func writeTestCertificate(t *testing.T, dir string, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSCertificate(t *testing.T) {
+	dir := t.TempDir()
+	oldCert, oldKey := writeTestCertificate(t, dir, "old")
+	newCert, newKey := writeTestCertificate(t, dir, "new")
+
+	c := newTLSCertificate()
+	assert.NoError(t, c.Load(oldCert, oldKey))
+	cert, _ := c.GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "old", leaf.Subject.CommonName)
+
+	// The previous certificate is kept on error
+	assert.Error(t, c.Load(newCert, oldKey))
+	cert, _ = c.GetCertificate(nil)
+	leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	assert.Equal(t, "old", leaf.Subject.CommonName)
+
+	assert.NoError(t, c.Load(newCert, newKey))
+	cert, _ = c.GetCertificate(nil)
+	leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+	assert.Equal(t, "new", leaf.Subject.CommonName)
+}
+
+func TestSetTLSClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	caCert, caKey := writeTestCertificate(t, dir, "ca")
+
+	config := &tls.Config{}
+	assert.NoError(t, setTLSClientAuth(config, "", false))
+	assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+	assert.Error(t, setTLSClientAuth(config, "", true))
+
+	assert.NoError(t, setTLSClientAuth(config, caCert, false))
+	assert.Equal(t, tls.VerifyClientCertIfGiven, config.ClientAuth)
+	assert.NotNil(t, config.ClientCAs)
+	assert.NoError(t, setTLSClientAuth(config, caCert, true))
+	assert.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+
+	assert.Error(t, setTLSClientAuth(&tls.Config{}, caKey, false))
+	assert.Error(t, setTLSClientAuth(&tls.Config{}, filepath.Join(dir, "missing.pem"), false))
+}
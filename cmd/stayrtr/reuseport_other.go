@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is only supported on Linux")
+}
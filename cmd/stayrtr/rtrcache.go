@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/bgp/stayrtr/prefixfile"
+	log "github.com/sirupsen/logrus"
+	otelattribute "go.opentelemetry.io/otel/attribute"
+)
+
+// The caches given as rtr://host:port or rtr+tls://host:port are RTR servers, such as
+// another StayRTR: their data is downloaded with a Reset Query on each refresh.
+const (
+	rtrCacheScheme    = "rtr://"
+	rtrTLSCacheScheme = "rtr+tls://"
+)
+
+// isRTRCache returns whether a cache is an RTR server rather than a file or a URL.
+func isRTRCache(file string) bool {
+	return strings.HasPrefix(file, rtrCacheScheme) || strings.HasPrefix(file, rtrTLSCacheScheme)
+}
+
+// rtrCacheClient collects the objects of the answer to a Reset Query, until the End of Data.
+type rtrCacheClient struct {
+	data prefixfile.VRPList
+	done bool
+	err  error
+	// Version requested, or the one of the server when it does not support it
+	version uint8
+	// Session and serial of the data, from the End of Data
+	sessionId uint16
+	serial    uint32
+}
+
+func (c *rtrCacheClient) HandlePDU(cs *rtr.ClientSession, pdu rtr.PDU) {
+	switch pdu := pdu.(type) {
+	case *rtr.PDUCacheResponse, *rtr.PDUSerialNotify:
+	case *rtr.PDUIPv4Prefix:
+		c.addVRP(pdu.Prefix, pdu.MaxLen, pdu.ASN, pdu.Flags)
+	case *rtr.PDUIPv6Prefix:
+		c.addVRP(pdu.Prefix, pdu.MaxLen, pdu.ASN, pdu.Flags)
+	case *rtr.PDURouterKey:
+		if pdu.Flags == rtr.FLAG_ADDED {
+			c.data.BgpsecKeys = append(c.data.BgpsecKeys, prefixfile.BgpsecKeyJson{
+				ASN:    pdu.ASN,
+				SKI:    hex.EncodeToString(pdu.SubjectKeyIdentifier[:]),
+				Pubkey: base64.StdEncoding.EncodeToString(pdu.SubjectPublicKeyInfo),
+			})
+		}
+	case *rtr.PDUASPA:
+		if pdu.Flags == rtr.FLAG_ADDED {
+			c.data.ASPA = append(c.data.ASPA, prefixfile.ASPAJson{
+				CustomerASID: pdu.CustomerASN,
+				Providers:    pdu.Providers,
+			})
+		}
+	case *rtr.PDUEndOfData:
+		c.done = true
+		c.sessionId = pdu.SessionId
+		c.serial = pdu.SerialNumber
+		cs.Disconnect()
+	case *rtr.PDUErrorReport:
+		if pdu.ErrorCode == rtr.PDU_ERROR_BADPROTOVERSION {
+			c.version = pdu.Version
+		}
+		c.err = fmt.Errorf("error report from the RTR server (code %d): %v", pdu.ErrorCode, strings.TrimRight(pdu.ErrorMsg, "\x00"))
+		cs.Disconnect()
+	default:
+		c.err = fmt.Errorf("unexpected PDU from the RTR server: %v", pdu)
+		cs.Disconnect()
+	}
+}
+
+func (c *rtrCacheClient) addVRP(prefix netip.Prefix, maxLen uint8, asn uint32, flags uint8) {
+	if flags != rtr.FLAG_ADDED {
+		return
+	}
+	c.data.Data = append(c.data.Data, prefixfile.VRPJson{
+		Prefix: prefix.String(),
+		Length: maxLen,
+		ASN:    asn,
+	})
+}
+
+func (c *rtrCacheClient) ClientConnected(cs *rtr.ClientSession) {
+	cs.SendResetQuery()
+}
+
+func (c *rtrCacheClient) ClientDisconnected(cs *rtr.ClientSession) {
+}
+
+// fetchRTR downloads the data of an RTR server, with the highest version it supports.
+// The buildtime of the data is the time of the download.
+func (s *state) fetchRTR(file string) (*rtrCacheClient, error) {
+	version := uint8(rtr.PROTOCOL_VERSION_2)
+	for {
+		client, err := s.queryRTR(file, version)
+		if err != nil && client != nil && client.err != nil && client.version < version {
+			log.WithField("source", file).Infof("RTR server only supports version %d", client.version)
+			version = client.version
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		client.data.Metadata.Counts = len(client.data.Data)
+		client.data.Metadata.Buildtime = time.Now().UTC().Format(time.RFC3339)
+		return client, nil
+	}
+}
+
+// dialRTR connects to an RTR server, over TLS for rtr+tls://.
+func (s *state) dialRTR(file string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: s.rtrTimeout}
+	if strings.HasPrefix(file, rtrTLSCacheScheme) {
+		config, err := s.fetchConfig.TLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		if config == nil {
+			config = &tls.Config{}
+		}
+		return tls.DialWithDialer(dialer, "tcp", strings.TrimPrefix(file, rtrTLSCacheScheme), config)
+	}
+	return dialer.Dial("tcp", strings.TrimPrefix(file, rtrCacheScheme))
+}
+
+func (s *state) queryRTR(file string, version uint8) (*rtrCacheClient, error) {
+	conn, err := s.dialRTR(file)
+	if err != nil {
+		return nil, err
+	}
+	// The whole download must complete within the timeout
+	if s.rtrTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.rtrTimeout))
+	}
+
+	client := &rtrCacheClient{version: version}
+	session := rtr.NewClientSession(rtr.ClientConfiguration{ProtocolVersion: version}, client)
+	err = session.StartWithConn(conn)
+	if client.err != nil {
+		return client, client.err
+	}
+	if !client.done {
+		if err == nil {
+			err = fmt.Errorf("connection closed before the End of Data")
+		}
+		return client, err
+	}
+	return client, nil
+}
+
+// updateRTR refreshes the data from an RTR server. Unlike a file, the data of a server
+// does not become stale as long as it can be downloaded.
+func (s *state) updateRTR(file string) (bool, error) {
+	span := s.traceStep("fetch", otelattribute.String("source", file))
+	client, err := s.fetchRTR(file)
+	if err == nil {
+		span.SetAttributes(otelattribute.Int("vrps", len(client.data.Data)), otelattribute.Int64("serial", int64(client.serial)))
+	}
+	endStep(span, err)
+	if err != nil {
+		return false, err
+	}
+	LastRefresh.WithLabelValues(file).Set(float64(s.lastts.UnixNano() / 1e9))
+	vrplistjson := &client.data
+
+	// The buildtime is left out, as it changes with each download
+	objects, err := json.Marshal([]interface{}{vrplistjson.Data, vrplistjson.BgpsecKeys, vrplistjson.ASPA})
+	if err != nil {
+		return false, err
+	}
+	hsum := newSHA256(objects)
+	changed := s.lasthash == nil || string(s.lasthash) != string(hsum)
+	if s.cascade != nil {
+		s.cascade.downloaded(file, client, changed)
+	}
+	if !changed {
+		s.lastdata.Metadata.Buildtime = vrplistjson.Metadata.Buildtime
+		return false, IdenticalFile{File: file}
+	}
+
+	log.WithField("source", file).Infof("new data from the RTR server: %v VRPs, %v router keys, %v ASPAs",
+		len(vrplistjson.Data), len(vrplistjson.BgpsecKeys), len(vrplistjson.ASPA))
+	s.setLastData(file, hsum, vrplistjson)
+	return true, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/bgp/stayrtr/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func startRTRCache(t *testing.T, version uint8) (*rtr.Server, string) {
+	deh := &rtr.DefaultRTREventHandler{}
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 42, ProtocolVersion: version, EnforceVersion: version != rtr.PROTOCOL_VERSION_2}, nil, deh)
+	deh.SetVRPManager(server)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go server.Serve(listener)
+	return server, "rtr://" + listener.Addr().String()
+}
+
+func TestUpdateRTR(t *testing.T) {
+	server, cache := startRTRCache(t, rtr.PROTOCOL_VERSION_2)
+	s := &state{
+		caches:      []string{cache},
+		lastdata:    &prefixfile.VRPList{},
+		checktime:   true,
+		lockJson:    &sync.RWMutex{},
+		fetchConfig: utils.NewFetchConfig(),
+		rtrTimeout:  5 * time.Second,
+
+		checktimeThreshold: 24 * time.Hour,
+	}
+
+	// No data yet
+	_, err := s.updateCaches()
+	assert.EqualError(t, err, "error report from the RTR server (code 2): No data available")
+
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496},
+	}, []rtr.BgpsecKey{
+		{ASN: 64497, SKI: [20]byte{1, 2, 3}, Pubkey: []byte{4, 5, 6}},
+	}, []rtr.ASPA{
+		{CustomerASN: 64498, Providers: []uint32{64499}},
+	})
+	updated, err := s.updateCaches()
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, cache, s.getActiveCache())
+	assert.Equal(t, []prefixfile.VRPJson{
+		{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496), Source: cache},
+	}, s.lastdata.Data)
+	assert.Equal(t, []prefixfile.BgpsecKeyJson{
+		{ASN: 64497, SKI: "0102030000000000000000000000000000000000", Pubkey: "BAUG"},
+	}, s.lastdata.BgpsecKeys)
+	assert.Equal(t, []prefixfile.ASPAJson{
+		{CustomerASID: 64498, Providers: []uint32{64499}},
+	}, s.lastdata.ASPA)
+
+	// Unchanged
+	updated, err = s.updateCaches()
+	assert.NoError(t, err)
+	assert.False(t, updated)
+}
+
+func TestUpdateRTRVersion(t *testing.T) {
+	server, cache := startRTRCache(t, rtr.PROTOCOL_VERSION_1)
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496},
+	}, nil, []rtr.ASPA{
+		{CustomerASN: 64498, Providers: []uint32{64499}},
+	})
+	s := &state{
+		lastdata:    &prefixfile.VRPList{},
+		lockJson:    &sync.RWMutex{},
+		fetchConfig: utils.NewFetchConfig(),
+		rtrTimeout:  5 * time.Second,
+	}
+	updated, err := s.updateFile(cache)
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	assert.Len(t, s.lastdata.Data, 1)
+	// ASPAs are only sent with version 2
+	assert.Empty(t, s.lastdata.ASPA)
+}
+
+func TestIsRTRCache(t *testing.T) {
+	assert.True(t, isRTRCache("rtr://192.0.2.1:323"))
+	assert.True(t, isRTRCache("rtr+tls://rtr.example:324"))
+	assert.False(t, isRTRCache("https://rtr.example/vrps.json"))
+	assert.False(t, isLocalFile("rtr://192.0.2.1:323"))
+}
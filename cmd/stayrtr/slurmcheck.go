@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bgp/stayrtr/prefixfile"
+)
+
+func describeBgpsecKey(key prefixfile.BgpsecKeyJson) string {
+	return fmt.Sprintf("router key AS%d SKI %v", key.ASN, key.SKI)
+}
+
+func describeASPA(aspa prefixfile.ASPAJson) string {
+	return fmt.Sprintf("ASPA AS%d providers %v", aspa.CustomerASID, aspa.Providers)
+}
+
+// checkSlurm loads Slurm files, merged and validated as with -slurm.required, and the
+// cache, then prints the VRPs, router keys and ASPAs the files filter (-) and assert (+).
+func (s *state) checkSlurm(w io.Writer, files []string) error {
+	if _, err := s.updateSlurms(files); err != nil {
+		return err
+	}
+	if _, err := s.updateCaches(); err != nil {
+		return err
+	}
+
+	kept, removed := s.slurm.FilterOnVRPs(s.lastdata.Data)
+	for _, vrp := range removed {
+		fmt.Fprintf(w, "- %v %v\n", vrp.String(), vrp.TA)
+	}
+	var asserted int
+	for _, file := range s.slurmFiles {
+		for _, vrp := range s.slurmConfigs[file].AssertVRPs() {
+			fmt.Fprintf(w, "+ %v %v\n", vrp.String(), file)
+			asserted++
+		}
+	}
+
+	keptKeys, removedKeys := s.slurm.FilterOnBgpsecKeys(s.lastdata.BgpsecKeys)
+	for _, key := range removedKeys {
+		fmt.Fprintf(w, "- %v %v\n", describeBgpsecKey(key), key.TA)
+	}
+	assertedKeys := s.slurm.AssertBgpsecKeys()
+	for _, key := range assertedKeys {
+		fmt.Fprintf(w, "+ %v\n", describeBgpsecKey(key))
+	}
+
+	keptASPAs, removedASPAs := s.slurm.FilterOnASPAs(s.lastdata.ASPA)
+	for _, aspa := range removedASPAs {
+		fmt.Fprintf(w, "- %v\n", describeASPA(aspa))
+	}
+	assertedASPAs := s.slurm.AssertASPAs()
+	for _, aspa := range assertedASPAs {
+		fmt.Fprintf(w, "+ %v\n", describeASPA(aspa))
+	}
+
+	fmt.Fprintf(w, "%d VRPs kept, %d filtered, %d asserted; %d router keys kept, %d filtered, %d asserted; %d ASPAs kept, %d filtered, %d asserted\n",
+		len(kept), len(removed), asserted, len(keptKeys), len(removedKeys), len(assertedKeys), len(keptASPAs), len(removedASPAs), len(assertedASPAs))
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/bgp/stayrtr/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSlurm(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "slurm.json")
+	data := `{
+  "slurmVersion": 2,
+  "validationOutputFilters": {
+    "prefixFilters": [{"prefix": "1.0.0.0/16"}],
+    "bgpsecFilters": [{"asn": 64496}],
+    "aspaFilters": [{"customerAsid": 64496}]
+  },
+  "locallyAddedAssertions": {
+    "prefixAssertions": [{"asn": 64497, "prefix": "198.51.100.0/24"}],
+    "bgpsecAssertions": [{"asn": 64497, "SKI": "C-7Hteo_D9vJXQ3UfzxbwnXaijM", "routerPublicKey": "AQID"}],
+    "aspaAssertions": [{"customerAsid": 64497, "providerSet": [64498]}]
+  }
+}`
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &state{
+		caches:      []string{"smalltest.rpki.json"},
+		lastdata:    &prefixfile.VRPList{},
+		lockJson:    &sync.RWMutex{},
+		fetchConfig: utils.NewFetchConfig(),
+	}
+	var out bytes.Buffer
+	assert.NoError(t, s.checkSlurm(&out, []string{file}))
+	assert.Equal(t, "- 1.0.0.0/24/24/13335 apnic\n"+
+		"+ 198.51.100.0/24/24/64497 "+file+"\n"+
+		"- router key AS64496 SKI E2F075EC50E9F2EFCED506026BF6E4D1BD5A1A0F arin\n"+
+		"+ router key AS64497 SKI 0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33\n"+
+		"- ASPA AS64496 providers [64500 64501]\n"+
+		"+ ASPA AS64497 providers [64498]\n"+
+		"1 VRPs kept, 1 filtered, 1 asserted; 0 router keys kept, 1 filtered, 1 asserted; 0 ASPAs kept, 1 filtered, 1 asserted\n", out.String())
+
+	if err := os.WriteFile(file, []byte(`{"slurmVersion": 1, "locallyAddedAssertions": {"prefixAssertions": [{"asn": 64497, "prefix": "198.51.100.0/33"}]}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, s.checkSlurm(&out, []string{file}))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// reloadSlurm loads the Slurm files, and the ones of the views, again and applies them
+// without refreshing the cache.
+func (s *state) reloadSlurm() error {
+	s.lockUpdate.Lock()
+	defer s.lockUpdate.Unlock()
+	defer s.traceUpdate("slurm.reload")()
+	var updated bool
+	var err error
+	if len(s.slurmPaths) > 0 {
+		updated, err = s.updateSlurms(s.slurmPaths)
+	}
+	viewsUpdated := s.updateViewsSlurm()
+	if updated || viewsUpdated {
+		if err := s.updateFromNewState(); err != nil {
+			log.Errorf("Error updating from new state: %v", err)
+		}
+	}
+	return err
+}
+
+// routineSlurmReload reloads the Slurm files on SIGUSR1.
+func (s *state) routineSlurmReload() {
+	if slurmReloadSignal == nil {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, slurmReloadSignal)
+	for range signals {
+		log.Info("Received Slurm reload signal")
+		if err := s.reloadSlurm(); err != nil {
+			log.Errorf("Slurm: %v", err)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package main
+
+import (
+	"os"
+)
+
+// There is no signal to reload the Slurm files on Windows, only the admin API
+var slurmReloadSignal os.Signal
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/bgp/stayrtr/prefixfile"
+)
+
+// Comment sent when there is no change, so that proxies do not close an idle stream.
+const changesKeepalive = 30 * time.Second
+
+// changeSet is the objects announced or withdrawn by a serial.
+type changeSet struct {
+	VRPs       []prefixfile.VRPJson       `json:"vrps"`
+	BgpsecKeys []prefixfile.BgpsecKeyJson `json:"bgpsec_keys"`
+	ASPAs      []prefixfile.ASPAJson      `json:"aspas"`
+}
+
+func (c *changeSet) empty() bool {
+	return len(c.VRPs) == 0 && len(c.BgpsecKeys) == 0 && len(c.ASPAs) == 0
+}
+
+// changesMessage is the data of an event of the change feed: the complete set of objects
+// (snapshot) or the changes since the previous event (update).
+type changesMessage struct {
+	SessionID uint16    `json:"session_id"`
+	Serial    uint32    `json:"serial"`
+	Announced changeSet `json:"announced"`
+	Withdrawn changeSet `json:"withdrawn"`
+}
+
+func newChangeSet() changeSet {
+	return changeSet{
+		VRPs:       make([]prefixfile.VRPJson, 0),
+		BgpsecKeys: make([]prefixfile.BgpsecKeyJson, 0),
+		ASPAs:      make([]prefixfile.ASPAJson, 0),
+	}
+}
+
+// changesUpdate returns the changes since a serial, or the complete set (snapshot) if
+// the serial is too old.
+func changesUpdate(server *rtr.Server, serial uint32, snapshot bool) (*changesMessage, bool) {
+	msg := &changesMessage{
+		SessionID: server.GetSessionId(),
+		Announced: newChangeSet(),
+		Withdrawn: newChangeSet(),
+	}
+	if !snapshot {
+		vrps, keys, aspas, current, ok := server.GetDataSerialDiffCurrent(serial)
+		if ok {
+			msg.Serial = current
+			for _, vrp := range vrps {
+				set := &msg.Withdrawn
+				if vrp.Flags == rtr.FLAG_ADDED {
+					set = &msg.Announced
+				}
+				set.VRPs = append(set.VRPs, vrpToJson(vrp))
+			}
+			for _, key := range keys {
+				set := &msg.Withdrawn
+				if key.Flags == rtr.FLAG_ADDED {
+					set = &msg.Announced
+				}
+				set.BgpsecKeys = append(set.BgpsecKeys, bgpsecKeyToJson(key))
+			}
+			for _, aspa := range aspas {
+				set := &msg.Withdrawn
+				if aspa.Flags == rtr.FLAG_ADDED {
+					set = &msg.Announced
+				}
+				set.ASPAs = append(set.ASPAs, aspaToJson(aspa))
+			}
+			return msg, false
+		}
+	}
+	vrps, keys, aspas, current, _ := server.GetCurrentDataSerial()
+	msg.Serial = current
+	for _, vrp := range vrps {
+		msg.Announced.VRPs = append(msg.Announced.VRPs, vrpToJson(vrp))
+	}
+	for _, key := range keys {
+		msg.Announced.BgpsecKeys = append(msg.Announced.BgpsecKeys, bgpsecKeyToJson(key))
+	}
+	for _, aspa := range aspas {
+		msg.Announced.ASPAs = append(msg.Announced.ASPAs, aspaToJson(aspa))
+	}
+	return msg, true
+}
+
+// changesEventID identifies an event by the session and the serial: the browsers send it
+// back in the Last-Event-ID header when they reconnect.
+func changesEventID(session uint16, serial uint32) string {
+	return fmt.Sprintf("%d/%d", session, serial)
+}
+
+func parseChangesEventID(id string) (uint16, uint32, bool) {
+	var session uint16
+	var serial uint32
+	if _, err := fmt.Sscanf(id, "%d/%d", &session, &serial); err != nil {
+		return 0, 0, false
+	}
+	return session, serial, true
+}
+
+// changesHandler streams the changes of every new serial as Server-Sent Events. The first
+// event is the complete set, unless the client resumes from an event of the current session.
+// At most maxStreams streams are open at once (0 for no limit).
+func changesHandler(server *rtr.Server, keepalive time.Duration, maxStreams int) http.HandlerFunc {
+	var streams chan struct{}
+	if maxStreams > 0 {
+		streams = make(chan struct{}, maxStreams)
+	}
+	return func(wr http.ResponseWriter, r *http.Request) {
+		flusher, ok := wr.(http.Flusher)
+		if !ok {
+			http.Error(wr, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		if streams != nil {
+			select {
+			case streams <- struct{}{}:
+				defer func() { <-streams }()
+			default:
+				http.Error(wr, "Too many streams open", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		// Subscribe before the first event so that no serial is missed
+		notifications := server.Subscribe()
+		defer server.Unsubscribe(notifications)
+
+		var serial uint32
+		var started bool
+		if session, lastSerial, ok := parseChangesEventID(r.Header.Get("Last-Event-ID")); ok && session == server.GetSessionId() {
+			serial = lastSerial
+			started = true
+		}
+
+		wr.Header().Set("Content-Type", "text/event-stream")
+		wr.Header().Set("Cache-Control", "no-cache")
+		wr.Header().Set("X-Accel-Buffering", "no")
+		wr.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ticker := time.NewTicker(keepalive)
+		defer ticker.Stop()
+		for {
+			if _, valid := server.GetCurrentSerial(server.GetSessionId()); valid {
+				msg, snapshot := changesUpdate(server, serial, !started)
+				if snapshot || !msg.Announced.empty() || !msg.Withdrawn.empty() {
+					event := "update"
+					if snapshot {
+						event = "snapshot"
+					}
+					data, err := json.Marshal(msg)
+					if err != nil {
+						return
+					}
+					if _, err := fmt.Fprintf(wr, "id: %s\nevent: %s\ndata: %s\n\n", changesEventID(msg.SessionID, msg.Serial), event, data); err != nil {
+						return
+					}
+					flusher.Flush()
+				}
+				serial = msg.Serial
+				started = true
+			}
+
+		wait:
+			for {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-notifications:
+					break wait
+				case <-ticker.C:
+					if _, err := fmt.Fprint(wr, ": keepalive\n\n"); err != nil {
+						return
+					}
+					flusher.Flush()
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+type sseEvent struct {
+	id    string
+	event string
+	data  changesMessage
+}
+
+func readSSEEvent(t *testing.T, reader *bufio.Reader) sseEvent {
+	var res sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return res
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if res.event != "" {
+				return res
+			}
+		case strings.HasPrefix(line, "id: "):
+			res.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			res.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &res.data))
+		}
+	}
+}
+
+func openChanges(t *testing.T, url string, lastEventID string) (*http.Response, *bufio.Reader) {
+	req, err := http.NewRequest("GET", url, nil)
+	assert.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func TestChangesHandler(t *testing.T) {
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 42}, nil, nil)
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496},
+	}, nil, nil)
+	first, _ := server.GetCurrentSerial(42)
+
+	ts := httptest.NewServer(changesHandler(server, time.Hour, 3))
+	defer ts.Close()
+
+	resp, reader := openChanges(t, ts.URL, "")
+	defer resp.Body.Close()
+	ev := readSSEEvent(t, reader)
+	assert.Equal(t, "snapshot", ev.event)
+	assert.Equal(t, changesEventID(42, first), ev.id)
+	assert.Equal(t, []prefixfile.VRPJson{{Prefix: "192.0.2.0/24", Length: 24, ASN: float64(64496)}}, ev.data.Announced.VRPs)
+	assert.Empty(t, ev.data.Withdrawn.VRPs)
+
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("198.51.100.0/24"), MaxLen: 24, ASN: 64497},
+	}, nil, []rtr.ASPA{
+		{CustomerASN: 64498, Providers: []uint32{64499}},
+	})
+	second, _ := server.GetCurrentSerial(42)
+	server.NotifySubscribers(second)
+
+	ev = readSSEEvent(t, reader)
+	assert.Equal(t, "update", ev.event)
+	assert.Equal(t, changesEventID(42, second), ev.id)
+	assert.Equal(t, second, ev.data.Serial)
+	assert.Equal(t, []prefixfile.VRPJson{{Prefix: "198.51.100.0/24", Length: 24, ASN: float64(64497)}}, ev.data.Announced.VRPs)
+	assert.Equal(t, []prefixfile.VRPJson{{Prefix: "192.0.2.0/24", Length: 24, ASN: float64(64496)}}, ev.data.Withdrawn.VRPs)
+	assert.Equal(t, []prefixfile.ASPAJson{{CustomerASID: 64498, Providers: []uint32{64499}}}, ev.data.Announced.ASPAs)
+
+	// Resuming from the first serial gets the changes only
+	resumed, reader := openChanges(t, ts.URL, changesEventID(42, first))
+	defer resumed.Body.Close()
+	ev = readSSEEvent(t, reader)
+	assert.Equal(t, "update", ev.event)
+	assert.Equal(t, changesEventID(42, second), ev.id)
+
+	// Another session gets the complete set
+	other, reader := openChanges(t, ts.URL, changesEventID(43, first))
+	defer other.Body.Close()
+	ev = readSSEEvent(t, reader)
+	assert.Equal(t, "snapshot", ev.event)
+	assert.Len(t, ev.data.Announced.VRPs, 1)
+
+	// Over the limit of streams
+	full, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, full.StatusCode)
+}
+
+func TestParseChangesEventID(t *testing.T) {
+	session, serial, ok := parseChangesEventID("42/7")
+	assert.True(t, ok)
+	assert.Equal(t, uint16(42), session)
+	assert.Equal(t, uint32(7), serial)
+
+	_, _, ok = parseChangesEventID("")
+	assert.False(t, ok)
+	_, _, ok = parseChangesEventID("42")
+	assert.False(t, ok)
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Failures from an address are forgotten after this long without a new attempt.
+const sshAuthFailureExpiry = time.Hour
+
+type sshAuthFailure struct {
+	count    int
+	unlogged int
+	last     time.Time
+	lastLog  time.Time
+}
+
+// sshAuthRejection is the last credential rejected on a connection still authenticating.
+type sshAuthRejection struct {
+	method  string
+	message string
+}
+
+// sshAuthGuard keeps track of failed SSH authentications per source IP.
+// It delays the responses to repeated offenders and aggregates the logs
+// so that scanners cannot flood them. A connection counts as one failure once it
+// is closed without authenticating: a router offering several keys before the
+// right one is not backed off.
+type sshAuthGuard struct {
+	lock     *sync.Mutex
+	failures map[string]*sshAuthFailure
+	// By remote address (with the port) of the connections authenticating
+	rejections map[string]sshAuthRejection
+
+	// Delay after the first failure, doubled for each subsequent one (0 to disable)
+	backoff    time.Duration
+	backoffMax time.Duration
+	// Failures from the same address are logged at most once per interval (0 to log all)
+	logInterval time.Duration
+}
+
+func newSSHAuthGuard(backoff, backoffMax, logInterval time.Duration) *sshAuthGuard {
+	return &sshAuthGuard{
+		lock:        &sync.Mutex{},
+		failures:    make(map[string]*sshAuthFailure),
+		rejections:  make(map[string]sshAuthRejection),
+		backoff:     backoff,
+		backoffMax:  backoffMax,
+		logInterval: logInterval,
+	}
+}
+
+func sshAuthSource(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Delay returns how long to wait before answering an authentication attempt from addr.
+func (g *sshAuthGuard) Delay(addr net.Addr) time.Duration {
+	if g.backoff <= 0 {
+		return 0
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	failure, ok := g.failures[sshAuthSource(addr)]
+	if !ok || failure.count == 0 {
+		return 0
+	}
+	delay := g.backoff
+	for i := 1; i < failure.count; i++ {
+		delay *= 2
+		if g.backoffMax > 0 && delay >= g.backoffMax {
+			return g.backoffMax
+		}
+	}
+	return delay
+}
+
+// Failed records a failed attempt and logs it, unless a line was already logged for the
+// address during the log interval: it will then be included in the next summary line.
+func (g *sshAuthGuard) Failed(addr net.Addr, method string, format string, args ...interface{}) {
+	SSHAuthFailures.WithLabelValues(method).Inc()
+
+	now := time.Now()
+	source := sshAuthSource(addr)
+
+	g.lock.Lock()
+	failure, ok := g.failures[source]
+	if !ok {
+		failure = &sshAuthFailure{}
+		g.failures[source] = failure
+	}
+	failure.count++
+	failure.last = now
+	logNow := g.logInterval <= 0 || now.Sub(failure.lastLog) >= g.logInterval
+	if logNow {
+		failure.lastLog = now
+	} else {
+		failure.unlogged++
+	}
+	g.lock.Unlock()
+
+	if logNow {
+		log.Warnf(format, args...)
+	}
+}
+
+// Rejected records a credential rejected on a connection, the client can still try
+// another one. The failure is recorded if the connection is closed without authenticating.
+func (g *sshAuthGuard) Rejected(addr net.Addr, method string, format string, args ...interface{}) {
+	g.lock.Lock()
+	g.rejections[addr.String()] = sshAuthRejection{method: method, message: fmt.Sprintf(format, args...)}
+	g.lock.Unlock()
+}
+
+// Succeeded clears the failures of an address.
+func (g *sshAuthGuard) Succeeded(addr net.Addr) {
+	g.lock.Lock()
+	delete(g.failures, sshAuthSource(addr))
+	delete(g.rejections, addr.String())
+	g.lock.Unlock()
+}
+
+// Closed records the failure of a connection closed after its credentials were rejected.
+func (g *sshAuthGuard) Closed(addr net.Addr) {
+	g.lock.Lock()
+	rejection, ok := g.rejections[addr.String()]
+	delete(g.rejections, addr.String())
+	g.lock.Unlock()
+	if ok {
+		g.Failed(addr, rejection.method, "%s", rejection.message)
+	}
+}
+
+// Listener tells the guard when the connections accepted by ln are closed.
+func (g *sshAuthGuard) Listener(ln net.Listener) net.Listener {
+	return &sshAuthListener{Listener: ln, guard: g}
+}
+
+type sshAuthListener struct {
+	net.Listener
+	guard *sshAuthGuard
+}
+
+func (l *sshAuthListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &sshAuthConn{Conn: conn, guard: l.guard, closeOnce: &sync.Once{}}, nil
+}
+
+type sshAuthConn struct {
+	net.Conn
+	guard     *sshAuthGuard
+	closeOnce *sync.Once
+}
+
+func (c *sshAuthConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.guard.Closed(c.RemoteAddr())
+	})
+	return c.Conn.Close()
+}
+
+// flush logs a summary for the addresses with unlogged failures and forgets the idle ones.
+func (g *sshAuthGuard) flush(now time.Time) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for source, failure := range g.failures {
+		if failure.unlogged > 0 && now.Sub(failure.lastLog) >= g.logInterval {
+			log.Warnf("%d more failed SSH authentication(s) from %v since %v (%d in total)",
+				failure.unlogged, source, failure.lastLog.UTC().Format(time.RFC3339), failure.count)
+			failure.unlogged = 0
+			failure.lastLog = now
+		}
+		if failure.unlogged == 0 && now.Sub(failure.last) >= sshAuthFailureExpiry {
+			delete(g.failures, source)
+		}
+	}
+}
+
+func (g *sshAuthGuard) routineFlush() {
+	interval := g.logInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	for now := range ticker.C {
+		g.flush(now)
+	}
+}
+
+// newSSHServerConfig builds the configuration of the SSH server from the host key and
+// the credentials of config. keys is nil unless key authentication is enabled.
+func newSSHServerConfig(config reloadableConfig, authGuard *sshAuthGuard, keys *sshAuthorizedKeys) (*ssh.ServerConfig, error) {
+	sshkey, err := os.ReadFile(config.SSHKey)
+	if err != nil {
+		return nil, err
+	}
+	private, err := ssh.ParsePrivateKey(sshkey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse private key: %v", err)
+	}
+
+	sshConfig := &ssh.ServerConfig{}
+	if *SSHAuthEnablePassword {
+		user := config.SSHAuthUser
+		password := config.SSHAuthPassword
+		if password == "" {
+			password = os.Getenv(ENV_SSH_PASSWORD)
+		}
+		sshConfig.PasswordCallback = func(conn ssh.ConnMetadata, suppliedPassword []byte) (*ssh.Permissions, error) {
+			time.Sleep(authGuard.Delay(conn.RemoteAddr()))
+			log.Infof("Connected (ssh-password): %v/%v", conn.User(), conn.RemoteAddr())
+			if conn.User() != user || !bytes.Equal(suppliedPassword, []byte(password)) {
+				authGuard.Rejected(conn.RemoteAddr(), "password", "Wrong user or password for %v/%v. Disconnecting.", conn.User(), conn.RemoteAddr())
+				return nil, errors.New("Wrong user or password")
+			}
+			authGuard.Succeeded(conn.RemoteAddr())
+
+			return &ssh.Permissions{
+				CriticalOptions: make(map[string]string),
+				Extensions:      make(map[string]string),
+			}, nil
+		}
+	}
+	if keys != nil {
+		sshConfig.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			time.Sleep(authGuard.Delay(conn.RemoteAddr()))
+			keyBase64 := base64.RawStdEncoding.EncodeToString(key.Marshal())
+			if !*SSHAuthKeysBypass {
+				match, ok := keys.Match(key.Type(), keyBase64)
+				if !ok {
+					authGuard.Rejected(conn.RemoteAddr(), "key", "No key for %v/%v %v %v. Disconnecting.", conn.User(), conn.RemoteAddr(), key.Type(), keyBase64)
+					return nil, errors.New("Key not found")
+				}
+				log.Infof("Connected (ssh-key): %v/%v with key %v %v (matched with line %v of %v)",
+					conn.User(), conn.RemoteAddr(), key.Type(), keyBase64, match.Number, match.Source)
+				authGuard.Succeeded(conn.RemoteAddr())
+			} else {
+				log.Infof("Connected (ssh-key): %v/%v with key %v %v", conn.User(), conn.RemoteAddr(), key.Type(), keyBase64)
+			}
+
+			return &ssh.Permissions{
+				CriticalOptions: make(map[string]string),
+				Extensions:      make(map[string]string),
+			}, nil
+		}
+	}
+
+	if !(*SSHAuthEnableKey || *SSHAuthEnablePassword) {
+		sshConfig.NoClientAuth = true
+	}
+
+	sshConfig.AddHostKey(private)
+	return sshConfig, nil
+}
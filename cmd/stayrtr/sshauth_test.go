@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSSHAuthGuard(t *testing.T) {
+	g := newSSHAuthGuard(time.Second, 5*time.Second, time.Minute)
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+	other := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4321}
+
+	if d := g.Delay(addr); d != 0 {
+		t.Errorf("Wanted no delay before a failure, got %v", d)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		g.Failed(addr, "password", "failure %d", i)
+		// The source port is not taken into account
+		if d := g.Delay(other); d != w {
+			t.Errorf("After %d failure(s): wanted %v, got %v", i+1, w, d)
+		}
+	}
+
+	failure := g.failures["192.0.2.1"]
+	if failure.unlogged != len(want)-1 {
+		t.Errorf("Wanted %d unlogged failures, got %d", len(want)-1, failure.unlogged)
+	}
+	g.flush(failure.lastLog.Add(time.Minute))
+	if failure.unlogged != 0 {
+		t.Errorf("Wanted unlogged failures to be flushed, got %d", failure.unlogged)
+	}
+	g.flush(failure.last.Add(sshAuthFailureExpiry))
+	if _, ok := g.failures["192.0.2.1"]; ok {
+		t.Errorf("Wanted idle address to be forgotten")
+	}
+
+	g.Failed(addr, "key", "failure")
+	g.Succeeded(addr)
+	if d := g.Delay(addr); d != 0 {
+		t.Errorf("Wanted no delay after a success, got %v", d)
+	}
+}
+
+func TestSSHAuthGuardRejected(t *testing.T) {
+	g := newSSHAuthGuard(time.Second, 5*time.Second, time.Minute)
+	addr := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+
+	// Several keys offered before the right one
+	g.Rejected(addr, "key", "no key %d", 1)
+	g.Rejected(addr, "key", "no key %d", 2)
+	if d := g.Delay(addr); d != 0 {
+		t.Errorf("Wanted no delay while authenticating, got %v", d)
+	}
+	g.Succeeded(addr)
+	g.Closed(addr)
+	if d := g.Delay(addr); d != 0 {
+		t.Errorf("Wanted no delay after a success, got %v", d)
+	}
+
+	// Closed without authenticating: a single failure
+	g.Rejected(addr, "key", "no key %d", 1)
+	g.Rejected(addr, "key", "no key %d", 2)
+	g.Closed(addr)
+	if failure := g.failures["192.0.2.1"]; failure == nil || failure.count != 1 {
+		t.Errorf("Wanted one failure, got %+v", failure)
+	}
+	if len(g.rejections) != 0 {
+		t.Errorf("Wanted the rejections to be forgotten, got %v", g.rejections)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type sshAuthorizedKey struct {
+	Line   string
+	Source string
+	Number int
+}
+
+// sshAuthorizedKeys holds the keys allowed to connect over SSH.
+// They are loaded from a comma-separated list of files and directories
+// (all the *.pub files within) or from the environment if the list is empty.
+type sshAuthorizedKeys struct {
+	lock  *sync.RWMutex
+	paths []string
+	keys  []sshAuthorizedKey
+}
+
+func newSSHAuthorizedKeys(paths string) *sshAuthorizedKeys {
+	k := &sshAuthorizedKeys{
+		lock: &sync.RWMutex{},
+	}
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			k.paths = append(k.paths, path)
+		}
+	}
+	return k
+}
+
+func parseSSHAuthorizedKeys(data string, source string) []sshAuthorizedKey {
+	var keys []sshAuthorizedKey
+	for i, line := range strings.Split(data, "\n") {
+		if line == "" {
+			continue
+		}
+		keys = append(keys, sshAuthorizedKey{
+			Line:   line,
+			Source: source,
+			Number: i + 1,
+		})
+	}
+	return keys
+}
+
+// Load reads all the sources again. On error, the keys previously loaded are kept.
+func (k *sshAuthorizedKeys) Load() error {
+	var keys []sshAuthorizedKey
+	if len(k.paths) == 0 {
+		keys = parseSSHAuthorizedKeys(os.Getenv(ENV_SSH_KEY), fmt.Sprintf("envvar %v", ENV_SSH_KEY))
+	}
+	for _, path := range k.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		files := []string{path}
+		if info.IsDir() {
+			files, err = filepath.Glob(filepath.Join(path, "*.pub"))
+			if err != nil {
+				return err
+			}
+			sort.Strings(files)
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, parseSSHAuthorizedKeys(string(data), file)...)
+		}
+	}
+
+	k.lock.Lock()
+	k.keys = keys
+	k.lock.Unlock()
+	return nil
+}
+
+// Match returns the authorized key starting with the type and base64 encoding of a key.
+func (k *sshAuthorizedKeys) Match(keyType string, keyBase64 string) (sshAuthorizedKey, bool) {
+	prefix := fmt.Sprintf("%v %v", keyType, keyBase64)
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	for _, key := range k.keys {
+		if strings.HasPrefix(key.Line, prefix) {
+			return key, true
+		}
+	}
+	return sshAuthorizedKey{}, false
+}
+
+func (k *sshAuthorizedKeys) Count() int {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return len(k.keys)
+}
+
+// routineReload loads the keys again after each reload of the configuration.
+func (k *sshAuthorizedKeys) routineReload(reloads <-chan struct{}) {
+	for range reloads {
+		if err := k.Load(); err != nil {
+			log.Errorf("Error reloading authorized SSH keys, keeping the previous ones: %v", err)
+			continue
+		}
+		log.Infof("Reloaded %d authorized SSH key(s)", k.Count())
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSHAuthorizedKeys(t *testing.T) {
+	dir := t.TempDir()
+	keysDir := filepath.Join(dir, "keys")
+	assert.NoError(t, os.Mkdir(keysDir, 0755))
+
+	write := func(path string, content string) {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	write(filepath.Join(keysDir, "router1.pub"), "ssh-ed25519 AAAA1 router1\n")
+	write(filepath.Join(keysDir, "router2.pub"), "\nssh-ed25519 AAAA2 router2\n")
+	write(filepath.Join(keysDir, "README"), "ssh-ed25519 AAAA3 ignored\n")
+	single := filepath.Join(dir, "authorized_keys")
+	write(single, "ssh-rsa AAAA4 admin\n")
+
+	keys := newSSHAuthorizedKeys(strings.Join([]string{keysDir, single}, ", "))
+	assert.NoError(t, keys.Load())
+	assert.Equal(t, 3, keys.Count())
+
+	match, ok := keys.Match("ssh-ed25519", "AAAA2")
+	assert.True(t, ok)
+	assert.Equal(t, filepath.Join(keysDir, "router2.pub"), match.Source)
+	assert.Equal(t, 2, match.Number)
+
+	match, ok = keys.Match("ssh-rsa", "AAAA4")
+	assert.True(t, ok)
+	assert.Equal(t, single, match.Source)
+
+	_, ok = keys.Match("ssh-ed25519", "AAAA3")
+	assert.False(t, ok)
+
+	// Access is revoked on reload
+	assert.NoError(t, os.Remove(filepath.Join(keysDir, "router1.pub")))
+	assert.NoError(t, keys.Load())
+	_, ok = keys.Match("ssh-ed25519", "AAAA1")
+	assert.False(t, ok)
+
+	// A failed reload keeps the previous keys
+	assert.NoError(t, os.Remove(single))
+	assert.Error(t, keys.Load())
+	_, ok = keys.Match("ssh-rsa", "AAAA4")
+	assert.True(t, ok)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//go:embed status.html.tmpl
+var statusTemplateText string
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.UTC().Format("2006-01-02 15:04:05 MST")
+	},
+	"duration": func(seconds float64) string {
+		return (time.Duration(seconds) * time.Second).String()
+	},
+}).Parse(statusTemplateText))
+
+// slurmSummary are the Slurm files applied by the last update and the VRPs they changed.
+type slurmSummary struct {
+	Files    []string
+	Filtered int
+	Asserted int
+}
+
+type statusPage struct {
+	Version    string
+	Servers    []adminServerState
+	Caches     []string
+	Active     string
+	Refreshed  time.Time
+	LastChange time.Time
+	Slurm      slurmSummary
+	Clients    []adminClient
+	// Why the instance is not ready, see readiness
+	NotReady string
+}
+
+// status serves a page summarizing the data served and the clients, for the operators
+// without access to the metrics.
+func (s *state) status(wr http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	page := statusPage{
+		Version: AppVersion,
+		Caches:  s.caches,
+		Clients: s.adminClients(now),
+	}
+	for _, view := range s.rtrServers() {
+		page.Servers = append(page.Servers, newAdminServerState(view))
+	}
+	s.lockJson.RLock()
+	page.Active = s.activeCache
+	page.Refreshed = s.refreshed
+	page.LastChange = s.lastchange
+	page.Slurm = s.slurmSummary
+	s.lockJson.RUnlock()
+	if err := s.readiness(now); err != nil {
+		page.NotReady = err.Error()
+	}
+
+	wr.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(wr, page); err != nil {
+		log.Errorf("Error serving the status page: %v", err)
+	}
+}
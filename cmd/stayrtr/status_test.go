@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 42}, nil, nil)
+	server.AddData([]rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496},
+	}, nil, nil)
+	s := &state{
+		server:   server,
+		caches:   []string{"https://a.example/vrps.json", "https://b.example/vrps.json"},
+		lockJson: &sync.RWMutex{},
+		slurmSummary: slurmSummary{
+			Files:    []string{"/etc/stayrtr/slurm.json"},
+			Filtered: 3,
+			Asserted: 1,
+		},
+	}
+	s.setActiveCache("https://b.example/vrps.json")
+	s.applied = true
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	client := rtr.ClientFromConn(conn, server, nil)
+	server.ClientConnected(client)
+	defer client.Disconnect()
+
+	rec := httptest.NewRecorder()
+	s.status(rec, httptest.NewRequest("GET", "/status", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	body := rec.Body.String()
+	assert.Contains(t, body, "Not ready: no RTR listener up")
+	assert.Contains(t, body, "<td>default</td><td>42</td><td>0</td><td>1</td>")
+	assert.Contains(t, body, "https://a.example/vrps.json<br><b>https://b.example/vrps.json</b>")
+	assert.Contains(t, body, "<td>/etc/stayrtr/slurm.json<br></td>")
+	assert.Contains(t, body, "<td>pipe</td><td>pipe</td><td>default</td><td>0</td><td>not synced</td>")
+}
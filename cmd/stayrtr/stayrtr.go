@@ -2,20 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"crypto/tls"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
-	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
-	"syscall"
+	"sync/atomic"
 	"time"
 
 	rtr "github.com/bgp/stayrtr/lib"
@@ -24,12 +28,19 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	otelattribute "go.opentelemetry.io/otel/attribute"
 	"golang.org/x/crypto/ssh"
 )
 
 const (
 	ENV_SSH_PASSWORD = "STAYRTR_SSH_PASSWORD"
 	ENV_SSH_KEY      = "STAYRTR_SSH_AUTHORIZEDKEYS"
+	ENV_DEBUG_TOKEN  = "STAYRTR_DEBUG_TOKEN"
+	ENV_ADMIN_TOKEN  = "STAYRTR_ADMIN_TOKEN"
+	ENV_HANDOVER     = "STAYRTR_HANDOVER"
+
+	ENV_CACHE_PASSWORD = "STAYRTR_CACHE_PASSWORD"
+	ENV_CACHE_TOKEN    = "STAYRTR_CACHE_TOKEN"
 
 	METHOD_NONE = iota
 	METHOD_PASSWORD
@@ -40,6 +51,51 @@ const (
 	USE_SERIAL_FULL
 )
 
+// Handling of the conflicts between the filters and assertions of a Slurm file
+const (
+	SLURM_CONFLICT_ERROR = iota
+	SLURM_CONFLICT_PREFER_ASSERTION
+	SLURM_CONFLICT_PREFER_FILTER
+)
+
+// What to do with stale data, see -checktime.policy
+const (
+	STALE_POLICY_REJECT = iota
+	STALE_POLICY_WARN
+	STALE_POLICY_EXPIRE
+)
+
+// What to do when the cache was not refreshed for the expire interval, see -rtr.expire.policy
+const (
+	EXPIRE_POLICY_KEEP = iota
+	EXPIRE_POLICY_WITHDRAW
+	EXPIRE_POLICY_RESET
+)
+
+// Formats of the cache, see -cache.format
+const (
+	CACHE_FORMAT_AUTO = iota
+	CACHE_FORMAT_JSON
+	CACHE_FORMAT_CSV
+)
+
+// Formats of the logs, see -log.format
+const (
+	LOG_FORMAT_TEXT = iota
+	LOG_FORMAT_JSON
+)
+
+// Reasons for rejecting a VRP
+const (
+	INVALID_PREFIX               = "prefix"
+	INVALID_PREFIX_NOT_CANONICAL = "prefix_not_canonical"
+	INVALID_PREFIX_LENGTH_ZERO   = "prefix_length_zero"
+	INVALID_ASN                  = "asn"
+	INVALID_MAXLENGTH_TOO_SHORT  = "maxlength_too_short"
+	INVALID_MAXLENGTH_TOO_LONG   = "maxlength_too_long"
+	INVALID_MAXLENGTH_REDUNDANT  = "maxlength_redundant"
+)
+
 var (
 	version    = ""
 	buildinfos = ""
@@ -48,21 +104,90 @@ var (
 	MetricsAddr = flag.String("metrics.addr", ":9847", "Metrics address")
 	MetricsPath = flag.String("metrics.path", "/metrics", "Metrics path")
 
-	ExportPath = flag.String("export.path", "/rpki.json", "Export path")
+	ExportPath   = flag.String("export.path", "/rpki.json", "Export path")
+	ExportRoaSet = flag.String("export.roaset.path", "/rpki.roa-set", "Path of the export as an OpenBGPD roa-set (disabled if blank)")
+	ExportGzip   = flag.Bool("export.gzip", true, "Compress the export with gzip for the clients accepting it (disable with -export.gzip=false)")
+	ExportBuffer = flag.Bool("export.buffer", false, "Encode the export in memory before sending it, to answer with an error instead of a truncated export")
+	DebugToken   = flag.String("debug.token", "", fmt.Sprintf("Bearer token enabling the /debug/vrps endpoint (if blank, will use envvar %v, disabled if both are blank)", ENV_DEBUG_TOKEN))
+
+	ReusePort       = flag.Bool("reuseport", false, "Listen with SO_REUSEPORT, so that another process can bind the same addresses (Linux only)")
+	HandoverTimeout = flag.Duration("handover.timeout", time.Minute, "Time the process started on SIGUSR2 has to load the data before the handover is aborted")
+
+	BindAdmin  = flag.String("admin.bind", "", "Bind address for the admin API (disabled if empty)")
+	AdminToken = flag.String("admin.token", "", fmt.Sprintf("Bearer token required by the admin API (if blank, will use envvar %v)", ENV_ADMIN_TOKEN))
+
+	BindGRPC = flag.String("grpc.bind", "", "Bind address for the gRPC API (disabled if empty)")
+
+	EventsURL     = flag.String("events.url", "", "Publish the announcements and withdrawals of every new serial as JSON events to kafka://broker[,broker]/topic or nats://server[,server]/subject (disabled if empty)")
+	EventsTimeout = flag.Duration("events.timeout", 10*time.Second, "Timeout of the publication of the events of a serial")
+
+	JournalFile     = flag.String("journal.file", "", "JSON lines file each new serial is appended to, with its announcements and withdrawals (disabled if empty)")
+	JournalMaxBytes = flag.Int64("journal.maxbytes", 100<<20, "Size of the journal at which it is rotated to journal.file.1 (0 to disable)")
+	JournalKeep     = flag.Int("journal.keep", 10, "Number of rotated journals kept")
 
-	RTRVersion = flag.Int("protocol", 1, "RTR protocol version")
+	WebhookURL     = stringListFlag("webhook.url", "", "URL the update, fetch_failed, stale and churn events are posted to as JSON, repeated or comma-separated (disabled if empty)")
+	WebhookEvents  = flag.String("webhook.events", "", "Comma-separated events posted to -webhook.url (if blank, all)")
+	WebhookTimeout = flag.Duration("webhook.timeout", 10*time.Second, "Timeout of the posts to -webhook.url")
+	WebhookChurn   = flag.Float64("webhook.churn", 10, "Percentage of the VRPs announced and withdrawn by a serial above which the churn event is posted")
+
+	ComparePath     = flag.String("compare.path", "", "Path comparing a posted VRP JSON with the served VRPs, e.g. /compare (disabled if empty)")
+	CompareMaxBytes = flag.Int64("compare.maxbytes", 128<<20, "Maximum size of a VRP JSON posted to the compare path")
+	ValidatePath    = flag.String("validate.path", "", "Path validating a prefix and an origin ASN against the served VRPs, e.g. /validate (disabled if empty)")
+	StatusPath      = flag.String("status.path", "", "Path of the HTML status page, e.g. /status (disabled if empty)")
+	ChangesPath     = flag.String("changes.path", "", "Path streaming the changes of every new serial as Server-Sent Events, e.g. /changes (disabled if empty)")
+
+	ChangesMax = flag.Int("changes.max", 16, "Streams of -changes.path open at once, the next ones get a 503 (0 for no limit)")
+
+	RTRVersion = flag.Int("protocol", 1, "Highest RTR protocol version, clients using an older one are served with theirs")
 	SessionID  = flag.Int("rtr.sessionid", -1, "Set session ID (if < 0: will be randomized)")
 	RefreshRTR = flag.Int("rtr.refresh", 3600, "Refresh interval")
 	RetryRTR   = flag.Int("rtr.retry", 600, "Retry interval")
 	ExpireRTR  = flag.Int("rtr.expire", 7200, "Expire interval")
+	KeepDiff   = flag.Int("rtr.keepdiff", 3, "Number of previous serials the clients can get the changes from, older serials get a Cache Reset (reloaded on SIGHUP)")
+
+	MaxDiff = flag.Int("rtr.diff.max", 0, "Changes (VRPs, router keys and ASPAs) sent to a client at most, a client with more changes since its serial gets a Cache Reset instead (0 for no limit)")
+
+	SessionIDSeed     = flag.String("rtr.sessionid.seed", "", "Derive the session ID from this seed instead of randomizing it, stable across the restarts (requires -persist.file)")
+	SessionIDSeedFile = flag.String("rtr.sessionid.seed.file", "", "Derive the session ID from the content of this file instead of randomizing it, e.g. /etc/machine-id (requires -persist.file)")
+
+	ExpirePolicy = flag.String("rtr.expire.policy", "keep", "What to do when the cache was not refreshed for the expire interval: keep (serve the data), withdraw (serve a serial without any object) or reset (also send a Cache Reset)")
+
+	WriteTimeout = flag.Duration("rtr.write.timeout", 0, "Time a write to a client can take before it is disconnected, e.g. a router that stopped reading (0 to disable)")
+	QueueSize    = flag.Int("rtr.queue.size", rtr.DefaultSendQueueSize, "PDUs queued for each client, a client with a full queue when notified of a new serial is disconnected")
+
+	IdleRefreshes = flag.Int("rtr.idle", 0, "Disconnect the clients not sending any query for this many refresh intervals (-rtr.refresh), e.g. routers gone without closing their connection (0 to disable)")
+	KeepAlive     = flag.Duration("rtr.keepalive", 0, "Period of the TCP keepalives of the RTR connections (0 for the default of 15s, negative to disable)")
 
-	Bind = flag.String("bind", ":8282", "Bind address")
+	PDURate  = flag.Float64("rtr.pdu.rate", 0, "PDUs a client can send per second, a client over the limit gets an Error Report and is disconnected, e.g. 100 (0 to disable)")
+	PDUBurst = flag.Int("rtr.pdu.burst", 100, "PDUs a client can send at once above -rtr.pdu.rate")
 
-	BindTLS = flag.String("tls.bind", "", "Bind address for TLS")
+	Bind             = stringListFlag("bind", ":8282", "Bind address, repeated or comma-separated to listen on several")
+	RequireEncrypted = flag.Bool("require.encrypted", false, "Refuse to start if plain TCP is served (-bind must be empty, use -tls.bind and/or -ssh.bind)")
+	ACL              = flag.String("acl", "", "Prefixes (comma-separated) the RTR clients may connect from to -bind, -tls.bind and -ssh.bind, any if neither it nor -acl.file is set (reloaded on SIGHUP)")
+	ACLFile          = flag.String("acl.file", "", "File of the prefixes the RTR clients may connect from, one per line, in addition to -acl (reloaded on SIGHUP)")
+	BindUnix         = flag.String("bind.unix", "", "Path of a unix socket to serve plain RTR on, for the BGP daemons on the same host (disabled if empty)")
+	BindUnixMode     = flag.String("bind.unix.mode", "0660", "Permissions of the unix socket")
+	BindProxy        = flag.Bool("bind.proxy", false, "Read the address of the clients of -bind from a PROXY protocol header (v1 or v2), sent by a load balancer")
+	ProxyTrusted     = flag.String("proxy.trusted", "", "Prefixes (comma-separated) of the load balancers sending a PROXY protocol header, the other connections are used as is (required with -bind.proxy and -tls.proxy)")
+	TCPMD5Keys       = flag.String("tcp.md5.keys", "", "File of the TCP MD5 keys (RFC 2385) of the routers, an address or prefix and a key per line, set on the RTR binds and reloaded on SIGHUP (Linux only)")
+
+	BindTLS = stringListFlag("tls.bind", "", "Bind address for TLS, repeated or comma-separated to listen on several")
 	TLSCert = flag.String("tls.cert", "", "Certificate path")
 	TLSKey  = flag.String("tls.key", "", "Private key path")
 
-	BindSSH = flag.String("ssh.bind", "", "Bind address for SSH")
+	TLSProxy = flag.Bool("tls.proxy", false, "Read the address of the clients of -tls.bind from a PROXY protocol header (v1 or v2), sent before the TLS handshake")
+
+	TLSACMEDomain    = flag.String("tls.acme.domain", "", "Domains (comma-separated) to obtain and renew the TLS certificate for with ACME (Let's Encrypt), instead of -tls.cert and -tls.key")
+	TLSACMEEmail     = flag.String("tls.acme.email", "", "Contact email of the ACME account")
+	TLSACMECache     = flag.String("tls.acme.cache", "acme", "Directory the ACME account and certificates are stored in")
+	TLSACMEDirectory = flag.String("tls.acme.directory", "", "ACME directory URL (default Let's Encrypt)")
+	TLSACMEBind      = flag.String("tls.acme.bind", "", "Bind address for the ACME http-01 challenges, e.g. :80 (otherwise only the tls-alpn-01 challenges on a TLS bind on port 443 are answered)")
+	TLSACMEAcceptTOS = flag.Bool("tls.acme.accept-tos", false, "Accept the terms of service of the ACME CA, required with -tls.acme.domain")
+
+	TLSClientCA       = flag.String("tls.client.ca", "", "CA certificates (PEM) the certificates of the TLS clients are verified against")
+	TLSClientRequired = flag.Bool("tls.client.required", false, "Refuse the TLS clients without a certificate from -tls.client.ca")
+
+	BindSSH = stringListFlag("ssh.bind", "", "Bind address for SSH, repeated or comma-separated to listen on several")
 	SSHKey  = flag.String("ssh.key", "private.pem", "SSH host key")
 
 	SSHAuthEnablePassword = flag.Bool("ssh.method.password", false, "Enable password auth")
@@ -71,27 +196,102 @@ var (
 
 	SSHAuthEnableKey  = flag.Bool("ssh.method.key", false, "Enable key auth")
 	SSHAuthKeysBypass = flag.Bool("ssh.auth.key.bypass", false, "Accept any SSH key")
-	SSHAuthKeysList   = flag.String("ssh.auth.key.file", "", fmt.Sprintf("Authorized SSH key file (if blank, will use envvar %v", ENV_SSH_KEY))
+	SSHAuthKeysList   = flag.String("ssh.auth.key.file", "", fmt.Sprintf("Authorized SSH key files or directories of *.pub files, comma-separated and reloaded on SIGHUP (if blank, will use envvar %v)", ENV_SSH_KEY))
+
+	SSHAuthBackoff     = flag.Duration("ssh.auth.backoff", 0, "Delay answering an address after a failed SSH authentication, doubled at each failure (0 to disable)")
+	SSHAuthBackoffMax  = flag.Duration("ssh.auth.backoff.max", time.Minute, "Maximum delay for failed SSH authentications")
+	SSHAuthLogInterval = flag.Duration("ssh.auth.log.interval", 0, "Log failed SSH authentications from an address at most once per interval (0 to log all)")
 
 	TimeCheck = flag.Bool("checktime", true, "Check if JSON file isn't stale (disable by passing -checktime=false)")
+	TimeSkew  = flag.Duration("checktime.skew", 5*time.Minute, "Clock skew tolerated when checking the buildtime of the JSON file")
+	Strict    = flag.Bool("vrp.strict", false, "Reject non-canonical prefixes and a maxLength explicitly set to the prefix length (RFC 6482)")
+
+	StaleThreshold = flag.Duration("checktime.threshold", 24*time.Hour, "Age of the buildtime after which the JSON file is stale")
+	StalePolicy    = flag.String("checktime.policy", "reject", "What to do with stale data: reject (keep serving the previous data), warn (serve it anyway) or expire (withdraw the data served once stale)")
+
+	CacheBin      = flag.String("cache", "https://console.rpki-client.org/vrps.json", "URL of the cached JSON data or address of an RTR server (rtr://host:port or rtr+tls://host:port), or comma-separated URLs tried in order when one fails or is stale")
+	CacheResume   = flag.Int("cache.resume", 0, "Resume an interrupted download up to this many times using HTTP Range requests (0 to disable)")
+	CacheMaxBytes = flag.Int64("cache.maxbytes", 1<<30, "Reject cache and Slurm files larger than this many bytes (0 to disable)")
+	PersistFile   = flag.String("persist.file", "", "Save the data of the cache to this file after each update, and load it at startup so that it is served until the cache can be fetched")
+	CacheMember   = flag.String("cache.member", "", "File to extract when the cache is a tar.gz or zip archive (if blank, the only .json file)")
+	CacheDir      = flag.String("cache.dir", "", "Directory saving the last download of the cache and Slurm URLs with their ETag and Last-Modified, so that after a restart the saved copy is served when the server answers 304 (disabled if blank)")
+
+	CacheTLSCA   = flag.String("cache.tls.ca", "", "PEM file of the CA certificates verifying the cache and Slurm servers (if blank, the system roots)")
+	CacheTLSCert = flag.String("cache.tls.cert", "", "Client certificate presented to the cache and Slurm servers (PEM)")
+	CacheTLSKey  = flag.String("cache.tls.key", "", "Key of the client certificate presented to the cache and Slurm servers (PEM)")
 
-	CacheBin = flag.String("cache", "https://console.rpki-client.org/vrps.json", "URL of the cached JSON data")
+	CacheAuthUser     = flag.String("cache.auth.user", "", "User of the Basic authentication to the cache and Slurm servers")
+	CacheAuthPassword = flag.String("cache.auth.password", "", fmt.Sprintf("Password of the Basic authentication to the cache and Slurm servers (if blank, will use envvar %v)", ENV_CACHE_PASSWORD))
+	CacheAuthToken    = flag.String("cache.auth.token", "", fmt.Sprintf("Bearer token sent to the cache and Slurm servers (if blank, will use envvar %v)", ENV_CACHE_TOKEN))
+
+	CacheRTRTimeout = flag.Duration("cache.rtr.timeout", time.Minute, "Timeout of the download of the data from the RTR caches (rtr:// and rtr+tls://)")
+	CacheRTRCascade = flag.Bool("cache.rtr.cascade", false, "Serve the data of an RTR cache under its session ID and serials, and update as soon as it sends a Serial Notify")
+
+	CacheFormat = flag.String("cache.format", "auto", "Format of the cache: json, csv (the rpki-client CSV output) or auto to detect it from the Content-Type and the content")
+
+	Watch = flag.Bool("watch", true, "Update as soon as a local cache or Slurm file changes (disable with -watch=false)")
+
+	CacheCompression = flag.Bool("cache.compression", true, "Request gzip or br encoded cache and Slurm files (disable with -cache.compression=false)")
+
+	CacheMaxRedirects  = flag.Int("cache.maxredirects", 10, "Maximum number of redirects followed when fetching the cache or Slurm files")
+	CacheRedirectHosts = flag.String("cache.redirect.hosts", "", "Comma-separated hosts redirects may lead to, .example.com also allows the subdomains (if blank, any host)")
+
+	CacheFamily        = flag.String("cache.family", "", "Address family of the connections to the cache and Slurm servers: prefer-ipv6, prefer-ipv4, ipv6-only or ipv4-only (if blank, the order of the resolver)")
+	CacheFallbackDelay = flag.Duration("cache.fallback.delay", 300*time.Millisecond, "Delay before connecting to the cache and Slurm servers with the other address family when the first does not answer")
+
+	FetchProxy   = flag.String("fetch.proxy", "", "Proxy of the cache and Slurm URLs, e.g. http://proxy.example.com:3128 (if blank, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables)")
+	FetchNoProxy = flag.String("fetch.noproxy", "", "Comma-separated hosts and domains not reached through -fetch.proxy, as NO_PROXY")
 
 	Etag            = flag.Bool("etag", true, "Control usage of Etag header (disable with -etag=false)")
 	LastModified    = flag.Bool("last.modified", true, "Control usage of Last-Modified header (disable with -last.modified=false)")
 	UserAgent       = flag.String("useragent", fmt.Sprintf("StayRTR-%v (+https://github.com/bgp/stayrtr)", AppVersion), "User-Agent header")
 	Mime            = flag.String("mime", "application/json", "Accept setting format (some servers may prefer text/json)")
 	RefreshInterval = flag.Int("refresh", 600, "Refresh interval in seconds")
+	RefreshJitter   = flag.Float64("refresh.jitter", 0, "Fraction of the refresh interval randomly added or removed, e.g. 0.1 for ±10% (0 to disable)")
 	MaxConn         = flag.Int("maxconn", 0, "Max simultaneous connections (0 to disable limit)")
+	MaxConnAddress  = flag.Int("maxconn.address", 0, "Max simultaneous connections from an address (0 to disable limit)")
+	MaxConnSubnet   = flag.Int("maxconn.subnet", 0, "Max simultaneous connections from a subnet of -maxconn.subnet.ipv4 or -maxconn.subnet.ipv6 bits (0 to disable limit)")
+	SubnetIPv4      = flag.Int("maxconn.subnet.ipv4", 24, "Prefix length of the IPv4 subnets of -maxconn.subnet")
+	SubnetIPv6      = flag.Int("maxconn.subnet.ipv6", 48, "Prefix length of the IPv6 subnets of -maxconn.subnet")
+	ConnRate        = flag.Float64("maxconn.rate", 0, "Max connections per second from an address (0 to disable limit)")
+	ConnBurst       = flag.Int("maxconn.burst", 5, "Connections an address can open at once above -maxconn.rate")
 	SendNotifs      = flag.Bool("notifications", true, "Send notifications to clients (disable with -notifications=false)")
+	InitialNotifs   = flag.Bool("notifications.initial", true, "Send notifications on the initial load (disable with -notifications.initial=false)")
+
+	RefreshBackoffMax = flag.Duration("refresh.backoff.max", 30*time.Minute, "Maximum delay between refreshes after failures, the delay doubling from the refresh interval at each failure (0 to disable backoff)")
 
-	Slurm        = flag.String("slurm", "", "Slurm configuration file (filters and assertions)")
-	SlurmRefresh = flag.Bool("slurm.refresh", true, "Refresh along the cache (disable with -slurm.refresh=false)")
+	Slurm          = stringListFlag("slurm", "", "Slurm configuration file (filters and assertions), repeated or comma-separated to merge several")
+	SlurmRefresh   = flag.Bool("slurm.refresh", true, "Refresh along the cache (disable with -slurm.refresh=false)")
+	SlurmInterval  = flag.Int("slurm.interval", 0, "Refresh interval of the Slurm file in seconds (if 0: refreshed along the cache)")
+	SlurmRequired  = flag.Bool("slurm.required", false, "Exit if the Slurm file cannot be loaded or is invalid at startup")
+	Views          = flag.String("views", "", "File mapping client source prefixes to views filtered by their own Slurm file")
+	SlurmConflicts = flag.String("slurm.conflicts", "error", "Policy when a prefix is both filtered and asserted (error, prefer-assertion or prefer-filter)")
+	SlurmCheck     = stringListFlag("slurm.check", "", "Check Slurm files against the cache, print the VRPs and ASPAs they filter and assert, then exit")
 
-	LogLevel   = flag.String("loglevel", "info", "Log level")
-	LogVerbose = flag.Bool("log.verbose", true, "Additional debug logs (disable with -log.verbose=false)")
-	Version    = flag.Bool("version", false, "Print version")
+	SlurmRefreshInterval = aliasFlag("slurm.refresh.interval", "slurm.interval")
 
+	Once = flag.Bool("once", false, "Fetch the cache and Slurm files, print a summary of the data that would be served and exit, without starting the listeners")
+
+	LogLevel        = flag.String("loglevel", "info", "Log level")
+	LogVerbose      = flag.Bool("log.verbose", true, "Additional debug logs (disable with -log.verbose=false)")
+	LogFormat       = flag.String("log.format", "text", "Log format (text or json)")
+	OTelEndpoint    = flag.String("otel.endpoint", "", "OTLP/HTTP endpoint the traces of the updates are exported to, e.g. http://localhost:4318 (if blank, OTEL_EXPORTER_OTLP_ENDPOINT or not exported)")
+	LogSampleRate   = flag.Int("log.sample.rate", 0, "Only log 1 in N accepted connections (0 to log all)")
+	LogSampleWindow = flag.Duration("log.sample.window", 0, "Do not log connections from an address logged less than this long ago (0 to log all)")
+	Version         = flag.Bool("version", false, "Print version")
+
+	LogDiff     = flag.Int("log.diff", 0, "Log up to this many VRPs announced and withdrawn by each new serial (0 to disable)")
+	LogDiffFile = flag.String("log.diff.file", "", "File the changes of -log.diff are appended to (if blank, the log)")
+
+	ConfigFile = flag.String("config", "", "YAML (.yaml, .yml) or TOML (.toml) file setting the flags by name, the command line takes precedence")
+
+	NumberOfASNs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpki_origin_asns",
+			Help: "Number of unique origin ASNs in the served VRPs.",
+		},
+		[]string{"path"},
+	)
 	NumberOfVRPs = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "rpki_vrps",
@@ -120,24 +320,179 @@ var (
 		},
 		[]string{"path", "code"},
 	)
+	CacheActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpki_cache_active",
+			Help: "Whether the data is served from the cache (1) or not (0).",
+		},
+		[]string{"path"},
+	)
+	RefreshFailures = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rpki_refresh_failures",
+			Help: "Number of consecutive refreshes which failed to update from any cache.",
+		},
+	)
+	RefreshBackoff = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rpki_refresh_backoff_seconds",
+			Help: "Delay before the next refresh after failures (0 when the last refresh succeeded).",
+		},
+	)
+	CascadeSerial = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpki_cascade_serial",
+			Help: "Serial of the data last downloaded from the RTR cache.",
+		},
+		[]string{"path"},
+	)
+	CascadePropagation = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "rpki_cascade_propagation_seconds",
+			Help:    "Time from a Serial Notify of the RTR cache to the notification of the clients.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+		},
+	)
+	EventsPublished = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "events_published_total",
+			Help: "Total number of change events published to -events.url.",
+		},
+	)
+	EventsErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "events_errors_total",
+			Help: "Total number of failed publications of the change events of a serial.",
+		},
+	)
+	WebhooksPosted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhooks_posted_total",
+			Help: "Total number of events posted to the webhooks by event.",
+		},
+		[]string{"event"},
+	)
+	WebhookErrors = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "webhook_errors_total",
+			Help: "Total number of events which could not be posted to a webhook or were dropped.",
+		},
+	)
+	SlurmVRPs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpki_slurm_vrps",
+			Help: "Number of VRPs filtered and asserted by the Slurm files at the last update.",
+		},
+		[]string{"view", "action"},
+	)
+	SlurmFailures = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpki_slurm_failures",
+			Help: "Number of consecutive loads of the Slurm file which failed (fetch, parsing or validation).",
+		},
+		[]string{"path"},
+	)
+	FetchedBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "refresh_bytes_total",
+			Help: "Total number of bytes fetched (after HTTP decompression, before archive extraction).",
+		},
+		[]string{"path"},
+	)
+	ExportErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "export_errors_total",
+			Help: "Total number of exports which could not be encoded or sent, by format.",
+		},
+		[]string{"format"},
+	)
 	ClientsMetric = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "rtr_clients",
 			Help: "Number of clients connected.",
 		},
-		[]string{"bind"},
+		[]string{"bind", "listener"},
+	)
+	TLSClientsMetric = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rtr_tls_clients",
+			Help: "Number of TLS clients connected by certificate subject.",
+		},
+		[]string{"bind", "subject"},
+	)
+	ACLRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rtr_acl_rejected_total",
+			Help: "Total number of connections rejected by the source ACL.",
+		},
+		[]string{"bind", "listener"},
+	)
+	SSHAuthFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ssh_auth_failures_total",
+			Help: "Total number of failed SSH authentications by method.",
+		},
+		[]string{"method"},
 	)
 	PDUsRecv = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "rtr_pdus",
 			Help: "PDU received.",
 		},
-		[]string{"type"},
+		[]string{"type", "listener"},
+	)
+	PDUsSent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rtr_pdus_sent_total",
+			Help: "Total number of PDUs sent by type.",
+		},
+		[]string{"type", "listener"},
+	)
+	BytesSent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rtr_sent_bytes_total",
+			Help: "Total number of bytes sent to the clients by listener.",
+		},
+		[]string{"listener"},
+	)
+	BytesReceived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rtr_received_bytes_total",
+			Help: "Total number of bytes received from the clients by listener.",
+		},
+		[]string{"listener"},
+	)
+
+	PDUFloods = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rtr_pdu_floods_total",
+			Help: "Clients disconnected for sending PDUs faster than -rtr.pdu.rate.",
+		},
+		[]string{"bind", "listener"},
 	)
 
+	InvalidVRPs = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rpki_vrps_invalid",
+			Help: "Number of VRPs rejected by reason.",
+		},
+		[]string{"reason", "path"},
+	)
+
+	invalidReasons = []string{
+		INVALID_PREFIX,
+		INVALID_PREFIX_NOT_CANONICAL,
+		INVALID_PREFIX_LENGTH_ZERO,
+		INVALID_ASN,
+		INVALID_MAXLENGTH_TOO_SHORT,
+		INVALID_MAXLENGTH_TOO_LONG,
+		INVALID_MAXLENGTH_REDUNDANT,
+	}
+
 	protoverToLib = map[int]uint8{
 		0: rtr.PROTOCOL_VERSION_0,
 		1: rtr.PROTOCOL_VERSION_1,
+		2: rtr.PROTOCOL_VERSION_2,
 	}
 	authToId = map[string]int{
 		"none":     METHOD_NONE,
@@ -149,20 +504,100 @@ var (
 		"startup": USE_SERIAL_START,
 		"full":    USE_SERIAL_FULL,
 	}
+	slurmConflictToId = map[string]int{
+		"error":            SLURM_CONFLICT_ERROR,
+		"prefer-assertion": SLURM_CONFLICT_PREFER_ASSERTION,
+		"prefer-filter":    SLURM_CONFLICT_PREFER_FILTER,
+	}
+	cacheFormatToId = map[string]int{
+		"auto": CACHE_FORMAT_AUTO,
+		"json": CACHE_FORMAT_JSON,
+		"csv":  CACHE_FORMAT_CSV,
+	}
+
+	logFormatToId = map[string]int{
+		"text": LOG_FORMAT_TEXT,
+		"json": LOG_FORMAT_JSON,
+	}
+	stalePolicyToId = map[string]int{
+		"reject": STALE_POLICY_REJECT,
+		"warn":   STALE_POLICY_WARN,
+		"expire": STALE_POLICY_EXPIRE,
+	}
+	expirePolicyToId = map[string]int{
+		"keep":     EXPIRE_POLICY_KEEP,
+		"withdraw": EXPIRE_POLICY_WITHDRAW,
+		"reset":    EXPIRE_POLICY_RESET,
+	}
 )
 
 func initMetrics() {
 	prometheus.MustRegister(NumberOfVRPs)
+	prometheus.MustRegister(NumberOfASNs)
 	prometheus.MustRegister(LastChange)
 	prometheus.MustRegister(LastRefresh)
 	prometheus.MustRegister(RefreshStatusCode)
+	prometheus.MustRegister(CacheActive)
+	prometheus.MustRegister(RefreshFailures)
+	prometheus.MustRegister(RefreshBackoff)
+	prometheus.MustRegister(CascadeSerial)
+	prometheus.MustRegister(CascadePropagation)
+	prometheus.MustRegister(EventsPublished)
+	prometheus.MustRegister(EventsErrors)
+	prometheus.MustRegister(WebhooksPosted)
+	prometheus.MustRegister(WebhookErrors)
+	prometheus.MustRegister(SlurmVRPs)
+	prometheus.MustRegister(SlurmFailures)
+	prometheus.MustRegister(FetchedBytes)
+	prometheus.MustRegister(ExportErrors)
 	prometheus.MustRegister(ClientsMetric)
+	prometheus.MustRegister(TLSClientsMetric)
+	prometheus.MustRegister(ACLRejected)
 	prometheus.MustRegister(PDUsRecv)
+	prometheus.MustRegister(PDUsSent)
+	prometheus.MustRegister(BytesSent)
+	prometheus.MustRegister(BytesReceived)
+	prometheus.MustRegister(PDUFloods)
+	prometheus.MustRegister(SSHAuthFailures)
+	prometheus.MustRegister(InvalidVRPs)
+}
+
+// changeAgeCollector reports the time elapsed since the last change of the cache,
+// computed when scraped so that it keeps increasing while the data is stuck.
+type changeAgeCollector struct {
+	s    *state
+	desc *prometheus.Desc
+}
+
+func newChangeAgeCollector(s *state) *changeAgeCollector {
+	return &changeAgeCollector{
+		s: s,
+		desc: prometheus.NewDesc(
+			"rpki_change_age_seconds",
+			"Seconds since the last change.",
+			[]string{"path"}, nil,
+		),
+	}
+}
+
+func (c *changeAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *changeAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.s.lockJson.RLock()
+	lastchange := c.s.lastchange
+	activeCache := c.s.activeCache
+	c.s.lockJson.RUnlock()
+	if lastchange.IsZero() {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Since(lastchange).Seconds(), activeCache)
 }
 
-func metricHTTP() {
+func metricHTTP(listener net.Listener) {
 	http.Handle(*MetricsPath, promhttp.Handler())
-	log.Fatal(http.ListenAndServe(*MetricsAddr, nil))
+	fatalServe(http.Serve(listener, nil))
 }
 
 // newSHA256 will return the sha256 sum of the byte slice
@@ -181,66 +616,158 @@ func decodeJSON(data []byte) (*prefixfile.VRPList, error) {
 	return &vrplistjson, err
 }
 
-func isValidPrefixLength(prefix *net.IPNet, maxLength uint8) bool {
-	plen, max := net.IPMask.Size(prefix.Mask)
+// checkPrefixLength returns why the maxLength of a VRP is invalid, or an empty string when it is valid.
+// Per RFC 6482, the maxLength must be between the prefix length and the length of an address of the family.
+// When strict, a maxLength explicitly set to the prefix length is rejected too, as it should have been omitted.
+func checkPrefixLength(prefix netip.Prefix, vrp prefixfile.VRPJson, strict bool) string {
+	reason, err := prefixLengthError(prefix, vrp, strict)
+	if err != nil {
+		log.Error(err)
+	}
+	return reason
+}
+
+// prefixLengthError returns the reason and the description of an invalid maxLength (see checkPrefixLength).
+func prefixLengthError(prefix netip.Prefix, vrp prefixfile.VRPJson, strict bool) (string, error) {
+	plen, max := prefix.Bits(), prefix.Addr().BitLen()
+	maxLength := int(vrp.Length)
 
-	if plen == 0 || uint8(plen) > maxLength || maxLength > uint8(max) {
-		log.Errorf("%s Maxlength wrong: %d - %d", prefix, plen, maxLength)
-		return false
+	// Checked first so that a maxLength outside of the family is always reported as such
+	if maxLength > max {
+		family := "IPv6"
+		if prefix.Addr().Is4() {
+			family = "IPv4"
+		}
+		return INVALID_MAXLENGTH_TOO_LONG, fmt.Errorf("%s Maxlength wrong: %d - %d (longer than %d for %s)", prefix, plen, maxLength, max, family)
+	}
+	if plen == 0 {
+		return INVALID_PREFIX_LENGTH_ZERO, fmt.Errorf("%s Prefix length is zero", prefix)
 	}
-	return true
+	if maxLength < plen {
+		return INVALID_MAXLENGTH_TOO_SHORT, fmt.Errorf("%s Maxlength wrong: %d - %d (shorter than the prefix)", prefix, plen, maxLength)
+	}
+	if strict && !vrp.NoMaxLength && maxLength == plen {
+		return INVALID_MAXLENGTH_REDUNDANT, fmt.Errorf("%s Maxlength wrong: %d - %d (explicitly set to the prefix length)", prefix, plen, maxLength)
+	}
+	return "", nil
+}
+
+// vrpStats are the counts gathered by processData.
+type vrpStats struct {
+	// Valid VRPs, including duplicates
+	Count   int
+	CountV4 int
+	CountV6 int
+	// Unique origin ASNs of the valid VRPs
+	ASNs int
+
+	// Rejected VRPs by reason
+	Invalid map[string]int
 }
 
 // processData will take a slice of prefix.VRPJson and attempt to convert them to a slice of rtr.VRP.
 // Will check the following:
-// 1 - The prefix is a valid prefix
+// 1 - The prefix is a valid prefix (and is canonical when strict)
 // 2 - The ASN is a valid ASN
-// 3 - The MaxLength is valid
-// Will return a deduped slice, as well as total VRPs, IPv4 VRPs, and IPv6 VRPs
-func processData(vrplistjson []prefixfile.VRPJson) ([]rtr.VRP, int, int, int) {
-	filterDuplicates := make(map[string]bool)
-
-	var vrplist []rtr.VRP
-	var countv4 int
-	var countv6 int
+// 3 - The MaxLength is valid (see checkPrefixLength)
+// Will return a deduped slice, as well as the counts of total VRPs, IPv4 VRPs, IPv6 VRPs, unique ASNs and rejected VRPs
+func processData(vrplistjson []prefixfile.VRPJson, strict bool) ([]rtr.VRP, vrpStats) {
+	// The VRPs are comparable: they are their own key
+	filterDuplicates := make(map[rtr.VRP]struct{}, len(vrplistjson))
+	uniqueASNs := make(map[uint32]struct{})
+
+	vrplist := make([]rtr.VRP, 0, len(vrplistjson))
+	stats := vrpStats{
+		Invalid: make(map[string]int),
+	}
 
 	for _, v := range vrplistjson {
-		prefix, err := v.GetPrefix2()
+		parsed, err := v.GetNetipPrefix()
 		if err != nil {
 			log.Error(err)
+			stats.Invalid[INVALID_PREFIX]++
+			continue
+		}
+		prefix := parsed.Masked()
+		if strict && parsed != prefix {
+			log.Errorf("%s Prefix is not canonical (%s)", v.Prefix, prefix)
+			stats.Invalid[INVALID_PREFIX_NOT_CANONICAL]++
 			continue
 		}
 		asn, err := v.GetASN2()
 		if err != nil {
 			log.Error(err)
+			stats.Invalid[INVALID_ASN]++
 			continue
 		}
 
-		if !isValidPrefixLength(prefix, v.Length) {
+		if reason := checkPrefixLength(prefix, v, strict); reason != "" {
+			stats.Invalid[reason]++
 			continue
 		}
 
-		if prefix.IP.To4() != nil {
-			countv4++
+		if prefix.Addr().Is4() {
+			stats.CountV4++
 		} else {
-			countv6++
+			stats.CountV6++
 		}
-
-		key := fmt.Sprintf("%s,%d,%d", prefix, asn, v.Length)
-		_, exists := filterDuplicates[key]
-		if exists {
-			continue
-		}
-		filterDuplicates[key] = true
+		stats.Count++
 
 		vrp := rtr.VRP{
-			Prefix: *prefix,
+			Prefix: prefix,
 			ASN:    asn,
 			MaxLen: v.Length,
 		}
+		if _, exists := filterDuplicates[vrp]; exists {
+			continue
+		}
+		filterDuplicates[vrp] = struct{}{}
+		uniqueASNs[asn] = struct{}{}
 		vrplist = append(vrplist, vrp)
 	}
-	return vrplist, countv4 + countv6, countv4, countv6
+	stats.ASNs = len(uniqueASNs)
+	return vrplist, stats
+}
+
+// processBgpsecKeys converts the router keys of the JSON, skipping the ones with an invalid
+// SKI (20 bytes, hex encoded) or SubjectPublicKeyInfo (base64 encoded).
+func processBgpsecKeys(keysjson []prefixfile.BgpsecKeyJson) []rtr.BgpsecKey {
+	keys := make([]rtr.BgpsecKey, 0, len(keysjson))
+	for _, v := range keysjson {
+		ski, err := hex.DecodeString(v.SKI)
+		if err != nil || len(ski) != 20 {
+			log.Errorf("Router key of AS%d ignored: invalid SKI %q", v.ASN, v.SKI)
+			continue
+		}
+		pubkey, err := base64.StdEncoding.DecodeString(v.Pubkey)
+		if err != nil || len(pubkey) == 0 {
+			log.Errorf("Router key of AS%d ignored: invalid public key", v.ASN)
+			continue
+		}
+		key := rtr.BgpsecKey{
+			ASN:    v.ASN,
+			Pubkey: pubkey,
+		}
+		copy(key.SKI[:], ski)
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// processASPAs converts the ASPAs of the JSON, skipping the ones of AS0 which cannot be a customer.
+func processASPAs(aspasjson []prefixfile.ASPAJson) []rtr.ASPA {
+	aspas := make([]rtr.ASPA, 0, len(aspasjson))
+	for _, v := range aspasjson {
+		if v.CustomerASID == 0 {
+			log.Errorf("ASPA of AS0 ignored")
+			continue
+		}
+		aspas = append(aspas, rtr.ASPA{
+			CustomerASN: v.CustomerASID,
+			Providers:   v.Providers,
+		})
+	}
+	return aspas
 }
 
 type IdenticalFile struct {
@@ -251,87 +778,202 @@ func (e IdenticalFile) Error() string {
 	return fmt.Sprintf("File %s is identical to the previous version", e.File)
 }
 
+type SlurmConflictError struct {
+	File  string
+	Count int
+}
+
+func (e SlurmConflictError) Error() string {
+	return fmt.Sprintf("File %s has %d prefix assertion(s) matched by a filter, keeping the previous version", e.File, e.Count)
+}
+
+type SlurmOverlapError struct {
+	Count int
+}
+
+func (e SlurmOverlapError) Error() string {
+	return fmt.Sprintf("%d overlapping entries between the Slurm files, keeping the previous version", e.Count)
+}
+
 // Update the state based on the current slurm file and data.
 func (s *state) updateFromNewState() error {
 	sessid := s.server.GetSessionId()
 
 	vrpsjson := s.lastdata.Data
-	if (vrpsjson == nil) {
+	if vrpsjson == nil {
 		return nil
 	}
 
-	if s.checktime {
-		buildtime, err := time.Parse(time.RFC3339, s.lastdata.Metadata.Buildtime)
-		if err != nil {
-			return err
-		}
-		notafter := buildtime.Add(time.Hour * 24)
-		if time.Now().UTC().After(notafter) {
-			return errors.New(fmt.Sprintf("VRP JSON file is older than 24 hours: %v", buildtime))
-		}
+	if err := s.checkStale(s.lastdata); err != nil {
+		return err
 	}
 
+	span := s.traceStep("slurm")
+	var filtered, asserted int
 	if s.slurm != nil {
 		kept, removed := s.slurm.FilterOnVRPs(vrpsjson)
-		asserted := s.slurm.AssertVRPs()
-		log.Infof("Slurm filtering: %v kept, %v removed, %v asserted", len(kept), len(removed), len(asserted))
-		vrpsjson = append(kept, asserted...)
+		assertedVRPs := make([]prefixfile.VRPJson, 0)
+		for _, file := range s.slurmFiles {
+			fileAsserted := s.slurmConfigs[file].AssertVRPs()
+			for i := range fileAsserted {
+				fileAsserted[i].Source = file
+			}
+			assertedVRPs = append(assertedVRPs, fileAsserted...)
+		}
+		log.Infof("Slurm filtering: %v kept, %v removed, %v asserted", len(kept), len(removed), len(assertedVRPs))
+		vrpsjson = append(kept, assertedVRPs...)
+		filtered, asserted = len(removed), len(assertedVRPs)
+	}
+	SlurmVRPs.WithLabelValues("default", "filtered").Set(float64(filtered))
+	SlurmVRPs.WithLabelValues("default", "asserted").Set(float64(asserted))
+	s.lockJson.Lock()
+	s.slurmSummary = slurmSummary{
+		Files:    append([]string(nil), s.slurmFiles...),
+		Filtered: filtered,
+		Asserted: asserted,
+	}
+	s.lockJson.Unlock()
+	keysjson := s.lastdata.BgpsecKeys
+	aspasjson := s.lastdata.ASPA
+	if s.slurm != nil {
+		keysjson = s.slurm.FilterAssertBgpsecKeys(keysjson)
+		aspasjson = s.slurm.FilterAssertASPAs(aspasjson)
+	}
+	span.SetAttributes(otelattribute.Int("filtered", filtered), otelattribute.Int("asserted", asserted))
+	span.End()
+
+	span = s.traceStep("process")
+	vrps, stats := processData(vrpsjson, s.strict)
+	keys := processBgpsecKeys(keysjson)
+	aspas := processASPAs(aspasjson)
+	span.SetAttributes(otelattribute.Int("vrps", len(vrps)), otelattribute.Int("bgpsec_keys", len(keys)), otelattribute.Int("aspas", len(aspas)))
+	span.End()
+
+	log.Infof("New update (%v uniques, %v total prefixes, %v router keys, %v ASPAs).", len(vrps), stats.Count, len(keys), len(aspas))
+
+	span = s.traceStep("diff")
+	previous, previousSerial, hadPrevious := s.server.GetCurrentVRPsSerial()
+	if serial, ok := s.cascadeSerial(); ok {
+		s.server.AddDataSerial(vrps, keys, aspas, serial)
+	} else {
+		s.server.AddData(vrps, keys, aspas)
 	}
-
-	vrps, count, countv4, countv6 := processData(vrpsjson)
-
-	log.Infof("New update (%v uniques, %v total prefixes).", len(vrps), count)
-
-	s.server.AddVRPs(vrps)
-
 	serial, _ := s.server.GetCurrentSerial(sessid)
-	log.Infof("Updated added, new serial %v", serial)
-	if s.sendNotifs {
+	span.SetAttributes(otelattribute.Int64("serial", int64(serial)))
+	span.End()
+
+	log.WithField("serial", serial).Infof("Updated added, new serial %v", serial)
+	span = s.traceStep("notify")
+	if s.sendNotifs && (s.initialNotifs || s.loaded) {
 		log.Debugf("Sending notifications to clients")
 		s.server.NotifyClientsLatest()
+	} else {
+		s.server.NotifySubscribers(serial)
 	}
-
+	if s.cascade != nil {
+		s.cascade.propagated(time.Now())
+	}
+	s.fireUpdate(len(previous), previousSerial, hadPrevious, len(vrps), len(keys), len(aspas))
+	span.End()
+	s.loaded = true
+	s.expired = false
 	s.lockJson.Lock()
-	s.exported = prefixfile.VRPList{
+	s.applied = true
+	s.lockJson.Unlock()
+	if s.ready != nil {
+		s.ready()
+	}
+
+	span = s.traceStep("views", otelattribute.Int("views", len(s.views)))
+	s.updateViews(vrpsjson, keysjson, aspasjson)
+	span.End()
+
+	span = s.traceStep("export")
+	exported := prefixfile.VRPList{
 		Metadata: prefixfile.MetaData{
 			Counts:    len(vrpsjson),
 			Buildtime: s.lastdata.Metadata.Buildtime,
 		},
-		Data: vrpsjson,
+		Data:       vrpsjson,
+		BgpsecKeys: keysjson,
+		ASPA:       aspasjson,
+	}
+	tag := exportTag(exported)
+	var index *validateIndex
+	if s.validateEnabled {
+		index = newValidateIndex(vrpsjson, s.strict)
 	}
 
+	s.lockJson.Lock()
+	s.exported = exported
+	s.validateIndex = index
+	if tag != s.exportedTag {
+		s.exportedTag = tag
+		s.exportedModified = time.Now().UTC()
+	}
 	s.lockJson.Unlock()
 
+	if err := s.persist(); err != nil {
+		log.Errorf("Error saving to %v: %v", s.persistFile, err)
+	}
+	if err := s.persistSession(); err != nil {
+		log.Errorf("Error saving to %v: %v", sessionStateFile(s.persistFile), err)
+	}
+	span.End()
+
 	if s.metricsEvent != nil {
 		var countv4_dup int
 		var countv6_dup int
 		for _, vrp := range vrps {
-			if vrp.Prefix.IP.To4() != nil {
+			if vrp.Prefix.Addr().Is4() {
 				countv4_dup++
-			} else if vrp.Prefix.IP.To16() != nil {
+			} else {
 				countv6_dup++
 			}
 		}
-		s.metricsEvent.UpdateMetrics(countv4, countv6, countv4_dup, countv6_dup, s.lastchange, s.lastts, *CacheBin)
+		activeCache := s.getActiveCache()
+		s.metricsEvent.UpdateMetrics(stats.CountV4, stats.CountV6, countv4_dup, countv6_dup, stats.ASNs, s.lastchange, s.lastts, activeCache)
+		s.metricsEvent.UpdateInvalidMetrics(stats.Invalid, activeCache)
 	}
 
 	return nil
 }
 
+// checkBuildtime returns an error if the buildtime is older than the threshold, with a
+// tolerance for the clock skew. A buildtime in the future beyond the tolerance is accepted
+// but logged, as the clock of the generator or of this host is likely wrong.
+func checkBuildtime(buildtime time.Time, now time.Time, threshold time.Duration, skew time.Duration) error {
+	notafter := buildtime.Add(threshold + skew)
+	if now.After(notafter) {
+		return errors.New(fmt.Sprintf("VRP JSON file is older than %v: %v", threshold, buildtime))
+	}
+	if buildtime.After(now.Add(skew)) {
+		log.Warnf("VRP JSON file was built %v in the future (%v), check the clocks", buildtime.Sub(now).Round(time.Second), buildtime)
+	}
+	return nil
+}
+
 func (s *state) updateFile(file string) (bool, error) {
-	log.Debugf("Refreshing cache from %s", file)
+	log.WithField("source", file).Debugf("Refreshing cache from %s", file)
 
 	s.lastts = time.Now().UTC()
+	if isRTRCache(file) {
+		return s.updateRTR(file)
+	}
+	span := s.traceStep("fetch", otelattribute.String("source", file))
 	data, code, lastrefresh, err := s.fetchConfig.FetchFile(file)
+	span.SetAttributes(otelattribute.Int("status", code), otelattribute.Int("bytes", len(data)))
+	endStep(span, err)
+	// Also count the requests answered with a 304 or an error
+	if code != -1 {
+		RefreshStatusCode.WithLabelValues(file, fmt.Sprintf("%d", code)).Inc()
+	}
 	if err != nil {
 		return false, err
 	}
 	if lastrefresh {
 		LastRefresh.WithLabelValues(file).Set(float64(s.lastts.UnixNano() / 1e9))
 	}
-	if code != -1 {
-		RefreshStatusCode.WithLabelValues(file, fmt.Sprintf("%d", code)).Inc()
-	}
 
 	hsum := newSHA256(data)
 	if s.lasthash != nil {
@@ -341,65 +983,228 @@ func (s *state) updateFile(file string) (bool, error) {
 		}
 	}
 
-	log.Infof("new cache file: Updating sha256 hash %x -> %x", s.lasthash, hsum)
+	log.WithField("source", file).Infof("new cache file: Updating sha256 hash %x -> %x", s.lasthash, hsum)
 
-	vrplistjson, err := decodeJSON(data)
+	span = s.traceStep("decode", otelattribute.String("source", file))
+	vrplistjson, err := decodeCache(data, s.cacheFormat, s.fetchConfig.ContentType(file))
+	if err == nil {
+		span.SetAttributes(otelattribute.Int("vrps", len(vrplistjson.Data)))
+	}
+	endStep(span, err)
 	if err != nil {
 		return false, err
 	}
+	if err := s.checkStale(vrplistjson); err != nil {
+		return false, err
+	}
+	s.setLastData(file, hsum, vrplistjson)
+
+	return true, nil
+}
+
+// setLastData records the new data of a cache, with its hash.
+func (s *state) setLastData(file string, hsum []byte, vrplistjson *prefixfile.VRPList) {
+	// The few trust anchor names are shared instead of being allocated for every VRP
+	tas := make(map[string]string)
+	for i := range vrplistjson.Data {
+		vrplistjson.Data[i].Source = file
+		ta := vrplistjson.Data[i].TA
+		if interned, ok := tas[ta]; ok {
+			vrplistjson.Data[i].TA = interned
+		} else {
+			tas[ta] = ta
+		}
+	}
 
 	s.lasthash = hsum
+	s.lockJson.Lock()
 	s.lastchange = time.Now().UTC()
+	s.lockJson.Unlock()
 	s.lastdata = vrplistjson
-
-	return true, nil
 }
 
-func (s *state) updateSlurm(file string) (bool, error) {
-	log.Debugf("Refreshing slurm from %v", file)
-	data, code, lastrefresh, err := s.fetchConfig.FetchFile(file)
-	if err != nil {
-		return false, err
-	}
-	if lastrefresh {
-		LastRefresh.WithLabelValues(file).Set(float64(s.lastts.UnixNano() / 1e9))
+// updateSlurms refreshes the Slurm files, which are merged. They are only used together:
+// if one of them cannot be loaded or if they overlap, the previous ones are kept.
+func (s *state) updateSlurms(files []string) (bool, error) {
+	updated := strings.Join(files, ",") != strings.Join(s.slurmFiles, ",")
+	configs := make([]*prefixfile.SlurmConfig, len(files))
+	for i, file := range files {
+		if _, ok := s.slurmConfigs[file]; !ok {
+			s.fetchConfig.Forget(file)
+		}
+		slurm, err := s.loadSlurm(file)
+		switch err.(type) {
+		case nil:
+			updated = true
+		case utils.HttpNotModified, utils.IdenticalEtag:
+			log.Info(err)
+			slurm = s.slurmConfigs[file]
+		default:
+			s.forgetSlurms(files)
+			return false, err
+		}
+		configs[i] = slurm
 	}
-	if code != -1 {
-		RefreshStatusCode.WithLabelValues(file, fmt.Sprintf("%d", code)).Inc()
+	if !updated {
+		return false, nil
 	}
 
-	buf := bytes.NewBuffer(data)
+	overlaps := prefixfile.FindOverlaps(configs)
+	for _, overlap := range overlaps {
+		log.Warnf("Slurm overlap between %v and %v: %v", files[overlap.File], files[overlap.OtherFile], overlap)
+	}
+	if len(overlaps) > 0 {
+		s.forgetSlurms(files)
+		return false, SlurmOverlapError{Count: len(overlaps)}
+	}
 
-	slurm, err := prefixfile.DecodeJSONSlurm(buf)
-	if err != nil {
-		return false, err
+	s.slurm = prefixfile.MergeSlurm(configs)
+	s.slurmFiles = files
+	s.slurmConfigs = make(map[string]*prefixfile.SlurmConfig, len(files))
+	for i, file := range files {
+		s.slurmConfigs[file] = configs[i]
 	}
-	s.slurm = slurm
 	return true, nil
 }
 
-func (s *state) routineUpdate(file string, interval int, slurmFile string) {
-	log.Debugf("Starting refresh routine (file: %v, interval: %vs, slurm: %v)", file, interval, slurmFile)
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGHUP)
-	for {
-		var delay *time.Timer
+// forgetSlurms drops the conditional requests of Slurm files which could not be used, so
+// that they are loaded in full on the next refresh.
+func (s *state) forgetSlurms(files []string) {
+	for _, file := range files {
+		s.fetchConfig.Forget(file)
+	}
+}
+
+// countSlurmFailure updates the consecutive failures to load a Slurm file.
+func countSlurmFailure(file string, err error) {
+	switch err.(type) {
+	case nil, utils.HttpNotModified, utils.IdenticalEtag:
+		SlurmFailures.WithLabelValues(file).Set(0)
+	default:
+		SlurmFailures.WithLabelValues(file).Inc()
+	}
+}
+
+// loadSlurm fetches, decodes and checks a Slurm file.
+func (s *state) loadSlurm(file string) (slurm *prefixfile.SlurmConfig, err error) {
+	span := s.traceStep("slurm.load", otelattribute.String("source", file))
+	defer func() {
+		countSlurmFailure(file, err)
+		endStep(span, err)
+	}()
+	log.WithField("source", file).Debugf("Refreshing slurm from %v", file)
+	data, code, lastrefresh, err := s.fetchConfig.FetchFile(file)
+	// Also count the requests answered with a 304 or an error
+	if code != -1 {
+		RefreshStatusCode.WithLabelValues(file, fmt.Sprintf("%d", code)).Inc()
+	}
+	if err != nil {
+		switch err.(type) {
+		case utils.HttpNotModified, utils.IdenticalEtag:
+			return nil, err
+		}
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+	if lastrefresh {
+		LastRefresh.WithLabelValues(file).Set(float64(s.lastts.UnixNano() / 1e9))
+	}
+
+	buf := bytes.NewBuffer(data)
+
+	slurm, err = prefixfile.DecodeJSONSlurm(buf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Slurm file %v: %v", file, err)
+	}
+	if err := slurm.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid Slurm file %v: %v", file, err)
+	}
+
+	conflicts := slurm.FindConflicts()
+	for _, conflict := range conflicts {
+		log.WithField("source", file).Warnf("Slurm conflict in %v: %v", file, conflict)
+	}
+	if len(conflicts) > 0 {
+		switch s.slurmConflicts {
+		case SLURM_CONFLICT_PREFER_ASSERTION:
+			// Assertions are added after filtering, nothing to change.
+		case SLURM_CONFLICT_PREFER_FILTER:
+			slurm.RemoveAssertions(conflicts)
+		default:
+			return nil, SlurmConflictError{File: file, Count: len(conflicts)}
+		}
+	}
+
+	return slurm, nil
+}
+
+// jitterInterval adds a random part of up to ±jitter times the interval to an interval,
+// with r uniformly distributed in [0, 1).
+func jitterInterval(interval time.Duration, jitter float64, r float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	return interval + time.Duration(float64(interval)*jitter*(2*r-1))
+}
+
+// backoffInterval doubles an interval for each consecutive failure after the first one,
+// up to max (or the interval if longer). A max of 0 disables the backoff.
+func backoffInterval(interval time.Duration, failures int, max time.Duration) time.Duration {
+	if max <= 0 || failures <= 1 {
+		return interval
+	}
+	if max < interval {
+		max = interval
+	}
+	for i := 1; i < failures && interval < max; i++ {
+		interval *= 2
+	}
+	if interval > max {
+		interval = max
+	}
+	return interval
+}
+
+// routineUpdate refreshes the cache, and the Slurm file unless it has its own interval.
+func (s *state) routineUpdate(reloads <-chan struct{}) {
+	log.Debugf("Starting refresh routine (caches: %v)", s.caches)
+	// Seeded so that the instances started together do not refresh together
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		s.lockUpdate.Lock()
+		interval := s.refreshInterval
+		s.lockUpdate.Unlock()
+
+		next := time.Duration(interval) * time.Second
 		if s.lastchange.IsZero() {
-			log.Warn("Initial sync not complete. Refreshing every 30 seconds")
-			delay = time.NewTimer(time.Duration(30) * time.Second)
+			next = time.Duration(30) * time.Second
+		}
+		if s.refreshFailures > 0 {
+			next = backoffInterval(next, s.refreshFailures, s.refreshBackoffMax)
+			RefreshBackoff.Set(next.Seconds())
 		} else {
-			delay = time.NewTimer(time.Duration(interval) * time.Second)
+			RefreshBackoff.Set(0)
 		}
+		if s.lastchange.IsZero() {
+			log.Warnf("Initial sync not complete. Refreshing in %v", next)
+		} else if s.refreshFailures > 0 {
+			log.Warnf("%d refreshes failed, next one in %v", s.refreshFailures, next)
+		}
+		delay := time.NewTimer(jitterInterval(next, s.refreshJitter, rnd.Float64()))
 		select {
 		case <-delay.C:
-		case <-signals:
-			log.Debug("Received HUP signal")
+		case <-reloads:
 		}
 		delay.Stop()
+		atomic.StoreInt64(&s.refreshStarted, time.Now().UnixNano())
+		s.lockUpdate.Lock()
+		endTrace := s.traceUpdate("refresh")
 		slurmNotPresentOrUpdated := false
-		if slurmFile != "" {
+		if len(s.slurmPaths) > 0 && s.slurmRefresh && s.slurmInterval <= 0 {
 			var err error
-			slurmNotPresentOrUpdated, err = s.updateSlurm(slurmFile)
+			slurmNotPresentOrUpdated, err = s.updateSlurms(s.slurmPaths)
 			if err != nil {
 				switch err.(type) {
 				case utils.HttpNotModified:
@@ -411,40 +1216,128 @@ func (s *state) routineUpdate(file string, interval int, slurmFile string) {
 				}
 			}
 		}
-		cacheUpdated, err := s.updateFile(file)
+		// The Slurm files of the views are refreshed along those of the default view
+		viewsUpdated := false
+		if s.slurmRefresh && s.slurmInterval <= 0 {
+			viewsUpdated = s.updateViewsSlurm()
+		}
+		cacheUpdated, err := s.updateCaches()
+		if err != nil {
+			log.Errorf("Error updating: %v", err)
+			s.refreshFailures++
+			s.webhooks.fire(webhookEvent{Event: WEBHOOK_FETCH_FAILED, Error: err.Error(), Failures: s.refreshFailures}, time.Now())
+		} else {
+			s.refreshFailures = 0
+		}
+		RefreshFailures.Set(float64(s.refreshFailures))
+
+		// Only process the first time after there is either a cache or SLURM
+		// update, or to serve again the data withdrawn once it is refreshed.
+		if cacheUpdated || slurmNotPresentOrUpdated || viewsUpdated || (s.expired && err == nil) {
+			err := s.updateFromNewState()
+			if err != nil {
+				log.Errorf("Error updating from new state: %v", err)
+			}
+		}
+		s.expireStale()
+		endTrace()
+		s.lockUpdate.Unlock()
+		atomic.StoreInt64(&s.refreshStarted, 0)
+	}
+}
+
+// slurmRetryInterval returns the interval before the next refresh of the Slurm files on
+// their own interval. After a failure, they are retried along the cache if it is refreshed
+// more often, rather than kept for the whole interval.
+func slurmRetryInterval(slurmInterval int, refreshInterval int, failed bool) int {
+	if failed && refreshInterval > 0 && refreshInterval < slurmInterval {
+		return refreshInterval
+	}
+	return slurmInterval
+}
+
+// routineSlurm refreshes the Slurm files, and the ones of the views, on their own interval,
+// independently of the cache.
+// It waits for a reload setting an interval while -slurm.interval is 0.
+func (s *state) routineSlurm(reloads <-chan struct{}) {
+	log.Debug("Starting slurm refresh routine")
+	var failed bool
+	for {
+		s.lockUpdate.Lock()
+		interval := s.slurmInterval
+		if interval > 0 {
+			interval = slurmRetryInterval(interval, s.refreshInterval, failed)
+		}
+		s.lockUpdate.Unlock()
+
+		var delay *time.Timer
+		var timeout <-chan time.Time
+		if interval > 0 {
+			if failed {
+				log.Warnf("Slurm refresh failed, retrying in %v", time.Duration(interval)*time.Second)
+			}
+			delay = time.NewTimer(time.Duration(interval) * time.Second)
+			timeout = delay.C
+		}
+		select {
+		case <-timeout:
+		case <-reloads:
+		}
+		if delay != nil {
+			delay.Stop()
+		}
+		s.lockUpdate.Lock()
+		if !s.slurmRefresh || s.slurmInterval <= 0 {
+			s.lockUpdate.Unlock()
+			continue
+		}
+		endTrace := s.traceUpdate("slurm.refresh")
+		var slurmUpdated bool
+		var err error
+		if len(s.slurmPaths) > 0 {
+			slurmUpdated, err = s.updateSlurms(s.slurmPaths)
+		}
+		viewsUpdated := s.updateViewsSlurm()
+		failed = false
 		if err != nil {
 			switch err.(type) {
 			case utils.HttpNotModified:
 				log.Info(err)
 			case utils.IdenticalEtag:
 				log.Info(err)
-			case IdenticalFile:
-				log.Info(err)
 			default:
-				log.Errorf("Error updating: %v", err)
+				log.Errorf("Slurm: %v", err)
+				failed = true
 			}
 		}
-
-		// Only process the first time after there is either a cache or SLURM
-		// update.
-		if cacheUpdated || slurmNotPresentOrUpdated {
+		if slurmUpdated || viewsUpdated {
 			err := s.updateFromNewState()
 			if err != nil {
 				log.Errorf("Error updating from new state: %v", err)
 			}
 		}
+		endTrace()
+		s.lockUpdate.Unlock()
 	}
 }
 
-func (s *state) exporter(wr http.ResponseWriter, r *http.Request) {
-	s.lockJson.RLock()
-	toExport := s.exported
-	s.lockJson.RUnlock()
-	enc := json.NewEncoder(wr)
-	enc.Encode(toExport)
-}
-
 type state struct {
+	// Start of the refresh in progress in unix nanoseconds (0 if none), for the systemd watchdog.
+	// First field to be 64-bit aligned for the atomic operations.
+	refreshStarted int64
+
+	// Caches in priority order, and the one the data is served from
+	caches      []string
+	activeCache string
+	// Last successful refresh of the cache, guarded by lockJson
+	refreshed time.Time
+	// Whether the data of a refresh was served to the routers, guarded by lockJson
+	applied bool
+	// Age of the last refresh after which the instance is not ready, see readiness
+	expire time.Duration
+	// RTR listeners serving, accessed atomically
+	listening int32
+
 	lastdata   *prefixfile.VRPList
 	lasthash   []byte
 	lastchange time.Time
@@ -452,6 +1345,10 @@ type state struct {
 	sendNotifs bool
 	useSerial  int
 
+	initialNotifs bool
+	// Set after the first successful update
+	loaded bool
+
 	fetchConfig *utils.FetchConfig
 
 	server *rtr.Server
@@ -459,42 +1356,234 @@ type state struct {
 	metricsEvent *metricsEvent
 
 	exported prefixfile.VRPList
-	lockJson *sync.RWMutex
+	// ETag of the export and time it last changed
+	exportedTag      string
+	exportedModified time.Time
+	lockJson         *sync.RWMutex
+	// Gzipped exports, nil if disabled
+	exportCache *exportCache
+
+	compareMaxBytes int64
+
+	// Whether the validate path is enabled, and its index of the VRPs exported
+	validateEnabled bool
+	validateIndex   *validateIndex
+
+	// File the data of the cache is saved to, and the hash of the data saved
+	persistFile   string
+	persistedHash []byte
+	exportBuffer  bool
+
+	// Sessions saved with the data when the session ID is seeded
+	session *sessionState
+
+	// Slurm files merged, the files in use and their configuration
+	slurm          *prefixfile.SlurmConfig
+	slurmFiles     []string
+	slurmConfigs   map[string]*prefixfile.SlurmConfig
+	slurmConflicts int
+	// Files and VRPs filtered and asserted in the last update, guarded by lockJson
+	slurmSummary slurmSummary
+
+	refreshJitter     float64
+	refreshBackoffMax time.Duration
+	// Consecutive refreshes which failed
+	refreshFailures int
+
+	// Applied again on reload, guarded by lockUpdate
+	refreshInterval int
+	slurmPaths      []string
+	slurmRefresh    bool
+	slurmInterval   int
+	// Watches the local cache and Slurm files, nil if disabled
+	watcher *fileWatcher
+
+	// Views selected by the source address of the clients, the default view is server
+	views vrpViews
+
+	checktime          bool
+	checktimeThreshold time.Duration
+	checktimeSkew      time.Duration
+	strict             bool
+	cacheFormat        int
+	// Timeout of the download from an RTR cache
+	rtrTimeout time.Duration
+	cascade    *rtrCascade
+
+	stalePolicy  int
+	expirePolicy int
+	// Set while the data checked is stale, so that the stale webhook is only posted once
+	stale bool
+
+	// Posts the events, nil if disabled
+	webhooks *webhooks
+	// Percentage of the VRPs changed by a serial posted as churn
+	webhookChurn float64
+	// Set once the stale data was withdrawn, until new data is served
+	expired bool
+
+	// Serializes the cache and Slurm refresh routines
+	lockUpdate *sync.Mutex
+	// Span of the update in progress, guarded by lockUpdate
+	traceCtx context.Context
+
+	// Called each time the data is loaded, after the listeners are started
+	ready func()
+}
 
-	slurm *prefixfile.SlurmConfig
+type metricsEvent struct {
+	// Number of TLS clients by bind and subject, the labels are removed when 0
+	tlsLock    *sync.Mutex
+	tlsClients map[[2]string]int
+}
 
-	checktime bool
+func newMetricsEvent() *metricsEvent {
+	return &metricsEvent{
+		tlsLock:    &sync.Mutex{},
+		tlsClients: make(map[[2]string]int),
+	}
 }
 
-type metricsEvent struct {
+// tlsClientLabels returns the labels of a client in TLSClientsMetric, if it presented a certificate.
+func tlsClientLabels(c *rtr.Client) ([2]string, bool) {
+	state, ok := c.GetTLSConnectionState()
+	if !ok || len(state.PeerCertificates) == 0 {
+		return [2]string{}, false
+	}
+	return [2]string{c.GetLocalAddress().String(), state.PeerCertificates[0].Subject.String()}, true
+}
+
+// listenerLabel returns the label of the listener of a client in the RTR metrics, its
+// transport and local address, e.g. tls://192.0.2.1:8283.
+func listenerLabel(transport string, addr net.Addr) string {
+	return transport + "://" + addr.String()
+}
+
+func clientLabels(c *rtr.Client) (string, string) {
+	addr := c.GetLocalAddress()
+	return addr.String(), listenerLabel(c.GetTransport(), addr)
 }
 
 func (m *metricsEvent) ClientConnected(c *rtr.Client) {
-	ClientsMetric.WithLabelValues(c.GetLocalAddress().String()).Inc()
+	ClientsMetric.WithLabelValues(clientLabels(c)).Inc()
+	if labels, ok := tlsClientLabels(c); ok {
+		m.tlsLock.Lock()
+		m.tlsClients[labels]++
+		TLSClientsMetric.WithLabelValues(labels[0], labels[1]).Set(float64(m.tlsClients[labels]))
+		m.tlsLock.Unlock()
+	}
 }
 
 func (m *metricsEvent) ClientDisconnected(c *rtr.Client) {
-	ClientsMetric.WithLabelValues(c.GetLocalAddress().String()).Dec()
+	ClientsMetric.WithLabelValues(clientLabels(c)).Dec()
+	if labels, ok := tlsClientLabels(c); ok {
+		m.tlsLock.Lock()
+		m.tlsClients[labels]--
+		if m.tlsClients[labels] <= 0 {
+			delete(m.tlsClients, labels)
+			TLSClientsMetric.DeleteLabelValues(labels[0], labels[1])
+		} else {
+			TLSClientsMetric.WithLabelValues(labels[0], labels[1]).Set(float64(m.tlsClients[labels]))
+		}
+		m.tlsLock.Unlock()
+	}
+}
+
+// pduTypeLabel returns the label of the type of a PDU in PDUsRecv and PDUsSent, e.g. end_of_data.
+func pduTypeLabel(pdu rtr.PDU) string {
+	return pduTypeIdLabel(pdu.GetType())
+}
+
+func pduTypeIdLabel(pduType uint8) string {
+	return strings.ToLower(
+		strings.Replace(
+			rtr.TypeToString(
+				pduType),
+			" ",
+			"_", -1))
 }
 
 func (m *metricsEvent) HandlePDU(c *rtr.Client, pdu rtr.PDU) {
-	PDUsRecv.WithLabelValues(
-		strings.ToLower(
-			strings.Replace(
-				rtr.TypeToString(
-					pdu.GetType()),
-				" ",
-				"_", -1))).Inc()
+	_, listener := clientLabels(c)
+	PDUsRecv.WithLabelValues(pduTypeLabel(pdu), listener).Inc()
 }
 
-func (m *metricsEvent) UpdateMetrics(numIPv4 int, numIPv6 int, numIPv4filtered int, numIPv6filtered int, changed time.Time, refreshed time.Time, file string) {
+func (m *metricsEvent) PDUSent(c *rtr.Client, pdu rtr.PDU, length int) {
+	_, listener := clientLabels(c)
+	PDUsSent.WithLabelValues(pduTypeLabel(pdu), listener).Inc()
+	BytesSent.WithLabelValues(listener).Add(float64(length))
+}
+
+// PDUBatchSent counts the PDUs of a batch written to a client at once.
+func (m *metricsEvent) PDUBatchSent(c *rtr.Client, batch *rtr.PDUBatch, length int) {
+	_, listener := clientLabels(c)
+	for pduType, count := range batch.Types {
+		PDUsSent.WithLabelValues(pduTypeIdLabel(pduType), listener).Add(float64(count))
+	}
+	BytesSent.WithLabelValues(listener).Add(float64(length))
+}
+
+func (m *metricsEvent) PDUFlood(c *rtr.Client, pdu rtr.PDU) {
+	PDUFloods.WithLabelValues(clientLabels(c)).Inc()
+}
+
+func (m *metricsEvent) BytesReceived(c *rtr.Client, length int) {
+	_, listener := clientLabels(c)
+	BytesReceived.WithLabelValues(listener).Add(float64(length))
+}
+
+func (m *metricsEvent) UpdateMetrics(numIPv4 int, numIPv6 int, numIPv4filtered int, numIPv6filtered int, numASNs int, changed time.Time, refreshed time.Time, file string) {
 	NumberOfVRPs.WithLabelValues("ipv4", "filtered", file).Set(float64(numIPv4filtered))
 	NumberOfVRPs.WithLabelValues("ipv4", "unfiltered", file).Set(float64(numIPv4))
 	NumberOfVRPs.WithLabelValues("ipv6", "filtered", file).Set(float64(numIPv6filtered))
 	NumberOfVRPs.WithLabelValues("ipv6", "unfiltered", file).Set(float64(numIPv6))
+	NumberOfASNs.WithLabelValues(file).Set(float64(numASNs))
 	LastChange.WithLabelValues(file).Set(float64(changed.UnixNano() / 1e9))
 }
 
+func (m *metricsEvent) UpdateInvalidMetrics(invalid map[string]int, file string) {
+	for _, reason := range invalidReasons {
+		InvalidVRPs.WithLabelValues(reason, file).Set(float64(invalid[reason]))
+	}
+}
+
+// sessionIDFromSeed returns the session ID derived from the seed or the content of the seed
+// file if one is set, mixed with the epoch of the saved sessions, the session ID set
+// otherwise (randomized if negative).
+func sessionIDFromSeed(sessionID int, seed string, seedFile string, epoch string) (int, error) {
+	if seed == "" && seedFile == "" {
+		return sessionID, nil
+	}
+	if seed != "" && seedFile != "" {
+		return 0, errors.New("-rtr.sessionid.seed and -rtr.sessionid.seed.file cannot be used together")
+	}
+	if sessionID >= 0 {
+		return 0, errors.New("-rtr.sessionid cannot be used with -rtr.sessionid.seed or -rtr.sessionid.seed.file")
+	}
+	if seedFile != "" {
+		data, err := os.ReadFile(seedFile)
+		if err != nil {
+			return 0, fmt.Errorf("rtr.sessionid.seed.file: %v", err)
+		}
+		seed = strings.TrimSpace(string(data))
+		if seed == "" {
+			return 0, fmt.Errorf("rtr.sessionid.seed.file: %v is empty", seedFile)
+		}
+	}
+	return int(rtr.SessionIdFromSeed([]byte(seed + "\n" + epoch))), nil
+}
+
+// checkEncryptedOnly returns an error if plain RTR would be served or nothing would be served encrypted.
+func checkEncryptedOnly(bind, bindTLS, bindSSH string) error {
+	if bind != "" {
+		return fmt.Errorf("-require.encrypted is set but -bind %q serves plain TCP, disable it with -bind \"\"", bind)
+	}
+	if bindTLS == "" && bindSSH == "" {
+		return errors.New("-require.encrypted is set but neither -tls.bind nor -ssh.bind is set")
+	}
+	return nil
+}
+
 func main() {
 	if err := run(); err != nil {
 		fmt.Fprintf(os.Stderr, "%s\n", err)
@@ -503,6 +1592,9 @@ func main() {
 }
 
 func run() error {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		return runValidate(os.Args[2:])
+	}
 	flag.Parse()
 	if flag.NArg() > 0 {
 		fmt.Printf("%s: illegal positional argument(s) provided (\"%s\") - did you mean to provide a flag?\n", os.Args[0], strings.Join(flag.Args(), " "))
@@ -512,20 +1604,96 @@ func run() error {
 		fmt.Println(AppVersion)
 		os.Exit(0)
 	}
+	cli := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		cli[flagName(f.Name)] = true
+	})
+	if *ConfigFile != "" {
+		if err := loadConfig(flag.CommandLine, *ConfigFile); err != nil {
+			log.Fatalf("Config: %v", err)
+		}
+	}
+	reload := newReloader(*ConfigFile, cli)
+
+	lns := newListeners(*ReusePort)
+	handoverChild, handover, err := inheritHandover(lns)
+	if err != nil {
+		log.Fatalf("Handover: %v", err)
+	}
+	if handover == nil {
+		if err := inheritSystemd(lns); err != nil {
+			log.Fatalf("systemd: %v", err)
+		}
+	}
 
+	logFormat, ok := logFormatToId[*LogFormat]
+	if !ok {
+		log.Fatalf("Log format %v unknown", *LogFormat)
+	}
+	setLogFormat(logFormat)
 	lvl, _ := log.ParseLevel(*LogLevel)
 	log.SetLevel(lvl)
+	if err := initTracing(*OTelEndpoint); err != nil {
+		log.Fatalf("otel.endpoint: %v", err)
+	}
+
+	if *KeepDiff < 1 {
+		log.Fatalf("-rtr.keepdiff must be at least 1")
+	}
 
 	deh := &rtr.DefaultRTREventHandler{
-		Log: log.StandardLogger(),
+		Log: newRTRLogger(log.StandardLogger()),
+	}
+
+	// A session ID stable across the restarts requires the serial to be stable as well: the
+	// serials of the sessions are saved with the data, and a new epoch changes the session
+	// ID when they are lost
+	var session *sessionState
+	var epoch string
+	if *SessionIDSeed != "" || *SessionIDSeedFile != "" {
+		if *PersistFile == "" {
+			log.Fatal("-rtr.sessionid.seed and -rtr.sessionid.seed.file require -persist.file, to resume the serial after a restart")
+		}
+		file := sessionStateFile(*PersistFile)
+		session, err = loadSessionState(file)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Errorf("Error loading %v, starting new sessions: %v", file, err)
+			}
+			if session, err = newSessionState(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		epoch = session.Epoch
+	}
+	sessionID, err := sessionIDFromSeed(*SessionID, *SessionIDSeed, *SessionIDSeedFile, epoch)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	sc := rtr.ServerConfiguration{
+		MaxConn: *MaxConn,
+		SourceLimits: rtr.SourceLimits{
+			MaxPerAddress: *MaxConnAddress,
+			MaxPerSubnet:  *MaxConnSubnet,
+			SubnetIPv4:    *SubnetIPv4,
+			SubnetIPv6:    *SubnetIPv6,
+			Rate:          *ConnRate,
+			Burst:         *ConnBurst,
+		},
 		ProtocolVersion: protoverToLib[*RTRVersion],
-		SessId:          *SessionID,
-		KeepDifference:  3,
-		Log:             log.StandardLogger(),
+		SessId:          sessionID,
+		KeepDifference:  *KeepDiff,
+		MaxDiffSize:     *MaxDiff,
+		Log:             newRTRLogger(log.StandardLogger()),
 		LogVerbose:      *LogVerbose,
+		LogSampleRate:   *LogSampleRate,
+		LogSampleWindow: *LogSampleWindow,
+		WriteTimeout:    *WriteTimeout,
+		SendQueueSize:   *QueueSize,
+		IdleTimeout:     time.Duration(*IdleRefreshes) * time.Duration(*RefreshRTR) * time.Second,
+		PDURate:         *PDURate,
+		PDUBurst:        *PDUBurst,
 
 		RefreshInterval: uint32(*RefreshRTR),
 		RetryInterval:   uint32(*RetryRTR),
@@ -536,56 +1704,222 @@ func run() error {
 	var enableHTTP bool
 	if *MetricsAddr != "" {
 		initMetrics()
-		me = &metricsEvent{}
+		me = newMetricsEvent()
 		enableHTTP = true
 	}
 
+	slurmConflicts, ok := slurmConflictToId[*SlurmConflicts]
+	if !ok {
+		log.Fatalf("Slurm conflict policy %v unknown", *SlurmConflicts)
+	}
+	stalePolicy, ok := stalePolicyToId[*StalePolicy]
+	if !ok {
+		log.Fatalf("Stale data policy %v unknown", *StalePolicy)
+	}
+	expirePolicy, ok := expirePolicyToId[*ExpirePolicy]
+	if !ok {
+		log.Fatalf("Expire policy %v unknown", *ExpirePolicy)
+	}
+	cacheFormat, ok := cacheFormatToId[*CacheFormat]
+	if !ok {
+		log.Fatalf("Cache format %v unknown", *CacheFormat)
+	}
+
 	server := rtr.NewServer(sc, me, deh)
 	deh.SetVRPManager(server)
 
 	s := state{
-		server:       server,
-		lastdata:     &prefixfile.VRPList{},
-		metricsEvent: me,
-		sendNotifs:   *SendNotifs,
-		checktime:    *TimeCheck,
-		lockJson:     &sync.RWMutex{},
+		server:        server,
+		caches:        splitList(*CacheBin),
+		lastdata:      &prefixfile.VRPList{},
+		metricsEvent:  me,
+		sendNotifs:    *SendNotifs,
+		initialNotifs: *InitialNotifs,
+		checktime:     *TimeCheck,
+		checktimeSkew: *TimeSkew,
+		exportBuffer:  *ExportBuffer,
+		strict:        *Strict,
+		cacheFormat:   cacheFormat,
+		rtrTimeout:    *CacheRTRTimeout,
+		lockJson:      &sync.RWMutex{},
+		lockUpdate:    &sync.Mutex{},
+
+		checktimeThreshold: *StaleThreshold,
+		stalePolicy:        stalePolicy,
+		expirePolicy:       expirePolicy,
+
+		slurmConflicts:  slurmConflicts,
+		compareMaxBytes: *CompareMaxBytes,
+		validateEnabled: *ValidatePath != "",
+		persistFile:     *PersistFile,
+		session:         session,
+
+		refreshInterval:   *RefreshInterval,
+		refreshJitter:     *RefreshJitter,
+		refreshBackoffMax: *RefreshBackoffMax,
+		expire:            time.Duration(*ExpireRTR) * time.Second,
+		slurmPaths:        Slurm.Values(),
+		slurmRefresh:      *SlurmRefresh,
+		slurmInterval:     *SlurmInterval,
 
 		fetchConfig: utils.NewFetchConfig(),
 	}
+	if *CacheRTRCascade {
+		s.cascade = newRTRCascade()
+	}
 	s.fetchConfig.UserAgent = *UserAgent
 	s.fetchConfig.Mime = *Mime
 	s.fetchConfig.EnableEtags = *Etag
 	s.fetchConfig.EnableLastModified = *LastModified
+	s.fetchConfig.RangeResume = *CacheResume
+	s.fetchConfig.MaxBytes = *CacheMaxBytes
+	s.fetchConfig.DisableCompression = !*CacheCompression
+	s.fetchConfig.ArchiveMember = *CacheMember
+	s.fetchConfig.CacheDir = *CacheDir
+	s.fetchConfig.MaxRedirects = *CacheMaxRedirects
+	for _, host := range strings.Split(*CacheRedirectHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			s.fetchConfig.AllowedHosts = append(s.fetchConfig.AllowedHosts, host)
+		}
+	}
+	if err := utils.CheckAddressFamily(*CacheFamily); err != nil {
+		log.Fatalf("cache.family: %v", err)
+	}
+	s.fetchConfig.AddressFamily = *CacheFamily
+	s.fetchConfig.FallbackDelay = *CacheFallbackDelay
+	s.fetchConfig.Proxy = *FetchProxy
+	s.fetchConfig.NoProxy = *FetchNoProxy
+	s.fetchConfig.CAFile = *CacheTLSCA
+	s.fetchConfig.CertFile = *CacheTLSCert
+	s.fetchConfig.KeyFile = *CacheTLSKey
+	if _, err := s.fetchConfig.TLSConfig(); err != nil {
+		log.Fatalf("cache.tls: %v", err)
+	}
+	s.fetchConfig.Username = *CacheAuthUser
+	s.fetchConfig.Password = *CacheAuthPassword
+	if s.fetchConfig.Password == "" {
+		s.fetchConfig.Password = os.Getenv(ENV_CACHE_PASSWORD)
+	}
+	s.fetchConfig.BearerToken = *CacheAuthToken
+	if s.fetchConfig.BearerToken == "" {
+		s.fetchConfig.BearerToken = os.Getenv(ENV_CACHE_TOKEN)
+	}
+	if s.fetchConfig.Username != "" && s.fetchConfig.BearerToken != "" {
+		log.Fatalf("-cache.auth.user and -cache.auth.token cannot be used together")
+	}
+	s.fetchConfig.Log = log.StandardLogger()
+	s.fetchConfig.Fetched = func(file string, size int) {
+		FetchedBytes.WithLabelValues(file).Add(float64(size))
+	}
+
+	if files := SlurmCheck.Values(); len(files) > 0 {
+		return s.checkSlurm(os.Stdout, files)
+	}
+	if *Once {
+		return s.reportOnce(os.Stdout)
+	}
+	if urls := WebhookURL.Values(); len(urls) > 0 {
+		events, err := parseWebhookEvents(*WebhookEvents)
+		if err != nil {
+			log.Fatalf("webhook.events: %v", err)
+		}
+		s.webhooks = newWebhooks(urls, events, *WebhookTimeout, *UserAgent)
+		s.webhookChurn = *WebhookChurn
+		go s.webhooks.run()
+	}
 
 	if enableHTTP {
+		prometheus.MustRegister(newChangeAgeCollector(&s))
+		prometheus.MustRegister(newClientsCollector(&s))
+		http.HandleFunc("/healthz", healthz)
+		http.HandleFunc("/readyz", s.readyz)
+		prometheus.MustRegister(prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "rtr_notifications_skipped_total",
+				Help: "Serial Notify not sent to clients already at the serial.",
+			},
+			func() float64 {
+				return float64(server.GetNotificationsSkipped())
+			},
+		))
+		prometheus.MustRegister(prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "rtr_large_diff_resets_total",
+				Help: "Cache Reset sent instead of more changes than -rtr.diff.max.",
+			},
+			func() float64 {
+				return float64(server.GetLargeDiffResets())
+			},
+		))
+		if *ExportGzip {
+			s.exportCache = newExportCache()
+		}
 		if *ExportPath != "" {
 			http.HandleFunc(*ExportPath, s.exporter)
 		}
-		go metricHTTP()
+		if *ExportRoaSet != "" {
+			http.HandleFunc(*ExportRoaSet, s.formatExporter(exportFormatRoaSet))
+		}
+		if *ComparePath != "" {
+			http.HandleFunc(*ComparePath, s.compare)
+		}
+		if *ValidatePath != "" {
+			http.HandleFunc(*ValidatePath, s.validate)
+		}
+		if *StatusPath != "" {
+			http.HandleFunc(*StatusPath, s.status)
+		}
+		if *ChangesPath != "" {
+			http.HandleFunc(*ChangesPath, changesHandler(server, changesKeepalive, *ChangesMax))
+		}
+		debugToken := *DebugToken
+		if debugToken == "" {
+			debugToken = os.Getenv(ENV_DEBUG_TOKEN)
+		}
+		if debugToken != "" {
+			http.HandleFunc("/debug/vrps", debugVRPsHandler(server, debugToken))
+		}
+		metricsListener, err := lns.Listen("metrics.addr", *MetricsAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go metricHTTP(metricsListener)
 	}
 
-	if *Bind == "" && *BindTLS == "" && *BindSSH == "" {
+	if *RequireEncrypted {
+		if err := checkEncryptedOnly(Bind.String(), BindTLS.String(), BindSSH.String()); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(Bind.Values()) == 0 && len(BindTLS.Values()) == 0 && len(BindSSH.Values()) == 0 && *BindUnix == "" {
 		log.Fatalf("Specify at least a bind address")
 	}
 
-	_, err := s.updateFile(*CacheBin)
-	if err != nil {
-		switch err.(type) {
-		case utils.HttpNotModified:
-			log.Info(err)
-		case IdenticalFile:
-			log.Info(err)
-		case utils.IdenticalEtag:
-			log.Info(err)
-		default:
-			log.Errorf("Error updating: %v", err)
+	if s.persistFile != "" {
+		// Served until the cache is fetched
+		persisted, err := loadPersisted(s.persistFile)
+		if err == nil {
+			log.Infof("Loaded %d VRPs from %v", len(persisted.Data), s.persistFile)
+			s.lastdata = persisted
+		} else if !os.IsNotExist(err) {
+			log.Errorf("Error loading %v: %v", s.persistFile, err)
 		}
 	}
 
-	slurmFile := *Slurm
-	if slurmFile != "" {
-		_, err := s.updateSlurm(slurmFile)
+	// The watchdog is pinged during the initial refresh, whose duration is bounded by
+	// TimeoutStartSec rather than WatchdogSec, until READY=1 is reported
+	if interval := systemdWatchdogInterval(os.Getenv, os.Getpid()); interval > 0 {
+		go s.routineWatchdog(interval)
+	}
+	endTrace := s.traceUpdate("startup")
+
+	_, err = s.updateCaches()
+	if err != nil {
+		log.Errorf("Error updating: %v", err)
+	}
+
+	if len(s.slurmPaths) > 0 {
+		_, err := s.updateSlurms(s.slurmPaths)
 		if err != nil {
 			switch err.(type) {
 			case utils.HttpNotModified:
@@ -593,12 +1927,40 @@ func run() error {
 			case utils.IdenticalEtag:
 				log.Info(err)
 			default:
+				if *SlurmRequired {
+					log.Fatalf("Slurm: %v", err)
+				}
 				log.Errorf("Slurm: %v", err)
 			}
 		}
-		if !*SlurmRefresh {
-			slurmFile = ""
+	}
+
+	if *Views != "" {
+		views, err := loadViews(*Views, func() *rtr.Server {
+			vdeh := &rtr.DefaultRTREventHandler{
+				Log: newRTRLogger(log.StandardLogger()),
+			}
+			vserver := rtr.NewServer(sc, me, vdeh)
+			vdeh.SetVRPManager(vserver)
+			return vserver
+		})
+		if err != nil {
+			log.Fatalf("Views: %v", err)
+		}
+		s.views = views
+		s.updateViewsSlurm()
+		for _, view := range views {
+			if *SlurmRequired && view.slurmFile != "" && view.slurm == nil {
+				log.Fatalf("Slurm of view %v could not be loaded", view.name)
+			}
 		}
+		server.SetViewSelector(views.selector)
+	}
+
+	if handover != nil {
+		s.resumeSessions(handover.Sessions)
+	} else if session != nil {
+		s.resumeSessions(session.resumable(uint16(sessionID)))
 	}
 
 	// Initial calculation of state (after fetching cache + slurm)
@@ -606,120 +1968,271 @@ func run() error {
 	if err != nil {
 		log.Warnf("Error setting up initial state: %s", err)
 	}
+	endTrace()
 
-	if *Bind != "" {
-		go func() {
-			sessid := server.GetSessionId()
-			log.Infof("StayRTR Server started (sessionID:%d, refresh:%d, retry:%d, expire:%d)", sessid, sc.RefreshInterval, sc.RetryInterval, sc.ExpireInterval)
-			err := server.Start(*Bind)
-			if err != nil {
-				log.Fatal(err)
-			}
-		}()
+	var md5Keys *tcpMD5Keys
+	if *TCPMD5Keys != "" {
+		md5Keys = newTCPMD5Keys(*TCPMD5Keys)
+		if err := md5Keys.Load(); err != nil {
+			log.Fatalf("TCP MD5: %v", err)
+		}
+		log.Infof("Loaded %d TCP MD5 key(s)", md5Keys.Count())
+		go md5Keys.routineReload(reload.Subscribe())
+	}
+	acl := newSourceACL()
+	if prefixes, err := parseACL(*ACL, *ACLFile); err != nil {
+		log.Fatalf("acl: %v", err)
+	} else if len(prefixes) > 0 {
+		acl.Set(prefixes)
+		log.Infof("Accepting the RTR clients from %d prefix(es)", len(prefixes))
+	} else if prefixes != nil {
+		acl.Set(prefixes)
+		log.Warn("The ACL is empty, rejecting all the RTR clients")
+	}
+	reload.acl = acl
+
+	proxyBinds := map[string]bool{"bind": *BindProxy, "tls.bind": *TLSProxy}
+	proxyTrusted, err := parseACL(*ProxyTrusted, "")
+	if err != nil {
+		log.Fatalf("proxy.trusted: %v", err)
+	}
+	if (*BindProxy || *TLSProxy) && len(proxyTrusted) == 0 {
+		// Otherwise any client could send a header to spoof its address past the ACL
+		log.Fatal("proxy.trusted: the prefixes of the load balancers are required with -bind.proxy and -tls.proxy")
 	}
-	if *BindTLS != "" {
-		cert, err := tls.LoadX509KeyPair(*TLSCert, *TLSKey)
+
+	// listenRTR listens for the routers, setting their TCP MD5 keys and keepalives and reading
+	// the PROXY protocol header before checking the ACL
+	listenRTR := func(name string, addr string) (net.Listener, error) {
+		listener, err := lns.Listen(name, addr)
 		if err != nil {
-			log.Fatal(err)
-		}
-		tlsConfig := tls.Config{
-			Certificates: []tls.Certificate{cert},
+			return nil, err
 		}
-		go func() {
-			err := server.StartTLS(*BindTLS, &tlsConfig)
-			if err != nil {
-				log.Fatal(err)
+		if md5Keys != nil {
+			if err := md5Keys.Add(listener); err != nil {
+				return nil, err
 			}
-		}()
+		}
+		if *KeepAlive != 0 {
+			listener = &keepAliveListener{Listener: listener, period: *KeepAlive}
+		}
+		if proxyBinds[name] {
+			log.Infof("Reading the PROXY protocol header on %v", name)
+			listener = newProxyListener(listener, name, proxyTrusted)
+		}
+		return &aclListener{Listener: listener, name: name, acl: acl}, nil
+	}
+
+	if len(Bind.Values()) > 0 {
+		log.Infof("StayRTR Server started (sessionID:%d, refresh:%d, retry:%d, expire:%d)", server.GetSessionId(), sc.RefreshInterval, sc.RetryInterval, sc.ExpireInterval)
 	}
-	if *BindSSH != "" {
-		sshkey, err := os.ReadFile(*SSHKey)
+	for _, addr := range Bind.Values() {
+		listener, err := listenRTR("bind", addr)
 		if err != nil {
 			log.Fatal(err)
 		}
-		private, err := ssh.ParsePrivateKey(sshkey)
+		go s.serveRTR(func() error {
+			return server.Serve(listener)
+		})
+	}
+	if *BindUnix != "" {
+		mode, err := strconv.ParseUint(*BindUnixMode, 8, 32)
 		if err != nil {
-			log.Fatal("Failed to parse private key: ", err)
+			log.Fatalf("bind.unix.mode: %v", err)
 		}
-
-		sshConfig := ssh.ServerConfig{}
-
-		log.Infof("Enabling ssh with the following authentications: password=%v, key=%v", *SSHAuthEnablePassword, *SSHAuthEnableKey)
-		if *SSHAuthEnablePassword {
-			password := *SSHAuthPassword
-			if password == "" {
-				password = os.Getenv(ENV_SSH_PASSWORD)
+		listener, err := lns.ListenUnix("bind.unix", *BindUnix, os.FileMode(mode))
+		if err != nil {
+			log.Fatal(err)
+		}
+		go s.serveRTR(func() error {
+			return server.Serve(listener)
+		})
+	}
+	if len(BindTLS.Values()) > 0 {
+		var tlsConfig *tls.Config
+		if *TLSACMEDomain != "" {
+			if *TLSCert != "" || *TLSKey != "" {
+				log.Fatal("-tls.acme.domain cannot be used with -tls.cert and -tls.key")
 			}
-			sshConfig.PasswordCallback = func(conn ssh.ConnMetadata, suppliedPassword []byte) (*ssh.Permissions, error) {
-				log.Infof("Connected (ssh-password): %v/%v", conn.User(), conn.RemoteAddr())
-				if conn.User() != *SSHAuthUser || !bytes.Equal(suppliedPassword, []byte(password)) {
-					log.Warnf("Wrong user or password for %v/%v. Disconnecting.", conn.User(), conn.RemoteAddr())
-					return nil, errors.New("Wrong user or password")
-				}
-
-				return &ssh.Permissions{
-					CriticalOptions: make(map[string]string),
-					Extensions:      make(map[string]string),
-				}, nil
+			domains := acmeDomains(*TLSACMEDomain)
+			manager, err := newACMEManager(domains, *TLSACMEEmail, *TLSACMECache, *TLSACMEDirectory, *TLSACMEAcceptTOS)
+			if err != nil {
+				log.Fatal(err)
 			}
-		}
-		if *SSHAuthEnableKey {
-			var sshClientKeysToDecode string
-			if *SSHAuthKeysList == "" {
-				sshClientKeysToDecode = os.Getenv(ENV_SSH_KEY)
-			} else {
-				sshClientKeysToDecodeBytes, err := os.ReadFile(*SSHAuthKeysList)
+			tlsConfig = acmeTLSConfig(manager, domains)
+			log.Infof("Obtaining the TLS certificate of %v with ACME", strings.Join(domains, ", "))
+			if *TLSACMEBind != "" {
+				listener, err := lns.Listen("tls.acme.bind", *TLSACMEBind)
 				if err != nil {
 					log.Fatal(err)
 				}
-				sshClientKeysToDecode = string(sshClientKeysToDecodeBytes)
+				go func() {
+					fatalServe(http.Serve(listener, manager.HTTPHandler(http.NotFoundHandler())))
+				}()
+			} else {
+				log.Warn("No -tls.acme.bind, only the tls-alpn-01 challenges on a TLS bind on port 443 are answered")
 			}
-			sshClientKeys := strings.Split(sshClientKeysToDecode, "\n")
-
-			sshConfig.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
-				keyBase64 := base64.RawStdEncoding.EncodeToString(key.Marshal())
-				if !*SSHAuthKeysBypass {
-					var noKeys bool
-					for i, k := range sshClientKeys {
-						if k == "" {
-							continue
-						}
-						if strings.HasPrefix(k, fmt.Sprintf("%v %v", key.Type(), keyBase64)) {
-							log.Infof("Connected (ssh-key): %v/%v with key %v %v (matched with line %v)",
-								conn.User(), conn.RemoteAddr(), key.Type(), keyBase64, i+1)
-							noKeys = true
-							break
-						}
-					}
-					if !noKeys {
-						log.Warnf("No key for %v/%v %v %v. Disconnecting.", conn.User(), conn.RemoteAddr(), key.Type(), keyBase64)
-						return nil, errors.New("Key not found")
-					}
-				} else {
-					log.Infof("Connected (ssh-key): %v/%v with key %v %v", conn.User(), conn.RemoteAddr(), key.Type(), keyBase64)
-				}
-
-				return &ssh.Permissions{
-					CriticalOptions: make(map[string]string),
-					Extensions:      make(map[string]string),
-				}, nil
+		} else {
+			tlsCert := newTLSCertificate()
+			if err := tlsCert.Load(*TLSCert, *TLSKey); err != nil {
+				log.Fatal(err)
 			}
+			reload.tlsCert = tlsCert
+			tlsConfig = &tls.Config{
+				GetCertificate: tlsCert.GetCertificate,
+			}
+		}
+		if err := setTLSClientAuth(tlsConfig, *TLSClientCA, *TLSClientRequired); err != nil {
+			log.Fatal(err)
+		}
+		for _, addr := range BindTLS.Values() {
+			listener, err := listenRTR("tls.bind", addr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			go s.serveRTR(func() error {
+				return server.ServeTLS(listener, tlsConfig)
+			})
 		}
+	}
+	if len(BindSSH.Values()) > 0 {
+		authGuard := newSSHAuthGuard(*SSHAuthBackoff, *SSHAuthBackoffMax, *SSHAuthLogInterval)
+		go authGuard.routineFlush()
 
-		if !(*SSHAuthEnableKey || *SSHAuthEnablePassword) {
-			sshConfig.NoClientAuth = true
+		log.Infof("Enabling ssh with the following authentications: password=%v, key=%v", *SSHAuthEnablePassword, *SSHAuthEnableKey)
+		var sshClientKeys *sshAuthorizedKeys
+		if *SSHAuthEnableKey {
+			sshClientKeys = newSSHAuthorizedKeys(*SSHAuthKeysList)
+			if err := sshClientKeys.Load(); err != nil {
+				log.Fatal(err)
+			}
+			log.Infof("Loaded %d authorized SSH key(s)", sshClientKeys.Count())
+			go sshClientKeys.routineReload(reload.Subscribe())
 		}
 
-		sshConfig.AddHostKey(private)
-		go func() {
-			err := server.StartSSH(*BindSSH, &sshConfig)
+		sshConfig, err := newSSHServerConfig(reload.current, authGuard, sshClientKeys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reload.server = server
+		reload.sshConfig = func(config reloadableConfig) (*ssh.ServerConfig, error) {
+			return newSSHServerConfig(config, authGuard, sshClientKeys)
+		}
+		for _, addr := range BindSSH.Values() {
+			listener, err := listenRTR("ssh.bind", addr)
 			if err != nil {
 				log.Fatal(err)
 			}
+			listener = authGuard.Listener(listener)
+			go s.serveRTR(func() error {
+				return server.ServeSSH(listener, sshConfig)
+			})
+		}
+	}
+
+	go s.routineSlurm(reload.Subscribe())
+	go s.routineSlurmReload()
+	if *BindAdmin != "" {
+		adminToken := *AdminToken
+		if adminToken == "" {
+			adminToken = os.Getenv(ENV_ADMIN_TOKEN)
+		}
+		if adminToken == "" {
+			log.Fatalf("The admin API requires -admin.token or envvar %v", ENV_ADMIN_TOKEN)
+		}
+		listener, err := lns.Listen("admin.bind", *BindAdmin)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			log.Infof("Enabling the admin API on %v", *BindAdmin)
+			fatalServe(http.Serve(listener, newAdminHandler(&s, adminToken, reload.broadcast)))
 		}()
 	}
+	if *BindGRPC != "" {
+		listener, err := lns.Listen("grpc.bind", *BindGRPC)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			log.Infof("Enabling gRPC on %v", *BindGRPC)
+			fatalServe(startGRPC(listener, server))
+		}()
+	}
+
+	if *EventsURL != "" {
+		publisher, err := newEventPublisher(*EventsURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer publisher.close()
+		feed := &eventFeed{
+			server:    server,
+			publisher: publisher,
+			timeout:   *EventsTimeout,
+		}
+		log.Infof("Publishing the change events to %v", *EventsURL)
+		go feed.run()
+	}
+	if *JournalFile != "" {
+		journal, err := newJournal(server, *JournalFile, *JournalMaxBytes, *JournalKeep)
+		if err != nil {
+			log.Fatalf("journal.file: %v", err)
+		}
+		log.Infof("Writing the serials and their changes to %v", *JournalFile)
+		go journal.run()
+	}
+	if *LogDiff > 0 {
+		logger, err := newDiffLogger(*LogDiffFile)
+		if err != nil {
+			log.Fatalf("log.diff.file: %v", err)
+		}
+		go (&diffLog{server: server, logger: logger, max: *LogDiff}).run()
+	}
+
+	lns.CloseInherited()
+	readyOnce := &sync.Once{}
+	s.lockUpdate.Lock()
+	s.ready = func() {
+		readyOnce.Do(func() {
+			notify := "READY=1"
+			if handoverChild != nil {
+				// Before the previous process exits
+				notify = fmt.Sprintf("MAINPID=%d\nREADY=1", os.Getpid())
+			}
+			if err := sdNotify(notify); err != nil {
+				log.Errorf("Error notifying systemd: %v", err)
+			}
+			if handoverChild != nil {
+				handoverChild.Ready()
+			}
+		})
+	}
+	if s.loaded {
+		s.ready()
+	}
+	s.lockUpdate.Unlock()
+	go s.routineHandover(lns, *HandoverTimeout)
+
+	if *Watch {
+		watcher, err := newFileWatcher(reload.broadcast, watchDelay)
+		if err != nil {
+			log.Errorf("Error watching files: %v", err)
+		} else {
+			s.lockUpdate.Lock()
+			s.watcher = watcher
+			s.watchFiles()
+			s.lockUpdate.Unlock()
+		}
+	}
+
+	if s.cascade != nil {
+		go s.routineCascade(reload.broadcast)
+	}
 
-	s.routineUpdate(*CacheBin, *RefreshInterval, slurmFile)
+	reloads := reload.Subscribe()
+	go reload.routineReload(&s)
+	s.routineUpdate(reloads)
 
 	return nil
 }
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	rtr "github.com/bgp/stayrtr/lib"
 	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/bgp/stayrtr/utils"
 	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestProcessData(t *testing.T) {
@@ -87,42 +95,125 @@ func TestProcessData(t *testing.T) {
 			TA:     "testrir",
 		},
 	)
-	got, count, v4count, v6count := processData(stuff)
+	got, stats := processData(stuff, false)
 	want := []rtr.VRP{
 		{
-			Prefix: mustParseIPNet("192.168.0.0/24"),
+			Prefix: mustParsePrefix("192.168.0.0/24"),
 			MaxLen: 24,
 			ASN:    123,
 		},
 		{
-			Prefix: mustParseIPNet("2001:db8::/32"),
+			Prefix: mustParsePrefix("2001:db8::/32"),
 			MaxLen: 33,
 			ASN:    123,
 		},
 		{
-			Prefix: mustParseIPNet("192.168.1.0/24"),
+			Prefix: mustParsePrefix("192.168.1.0/24"),
 			MaxLen: 25,
 			ASN:    123,
 		},
 	}
-	if count != 3 || v4count != 2 || v6count != 1 {
-		t.Errorf("Wanted count = 3, v4count = 2, v6count = 1, but got %d, %d, %d", count, v4count, v6count)
+	if stats.Count != 3 || stats.CountV4 != 2 || stats.CountV6 != 1 {
+		t.Errorf("Wanted count = 3, v4count = 2, v6count = 1, but got %d, %d, %d", stats.Count, stats.CountV4, stats.CountV6)
+	}
+	if stats.ASNs != 1 {
+		t.Errorf("Wanted 1 unique ASN, got %d", stats.ASNs)
+	}
+	wantInvalid := map[string]int{
+		INVALID_PREFIX:              2,
+		INVALID_ASN:                 2,
+		INVALID_MAXLENGTH_TOO_SHORT: 2,
+		INVALID_MAXLENGTH_TOO_LONG:  2,
+	}
+	if !cmp.Equal(stats.Invalid, wantInvalid) {
+		t.Errorf("Want invalid (%+v), Got (%+v)", wantInvalid, stats.Invalid)
 	}
 
-	if !cmp.Equal(got, want) {
+	if !cmp.Equal(got, want, comparePrefixes) {
 		t.Errorf("Want (%+v), Got (%+v)", want, got)
 	}
 }
 
-// mustParseIPNet is a test helper function to return a net.IPNet
+func TestProcessDataStrict(t *testing.T) {
+	tests := []struct {
+		name   string
+		json   string
+		strict bool
+		reason string
+	}{
+		{
+			name: "maxLength absent",
+			json: `{"prefix": "192.168.0.0/24", "asn": 123}`,
+		},
+		{
+			name:   "maxLength absent, strict",
+			json:   `{"prefix": "192.168.0.0/24", "asn": 123}`,
+			strict: true,
+		},
+		{
+			name: "maxLength equal to the prefix length",
+			json: `{"prefix": "192.168.0.0/24", "maxLength": 24, "asn": 123}`,
+		},
+		{
+			name:   "maxLength equal to the prefix length, strict",
+			json:   `{"prefix": "192.168.0.0/24", "maxLength": 24, "asn": 123}`,
+			strict: true,
+			reason: INVALID_MAXLENGTH_REDUNDANT,
+		},
+		{
+			name:   "maxLength longer than the prefix length, strict",
+			json:   `{"prefix": "2001:db8::/32", "maxLength": 48, "asn": 123}`,
+			strict: true,
+		},
+		{
+			name:   "maxLength zero",
+			json:   `{"prefix": "192.168.0.0/24", "maxLength": 0, "asn": 123}`,
+			reason: INVALID_MAXLENGTH_TOO_SHORT,
+		},
+		{
+			name:   "prefix length zero",
+			json:   `{"prefix": "0.0.0.0/0", "asn": 123}`,
+			reason: INVALID_PREFIX_LENGTH_ZERO,
+		},
+		{
+			name: "prefix not canonical",
+			json: `{"prefix": "192.168.0.1/24", "maxLength": 25, "asn": 123}`,
+		},
+		{
+			name:   "prefix not canonical, strict",
+			json:   `{"prefix": "192.168.0.1/24", "maxLength": 25, "asn": 123}`,
+			strict: true,
+			reason: INVALID_PREFIX_NOT_CANONICAL,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var vrp prefixfile.VRPJson
+			if err := json.Unmarshal([]byte(tc.json), &vrp); err != nil {
+				t.Fatal(err)
+			}
+			got, stats := processData([]prefixfile.VRPJson{vrp}, tc.strict)
+			if tc.reason == "" {
+				assert.Len(t, got, 1)
+				assert.Empty(t, stats.Invalid)
+			} else {
+				assert.Empty(t, got)
+				assert.Equal(t, map[string]int{tc.reason: 1}, stats.Invalid)
+			}
+		})
+	}
+}
+
+// comparePrefixes lets cmp compare the netip.Prefix values, which have unexported fields.
+var comparePrefixes = cmp.Comparer(func(a, b netip.Prefix) bool {
+	return a == b
+})
+
+// mustParsePrefix is a test helper function to return a masked netip.Prefix
 // This should only be called in test code, and it'll panic on test set up
 // if unable to parse.
-func mustParseIPNet(prefix string) net.IPNet {
-	_, ipnet, err := net.ParseCIDR(prefix)
-	if err != nil {
-		panic(err)
-	}
-	return *ipnet
+func mustParsePrefix(prefix string) netip.Prefix {
+	return netip.MustParsePrefix(prefix).Masked()
 }
 
 func BenchmarkDecodeJSON(b *testing.B) {
@@ -165,6 +256,12 @@ func TestJson(t *testing.T) {
 				Expires: 1627575699,
 			},
 		},
+		BgpsecKeys: []prefixfile.BgpsecKeyJson{
+			{ASN: 64496, SKI: "E2F075EC50E9F2EFCED506026BF6E4D1BD5A1A0F", Pubkey: "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE", TA: "arin", Expires: 1627575699},
+		},
+		ASPA: []prefixfile.ASPAJson{
+			{CustomerASID: 64496, Expires: 1627575699, Providers: []uint32{64500, 64501}},
+		},
 	})
 
 	if !cmp.Equal(got, want) {
@@ -173,6 +270,27 @@ func TestJson(t *testing.T) {
 
 }
 
+func TestProcessBgpsecKeys(t *testing.T) {
+	got := processBgpsecKeys([]prefixfile.BgpsecKeyJson{
+		{ASN: 64496, SKI: "E2F075EC50E9F2EFCED506026BF6E4D1BD5A1A0F", Pubkey: "AQID"},
+		// Invalid. SKI too short
+		{ASN: 64496, SKI: "E2F075EC", Pubkey: "AQID"},
+		// Invalid. Public key not base64
+		{ASN: 64496, SKI: "E2F075EC50E9F2EFCED506026BF6E4D1BD5A1A0F", Pubkey: "!"},
+	})
+	want := rtr.BgpsecKey{ASN: 64496, Pubkey: []byte{1, 2, 3}}
+	copy(want.SKI[:], []byte{0xe2, 0xf0, 0x75, 0xec, 0x50, 0xe9, 0xf2, 0xef, 0xce, 0xd5, 0x06, 0x02, 0x6b, 0xf6, 0xe4, 0xd1, 0xbd, 0x5a, 0x1a, 0x0f})
+	assert.Equal(t, []rtr.BgpsecKey{want}, got)
+}
+
+func TestProcessASPAs(t *testing.T) {
+	got := processASPAs([]prefixfile.ASPAJson{
+		{CustomerASID: 64496, Providers: []uint32{64500}},
+		{CustomerASID: 0, Providers: []uint32{64500}},
+	})
+	assert.Equal(t, []rtr.ASPA{{CustomerASN: 64496, Providers: []uint32{64500}}}, got)
+}
+
 func TestNewSHA256(t *testing.T) {
 	want := "8eddd6897b244bb4d045ff811128b50b53ed85d19a9d1b756a0a400e82b23c2f"
 	got := fmt.Sprintf("%x", newSHA256([]byte("☘️")))
@@ -180,3 +298,229 @@ func TestNewSHA256(t *testing.T) {
 		t.Errorf("Got (%s), Wanted (%s)", got, want)
 	}
 }
+
+func TestChangeAgeCollector(t *testing.T) {
+	s := &state{
+		lockJson: &sync.RWMutex{},
+	}
+	c := newChangeAgeCollector(s)
+	if got := testutil.CollectAndCount(c); got != 0 {
+		t.Errorf("Wanted no metric before the first change, got %d", got)
+	}
+
+	s.lastchange = time.Now().Add(-time.Minute)
+	if got := testutil.CollectAndCount(c); got != 1 {
+		t.Fatalf("Wanted 1 metric, got %d", got)
+	}
+	if got := testutil.ToFloat64(c); got < 60 {
+		t.Errorf("Wanted an age of at least 60 seconds, got %v", got)
+	}
+}
+
+func TestMetricsEventTraffic(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	client := rtr.ClientFromConn(conn, nil, nil)
+	m := newMetricsEvent()
+
+	pdu := &rtr.PDUEndOfData{}
+	m.PDUSent(client, pdu, 24)
+	m.BytesReceived(client, 12)
+	m.PDUFlood(client, &rtr.PDUResetQuery{})
+	assert.Equal(t, 1.0, testutil.ToFloat64(PDUsSent.WithLabelValues("end_of_data", "plain://pipe")))
+	assert.Equal(t, 24.0, testutil.ToFloat64(BytesSent.WithLabelValues("plain://pipe")))
+	assert.Equal(t, 12.0, testutil.ToFloat64(BytesReceived.WithLabelValues("plain://pipe")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(PDUFloods.WithLabelValues("pipe", "plain://pipe")))
+}
+
+func TestMetricsEventBatch(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	client := rtr.ClientFromConn(conn, nil, nil)
+	m := newMetricsEvent()
+
+	before := testutil.ToFloat64(PDUsSent.WithLabelValues("ipv4_prefix", "plain://pipe"))
+	bytesBefore := testutil.ToFloat64(BytesSent.WithLabelValues("plain://pipe"))
+	batch := rtr.NewPDUBatch(rtr.PROTOCOL_VERSION_1, []rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496, Flags: rtr.FLAG_ADDED},
+		{Prefix: mustParsePrefix("198.51.100.0/24"), MaxLen: 24, ASN: 64496, Flags: rtr.FLAG_ADDED},
+	}, nil, nil)
+	m.PDUBatchSent(client, batch, len(batch.Bytes()))
+	assert.Equal(t, 2.0, testutil.ToFloat64(PDUsSent.WithLabelValues("ipv4_prefix", "plain://pipe"))-before)
+	assert.Equal(t, 40.0, testutil.ToFloat64(BytesSent.WithLabelValues("plain://pipe"))-bytesBefore)
+}
+
+func TestCheckBuildtime(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		Buildtime time.Time
+		Threshold time.Duration
+		Skew      time.Duration
+		Stale     bool
+	}{
+		{Buildtime: now.Add(-time.Hour), Threshold: 24 * time.Hour, Skew: 0, Stale: false},
+		{Buildtime: now.Add(-24*time.Hour - time.Minute), Threshold: 24 * time.Hour, Skew: 0, Stale: true},
+		{Buildtime: now.Add(-24*time.Hour - time.Minute), Threshold: 24 * time.Hour, Skew: 5 * time.Minute, Stale: false},
+		{Buildtime: now.Add(-24*time.Hour - 10*time.Minute), Threshold: 24 * time.Hour, Skew: 5 * time.Minute, Stale: true},
+		{Buildtime: now.Add(-2 * time.Hour), Threshold: time.Hour, Skew: 5 * time.Minute, Stale: true},
+		// In the future: accepted with a warning
+		{Buildtime: now.Add(time.Hour), Threshold: 24 * time.Hour, Skew: 5 * time.Minute, Stale: false},
+	}
+	for _, test := range tests {
+		err := checkBuildtime(test.Buildtime, now, test.Threshold, test.Skew)
+		assert.Equal(t, test.Stale, err != nil, "buildtime %v, skew %v", test.Buildtime, test.Skew)
+	}
+}
+
+func TestSessionIDFromSeed(t *testing.T) {
+	id, err := sessionIDFromSeed(-1, "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, -1, id)
+	id, err = sessionIDFromSeed(42, "", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 42, id)
+
+	// The same seed gives the same session ID, from the flag or the file
+	id, err = sessionIDFromSeed(-1, "rtr-pair-1", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, int(rtr.SessionIdFromSeed([]byte("rtr-pair-1\n"))), id)
+	file := filepath.Join(t.TempDir(), "machine-id")
+	if err := os.WriteFile(file, []byte("rtr-pair-1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fromFile, err := sessionIDFromSeed(-1, "", file, "")
+	assert.NoError(t, err)
+	assert.Equal(t, id, fromFile)
+	other, _ := sessionIDFromSeed(-1, "rtr-pair-2", "", "")
+	assert.NotEqual(t, id, other)
+	// A new epoch, once the serials are lost, gives another session ID
+	other, _ = sessionIDFromSeed(-1, "rtr-pair-1", "", "0123456789abcdef")
+	assert.NotEqual(t, id, other)
+
+	_, err = sessionIDFromSeed(42, "rtr-pair-1", "", "")
+	assert.Error(t, err)
+	_, err = sessionIDFromSeed(-1, "rtr-pair-1", file, "")
+	assert.Error(t, err)
+	_, err = sessionIDFromSeed(-1, "", filepath.Join(t.TempDir(), "missing"), "")
+	assert.Error(t, err)
+}
+
+func TestCheckEncryptedOnly(t *testing.T) {
+	assert.NotNil(t, checkEncryptedOnly(":8282", ":8283", ""))
+	assert.NotNil(t, checkEncryptedOnly("", "", ""))
+	assert.Nil(t, checkEncryptedOnly("", ":8283", ""))
+	assert.Nil(t, checkEncryptedOnly("", "", ":8284"))
+}
+
+func TestCheckPrefixLength(t *testing.T) {
+	tests := []struct {
+		Prefix    string
+		MaxLength uint8
+		Reason    string
+	}{
+		{"192.0.2.0/24", 0, INVALID_MAXLENGTH_TOO_SHORT},
+		{"192.0.2.0/24", 23, INVALID_MAXLENGTH_TOO_SHORT},
+		{"192.0.2.0/24", 24, ""},
+		{"192.0.2.0/24", 32, ""},
+		{"192.0.2.0/24", 33, INVALID_MAXLENGTH_TOO_LONG},
+		{"192.0.2.0/24", 128, INVALID_MAXLENGTH_TOO_LONG},
+		{"192.0.2.0/24", 129, INVALID_MAXLENGTH_TOO_LONG},
+		{"192.0.2.1/32", 32, ""},
+		{"192.0.2.1/32", 33, INVALID_MAXLENGTH_TOO_LONG},
+		{"0.0.0.0/0", 0, INVALID_PREFIX_LENGTH_ZERO},
+		{"0.0.0.0/0", 32, INVALID_PREFIX_LENGTH_ZERO},
+		// Out of the family, whatever the prefix length
+		{"0.0.0.0/0", 33, INVALID_MAXLENGTH_TOO_LONG},
+		{"2001:db8::/32", 0, INVALID_MAXLENGTH_TOO_SHORT},
+		{"2001:db8::/32", 32, ""},
+		{"2001:db8::/32", 33, ""},
+		{"2001:db8::/32", 128, ""},
+		{"2001:db8::/32", 129, INVALID_MAXLENGTH_TOO_LONG},
+		{"2001:db8::1/128", 128, ""},
+		{"2001:db8::1/128", 129, INVALID_MAXLENGTH_TOO_LONG},
+		{"::/0", 128, INVALID_PREFIX_LENGTH_ZERO},
+		{"::/0", 129, INVALID_MAXLENGTH_TOO_LONG},
+	}
+	for _, test := range tests {
+		prefix := mustParsePrefix(test.Prefix)
+		vrp := prefixfile.VRPJson{Prefix: test.Prefix, Length: test.MaxLength, ASN: uint32(64496)}
+		assert.Equal(t, test.Reason, checkPrefixLength(prefix, vrp, false), "%s maxLength %d", test.Prefix, test.MaxLength)
+	}
+
+	// Each reason is counted separately
+	vrps := []prefixfile.VRPJson{
+		{Prefix: "192.0.2.0/24", Length: 33, ASN: uint32(64496)},
+		{Prefix: "2001:db8::/32", Length: 129, ASN: uint32(64496)},
+		{Prefix: "192.0.2.0/24", Length: 16, ASN: uint32(64496)},
+		{Prefix: "0.0.0.0/0", Length: 33, ASN: uint32(64496)},
+	}
+	_, stats := processData(vrps, false)
+	assert.Equal(t, map[string]int{
+		INVALID_MAXLENGTH_TOO_LONG:  3,
+		INVALID_MAXLENGTH_TOO_SHORT: 1,
+	}, stats.Invalid)
+}
+
+func TestJitterInterval(t *testing.T) {
+	interval := 600 * time.Second
+	assert.Equal(t, interval, jitterInterval(interval, 0, 0.9))
+	assert.Equal(t, 540*time.Second, jitterInterval(interval, 0.1, 0))
+	assert.Equal(t, interval, jitterInterval(interval, 0.1, 0.5))
+	assert.Equal(t, 654*time.Second, jitterInterval(interval, 0.1, 0.95).Round(time.Second))
+	// At most ±100%
+	assert.Equal(t, time.Duration(0), jitterInterval(interval, 2, 0))
+}
+
+func TestBackoffInterval(t *testing.T) {
+	max := 30 * time.Minute
+	assert.Equal(t, 30*time.Second, backoffInterval(30*time.Second, 0, max))
+	assert.Equal(t, 30*time.Second, backoffInterval(30*time.Second, 1, max))
+	assert.Equal(t, time.Minute, backoffInterval(30*time.Second, 2, max))
+	assert.Equal(t, 16*time.Minute, backoffInterval(30*time.Second, 6, max))
+	assert.Equal(t, max, backoffInterval(30*time.Second, 7, max))
+	assert.Equal(t, max, backoffInterval(30*time.Second, 1000, max))
+	// Never shorter than the interval
+	assert.Equal(t, time.Hour, backoffInterval(time.Hour, 3, max))
+	assert.Equal(t, 10*time.Minute, backoffInterval(10*time.Minute, 5, 0))
+}
+
+func TestSlurmRetryInterval(t *testing.T) {
+	assert.Equal(t, 3600, slurmRetryInterval(3600, 600, false))
+	assert.Equal(t, 600, slurmRetryInterval(3600, 600, true))
+	assert.Equal(t, 60, slurmRetryInterval(60, 600, true))
+}
+
+func TestUpdateSlurms(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	site := write("site.json", `{"slurmVersion": 1, "validationOutputFilters": {"prefixFilters": [{"prefix": "192.0.2.0/24"}]}}`)
+	team := write("team.json", `{"slurmVersion": 1, "locallyAddedAssertions": {"prefixAssertions": [{"asn": 64496, "prefix": "198.51.100.0/24"}]}}`)
+	overlapping := write("overlapping.json", `{"slurmVersion": 1, "locallyAddedAssertions": {"prefixAssertions": [{"asn": 64496, "prefix": "192.0.2.128/25"}]}}`)
+	invalid := write("invalid.json", `{"slurmVersion": 1,`)
+
+	s := &state{
+		fetchConfig: utils.NewFetchConfig(),
+	}
+	updated, err := s.updateSlurms([]string{site, team})
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, []string{site, team}, s.slurmFiles)
+	assert.Len(t, s.slurm.ValidationOutputFilters.PrefixFilters, 1)
+	assert.Len(t, s.slurm.LocallyAddedAssertions.PrefixAssertions, 1)
+
+	// The files in use are kept
+	_, err = s.updateSlurms([]string{site, overlapping})
+	assert.Equal(t, SlurmOverlapError{Count: 1}, err)
+	_, err = s.updateSlurms([]string{site, invalid})
+	assert.Error(t, err)
+	assert.Equal(t, 0.0, testutil.ToFloat64(SlurmFailures.WithLabelValues(site)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(SlurmFailures.WithLabelValues(invalid)))
+	assert.Equal(t, []string{site, team}, s.slurmFiles)
+	assert.Equal(t, "198.51.100.0/24", s.slurm.LocallyAddedAssertions.PrefixAssertions[0].Prefix)
+}
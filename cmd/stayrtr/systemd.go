@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// systemdListenerNames returns the names of the sockets passed by systemd socket activation
+// (sd_listen_fds), starting at descriptor 3. The FileDescriptorName of a socket must be
+// the name of the flag of its address (e.g. bind or tls.bind).
+func systemdListenerNames(getenv func(string) string, pid int) ([]string, error) {
+	if getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", getenv("LISTEN_FDS"))
+	}
+	names := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+	if len(names) != count {
+		return nil, fmt.Errorf("LISTEN_FDNAMES has %d names for %d sockets", len(names), count)
+	}
+	return names, nil
+}
+
+// inheritSystemd adds the sockets passed by systemd to l.
+func inheritSystemd(l *listeners) error {
+	names, err := systemdListenerNames(os.Getenv, os.Getpid())
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil || len(names) == 0 {
+		return err
+	}
+	log.Infof("Activated by systemd with the sockets %v", strings.Join(names, ", "))
+	return l.Inherit(names)
+}
+
+// sdNotify sends a state (e.g. READY=1) to systemd, if started by it with Type=notify.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		// Abstract socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// systemdWatchdogInterval returns the interval of the systemd watchdog (WatchdogSec), or 0
+// if it is disabled.
+func systemdWatchdogInterval(getenv func(string) string, pid int) time.Duration {
+	if watchdogPid := getenv("WATCHDOG_PID"); watchdogPid != "" && watchdogPid != strconv.Itoa(pid) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// watchdogHealthy returns whether the refresh started at started (unix nanoseconds, 0 when
+// not refreshing) has not hung for longer than the interval.
+func watchdogHealthy(started int64, now time.Time, interval time.Duration) bool {
+	return started == 0 || now.Sub(time.Unix(0, started)) < interval
+}
+
+// routineWatchdog pings the systemd watchdog, unless a refresh hangs, so that systemd
+// restarts the daemon.
+func (s *state) routineWatchdog(interval time.Duration) {
+	log.Debugf("Starting systemd watchdog routine (interval: %v)", interval)
+	ticker := time.NewTicker(interval / 2)
+	for now := range ticker.C {
+		started := atomic.LoadInt64(&s.refreshStarted)
+		if !watchdogHealthy(started, now, interval) {
+			log.Warnf("Refresh started %v ago, not pinging the systemd watchdog", now.Sub(time.Unix(0, started)).Round(time.Second))
+			continue
+		}
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			log.Errorf("Error pinging the systemd watchdog: %v", err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSystemdListenerNames(t *testing.T) {
+	env := map[string]string{
+		"LISTEN_PID":     "1234",
+		"LISTEN_FDS":     "2",
+		"LISTEN_FDNAMES": "bind:tls.bind",
+	}
+	getenv := func(key string) string { return env[key] }
+
+	names, err := systemdListenerNames(getenv, 1234)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bind", "tls.bind"}, names)
+
+	// Passed to another process
+	names, err = systemdListenerNames(getenv, 5678)
+	assert.NoError(t, err)
+	assert.Nil(t, names)
+
+	env["LISTEN_FDNAMES"] = "bind"
+	_, err = systemdListenerNames(getenv, 1234)
+	assert.Error(t, err)
+	env["LISTEN_FDS"] = "none"
+	_, err = systemdListenerNames(getenv, 1234)
+	assert.Error(t, err)
+}
+
+func TestSdNotify(t *testing.T) {
+	defer os.Setenv("NOTIFY_SOCKET", os.Getenv("NOTIFY_SOCKET"))
+	os.Unsetenv("NOTIFY_SOCKET")
+	assert.NoError(t, sdNotify("READY=1"))
+
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", socket)
+
+	assert.NoError(t, sdNotify("READY=1"))
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+}
+
+func TestSystemdWatchdog(t *testing.T) {
+	env := map[string]string{"WATCHDOG_USEC": "30000000", "WATCHDOG_PID": "1234"}
+	getenv := func(key string) string { return env[key] }
+	assert.Equal(t, 30*time.Second, systemdWatchdogInterval(getenv, 1234))
+	assert.Equal(t, time.Duration(0), systemdWatchdogInterval(getenv, 5678))
+	delete(env, "WATCHDOG_PID")
+	assert.Equal(t, 30*time.Second, systemdWatchdogInterval(getenv, 5678))
+	delete(env, "WATCHDOG_USEC")
+	assert.Equal(t, time.Duration(0), systemdWatchdogInterval(getenv, 1234))
+
+	now := time.Now()
+	assert.True(t, watchdogHealthy(0, now, 30*time.Second))
+	assert.True(t, watchdogHealthy(now.Add(-10*time.Second).UnixNano(), now, 30*time.Second))
+	assert.False(t, watchdogHealthy(now.Add(-time.Minute).UnixNano(), now, 30*time.Second))
+}
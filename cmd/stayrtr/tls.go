@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// tlsCertificate is the certificate presented to the TLS clients. It can be reloaded,
+// the new certificate is used for the next handshakes.
+type tlsCertificate struct {
+	lock *sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newTLSCertificate() *tlsCertificate {
+	return &tlsCertificate{
+		lock: &sync.RWMutex{},
+	}
+}
+
+// Load reads the certificate and its key, the previous certificate is kept on error.
+func (c *tlsCertificate) Load(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.cert = &cert
+	c.lock.Unlock()
+	return nil
+}
+
+func (c *tlsCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.cert, nil
+}
+
+// setTLSClientAuth verifies the certificates of the clients against the CA certificates
+// of a file, and requires one if required is set.
+func setTLSClientAuth(config *tls.Config, caFile string, required bool) error {
+	if caFile == "" {
+		if required {
+			return errors.New("-tls.client.required is set but -tls.client.ca is not")
+		}
+		return nil
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificate found in %v", caFile)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if required {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return nil
+}
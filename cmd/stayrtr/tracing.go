@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/bgp/stayrtr/utils"
+	"go.opentelemetry.io/otel"
+	otelattribute "go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Until initTracing sets a provider exporting them, the spans are not recorded.
+var tracer = otel.Tracer("github.com/bgp/stayrtr")
+
+// initTracing exports the spans over OTLP/HTTP to endpoint (e.g. http://localhost:4318),
+// or to the one of the OTEL_EXPORTER_OTLP_ENDPOINT environment variables if blank. The
+// spans are not exported if neither is set.
+func initTracing(endpoint string) error {
+	var options []otlptracehttp.Option
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return err
+		}
+		switch u.Scheme {
+		case "http":
+			options = append(options, otlptracehttp.WithInsecure())
+		case "https":
+		default:
+			return fmt.Errorf("endpoint %q is not an http or https URL", endpoint)
+		}
+		options = append(options, otlptracehttp.WithEndpoint(u.Host))
+		if u.Path != "" && u.Path != "/" {
+			options = append(options, otlptracehttp.WithURLPath(u.Path))
+		}
+	} else if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return err
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String("stayrtr"),
+			semconv.ServiceVersionKey.String(version),
+		)),
+	))
+	return nil
+}
+
+// traceUpdate starts the span of an update (a refresh, a reload), parent of the spans
+// of its steps, and returns the function ending it. It is guarded by lockUpdate.
+func (s *state) traceUpdate(name string) func() {
+	ctx, span := tracer.Start(context.Background(), name)
+	s.traceCtx = ctx
+	return func() {
+		span.End()
+		s.traceCtx = nil
+	}
+}
+
+// traceStep starts the span of a step (fetch, decode, Slurm, diff...) of the update in progress.
+func (s *state) traceStep(name string, attrs ...otelattribute.KeyValue) trace.Span {
+	ctx := s.traceCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// endStep ends the span of a step, recording its error if any. A file which did not
+// change is not an error.
+func endStep(span trace.Span, err error) {
+	switch err.(type) {
+	case nil, utils.HttpNotModified, utils.IdenticalEtag, IdenticalFile:
+	default:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
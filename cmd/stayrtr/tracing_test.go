@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bgp/stayrtr/utils"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInitTracing(t *testing.T) {
+	assert.Error(t, initTracing("ftp://localhost:4318"))
+}
+
+func TestTraceUpdate(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	s := &state{}
+	endTrace := s.traceUpdate("refresh")
+	endStep(s.traceStep("fetch"), utils.HttpNotModified{File: "vrps.json"})
+	endStep(s.traceStep("decode"), errors.New("invalid"))
+	endTrace()
+	assert.Nil(t, s.traceCtx)
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 3) {
+		return
+	}
+	fetch, decode, refresh := spans[0], spans[1], spans[2]
+	assert.Equal(t, "refresh", refresh.Name())
+	assert.Equal(t, refresh.SpanContext().SpanID(), fetch.Parent().SpanID())
+	assert.Equal(t, refresh.SpanContext().SpanID(), decode.Parent().SpanID())
+	assert.Equal(t, codes.Unset, fetch.Status().Code)
+	assert.Equal(t, codes.Error, decode.Status().Code)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/bgp/stayrtr/prefixfile"
+)
+
+// Route origin validation states (RFC 6811)
+const (
+	VALIDATION_NOT_FOUND = iota
+	VALIDATION_VALID
+	VALIDATION_INVALID
+)
+
+var validationStateToString = map[int]string{
+	VALIDATION_NOT_FOUND: "not-found",
+	VALIDATION_VALID:     "valid",
+	VALIDATION_INVALID:   "invalid",
+}
+
+// validationResult is the state of a route along with the covering VRPs explaining it.
+type validationResult struct {
+	State int
+	// Covering VRPs matching the route
+	Matched []rtr.VRP
+	// Covering VRPs with another ASN
+	UnmatchedAS []rtr.VRP
+	// Covering VRPs with the same ASN and a shorter maxLength
+	UnmatchedLength []rtr.VRP
+}
+
+// covers returns whether the VRP prefix contains the route prefix.
+func covers(vrp netip.Prefix, prefix netip.Prefix) bool {
+	return vrp.Addr().BitLen() == prefix.Addr().BitLen() && vrp.Bits() <= prefix.Bits() && vrp.Contains(prefix.Addr())
+}
+
+// validateOrigin computes the validation state of a route as described in RFC 6811.
+func validateOrigin(vrps []rtr.VRP, prefix netip.Prefix, asn uint32) validationResult {
+	var res validationResult
+	prefixLen := prefix.Bits()
+	for _, vrp := range vrps {
+		if !covers(vrp.Prefix, prefix) {
+			continue
+		}
+		// AS0 VRPs (RFC 6483) can never match a route
+		if vrp.ASN != asn || vrp.ASN == 0 {
+			res.UnmatchedAS = append(res.UnmatchedAS, vrp)
+		} else if prefixLen > int(vrp.MaxLen) {
+			res.UnmatchedLength = append(res.UnmatchedLength, vrp)
+		} else {
+			res.Matched = append(res.Matched, vrp)
+		}
+	}
+
+	switch {
+	case len(res.Matched) > 0:
+		res.State = VALIDATION_VALID
+	case len(res.UnmatchedAS) > 0 || len(res.UnmatchedLength) > 0:
+		res.State = VALIDATION_INVALID
+	default:
+		res.State = VALIDATION_NOT_FOUND
+	}
+	return res
+}
+
+// validateVRP is a VRP along with where it comes from.
+type validateVRP struct {
+	Prefix    string `json:"prefix"`
+	MaxLength uint8  `json:"maxLength"`
+	ASN       uint32 `json:"asn"`
+	TA        string `json:"ta,omitempty"`
+	// Path of the cache or Slurm file
+	Source string `json:"source,omitempty"`
+}
+
+type validateResponse struct {
+	Prefix          string        `json:"prefix"`
+	ASN             uint32        `json:"asn"`
+	State           string        `json:"state"`
+	Matched         []validateVRP `json:"matched"`
+	UnmatchedAS     []validateVRP `json:"unmatchedAs"`
+	UnmatchedLength []validateVRP `json:"unmatchedLength"`
+}
+
+// attributedVRPs returns the unique valid VRPs of a list, along with the entries of the
+// list each one comes from (the same VRP can be issued under several trust anchors).
+func attributedVRPs(vrpsjson []prefixfile.VRPJson, strict bool) ([]rtr.VRP, map[rtr.VRP][]validateVRP) {
+	vrps := make([]rtr.VRP, 0, len(vrpsjson))
+	attributions := make(map[rtr.VRP][]validateVRP, len(vrpsjson))
+	for _, v := range vrpsjson {
+		parsed, err := v.GetNetipPrefix()
+		if err != nil {
+			continue
+		}
+		prefix := parsed.Masked()
+		asn, err := v.GetASN2()
+		if err != nil {
+			continue
+		}
+		if checkPrefixLength(prefix, v, strict) != "" {
+			continue
+		}
+		vrp := rtr.VRP{
+			Prefix: prefix,
+			MaxLen: v.Length,
+			ASN:    asn,
+		}
+		if _, ok := attributions[vrp]; !ok {
+			vrps = append(vrps, vrp)
+		}
+		attributions[vrp] = append(attributions[vrp], validateVRP{
+			Prefix:    prefix.String(),
+			MaxLength: v.Length,
+			ASN:       asn,
+			TA:        v.TA,
+			Source:    v.Source,
+		})
+	}
+	return vrps, attributions
+}
+
+// validateIndex is the unique VRPs served and the entries they come from, built once per
+// update rather than on every query of the validate path.
+type validateIndex struct {
+	vrps         []rtr.VRP
+	attributions map[rtr.VRP][]validateVRP
+}
+
+func newValidateIndex(vrpsjson []prefixfile.VRPJson, strict bool) *validateIndex {
+	vrps, attributions := attributedVRPs(vrpsjson, strict)
+	return &validateIndex{vrps: vrps, attributions: attributions}
+}
+
+func attribute(vrps []rtr.VRP, attributions map[rtr.VRP][]validateVRP) []validateVRP {
+	res := make([]validateVRP, 0, len(vrps))
+	for _, vrp := range vrps {
+		res = append(res, attributions[vrp]...)
+	}
+	return res
+}
+
+// validate returns the validation state of the prefix and asn query parameters,
+// with the trust anchor and source of the VRPs explaining it.
+func (s *state) validate(wr http.ResponseWriter, r *http.Request) {
+	prefix, err := netip.ParsePrefix(r.URL.Query().Get("prefix"))
+	if err != nil {
+		http.Error(wr, fmt.Sprintf("Invalid prefix: %v", err), http.StatusBadRequest)
+		return
+	}
+	prefix = prefix.Masked()
+	asnParam := prefixfile.VRPJson{ASN: r.URL.Query().Get("asn")}
+	asn, err := asnParam.GetASN2()
+	if err != nil {
+		http.Error(wr, fmt.Sprintf("Invalid asn: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	s.lockJson.RLock()
+	index := s.validateIndex
+	s.lockJson.RUnlock()
+	if index == nil {
+		// No data served yet
+		index = &validateIndex{}
+	}
+	res := validateOrigin(index.vrps, prefix, asn)
+
+	wr.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(wr)
+	enc.Encode(validateResponse{
+		Prefix:          prefix.String(),
+		ASN:             asn,
+		State:           validationStateToString[res.State],
+		Matched:         attribute(res.Matched, index.attributions),
+		UnmatchedAS:     attribute(res.UnmatchedAS, index.attributions),
+		UnmatchedLength: attribute(res.UnmatchedLength, index.attributions),
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateHandler(t *testing.T) {
+	s := &state{
+		lockJson: &sync.RWMutex{},
+		validateIndex: newValidateIndex([]prefixfile.VRPJson{
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496), TA: "ripe", Source: "https://a.example/vrps.json"},
+			// Same VRP from another trust anchor
+			{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496), TA: "arin", Source: "https://a.example/vrps.json"},
+			{Prefix: "192.0.2.0/23", Length: 23, ASN: uint32(64497), TA: "Local", Source: "slurm.json"},
+			{Prefix: "198.51.100.0/24", Length: 24, ASN: uint32(64496), TA: "ripe"},
+		}, false),
+	}
+
+	tests := []struct {
+		Query           string
+		State           string
+		Matched         []validateVRP
+		UnmatchedAS     []validateVRP
+		UnmatchedLength []validateVRP
+	}{
+		{
+			Query: "prefix=192.0.2.0/24&asn=AS64496",
+			State: "valid",
+			Matched: []validateVRP{
+				{Prefix: "192.0.2.0/24", MaxLength: 24, ASN: 64496, TA: "ripe", Source: "https://a.example/vrps.json"},
+				{Prefix: "192.0.2.0/24", MaxLength: 24, ASN: 64496, TA: "arin", Source: "https://a.example/vrps.json"},
+			},
+			UnmatchedAS: []validateVRP{
+				{Prefix: "192.0.2.0/23", MaxLength: 23, ASN: 64497, TA: "Local", Source: "slurm.json"},
+			},
+			UnmatchedLength: []validateVRP{},
+		},
+		{
+			Query:   "prefix=192.0.2.128/25&asn=64496",
+			State:   "invalid",
+			Matched: []validateVRP{},
+			UnmatchedAS: []validateVRP{
+				{Prefix: "192.0.2.0/23", MaxLength: 23, ASN: 64497, TA: "Local", Source: "slurm.json"},
+			},
+			UnmatchedLength: []validateVRP{
+				{Prefix: "192.0.2.0/24", MaxLength: 24, ASN: 64496, TA: "ripe", Source: "https://a.example/vrps.json"},
+				{Prefix: "192.0.2.0/24", MaxLength: 24, ASN: 64496, TA: "arin", Source: "https://a.example/vrps.json"},
+			},
+		},
+		{
+			Query:           "prefix=203.0.113.0/24&asn=64496",
+			State:           "not-found",
+			Matched:         []validateVRP{},
+			UnmatchedAS:     []validateVRP{},
+			UnmatchedLength: []validateVRP{},
+		},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/validate?"+test.Query, nil)
+		rec := httptest.NewRecorder()
+		s.validate(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code, test.Query)
+
+		var got validateResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+		assert.Equal(t, test.State, got.State, test.Query)
+		assert.Equal(t, test.Matched, got.Matched, test.Query)
+		assert.Equal(t, test.UnmatchedAS, got.UnmatchedAS, test.Query)
+		assert.Equal(t, test.UnmatchedLength, got.UnmatchedLength, test.Query)
+	}
+
+	for _, query := range []string{"prefix=192.0.2.1&asn=64496", "prefix=192.0.2.0/24&asn=ASX"} {
+		req := httptest.NewRequest("GET", "/validate?"+query, nil)
+		rec := httptest.NewRecorder()
+		s.validate(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, query)
+	}
+}
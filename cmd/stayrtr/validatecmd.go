@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bgp/stayrtr/prefixfile"
+)
+
+// validationReport collects the problems found by the validate subcommand.
+type validationReport struct {
+	w        io.Writer
+	errors   int
+	warnings int
+}
+
+func (r *validationReport) errorf(file string, format string, a ...interface{}) {
+	r.errors++
+	fmt.Fprintf(r.w, "error: %s: %s\n", file, fmt.Sprintf(format, a...))
+}
+
+func (r *validationReport) warnf(file string, format string, a ...interface{}) {
+	r.warnings++
+	fmt.Fprintf(r.w, "warning: %s: %s\n", file, fmt.Sprintf(format, a...))
+}
+
+// checkCache decodes a cache file and checks its VRPs, router keys and ASPAs as they
+// would be loaded. It returns the decoded file, or nil if it cannot be decoded.
+func (r *validationReport) checkCache(file string, data []byte, format int, strict bool) *prefixfile.VRPList {
+	vrplist, err := decodeCache(data, format, "")
+	if err != nil {
+		r.errorf(file, "cannot decode: %v", err)
+		return nil
+	}
+	if vrplist.Metadata.Buildtime != "" {
+		if _, err := time.Parse(time.RFC3339, vrplist.Metadata.Buildtime); err != nil {
+			r.warnf(file, "invalid buildtime %q", vrplist.Metadata.Buildtime)
+		}
+	}
+
+	seen := make(map[string]int, len(vrplist.Data))
+	for i, v := range vrplist.Data {
+		parsed, err := v.GetNetipPrefix()
+		if err != nil {
+			r.errorf(file, "VRP %d: %v", i, err)
+			continue
+		}
+		prefix := parsed.Masked()
+		if parsed != prefix {
+			if strict {
+				r.errorf(file, "VRP %d: prefix %s is not canonical (%s)", i, v.Prefix, prefix)
+				continue
+			}
+			r.warnf(file, "VRP %d: prefix %s is not canonical (%s)", i, v.Prefix, prefix)
+		}
+		asn, err := v.GetASN2()
+		if err != nil {
+			r.errorf(file, "VRP %d: %v", i, err)
+			continue
+		}
+		if _, err := prefixLengthError(prefix, v, strict); err != nil {
+			r.errorf(file, "VRP %d: %v", i, err)
+			continue
+		}
+		key := fmt.Sprintf("%s-%d AS%d", prefix, v.Length, asn)
+		if first, ok := seen[key]; ok {
+			r.warnf(file, "VRP %d: %s is a duplicate of VRP %d", i, key, first)
+			continue
+		}
+		seen[key] = i
+	}
+
+	seenKeys := make(map[string]int, len(vrplist.BgpsecKeys))
+	for i, v := range vrplist.BgpsecKeys {
+		if ski, err := hex.DecodeString(v.SKI); err != nil || len(ski) != 20 {
+			r.errorf(file, "router key %d: AS%d has an invalid SKI %q", i, v.ASN, v.SKI)
+			continue
+		}
+		if pubkey, err := base64.StdEncoding.DecodeString(v.Pubkey); err != nil || len(pubkey) == 0 {
+			r.errorf(file, "router key %d: AS%d has an invalid public key", i, v.ASN)
+			continue
+		}
+		key := describeBgpsecKey(v)
+		if first, ok := seenKeys[key+v.Pubkey]; ok {
+			r.warnf(file, "router key %d: %s is a duplicate of router key %d", i, key, first)
+			continue
+		}
+		seenKeys[key+v.Pubkey] = i
+	}
+
+	customers := make(map[uint32]int, len(vrplist.ASPA))
+	for i, v := range vrplist.ASPA {
+		if v.CustomerASID == 0 {
+			r.errorf(file, "ASPA %d: AS0 cannot be a customer", i)
+			continue
+		}
+		if first, ok := customers[v.CustomerASID]; ok {
+			r.errorf(file, "ASPA %d: AS%d already has ASPA %d", i, v.CustomerASID, first)
+			continue
+		}
+		customers[v.CustomerASID] = i
+		for _, provider := range v.Providers {
+			if provider == v.CustomerASID {
+				r.warnf(file, "ASPA %d: AS%d is its own provider", i, provider)
+			}
+		}
+	}
+
+	fmt.Fprintf(r.w, "%s: %d VRPs, %d router keys, %d ASPAs\n", file, len(vrplist.Data), len(vrplist.BgpsecKeys), len(vrplist.ASPA))
+	return vrplist
+}
+
+// checkSlurm decodes a Slurm file and checks it as with -slurm.required, along with the
+// conflicts between its filters and its assertions. It returns nil if it cannot be used.
+func (r *validationReport) checkSlurm(file string, data []byte) *prefixfile.SlurmConfig {
+	slurm, err := prefixfile.DecodeJSONSlurm(bytes.NewReader(data))
+	if err != nil {
+		r.errorf(file, "cannot decode: %v", err)
+		return nil
+	}
+	if err := slurm.Validate(); err != nil {
+		r.errorf(file, "%v", err)
+		return nil
+	}
+	for _, conflict := range slurm.FindConflicts() {
+		r.errorf(file, "conflict: %v", conflict)
+	}
+	fmt.Fprintf(r.w, "%s: %d prefix filters, %d prefix assertions, %d BGPsec filters, %d BGPsec assertions, %d ASPA filters, %d ASPA assertions\n", file,
+		len(slurm.ValidationOutputFilters.PrefixFilters), len(slurm.LocallyAddedAssertions.PrefixAssertions),
+		len(slurm.ValidationOutputFilters.BgpsecFilters), len(slurm.LocallyAddedAssertions.BgpsecAssertions),
+		len(slurm.ValidationOutputFilters.ASPAFilters), len(slurm.LocallyAddedAssertions.ASPAAssertions))
+	return slurm
+}
+
+// validateFiles checks cache and Slurm files without starting the server. It prints the
+// problems found and returns an error if any of them would prevent the files from being used.
+func validateFiles(w io.Writer, caches []string, slurms []string, format int, strict bool) error {
+	report := &validationReport{w: w}
+
+	for _, file := range caches {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			report.errorf(file, "%v", err)
+			continue
+		}
+		report.checkCache(file, data, format, strict)
+	}
+
+	configs := make([]*prefixfile.SlurmConfig, 0, len(slurms))
+	files := make([]string, 0, len(slurms))
+	for _, file := range slurms {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			report.errorf(file, "%v", err)
+			continue
+		}
+		if slurm := report.checkSlurm(file, data); slurm != nil {
+			configs = append(configs, slurm)
+			files = append(files, file)
+		}
+	}
+	// The Slurm files are merged: their entries must not overlap
+	for _, overlap := range prefixfile.FindOverlaps(configs) {
+		report.errorf(files[overlap.File], "overlap with %v: %v", files[overlap.OtherFile], overlap)
+	}
+
+	fmt.Fprintf(w, "%d errors, %d warnings\n", report.errors, report.warnings)
+	if report.errors > 0 {
+		return fmt.Errorf("validation failed with %d errors", report.errors)
+	}
+	return nil
+}
+
+// runValidate is the validate subcommand: stayrtr validate -cache file.json -slurm slurm.json
+func runValidate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	caches := &stringList{}
+	fs.Var(caches, "cache", "Cache file to check (JSON or CSV), repeated or comma-separated")
+	slurms := &stringList{}
+	fs.Var(slurms, "slurm", "Slurm file to check, repeated or comma-separated to check that they can be merged")
+	format := fs.String("cache.format", "auto", "Format of the cache files: auto, json or csv")
+	strict := fs.Bool("vrp.strict", false, "Reject non-canonical prefixes and a maxLength explicitly set to the prefix length (RFC 6482)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s validate [-cache file] [-slurm file]\n\nChecks cache and Slurm files without starting the server, exits with an error code if they cannot be used.\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 || (len(caches.Values()) == 0 && len(slurms.Values()) == 0) {
+		fs.Usage()
+		os.Exit(2)
+	}
+	cacheFormat, ok := cacheFormatToId[*format]
+	if !ok {
+		return fmt.Errorf("cache.format: unknown format %q", *format)
+	}
+	return validateFiles(os.Stdout, caches.Values(), slurms.Values(), cacheFormat, *strict)
+}
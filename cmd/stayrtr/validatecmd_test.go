@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidationReportCheckCache(t *testing.T) {
+	data := []byte(`{"roas":[
+		{"prefix":"192.0.2.1/24","maxLength":24,"asn":"AS64496"},
+		{"prefix":"192.0.2.0/24","maxLength":33,"asn":64496},
+		{"prefix":"198.51.100.0/24","maxLength":24,"asn":"x"},
+		{"prefix":"203.0.113.0/24","maxLength":24,"asn":64497},
+		{"prefix":"203.0.113.0/24","maxLength":24,"asn":64497}
+	],
+	"aspas":[{"customer_asid":64498,"providers":[64499]},{"customer_asid":64498,"providers":[64500]}]}`)
+
+	var out bytes.Buffer
+	report := &validationReport{w: &out}
+	vrplist := report.checkCache("vrps.json", data, CACHE_FORMAT_AUTO, false)
+	assert.NotNil(t, vrplist)
+	assert.Equal(t, 3, report.errors)
+	assert.Equal(t, 2, report.warnings)
+	assert.Equal(t, `warning: vrps.json: VRP 0: prefix 192.0.2.1/24 is not canonical (192.0.2.0/24)
+error: vrps.json: VRP 1: 192.0.2.0/24 Maxlength wrong: 24 - 33 (longer than 32 for IPv4)
+error: vrps.json: VRP 2: Could not decode ASN string: x
+warning: vrps.json: VRP 4: 203.0.113.0/24-24 AS64497 is a duplicate of VRP 3
+error: vrps.json: ASPA 1: AS64498 already has ASPA 0
+vrps.json: 5 VRPs, 0 router keys, 2 ASPAs
+`, out.String())
+
+	// Non-canonical prefixes and a maxLength set to the prefix length are rejected when strict
+	report = &validationReport{w: &out}
+	report.checkCache("vrps.json", data, CACHE_FORMAT_AUTO, true)
+	assert.Equal(t, 6, report.errors)
+
+	report = &validationReport{w: &out}
+	assert.Nil(t, report.checkCache("vrps.json", []byte(`{"roas":[`), CACHE_FORMAT_JSON, false))
+	assert.Equal(t, 1, report.errors)
+}
+
+func TestValidateFiles(t *testing.T) {
+	dir := t.TempDir()
+	overlapping := filepath.Join(dir, "overlapping.json")
+	assert.NoError(t, ioutil.WriteFile(overlapping, []byte(`{
+		"slurmVersion": 1,
+		"validationOutputFilters": {"prefixFilters": [{"prefix": "10.0.0.0/16"}]},
+		"locallyAddedAssertions": {}
+	}`), 0644))
+
+	var out bytes.Buffer
+	assert.NoError(t, validateFiles(&out, []string{"smalltest.rpki.json"}, []string{"test.slurm.json"}, CACHE_FORMAT_AUTO, false))
+	assert.Contains(t, out.String(), "0 errors, 0 warnings\n")
+
+	out.Reset()
+	err := validateFiles(&out, nil, []string{"test.slurm.json", overlapping}, CACHE_FORMAT_AUTO, false)
+	assert.EqualError(t, err, "validation failed with 1 errors")
+	assert.Contains(t, out.String(), "error: test.slurm.json: overlap with "+overlapping)
+
+	out.Reset()
+	err = validateFiles(&out, []string{filepath.Join(dir, "missing.json")}, nil, CACHE_FORMAT_AUTO, false)
+	assert.Error(t, err)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/bgp/stayrtr/prefixfile"
+	"github.com/bgp/stayrtr/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+type viewConfig struct {
+	Name    string   `json:"name"`
+	Sources []string `json:"sources"`
+	Slurm   string   `json:"slurm"`
+}
+
+type viewsConfig struct {
+	Views []viewConfig `json:"views"`
+}
+
+// A vrpView serves the VRPs, filtered by its own Slurm file, to the clients connecting
+// from its source prefixes.
+type vrpView struct {
+	name    string
+	sources []*net.IPNet
+	server  *rtr.Server
+
+	slurmFile string
+	slurm     *prefixfile.SlurmConfig
+	loaded    bool
+}
+
+type vrpViews []*vrpView
+
+// decodeViews reads the mapping of the source prefixes to the views.
+func decodeViews(data []byte) (*viewsConfig, error) {
+	var config viewsConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	sources := make(map[string]string)
+	for _, view := range config.Views {
+		if view.Name == "" || view.Name == "default" {
+			return nil, fmt.Errorf("invalid view name %q", view.Name)
+		}
+		if names[view.Name] {
+			return nil, fmt.Errorf("duplicate view %v", view.Name)
+		}
+		names[view.Name] = true
+		for _, source := range view.Sources {
+			_, prefix, err := net.ParseCIDR(source)
+			if err != nil {
+				return nil, fmt.Errorf("view %v: %v", view.Name, err)
+			}
+			if other, ok := sources[prefix.String()]; ok {
+				return nil, fmt.Errorf("view %v: %v is already mapped to view %v", view.Name, prefix, other)
+			}
+			sources[prefix.String()] = view.Name
+		}
+	}
+	return &config, nil
+}
+
+func loadViews(file string, newServer func() *rtr.Server) (vrpViews, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	config, err := decodeViews(data)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+
+	views := make(vrpViews, len(config.Views))
+	for i, vc := range config.Views {
+		view := &vrpView{
+			name:      vc.Name,
+			slurmFile: vc.Slurm,
+			server:    newServer(),
+		}
+		for _, source := range vc.Sources {
+			_, prefix, _ := net.ParseCIDR(source)
+			view.sources = append(view.sources, prefix)
+		}
+		views[i] = view
+	}
+	return views, nil
+}
+
+// addrIP returns the IP of a client address, in its 4-byte form for IPv4, or nil.
+func addrIP(addr net.Addr) net.IP {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return nil
+		}
+		ip = net.ParseIP(host)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return ip
+}
+
+// selectView returns the view with the longest source prefix containing the address,
+// or nil if no view matches.
+func (v vrpViews) selectView(addr net.Addr) *vrpView {
+	ip := addrIP(addr)
+	if ip == nil {
+		return nil
+	}
+
+	var selected *vrpView
+	selectedLen := -1
+	for _, view := range v {
+		for _, source := range view.sources {
+			sourceLen, bits := source.Mask.Size()
+			if bits != len(ip)*8 || !source.Contains(ip) {
+				continue
+			}
+			if sourceLen > selectedLen {
+				selected = view
+				selectedLen = sourceLen
+			}
+		}
+	}
+	return selected
+}
+
+// selector returns the view server handling a client, nil for the default view.
+func (v vrpViews) selector(addr net.Addr) *rtr.Server {
+	if view := v.selectView(addr); view != nil {
+		return view.server
+	}
+	return nil
+}
+
+// updateViewsSlurm refreshes the Slurm files of the views and returns whether one changed.
+// A view keeps its previous Slurm file when the new one cannot be loaded.
+func (s *state) updateViewsSlurm() bool {
+	var updated bool
+	for _, view := range s.views {
+		if view.slurmFile == "" {
+			continue
+		}
+		slurm, err := s.loadSlurm(view.slurmFile)
+		if err != nil {
+			switch err.(type) {
+			case utils.HttpNotModified:
+				log.Info(err)
+			case utils.IdenticalEtag:
+				log.Info(err)
+			default:
+				log.Errorf("Slurm of view %v: %v", view.name, err)
+			}
+			continue
+		}
+		view.slurm = slurm
+		updated = true
+	}
+	return updated
+}
+
+// updateViews sends the VRPs, the router keys and the ASPAs, after the default filtering, to the views.
+func (s *state) updateViews(vrpsjson []prefixfile.VRPJson, keysjson []prefixfile.BgpsecKeyJson, aspasjson []prefixfile.ASPAJson) {
+	for _, view := range s.views {
+		viewjson := vrpsjson
+		viewkeys := keysjson
+		viewaspas := aspasjson
+		var filtered, assertedCount int
+		if view.slurm != nil {
+			kept, removed := view.slurm.FilterOnVRPs(vrpsjson)
+			asserted := view.slurm.AssertVRPs()
+			log.Infof("Slurm filtering of view %v: %v kept, %v removed, %v asserted", view.name, len(kept), len(removed), len(asserted))
+			viewjson = make([]prefixfile.VRPJson, 0, len(kept)+len(asserted))
+			viewjson = append(append(viewjson, kept...), asserted...)
+			viewkeys = view.slurm.FilterAssertBgpsecKeys(keysjson)
+			viewaspas = view.slurm.FilterAssertASPAs(aspasjson)
+			filtered, assertedCount = len(removed), len(asserted)
+		}
+		SlurmVRPs.WithLabelValues(view.name, "filtered").Set(float64(filtered))
+		SlurmVRPs.WithLabelValues(view.name, "asserted").Set(float64(assertedCount))
+
+		vrps, _ := processData(viewjson, s.strict)
+		view.server.AddData(vrps, processBgpsecKeys(viewkeys), processASPAs(viewaspas))
+
+		serial, _ := view.server.GetCurrentSerial(view.server.GetSessionId())
+		log.Infof("View %v updated (%v uniques), new serial %v", view.name, len(vrps), serial)
+		if s.sendNotifs && (s.initialNotifs || view.loaded) {
+			view.server.NotifyClientsLatest()
+		} else {
+			view.server.NotifySubscribers(serial)
+		}
+		view.loaded = true
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeViews(t *testing.T) {
+	invalid := map[string]string{
+		"no name":   `{"views": [{"sources": ["192.0.2.0/24"]}]}`,
+		"default":   `{"views": [{"name": "default", "sources": ["192.0.2.0/24"]}]}`,
+		"duplicate": `{"views": [{"name": "a"}, {"name": "a"}]}`,
+		"source":    `{"views": [{"name": "a", "sources": ["192.0.2.0"]}]}`,
+		"overlap":   `{"views": [{"name": "a", "sources": ["192.0.2.0/24"]}, {"name": "b", "sources": ["192.0.2.1/24"]}]}`,
+	}
+	for name, data := range invalid {
+		_, err := decodeViews([]byte(data))
+		assert.NotNil(t, err, name)
+	}
+
+	config, err := decodeViews([]byte(`{"views": [{"name": "a", "sources": ["192.0.2.0/24", "2001:db8::/32"], "slurm": "a.json"}]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []viewConfig{{Name: "a", Sources: []string{"192.0.2.0/24", "2001:db8::/32"}, Slurm: "a.json"}}, config.Views)
+}
+
+func TestSelectView(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "views.json")
+	data := `{"views": [
+		{"name": "customers", "sources": ["192.0.2.0/24", "2001:db8::/32"]},
+		{"name": "special", "sources": ["192.0.2.128/25"]}
+	]}`
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	views, err := loadViews(file, func() *rtr.Server {
+		return rtr.NewServer(rtr.ServerConfiguration{}, nil, nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"192.0.2.1":        "customers",
+		"192.0.2.129":      "special",
+		"::ffff:192.0.2.1": "customers",
+		"2001:db8::1":      "customers",
+		"198.51.100.1":     "",
+		"2001:db9::1":      "",
+	}
+	for addr, expected := range tests {
+		view := views.selectView(&net.TCPAddr{IP: net.ParseIP(addr), Port: 323})
+		if expected == "" {
+			assert.Nil(t, view, addr)
+			assert.Nil(t, views.selector(&net.TCPAddr{IP: net.ParseIP(addr), Port: 323}), addr)
+		} else if assert.NotNil(t, view, addr) {
+			assert.Equal(t, expected, view.name, addr)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+)
+
+// Time without changes to a watched file before the update is triggered, as a file
+// is often written in several steps.
+const watchDelay = 500 * time.Millisecond
+
+// isLocalFile returns whether a cache or Slurm path is a file rather than a URL or an
+// RTR server.
+func isLocalFile(path string) bool {
+	return path != "" && !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") && !isRTRCache(path)
+}
+
+// fileWatcher calls trigger when one of the files changes. The directories of the files
+// are watched rather than the files, so that a file replaced by a rename is still seen.
+type fileWatcher struct {
+	watcher *fsnotify.Watcher
+	trigger func()
+	delay   time.Duration
+
+	lock  *sync.Mutex
+	files map[string]bool
+	dirs  map[string]bool
+}
+
+func newFileWatcher(trigger func(), delay time.Duration) (*fileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &fileWatcher{
+		watcher: watcher,
+		trigger: trigger,
+		delay:   delay,
+		lock:    &sync.Mutex{},
+		files:   make(map[string]bool),
+		dirs:    make(map[string]bool),
+	}
+	go w.routine()
+	return w, nil
+}
+
+// Set replaces the files watched, the URLs are ignored.
+func (w *fileWatcher) Set(paths []string) error {
+	files := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, path := range paths {
+		if !isLocalFile(path) {
+			continue
+		}
+		file, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		files[file] = true
+		dirs[filepath.Dir(file)] = true
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for dir := range dirs {
+		if w.dirs[dir] {
+			continue
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			return err
+		}
+		log.Debugf("Watching %v", dir)
+	}
+	for dir := range w.dirs {
+		if !dirs[dir] {
+			w.watcher.Remove(dir)
+		}
+	}
+	w.files = files
+	w.dirs = dirs
+	return nil
+}
+
+func (w *fileWatcher) watched(file string) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.files[filepath.Clean(file)]
+}
+
+func (w *fileWatcher) routine() {
+	var timer *time.Timer
+	var fire <-chan time.Time
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod || !w.watched(event.Name) {
+				continue
+			}
+			log.Debugf("%v changed (%v)", event.Name, event.Op)
+			if timer == nil {
+				timer = time.NewTimer(w.delay)
+			} else {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(w.delay)
+			}
+			fire = timer.C
+		case <-fire:
+			fire = nil
+			log.Info("Watched files changed, updating")
+			w.trigger()
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("Error watching files: %v", err)
+		}
+	}
+}
+
+func (w *fileWatcher) Close() error {
+	return w.watcher.Close()
+}
+
+// watchFiles watches the local cache and Slurm files, if enabled.
+func (s *state) watchFiles() {
+	if s.watcher == nil {
+		return
+	}
+	paths := append(append([]string{}, s.slurmPaths...), s.caches...)
+	if err := s.watcher.Set(paths); err != nil {
+		log.Errorf("Error watching files: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileWatcher(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "vrps.json")
+	other := filepath.Join(dir, "other.json")
+	if err := os.WriteFile(file, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	triggers := make(chan struct{}, 10)
+	w, err := newFileWatcher(func() { triggers <- struct{}{} }, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	assert.NoError(t, w.Set([]string{"https://example.com/vrps.json", file}))
+	assert.Len(t, w.dirs, 1)
+
+	expect := func(triggered bool, msg string) {
+		select {
+		case <-triggers:
+			assert.True(t, triggered, msg)
+		case <-time.After(500 * time.Millisecond):
+			assert.False(t, triggered, msg)
+		}
+	}
+
+	// Several writes trigger a single update
+	os.WriteFile(file, []byte(`{"roas": []}`), 0644)
+	os.WriteFile(file, []byte(`{"roas": [], "metadata": {}}`), 0644)
+	expect(true, "written")
+	expect(false, "written once")
+
+	// Replaced by a rename
+	os.WriteFile(other, []byte("{}"), 0644)
+	expect(false, "other file")
+	os.Rename(other, file)
+	expect(true, "renamed")
+
+	assert.NoError(t, w.Set(nil))
+	assert.Empty(t, w.dirs)
+	os.WriteFile(file, []byte("{}"), 0644)
+	expect(false, "not watched")
+
+	assert.False(t, isLocalFile(""))
+	assert.False(t, isLocalFile("http://example.com/vrps.json"))
+	assert.True(t, isLocalFile("vrps.json"))
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Events posted to the webhooks.
+const (
+	WEBHOOK_UPDATE       = "update"
+	WEBHOOK_FETCH_FAILED = "fetch_failed"
+	WEBHOOK_STALE        = "stale"
+	WEBHOOK_CHURN        = "churn"
+)
+
+var webhookEvents = []string{WEBHOOK_UPDATE, WEBHOOK_FETCH_FAILED, WEBHOOK_STALE, WEBHOOK_CHURN}
+
+// Events waiting to be posted, the next ones are dropped when the webhooks do not answer.
+const webhookQueueSize = 64
+
+// webhookEvent is the JSON body posted to the webhooks.
+type webhookEvent struct {
+	Event  string `json:"event"`
+	Time   string `json:"time"`
+	Source string `json:"source,omitempty"`
+	// New serial and objects served (update and churn)
+	Serial     uint32 `json:"serial,omitempty"`
+	VRPs       int    `json:"vrps,omitempty"`
+	RouterKeys int    `json:"router_keys,omitempty"`
+	ASPAs      int    `json:"aspas,omitempty"`
+	// VRPs announced and withdrawn by the serial, and their percentage of the VRPs served
+	// before (churn)
+	Changes int     `json:"changes,omitempty"`
+	Churn   float64 `json:"churn,omitempty"`
+	// Why the cache could not be fetched or is stale, and the consecutive failures (fetch_failed)
+	Error    string `json:"error,omitempty"`
+	Failures int    `json:"failures,omitempty"`
+}
+
+// webhooks posts the events to URLs in the background, so that a slow webhook does not
+// delay the updates.
+type webhooks struct {
+	urls      []string
+	events    map[string]bool
+	userAgent string
+	client    *http.Client
+	queue     chan webhookEvent
+}
+
+// parseWebhookEvents returns the events of a comma-separated list, all of them if empty.
+func parseWebhookEvents(list string) (map[string]bool, error) {
+	events := make(map[string]bool)
+	for _, event := range strings.Split(list, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			events[event] = true
+		}
+	}
+	if len(events) == 0 {
+		for _, event := range webhookEvents {
+			events[event] = true
+		}
+		return events, nil
+	}
+	for event := range events {
+		known := false
+		for _, e := range webhookEvents {
+			known = known || e == event
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event %q (%s)", event, strings.Join(webhookEvents, ", "))
+		}
+	}
+	return events, nil
+}
+
+func newWebhooks(urls []string, events map[string]bool, timeout time.Duration, userAgent string) *webhooks {
+	return &webhooks{
+		urls:      urls,
+		events:    events,
+		userAgent: userAgent,
+		client:    &http.Client{Timeout: timeout},
+		queue:     make(chan webhookEvent, webhookQueueSize),
+	}
+}
+
+// fire queues an event, it does nothing if the webhooks are disabled or the event is not
+// selected.
+func (w *webhooks) fire(event webhookEvent, now time.Time) {
+	if w == nil || !w.events[event.Event] {
+		return
+	}
+	event.Time = now.UTC().Format(time.RFC3339)
+	select {
+	case w.queue <- event:
+	default:
+		log.Warnf("Webhook %v event dropped, the previous ones are not posted yet", event.Event)
+		WebhookErrors.Inc()
+	}
+}
+
+func (w *webhooks) post(url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", w.userAgent)
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
+
+func (w *webhooks) run() {
+	for event := range w.queue {
+		body, err := json.Marshal(event)
+		if err != nil {
+			log.Errorf("Webhook %v event: %v", event.Event, err)
+			continue
+		}
+		for _, url := range w.urls {
+			if err := w.post(url, body); err != nil {
+				log.Errorf("Error posting the %v event to %v: %v", event.Event, url, err)
+				WebhookErrors.Inc()
+				continue
+			}
+			WebhooksPosted.WithLabelValues(event.Event).Inc()
+		}
+	}
+}
+
+// fireUpdate posts the update event of a new serial, and the churn event if it announced
+// and withdrew more than webhookChurn percent of the VRPs served before.
+func (s *state) fireUpdate(previousCount int, previousSerial uint32, hadPrevious bool, vrps int, keys int, aspas int) {
+	if s.webhooks == nil {
+		return
+	}
+	serial, _ := s.server.GetCurrentSerial(s.server.GetSessionId())
+	now := time.Now()
+	event := webhookEvent{
+		Source:     s.getActiveCache(),
+		Serial:     serial,
+		VRPs:       vrps,
+		RouterKeys: keys,
+		ASPAs:      aspas,
+	}
+	update := event
+	update.Event = WEBHOOK_UPDATE
+	s.webhooks.fire(update, now)
+
+	if !hadPrevious || previousCount == 0 || s.webhookChurn <= 0 || serial == previousSerial {
+		return
+	}
+	diff, ok := s.server.GetVRPsSerialDiff(previousSerial)
+	if !ok {
+		return
+	}
+	churn := 100 * float64(len(diff)) / float64(previousCount)
+	if churn >= s.webhookChurn {
+		log.Warnf("Serial %d changed %.1f%% of the VRPs (%d changes)", serial, churn, len(diff))
+		event.Event = WEBHOOK_CHURN
+		event.Changes = len(diff)
+		event.Churn = churn
+		s.webhooks.fire(event, now)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	rtr "github.com/bgp/stayrtr/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWebhookEvents(t *testing.T) {
+	events, err := parseWebhookEvents("")
+	assert.NoError(t, err)
+	assert.Len(t, events, len(webhookEvents))
+
+	events, err = parseWebhookEvents("stale, churn")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{WEBHOOK_STALE: true, WEBHOOK_CHURN: true}, events)
+
+	_, err = parseWebhookEvents("update,expired")
+	assert.Error(t, err)
+}
+
+func TestWebhooks(t *testing.T) {
+	received := make(chan webhookEvent, webhookQueueSize)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "StayRTR-test", r.Header.Get("User-Agent"))
+		var event webhookEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		received <- event
+	}))
+	defer ts.Close()
+
+	server := rtr.NewServer(rtr.ServerConfiguration{KeepDifference: 3, SessId: 42}, nil, nil)
+	s := &state{
+		server:       server,
+		lockJson:     &sync.RWMutex{},
+		activeCache:  "cache.json",
+		webhooks:     newWebhooks([]string{ts.URL}, map[string]bool{WEBHOOK_UPDATE: true, WEBHOOK_CHURN: true}, time.Second, "StayRTR-test"),
+		webhookChurn: 50,
+	}
+	go s.webhooks.run()
+
+	// Not selected
+	s.webhooks.fire(webhookEvent{Event: WEBHOOK_STALE, Error: "stale"}, time.Now())
+
+	vrps := []rtr.VRP{
+		{Prefix: mustParsePrefix("192.0.2.0/24"), MaxLen: 24, ASN: 64496},
+		{Prefix: mustParsePrefix("198.51.100.0/24"), MaxLen: 24, ASN: 64497},
+	}
+	previous, previousSerial, hadPrevious := server.GetCurrentVRPsSerial()
+	server.AddData(vrps, nil, nil)
+	s.fireUpdate(len(previous), previousSerial, hadPrevious, len(vrps), 0, 0)
+	start, _ := server.GetCurrentSerial(42)
+
+	event := <-received
+	assert.Equal(t, WEBHOOK_UPDATE, event.Event)
+	assert.Equal(t, start, event.Serial)
+	assert.Equal(t, 2, event.VRPs)
+	assert.Equal(t, "cache.json", event.Source)
+
+	// One VRP withdrawn and one announced: 100% of the two served before
+	vrps = []rtr.VRP{
+		vrps[0],
+		{Prefix: mustParsePrefix("203.0.113.0/24"), MaxLen: 24, ASN: 64498},
+	}
+	previous, previousSerial, hadPrevious = server.GetCurrentVRPsSerial()
+	server.AddData(vrps, nil, nil)
+	s.fireUpdate(len(previous), previousSerial, hadPrevious, len(vrps), 0, 0)
+	serial, _ := server.GetCurrentSerial(42)
+
+	event = <-received
+	assert.Equal(t, WEBHOOK_UPDATE, event.Event)
+	assert.Equal(t, serial, event.Serial)
+	event = <-received
+	assert.Equal(t, WEBHOOK_CHURN, event.Event)
+	assert.Equal(t, serial, event.Serial)
+	assert.Equal(t, 2, event.Changes)
+	assert.Equal(t, 100.0, event.Churn)
+
+	select {
+	case event := <-received:
+		t.Errorf("unexpected event %v", event.Event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,231 @@
+package rtrlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// A PDUBatch is the VRPs, router keys and ASPAs sent between a Cache Response and an End of
+// Data, encoded once for a version. It is written as such to all the clients requesting the
+// same data instead of being serialized for each of them, e.g. when they are all notified.
+type PDUBatch struct {
+	Version uint8
+	// Number of PDUs of each type in the batch
+	Types map[uint8]int
+	Count int
+
+	buf []byte
+}
+
+// NewPDUBatch encodes the data for a version. The types of PDU the version does not
+// support (Router Keys for version 0, ASPAs before version 2) are left out.
+func NewPDUBatch(version uint8, vrps []VRP, keys []BgpsecKey, aspas []ASPA) *PDUBatch {
+	batch := &PDUBatch{
+		Version: version,
+		Types:   make(map[uint8]int),
+	}
+	buf := make([]byte, 0, len(vrps)*32)
+	add := func(pdu PDU) {
+		if pdu == nil || !IsCorrectPDUVersion(pdu, version) {
+			return
+		}
+		pdu.SetVersion(version)
+		buf = pdu.Append(buf)
+		batch.Types[pdu.GetType()]++
+		batch.Count++
+	}
+	// The prefixes are encoded from values on the stack: there are many of them
+	var v4 PDUIPv4Prefix
+	var v6 PDUIPv6Prefix
+	for _, vrp := range vrps {
+		if vrp.Prefix.Addr().Is6() {
+			v6 = PDUIPv6Prefix{Version: version, Flags: vrp.Flags, MaxLen: vrp.MaxLen, ASN: vrp.ASN, Prefix: vrp.Prefix}
+			buf = v6.Append(buf)
+			batch.Types[PDU_ID_IPV6_PREFIX]++
+		} else if vrp.Prefix.Addr().Is4() {
+			v4 = PDUIPv4Prefix{Version: version, Flags: vrp.Flags, MaxLen: vrp.MaxLen, ASN: vrp.ASN, Prefix: vrp.Prefix}
+			buf = v4.Append(buf)
+			batch.Types[PDU_ID_IPV4_PREFIX]++
+		} else {
+			continue
+		}
+		batch.Count++
+	}
+	for _, key := range keys {
+		add(bgpsecKeyPDU(key))
+	}
+	for _, aspa := range aspas {
+		add(aspaPDU(aspa))
+	}
+	batch.buf = buf
+	return batch
+}
+
+// Bytes returns the encoded PDUs, shared by all the clients: it must not be modified.
+func (b *PDUBatch) Bytes() []byte {
+	return b.buf
+}
+
+func (b *PDUBatch) Write(wr io.Writer) {
+	wr.Write(b.buf)
+}
+
+func (b *PDUBatch) Append(buf []byte) []byte {
+	return append(buf, b.buf...)
+}
+
+func (b *PDUBatch) String() string {
+	return fmt.Sprintf("PDU Batch v%d (%d PDUs, %d bytes)", b.Version, b.Count, len(b.buf))
+}
+
+// SetVersion does nothing: the PDUs are encoded for the version of the batch.
+func (b *PDUBatch) SetVersion(version uint8) {}
+
+func (b *PDUBatch) GetVersion() uint8 {
+	return b.Version
+}
+
+// GetType returns PDU_ID_BATCH, which is not sent on the wire: the batch is only used to
+// queue the PDUs it holds at once.
+func (b *PDUBatch) GetType() uint8 {
+	return PDU_ID_BATCH
+}
+
+// PDUs decodes the PDUs of the batch, along with their length.
+func (b *PDUBatch) PDUs(fn func(pdu PDU, length int)) {
+	for off := 0; off+8 <= len(b.buf); {
+		length := int(binary.BigEndian.Uint32(b.buf[off+4:]))
+		if length < 8 || off+length > len(b.buf) {
+			return
+		}
+		pdu, err := DecodeBytes(b.buf[off : off+length])
+		if err == nil {
+			fn(pdu, length)
+		}
+		off += length
+	}
+}
+
+// A PDUBatchManager is a VRPManager encoding the data it serves once per serial and
+// version, see PDUBatch.
+type PDUBatchManager interface {
+	// GetCurrentBatch returns the current data, along with its serial.
+	GetCurrentBatch(version uint8) (*PDUBatch, uint32, bool)
+	// GetSerialDiffBatch returns the changes since a serial, along with the current serial.
+	GetSerialDiffBatch(serial uint32, version uint8) (*PDUBatch, uint32, bool)
+}
+
+// An RTRServerBatchHandler is an RTRServerTrafficHandler told of the batches sent as a
+// whole. The PDUSent of the other handlers is called for each PDU of the batches.
+type RTRServerBatchHandler interface {
+	RTRServerTrafficHandler
+	PDUBatchSent(*Client, *PDUBatch, int)
+}
+
+type batchKey struct {
+	version uint8
+	// Serial the changes are from, the current data if full
+	serial uint32
+	full   bool
+}
+
+// batchEntry is built once, by the first client requesting it.
+type batchEntry struct {
+	once  sync.Once
+	batch *PDUBatch
+}
+
+// cachedBatch returns the batch of a key, built if it is not yet cached. The batches are
+// dropped when the data changes: the caller must hold the read lock on the data.
+func (s *Server) cachedBatch(key batchKey, build func() *PDUBatch) *PDUBatch {
+	s.batchlock.Lock()
+	entry, ok := s.batches[key]
+	if !ok {
+		entry = &batchEntry{}
+		s.batches[key] = entry
+	}
+	s.batchlock.Unlock()
+	entry.once.Do(func() {
+		entry.batch = build()
+	})
+	return entry.batch
+}
+
+func (s *Server) GetCurrentBatch(version uint8) (*PDUBatch, uint32, bool) {
+	s.vrplock.RLock()
+	defer s.vrplock.RUnlock()
+	serial, valid := s.getCurrentSerial()
+	if !valid {
+		return nil, serial, false
+	}
+	batch := s.cachedBatch(batchKey{version: version, full: true}, func() *PDUBatch {
+		return NewPDUBatch(version, s.vrpCurrent, s.keyCurrent, s.aspaCurrent)
+	})
+	return batch, serial, true
+}
+
+// GetSerialDiffBatch returns the changes since a serial. A diff larger than the MaxDiffSize
+// of the configuration is not sent, as if the serial was not known: the client gets a Cache
+// Reset instead.
+func (s *Server) GetSerialDiffBatch(serial uint32, version uint8) (*PDUBatch, uint32, bool) {
+	s.vrplock.RLock()
+	defer s.vrplock.RUnlock()
+	vrps, ok := s.getVRPsSerialDiff(serial)
+	if !ok {
+		return nil, s.vrpCurrentSerial, false
+	}
+	batch := s.cachedBatch(batchKey{version: version, serial: serial}, func() *PDUBatch {
+		if serial == s.vrpCurrentSerial {
+			return NewPDUBatch(version, nil, nil, nil)
+		}
+		if s.maxDiffSize > 0 && len(vrps) > s.maxDiffSize {
+			return nil
+		}
+		keys := ComputeBgpsecKeyDiff(s.keyCurrent, s.keySerial[serial])
+		aspas := ComputeASPADiff(s.aspaCurrent, s.aspaSerial[serial])
+		batch := NewPDUBatch(version, vrps, keys, aspas)
+		if s.maxDiffSize > 0 && batch.Count > s.maxDiffSize {
+			return nil
+		}
+		return batch
+	})
+	if batch == nil {
+		atomic.AddUint64(&s.largeDiffResets, 1)
+		return nil, s.vrpCurrentSerial, false
+	}
+	return batch, s.vrpCurrentSerial, true
+}
+
+// GetLargeDiffResets returns the number of Cache Reset sent instead of a diff larger than
+// the MaxDiffSize of the configuration.
+func (s *Server) GetLargeDiffResets() uint64 {
+	return atomic.LoadUint64(&s.largeDiffResets)
+}
+
+// SendBatch sends a batch between a Cache Response and an End of Data, like SendData.
+func (c *Client) SendBatch(sessionId uint16, serialNumber uint32, batch *PDUBatch) {
+	c.SendPDU(&PDUCacheResponse{
+		SessionId: sessionId,
+	})
+	c.SendRawPDU(batch)
+	c.sendEndOfData(sessionId, serialNumber)
+}
+
+// writeBatch writes a batch queued by SendBatch, the handler is told of its PDUs.
+func (c *Client) writeBatch(batch *PDUBatch) error {
+	n, err := c.wr.Write(batch.buf)
+	atomic.AddUint64(&c.pdusSent, uint64(batch.Count))
+	atomic.AddUint64(&c.bytesSent, uint64(n))
+	switch h := c.handler.(type) {
+	case RTRServerBatchHandler:
+		h.PDUBatchSent(c, batch, n)
+	case RTRServerTrafficHandler:
+		batch.PDUs(func(pdu PDU, length int) {
+			h.PDUSent(c, pdu, length)
+		})
+	}
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -21,11 +22,11 @@ type RTRClientSessionEventHandler interface {
 type ClientSession struct {
 	version uint8
 
-	connected bool
-
 	curserial uint32
 	transmits chan PDU
-	quit      chan bool
+	// Closed by Disconnect, it stops the sending and the reading
+	done         chan struct{}
+	disconnected sync.Once
 
 	tcpconn    net.Conn
 	sshsession *ssh.Session
@@ -52,7 +53,7 @@ func NewClientSession(configuration ClientConfiguration, handler RTRClientSessio
 	return &ClientSession{
 		version:   configuration.ProtocolVersion,
 		transmits: make(chan PDU, 256),
-		quit:      make(chan bool),
+		done:      make(chan struct{}),
 		log:       configuration.Log,
 		handler:   handler,
 	}
@@ -76,45 +77,63 @@ func (c *ClientSession) SendPDU(pdu PDU) {
 	c.SendRawPDU(pdu)
 }
 
+// SendRawPDU queues a PDU, waiting for room in the queue unless the session is disconnected.
 func (c *ClientSession) SendRawPDU(pdu PDU) {
-	c.transmits <- pdu
+	select {
+	case c.transmits <- pdu:
+	case <-c.done:
+	}
 }
 
 func (c *ClientSession) sendLoop() {
-	for c.connected {
+	for {
 		select {
 		case pdu := <-c.transmits:
 			if c.wr != nil {
-				c.wr.Write(pdu.Bytes())
+				writePDU(c.wr, pdu)
 			}
-		case <-c.quit:
-			break
+		case <-c.done:
+			return
 		}
 	}
 }
 
 func (c *ClientSession) refreshLoop() {
-	for c.connected {
+	for {
 		select {
 		case <-time.After(20 * time.Second):
 			// send refresh
+		case <-c.done:
+			return
 		}
 	}
 }
 
-func (c *ClientSession) Disconnect() {
-	c.connected = false
-	//log.Debugf("Disconnecting client %v", c.String())
-	if c.handler != nil {
-		c.handler.ClientDisconnected(c)
-	}
+// isDisconnected returns whether Disconnect was called, it is safe to call from any
+// goroutine (e.g. from the handler of a PDU while the sending goroutine runs).
+func (c *ClientSession) isDisconnected() bool {
 	select {
-	case c.quit <- true:
+	case <-c.done:
+		return true
 	default:
-
+		return false
 	}
+}
+
+// Disconnect closes the connection of the session, it can be called more than once (e.g.
+// by the handler of a PDU, then by the reading loop when the connection is closed).
+func (c *ClientSession) Disconnect() {
+	c.disconnected.Do(func() {
+		//log.Debugf("Disconnecting client %v", c.String())
+		if c.handler != nil {
+			c.handler.ClientDisconnected(c)
+		}
+		close(c.done)
 
-	c.tcpconn.Close()
+		if c.tcpconn != nil {
+			c.tcpconn.Close()
+		}
+	})
 }
 
 func (c *ClientSession) StartRW(rd io.Reader, wr io.Writer) error {
@@ -122,7 +141,7 @@ func (c *ClientSession) StartRW(rd io.Reader, wr io.Writer) error {
 	if c.handler != nil {
 		c.handler.ClientConnected(c)
 	}
-	for c.connected {
+	for !c.isDisconnected() {
 		dec, err := Decode(c.rd)
 		if err != nil || dec == nil {
 			if c.log != nil {
@@ -131,11 +150,12 @@ func (c *ClientSession) StartRW(rd io.Reader, wr io.Writer) error {
 			c.Disconnect()
 			return err
 		}
-		if c.version == PROTOCOL_VERSION_1 && dec.GetVersion() == PROTOCOL_VERSION_0 {
+		// The cache answers with its version, or reports the highest it supports
+		if dec.GetVersion() < c.version {
 			if c.log != nil {
-				c.log.Infof("Downgrading to version 0")
-				c.version = PROTOCOL_VERSION_0
+				c.log.Infof("Downgrading to version %d", dec.GetVersion())
 			}
+			c.version = dec.GetVersion()
 		}
 
 		if c.handler != nil {
@@ -149,7 +169,6 @@ func (c *ClientSession) StartWithConn(tcpconn net.Conn) error {
 	c.tcpconn = tcpconn
 	c.wr = tcpconn
 	c.rd = tcpconn
-	c.connected = true
 
 	return c.StartRW(c.tcpconn, c.tcpconn)
 }
@@ -158,7 +177,6 @@ func (c *ClientSession) StartWithSSH(tcpconn *net.TCPConn, session *ssh.Session)
 	c.tcpconn = tcpconn
 	c.rd, _ = session.StdoutPipe()
 	c.wr, _ = session.StdinPipe()
-	c.connected = true
 
 	return c.StartRW(c.rd, c.wr)
 }
@@ -0,0 +1,70 @@
+package rtrlib
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// connLogSampler decides which connections are logged when they are accepted and closed.
+// Connections from an address that was logged less than a window ago are not logged
+// and of the remaining ones, only 1 in rate is.
+type connLogSampler struct {
+	lock *sync.Mutex
+
+	rate  int
+	count int
+
+	window    time.Duration
+	lastLog   map[string]time.Time
+	lastPrune time.Time
+}
+
+func newConnLogSampler(rate int, window time.Duration) *connLogSampler {
+	return &connLogSampler{
+		lock:    &sync.Mutex{},
+		rate:    rate,
+		window:  window,
+		lastLog: make(map[string]time.Time),
+	}
+}
+
+// Sample returns whether a connection from addr must be logged.
+func (l *connLogSampler) Sample(addr net.Addr, now time.Time) bool {
+	if l == nil || (l.rate <= 1 && l.window <= 0) {
+		return true
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var host string
+	if l.window > 0 {
+		host = addr.String()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if last, ok := l.lastLog[host]; ok && now.Sub(last) < l.window {
+			return false
+		}
+		if now.Sub(l.lastPrune) >= l.window {
+			for h, last := range l.lastLog {
+				if now.Sub(last) >= l.window {
+					delete(l.lastLog, h)
+				}
+			}
+			l.lastPrune = now
+		}
+	}
+
+	if l.rate > 1 {
+		l.count++
+		if l.count%l.rate != 1 {
+			return false
+		}
+	}
+
+	if l.window > 0 {
+		l.lastLog[host] = now
+	}
+	return true
+}
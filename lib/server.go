@@ -2,12 +2,18 @@ package rtrlib
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
+	"net/netip"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -20,12 +26,34 @@ func GenerateSessionId() uint16 {
 	return sessid
 }
 
+// SessionIdFromSeed derives a session ID from a seed, so that the servers sharing it (e.g.
+// an active/standby pair) present the same session.
+func SessionIdFromSeed(seed []byte) uint16 {
+	sum := sha256.Sum256(seed)
+	return binary.BigEndian.Uint16(sum[:2])
+}
+
 type RTRServerEventHandler interface {
 	ClientConnected(*Client)
 	ClientDisconnected(*Client)
 	HandlePDU(*Client, PDU)
 }
 
+// An RTRServerTrafficHandler is an RTRServerEventHandler which is also told of the PDUs
+// sent and the bytes received, e.g. to count them.
+type RTRServerTrafficHandler interface {
+	RTRServerEventHandler
+	PDUSent(*Client, PDU, int)
+	BytesReceived(*Client, int)
+}
+
+// An RTRServerFloodHandler is an RTRServerEventHandler which is also told of the clients
+// disconnected for sending PDUs faster than allowed, with the PDU over the limit.
+type RTRServerFloodHandler interface {
+	RTRServerEventHandler
+	PDUFlood(*Client, PDU)
+}
+
 type RTREventHandler interface {
 	RequestCache(*Client)
 	RequestNewVersion(*Client, uint16, uint32)
@@ -38,6 +66,20 @@ type VRPManager interface {
 	GetVRPsSerialDiff(uint32) ([]VRP, bool)
 }
 
+// BgpsecKeyManager is implemented by the VRPManagers also holding BGPsec router keys,
+// which are sent to the clients using version 1 or later.
+type BgpsecKeyManager interface {
+	GetCurrentBgpsecKeys() []BgpsecKey
+	GetBgpsecKeysSerialDiff(uint32) ([]BgpsecKey, bool)
+}
+
+// ASPAManager is implemented by the VRPManagers also holding ASPAs, which are sent
+// to the clients using version 2.
+type ASPAManager interface {
+	GetCurrentASPAs() []ASPA
+	GetASPAsSerialDiff(uint32) ([]ASPA, bool)
+}
+
 type DefaultRTREventHandler struct {
 	vrpManager VRPManager
 	Log        Logger
@@ -47,6 +89,11 @@ func (e *DefaultRTREventHandler) SetVRPManager(m VRPManager) {
 	e.vrpManager = m
 }
 
+// clientLog adds the client address and the serial sent to the messages.
+func (e *DefaultRTREventHandler) clientLog(c *Client, serial uint32) Logger {
+	return withFields(e.Log, Fields{"client": c.GetRemoteAddress().String(), "serial": serial})
+}
+
 func (e *DefaultRTREventHandler) RequestCache(c *Client) {
 	if e.Log != nil {
 		e.Log.Debugf("%v > Request Cache", c)
@@ -58,6 +105,19 @@ func (e *DefaultRTREventHandler) RequestCache(c *Client) {
 		if e.Log != nil {
 			e.Log.Debugf("%v < No data", c)
 		}
+	} else if m, ok := e.vrpManager.(PDUBatchManager); ok {
+		batch, serial, valid := m.GetCurrentBatch(c.GetVersion())
+		if !valid {
+			c.SendNoDataError()
+			if e.Log != nil {
+				e.Log.Debugf("%v < No data", c)
+			}
+			return
+		}
+		c.SendBatch(sessionId, serial, batch)
+		if e.Log != nil {
+			e.clientLog(c, serial).Debugf("%v < Sent VRPs (current serial %d, session: %d)", c, serial, sessionId)
+		}
 	} else {
 		vrps, exists := e.vrpManager.GetCurrentVRPs()
 		if !exists {
@@ -66,9 +126,17 @@ func (e *DefaultRTREventHandler) RequestCache(c *Client) {
 				e.Log.Debugf("%v < Internal error requesting cache (does not exists)", c)
 			}
 		} else {
-			c.SendVRPs(sessionId, serial, vrps)
+			var keys []BgpsecKey
+			if m, ok := e.vrpManager.(BgpsecKeyManager); ok && c.GetVersion() >= PROTOCOL_VERSION_1 {
+				keys = m.GetCurrentBgpsecKeys()
+			}
+			var aspas []ASPA
+			if m, ok := e.vrpManager.(ASPAManager); ok && c.GetVersion() >= PROTOCOL_VERSION_2 {
+				aspas = m.GetCurrentASPAs()
+			}
+			c.SendData(sessionId, serial, vrps, keys, aspas)
 			if e.Log != nil {
-				e.Log.Debugf("%v < Sent VRPs (current serial %d, session: %d)", c, serial, sessionId)
+				e.clientLog(c, serial).Debugf("%v < Sent VRPs (current serial %d, session: %d)", c, serial, sessionId)
 			}
 		}
 	}
@@ -84,32 +152,61 @@ func (e *DefaultRTREventHandler) RequestNewVersion(c *Client, sessionId uint16,
 		if e.Log != nil {
 			e.Log.Debugf("%v < No data", c)
 		}
+	} else if m, ok := e.vrpManager.(PDUBatchManager); ok {
+		batch, serial, exists := m.GetSerialDiffBatch(serialNumber, c.GetVersion())
+		if !exists {
+			c.SendCacheReset()
+			if e.Log != nil {
+				e.clientLog(c, serial).Debugf("%v < Sent cache reset", c)
+			}
+			return
+		}
+		c.SendBatch(sessionId, serial, batch)
+		if e.Log != nil {
+			e.clientLog(c, serial).Debugf("%v < Sent VRPs (current serial %d, session from client: %d)", c, serial, sessionId)
+		}
 	} else {
 		vrps, exists := e.vrpManager.GetVRPsSerialDiff(serialNumber)
+		var keys []BgpsecKey
+		if m, ok := e.vrpManager.(BgpsecKeyManager); ok && exists && c.GetVersion() >= PROTOCOL_VERSION_1 {
+			keys, exists = m.GetBgpsecKeysSerialDiff(serialNumber)
+		}
+		var aspas []ASPA
+		if m, ok := e.vrpManager.(ASPAManager); ok && exists && c.GetVersion() >= PROTOCOL_VERSION_2 {
+			aspas, exists = m.GetASPAsSerialDiff(serialNumber)
+		}
 		if !exists {
 			c.SendCacheReset()
 			if e.Log != nil {
-				e.Log.Debugf("%v < Sent cache reset", c)
+				e.clientLog(c, serial).Debugf("%v < Sent cache reset", c)
 			}
 		} else {
-			c.SendVRPs(sessionId, serial, vrps)
+			c.SendData(sessionId, serial, vrps, keys, aspas)
 			if e.Log != nil {
-				e.Log.Debugf("%v < Sent VRPs (current serial %d, session from client: %d)", c, serial, sessionId)
+				e.clientLog(c, serial).Debugf("%v < Sent VRPs (current serial %d, session from client: %d)", c, serial, sessionId)
 			}
 		}
 	}
 }
 
 type Server struct {
+	// Accessed atomically, kept first for 64-bit alignment
+	notifySkipped   uint64
+	largeDiffResets uint64
+
 	baseVersion uint8
 	clientlock  *sync.RWMutex
 	clients     []*Client
+	subscribers map[chan uint32]bool
 	sessId      uint16
 	connected   int
 	maxconn     int
 
+	sshlock   *sync.RWMutex
 	sshconfig *ssh.ServerConfig
 
+	viewSelector ViewSelector
+
 	handler        RTRServerEventHandler
 	simpleHandler  RTREventHandler
 	enforceVersion bool
@@ -120,22 +217,51 @@ type Server struct {
 	vrpListSerial    []uint32
 	vrpCurrent       []VRP
 	vrpCurrentSerial uint32
+	vrpAddedSerial   map[string]uint32
+	keyCurrent       []BgpsecKey
+	keySerial        map[uint32][]BgpsecKey // Router keys at each serial a diff is kept for
+	aspaCurrent      []ASPA
+	aspaSerial       map[uint32][]ASPA // ASPAs at each serial a diff is kept for
 	keepDiff         int
+	maxDiffSize      int
 	manualserial     bool
 
+	// Data encoded for the clients, dropped when it changes
+	batchlock *sync.Mutex
+	batches   map[batchKey]*batchEntry
+
+	// Session resumed from another server, see ResumeSession
+	resume       bool
+	resumeSerial uint32
+	resumeHash   []byte
+
 	pduRefreshInterval uint32
 	pduRetryInterval   uint32
 	pduExpireInterval  uint32
 
-	log        Logger
-	logverbose bool
+	log           Logger
+	logverbose    bool
+	connLog       *connLogSampler
+	sourceLimiter *sourceLimiter
+
+	writeTimeout  time.Duration
+	sendQueueSize int
+	idleTimeout   time.Duration
+	pduRate       float64
+	pduBurst      int
 }
 
 type ServerConfiguration struct {
 	MaxConn         int
+	SourceLimits    SourceLimits
 	ProtocolVersion uint8
 	EnforceVersion  bool
-	KeepDifference  int
+	// Number of previous serials the clients can get the changes from, the clients at an
+	// older serial get a Cache Reset (0 to keep all)
+	KeepDifference int
+	// Objects (VRPs, router keys and ASPAs) in the changes sent to a client, a client with
+	// more changes gets a Cache Reset and downloads the full data instead (0 for no limit)
+	MaxDiffSize int
 
 	SessId int
 
@@ -145,7 +271,26 @@ type ServerConfiguration struct {
 
 	Log        Logger
 	LogVerbose bool
-}
+	// Log 1 in LogSampleRate accepted connections (0 or 1 to log all)
+	LogSampleRate int
+	// Do not log connections from an address logged less than LogSampleWindow ago
+	LogSampleWindow time.Duration
+
+	// Time a write to a client can take before it is disconnected (0 for no limit)
+	WriteTimeout time.Duration
+	// PDUs queued for each client (DefaultSendQueueSize if 0), see Client.SetSendQueueSize
+	SendQueueSize int
+	// Time after which a client not sending any Serial Query or Reset Query is disconnected
+	// (0 for no limit), e.g. a few refresh intervals
+	IdleTimeout time.Duration
+	// PDUs a client can send per second (0 for no limit), with bursts of PDUBurst. A client
+	// over the limit gets an Error Report and is disconnected
+	PDURate  float64
+	PDUBurst int
+}
+
+// DefaultSendQueueSize is the number of PDUs queued for each client by default.
+const DefaultSendQueueSize = 256
 
 func NewServer(configuration ServerConfiguration, handler RTRServerEventHandler, simpleHandler RTREventHandler) *Server {
 	var sessid uint16
@@ -169,15 +314,25 @@ func NewServer(configuration ServerConfiguration, handler RTRServerEventHandler,
 	}
 
 	return &Server{
-		vrplock:       &sync.RWMutex{},
-		vrpListDiff:   make([][]VRP, 0),
-		vrpMapSerial:  make(map[uint32]int),
-		vrpListSerial: make([]uint32, 0),
-		vrpCurrent:    make([]VRP, 0),
-		keepDiff:      configuration.KeepDifference,
+		vrplock:        &sync.RWMutex{},
+		vrpListDiff:    make([][]VRP, 0),
+		vrpMapSerial:   make(map[uint32]int),
+		vrpListSerial:  make([]uint32, 0),
+		vrpCurrent:     make([]VRP, 0),
+		vrpAddedSerial: make(map[string]uint32),
+		keyCurrent:     make([]BgpsecKey, 0),
+		keySerial:      make(map[uint32][]BgpsecKey),
+		aspaCurrent:    make([]ASPA, 0),
+		aspaSerial:     make(map[uint32][]ASPA),
+		keepDiff:       configuration.KeepDifference,
+		maxDiffSize:    configuration.MaxDiffSize,
+		batchlock:      &sync.Mutex{},
+		batches:        make(map[batchKey]*batchEntry),
 
 		clientlock:     &sync.RWMutex{},
+		sshlock:        &sync.RWMutex{},
 		clients:        make([]*Client, 0),
+		subscribers:    make(map[chan uint32]bool),
 		sessId:         sessid,
 		maxconn:        configuration.MaxConn,
 		baseVersion:    configuration.ProtocolVersion,
@@ -189,8 +344,16 @@ func NewServer(configuration ServerConfiguration, handler RTRServerEventHandler,
 		pduRetryInterval:   retryInterval,
 		pduExpireInterval:  expireInterval,
 
-		log:        configuration.Log,
-		logverbose: configuration.LogVerbose,
+		log:           configuration.Log,
+		logverbose:    configuration.LogVerbose,
+		connLog:       newConnLogSampler(configuration.LogSampleRate, configuration.LogSampleWindow),
+		sourceLimiter: newSourceLimiter(configuration.SourceLimits),
+
+		writeTimeout:  configuration.WriteTimeout,
+		sendQueueSize: configuration.SendQueueSize,
+		idleTimeout:   configuration.IdleTimeout,
+		pduRate:       configuration.PDURate,
+		pduBurst:      configuration.PDUBurst,
 	}
 }
 
@@ -270,6 +433,121 @@ func ApplyDiff(diff []VRP, prevVrps []VRP) []VRP {
 	return newvrps
 }
 
+func (key BgpsecKey) HashKey() string {
+	return fmt.Sprintf("%x-%v-%x", key.SKI, key.ASN, key.Pubkey)
+}
+
+// ComputeBgpsecKeyDiff returns the announcements of the new router keys and the withdrawals
+// of the removed ones.
+func ComputeBgpsecKeyDiff(newKeys []BgpsecKey, prevKeys []BgpsecKey) []BgpsecKey {
+	diff := make([]BgpsecKey, 0)
+	newKeysMap := make(map[string]bool, len(newKeys))
+	for _, key := range newKeys {
+		newKeysMap[key.HashKey()] = true
+	}
+	prevKeysMap := make(map[string]bool, len(prevKeys))
+	for _, key := range prevKeys {
+		prevKeysMap[key.HashKey()] = true
+	}
+
+	for _, key := range newKeys {
+		if !prevKeysMap[key.HashKey()] {
+			rcopy := key.Copy()
+			rcopy.Flags = FLAG_ADDED
+			diff = append(diff, rcopy)
+		}
+	}
+	for _, key := range prevKeys {
+		if !newKeysMap[key.HashKey()] {
+			rcopy := key.Copy()
+			rcopy.Flags = FLAG_REMOVED
+			diff = append(diff, rcopy)
+		}
+	}
+	return diff
+}
+
+// normalizeBgpsecKeys returns the router keys deduplicated and sorted by ASN.
+func normalizeBgpsecKeys(keys []BgpsecKey) []BgpsecKey {
+	seen := make(map[string]bool, len(keys))
+	normalized := make([]BgpsecKey, 0, len(keys))
+	for _, key := range keys {
+		if seen[key.HashKey()] {
+			continue
+		}
+		seen[key.HashKey()] = true
+		rcopy := key.Copy()
+		rcopy.Flags = FLAG_ADDED
+		normalized = append(normalized, rcopy)
+	}
+	sort.SliceStable(normalized, func(i, j int) bool { return normalized[i].ASN < normalized[j].ASN })
+	return normalized
+}
+
+func (aspa ASPA) HashKey() uint32 {
+	return aspa.CustomerASN
+}
+
+// ComputeASPADiff returns the announcements of the new or changed ASPAs, which replace
+// the previous ones of their customer, and the withdrawals of the removed ones.
+func ComputeASPADiff(newAspas []ASPA, prevAspas []ASPA) []ASPA {
+	diff := make([]ASPA, 0)
+	newAspasMap := make(map[uint32]ASPA, len(newAspas))
+	for _, aspa := range newAspas {
+		newAspasMap[aspa.HashKey()] = aspa
+	}
+	prevAspasMap := make(map[uint32]ASPA, len(prevAspas))
+	for _, aspa := range prevAspas {
+		prevAspasMap[aspa.HashKey()] = aspa
+	}
+
+	for _, aspa := range newAspas {
+		prev, exists := prevAspasMap[aspa.HashKey()]
+		if !exists || !prev.Equals(aspa) {
+			rcopy := aspa.Copy()
+			rcopy.Flags = FLAG_ADDED
+			diff = append(diff, rcopy)
+		}
+	}
+	for _, aspa := range prevAspas {
+		if _, exists := newAspasMap[aspa.HashKey()]; !exists {
+			diff = append(diff, ASPA{
+				CustomerASN: aspa.CustomerASN,
+				Flags:       FLAG_REMOVED,
+			})
+		}
+	}
+	return diff
+}
+
+// normalizeASPAs returns the ASPAs sorted by customer with their providers sorted and
+// deduplicated, as they are sent to the clients. The last ASPA of a customer is kept.
+func normalizeASPAs(aspas []ASPA) []ASPA {
+	byCustomer := make(map[uint32]ASPA, len(aspas))
+	for _, aspa := range aspas {
+		byCustomer[aspa.HashKey()] = aspa
+	}
+	normalized := make([]ASPA, 0, len(byCustomer))
+	for _, aspa := range byCustomer {
+		providers := make([]uint32, 0, len(aspa.Providers))
+		seen := make(map[uint32]bool, len(aspa.Providers))
+		for _, provider := range aspa.Providers {
+			if !seen[provider] {
+				seen[provider] = true
+				providers = append(providers, provider)
+			}
+		}
+		sort.Slice(providers, func(i, j int) bool { return providers[i] < providers[j] })
+		normalized = append(normalized, ASPA{
+			CustomerASN: aspa.CustomerASN,
+			Providers:   providers,
+			Flags:       FLAG_ADDED,
+		})
+	}
+	sort.Slice(normalized, func(i, j int) bool { return normalized[i].CustomerASN < normalized[j].CustomerASN })
+	return normalized
+}
+
 func (s *Server) SetManualSerial(v bool) {
 	s.manualserial = v
 }
@@ -285,6 +563,89 @@ func (s *Server) GetCurrentVRPs() ([]VRP, bool) {
 	return vrp, true
 }
 
+// GetCurrentVRPsSerial returns the current VRPs along with their serial.
+func (s *Server) GetCurrentVRPsSerial() ([]VRP, uint32, bool) {
+	s.vrplock.RLock()
+	defer s.vrplock.RUnlock()
+	serial, valid := s.getCurrentSerial()
+	return s.vrpCurrent, serial, valid
+}
+
+// GetVRPsSerialDiffCurrent returns the changes since a serial along with the current serial.
+func (s *Server) GetVRPsSerialDiffCurrent(serial uint32) ([]VRP, uint32, bool) {
+	s.vrplock.RLock()
+	defer s.vrplock.RUnlock()
+	vrp, ok := s.getVRPsSerialDiff(serial)
+	return vrp, s.vrpCurrentSerial, ok
+}
+
+// GetCurrentDataSerial returns the current VRPs, router keys and ASPAs along with their serial.
+func (s *Server) GetCurrentDataSerial() ([]VRP, []BgpsecKey, []ASPA, uint32, bool) {
+	s.vrplock.RLock()
+	defer s.vrplock.RUnlock()
+	serial, valid := s.getCurrentSerial()
+	return s.vrpCurrent, s.keyCurrent, s.aspaCurrent, serial, valid
+}
+
+// GetDataSerialDiffCurrent returns the changes to the VRPs, router keys and ASPAs since a
+// serial, along with the current serial.
+func (s *Server) GetDataSerialDiffCurrent(serial uint32) ([]VRP, []BgpsecKey, []ASPA, uint32, bool) {
+	s.vrplock.RLock()
+	defer s.vrplock.RUnlock()
+	vrps, ok := s.getVRPsSerialDiff(serial)
+	if !ok {
+		return nil, nil, nil, s.vrpCurrentSerial, false
+	}
+	if serial == s.vrpCurrentSerial {
+		return vrps, []BgpsecKey{}, []ASPA{}, serial, true
+	}
+	keys := ComputeBgpsecKeyDiff(s.keyCurrent, s.keySerial[serial])
+	aspas := ComputeASPADiff(s.aspaCurrent, s.aspaSerial[serial])
+	return vrps, keys, aspas, s.vrpCurrentSerial, true
+}
+
+func (s *Server) GetCurrentBgpsecKeys() []BgpsecKey {
+	s.vrplock.RLock()
+	keys := s.keyCurrent
+	s.vrplock.RUnlock()
+	return keys
+}
+
+// GetBgpsecKeysSerialDiff returns the changes to the router keys since a serial.
+func (s *Server) GetBgpsecKeysSerialDiff(serial uint32) ([]BgpsecKey, bool) {
+	s.vrplock.RLock()
+	defer s.vrplock.RUnlock()
+	if serial == s.vrpCurrentSerial {
+		return []BgpsecKey{}, true
+	}
+	prevKeys, ok := s.keySerial[serial]
+	if !ok {
+		return nil, false
+	}
+	return ComputeBgpsecKeyDiff(s.keyCurrent, prevKeys), true
+}
+
+func (s *Server) GetCurrentASPAs() []ASPA {
+	s.vrplock.RLock()
+	aspas := s.aspaCurrent
+	s.vrplock.RUnlock()
+	return aspas
+}
+
+// GetASPAsSerialDiff returns the changes to the ASPAs since a serial.
+func (s *Server) GetASPAsSerialDiff(serial uint32) ([]ASPA, bool) {
+	s.vrplock.RLock()
+	defer s.vrplock.RUnlock()
+	if serial == s.vrpCurrentSerial {
+		return []ASPA{}, true
+	}
+	prevAspas, ok := s.aspaSerial[serial]
+	if !ok {
+		return nil, false
+	}
+	return ComputeASPADiff(s.aspaCurrent, prevAspas), true
+}
+
 func (s *Server) GetVRPsSerialDiff(serial uint32) ([]VRP, bool) {
 	s.vrplock.RLock()
 	vrp, ok := s.getVRPsSerialDiff(serial)
@@ -345,6 +706,22 @@ func (s *Server) SetSerial(serial uint32) {
 }
 
 func (s *Server) AddVRPs(vrps []VRP) {
+	s.AddData(vrps, s.GetCurrentBgpsecKeys(), s.GetCurrentASPAs())
+}
+
+// AddData replaces the VRPs, the router keys and the ASPAs, under a new serial.
+func (s *Server) AddData(vrps []VRP, keys []BgpsecKey, aspas []ASPA) {
+	s.addData(vrps, keys, aspas, nil)
+}
+
+// AddDataSerial replaces the data like AddData, under the given serial rather than the
+// next one, for instance to keep the serials of an upstream cache. The serial must be
+// newer than the current one.
+func (s *Server) AddDataSerial(vrps []VRP, keys []BgpsecKey, aspas []ASPA, serial uint32) {
+	s.addData(vrps, keys, aspas, &serial)
+}
+
+func (s *Server) addData(vrps []VRP, keys []BgpsecKey, aspas []ASPA, serial *uint32) {
 	s.vrplock.RLock()
 
 	vrpCurrent := s.vrpCurrent
@@ -358,7 +735,7 @@ func (s *Server) AddVRPs(vrps []VRP) {
 	curDiff := append(added, removed...)
 	s.vrplock.RUnlock()
 
-	s.AddVRPsDiff(curDiff)
+	s.addDiff(curDiff, normalizeBgpsecKeys(keys), normalizeASPAs(aspas), serial)
 }
 
 func (s *Server) addSerial(serial uint32) []uint32 {
@@ -372,7 +749,83 @@ func (s *Server) addSerial(serial uint32) []uint32 {
 	return removed
 }
 
+// dataHash returns a hash of the VRPs, the router keys and the ASPAs, independent of their order.
+func dataHash(vrps []VRP, keys []BgpsecKey, aspas []ASPA) []byte {
+	items := make([]string, 0, len(vrps)+len(keys)+len(aspas))
+	for _, vrp := range vrps {
+		items = append(items, "vrp-"+vrp.HashKey())
+	}
+	for _, key := range keys {
+		items = append(items, "key-"+key.HashKey())
+	}
+	for _, aspa := range aspas {
+		items = append(items, fmt.Sprintf("aspa-%v-%v", aspa.CustomerASN, aspa.Providers))
+	}
+	sort.Strings(items)
+
+	h := sha256.New()
+	for _, item := range items {
+		h.Write([]byte(item))
+		h.Write([]byte{'\n'})
+	}
+	return h.Sum(nil)
+}
+
+// GetDataHash returns a hash of the data served, to be passed to ResumeSession.
+func (s *Server) GetDataHash() []byte {
+	s.vrplock.RLock()
+	defer s.vrplock.RUnlock()
+	return dataHash(s.vrpCurrent, s.keyCurrent, s.aspaCurrent)
+}
+
+// ResumeSession continues the session of another server (e.g. a process handing over its
+// sockets), so that its clients do not need a Cache Reset. The first data added keeps the
+// serial if it is identical to the data of the hash (see GetDataHash), or gets the next one.
+// Function must be called before the data is added.
+func (s *Server) ResumeSession(sessId uint16, serial uint32, hash []byte) {
+	s.vrplock.Lock()
+	defer s.vrplock.Unlock()
+	s.sessId = sessId
+	s.resume = true
+	s.resumeSerial = serial
+	s.resumeHash = hash
+}
+
+// SetKeepDifference changes the number of previous serials the changes are kept from,
+// the changes from the oldest serials are dropped if there are more.
+func (s *Server) SetKeepDifference(keep int) {
+	s.vrplock.Lock()
+	defer s.vrplock.Unlock()
+	s.keepDiff = keep
+	// The first diff is from the serial before the oldest one kept
+	removeDiff := len(s.vrpListSerial) - keep - 1
+	if keep <= 0 || removeDiff <= 0 {
+		return
+	}
+	removed := s.vrpListSerial[0:removeDiff]
+	s.vrpListSerial = s.vrpListSerial[removeDiff:]
+	s.vrpListDiff = s.vrpListDiff[removeDiff:]
+	for _, removeSerial := range removed {
+		delete(s.vrpMapSerial, removeSerial)
+		delete(s.keySerial, removeSerial)
+		delete(s.aspaSerial, removeSerial)
+	}
+	for k, v := range s.vrpMapSerial {
+		s.vrpMapSerial[k] = v - removeDiff
+	}
+}
+
+func (s *Server) GetKeepDifference() int {
+	s.vrplock.RLock()
+	defer s.vrplock.RUnlock()
+	return s.keepDiff
+}
+
 func (s *Server) AddVRPsDiff(diff []VRP) {
+	s.addDiff(diff, s.GetCurrentBgpsecKeys(), s.GetCurrentASPAs(), nil)
+}
+
+func (s *Server) addDiff(diff []VRP, keys []BgpsecKey, aspas []ASPA, serial *uint32) {
 	s.vrplock.RLock()
 	nextDiff := make([][]VRP, len(s.vrpListDiff))
 	for i, prevVrps := range s.vrpListDiff {
@@ -384,7 +837,18 @@ func (s *Server) AddVRPsDiff(diff []VRP) {
 
 	s.vrplock.Lock()
 	defer s.vrplock.Unlock()
+	if s.resume && len(s.vrpListSerial) == 0 {
+		s.resume = false
+		if bytes.Equal(dataHash(newVrpCurrent, keys, aspas), s.resumeHash) {
+			s.setSerial(s.resumeSerial)
+		} else {
+			s.setSerial(s.resumeSerial + 1)
+		}
+	}
 	newserial := s.generateSerial()
+	if serial != nil {
+		newserial = *serial
+	}
 	removed := s.addSerial(newserial)
 
 	nextDiff = append(nextDiff, diff)
@@ -402,12 +866,65 @@ func (s *Server) AddVRPsDiff(diff []VRP) {
 		}
 	}
 
+	s.keySerial[curserial] = s.keyCurrent
+	s.aspaSerial[curserial] = s.aspaCurrent
 	for _, removeSerial := range removed {
 		delete(s.vrpMapSerial, removeSerial)
+		delete(s.keySerial, removeSerial)
+		delete(s.aspaSerial, removeSerial)
 	}
 	s.vrpListDiff = nextDiff
+	s.batches = make(map[batchKey]*batchEntry)
 	s.vrpCurrent = newVrpCurrent
+	s.keyCurrent = keys
+	s.aspaCurrent = aspas
 	s.setSerial(newserial)
+
+	for _, vrp := range diff {
+		if vrp.Flags == FLAG_ADDED {
+			s.vrpAddedSerial[vrp.HashKey()] = newserial
+		} else {
+			delete(s.vrpAddedSerial, vrp.HashKey())
+		}
+	}
+}
+
+// VRPDebug is a VRP held by the server along with the serial it was added at.
+type VRPDebug struct {
+	VRP
+	SerialAdded uint32
+}
+
+// ServerDebugState is the data held by the server, for debugging purposes.
+type ServerDebugState struct {
+	SessionId uint16
+	Serial    uint32
+	Valid     bool
+	// Previous serials from which clients can get a diff
+	Serials []uint32
+	VRPs    []VRPDebug
+}
+
+func (s *Server) GetDebugState() ServerDebugState {
+	s.vrplock.RLock()
+	defer s.vrplock.RUnlock()
+	state := ServerDebugState{
+		SessionId: s.sessId,
+		Serials:   make([]uint32, 0, len(s.vrpMapSerial)),
+		VRPs:      make([]VRPDebug, len(s.vrpCurrent)),
+	}
+	state.Serial, state.Valid = s.getCurrentSerial()
+	for serial := range s.vrpMapSerial {
+		state.Serials = append(state.Serials, serial)
+	}
+	sort.Slice(state.Serials, func(i, j int) bool { return state.Serials[i] < state.Serials[j] })
+	for i, vrp := range s.vrpCurrent {
+		state.VRPs[i] = VRPDebug{
+			VRP:         vrp,
+			SerialAdded: s.vrpAddedSerial[vrp.HashKey()],
+		}
+	}
+	return state
 }
 
 func (s *Server) SetBaseVersion(version uint8) {
@@ -477,7 +994,7 @@ func (s *Server) HandlePDU(c *Client, pdu PDU) {
 			s.log.Debugf("Client %v uses version %v and server is using %v", c.String(), c.GetVersion(), s.baseVersion)
 		}
 		c.SendWrongVersionError()
-		c.Disconnect()
+		return
 	}
 	if c.GetVersion() > s.baseVersion {
 		// Downgrade
@@ -489,6 +1006,24 @@ func (s *Server) HandlePDU(c *Client, pdu PDU) {
 	}
 }
 
+func (s *Server) PDUSent(c *Client, pdu PDU, length int) {
+	if th, ok := s.handler.(RTRServerTrafficHandler); ok {
+		th.PDUSent(c, pdu, length)
+	}
+}
+
+func (s *Server) PDUFlood(c *Client, pdu PDU) {
+	if fh, ok := s.handler.(RTRServerFloodHandler); ok {
+		fh.PDUFlood(c, pdu)
+	}
+}
+
+func (s *Server) BytesReceived(c *Client, length int) {
+	if th, ok := s.handler.(RTRServerTrafficHandler); ok {
+		th.BytesReceived(c, length)
+	}
+}
+
 func (s *Server) RequestCache(c *Client) {
 	if s.simpleHandler != nil {
 		s.simpleHandler.RequestCache(c)
@@ -506,27 +1041,94 @@ func (s *Server) Start(bind string) error {
 	if err != nil {
 		return err
 	}
-	return s.loopTCP(tcplist, "tcp", s.acceptClientTCP)
+	return s.Serve(tcplist)
+}
+
+// Serve accepts plain RTR connections on a listener, until it is closed.
+func (s *Server) Serve(tcplist net.Listener) error {
+	return s.loopTCP(s.limitSources(tcplist, "tcp"), "tcp", s.acceptClientTCP)
 }
 
-func (s *Server) acceptClientTCP(tcpconn net.Conn) error {
-	client := ClientFromConn(tcpconn, s, s)
-	client.log = s.log
-	if s.enforceVersion {
-		client.SetVersion(s.baseVersion)
+// A ViewSelector returns the server holding the VRPs to send to a client connecting
+// from an address, or nil for the server the client connected to.
+type ViewSelector func(addr net.Addr) *Server
+
+// SetViewSelector allows serving the VRPs of other servers depending on the client address.
+// The clients are handled by the selected server: they receive its VRPs and notifications.
+func (s *Server) SetViewSelector(selector ViewSelector) {
+	s.viewSelector = selector
+}
+
+// view returns the server handling a client connecting from an address.
+func (s *Server) view(addr net.Addr) *Server {
+	if s.viewSelector != nil {
+		if view := s.viewSelector(addr); view != nil {
+			return view
+		}
+	}
+	return s
+}
+
+func (s *Server) acceptClientTCP(tcpconn net.Conn, logConnection bool) error {
+	view := s.view(tcpconn.RemoteAddr())
+	client := ClientFromConn(tcpconn, view, view)
+	client.SetMaxVersion(view.baseVersion)
+	client.log = withFields(view.log, Fields{"client": tcpconn.RemoteAddr().String()})
+	client.quiet = !logConnection
+	if view.enforceVersion {
+		client.SetVersion(view.baseVersion)
 	}
-	client.SetIntervals(s.pduRefreshInterval, s.pduRetryInterval, s.pduExpireInterval)
+	client.SetIntervals(view.pduRefreshInterval, view.pduRetryInterval, view.pduExpireInterval)
+	client.SetWriteTimeout(view.writeTimeout)
+	client.SetSendQueueSize(view.sendQueueSize)
+	client.SetIdleTimeout(view.idleTimeout)
+	client.SetPDURate(view.pduRate, view.pduBurst)
 	go client.Start()
 	return nil
 }
 
-func (s *Server) acceptClientSSH(tcpconn net.Conn) error {
-	_, chans, reqs, err := ssh.NewServerConn(tcpconn, s.sshconfig)
-	if err != nil {
-		return err
+// Time a TLS client has to complete the handshake.
+const tlsHandshakeTimeout = 30 * time.Second
+
+func (s *Server) acceptClientTLS(tcpconn net.Conn, logConnection bool) error {
+	tlsconn, ok := tcpconn.(*tls.Conn)
+	if !ok {
+		return s.acceptClientTCP(tcpconn, logConnection)
 	}
+	// The handshake (including the verification of the client certificate)
+	// runs in its own goroutine so that a slow client does not block the accept loop.
+	go func() {
+		tlsconn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		err := tlsconn.Handshake()
+		tlsconn.SetDeadline(time.Time{})
+		if err != nil {
+			if s.log != nil {
+				s.log.Errorf("Error with tls client %v: %v", tcpconn.RemoteAddr(), err)
+			}
+			tcpconn.Close()
+			return
+		}
+		if certs := tlsconn.ConnectionState().PeerCertificates; len(certs) > 0 && s.log != nil && logConnection {
+			s.log.Infof("TLS client %v authenticated as %v", tcpconn.RemoteAddr(), certs[0].Subject)
+		}
+		s.acceptClientTCP(tcpconn, logConnection)
+	}()
+	return nil
+}
 
+func (s *Server) acceptClientSSH(tcpconn net.Conn, logConnection bool) error {
+	// The handshake (including authentication) runs in its own goroutine
+	// so that a slow client does not block the accept loop.
 	go func() {
+		_, chans, reqs, err := ssh.NewServerConn(tcpconn, s.getSSHConfig())
+		if err != nil {
+			if s.log != nil {
+				s.log.Errorf("Error with ssh client %v: %v", tcpconn.RemoteAddr(), err)
+			}
+			tcpconn.Close()
+			return
+		}
+
 		s.connected++
 		cont := true
 		for cont {
@@ -564,12 +1166,19 @@ func (s *Server) acceptClientSSH(tcpconn net.Conn) error {
 							cont = false
 							break
 						}
-						client := ClientFromConnSSH(tcpconn, channel, s, s)
-						client.log = s.log
-						if s.enforceVersion {
-							client.SetVersion(s.baseVersion)
+						view := s.view(tcpconn.RemoteAddr())
+						client := ClientFromConnSSH(tcpconn, channel, view, view)
+						client.SetMaxVersion(view.baseVersion)
+						client.log = withFields(view.log, Fields{"client": tcpconn.RemoteAddr().String()})
+						client.quiet = !logConnection
+						if view.enforceVersion {
+							client.SetVersion(view.baseVersion)
 						}
-						client.SetIntervals(s.pduRefreshInterval, s.pduRetryInterval, s.pduExpireInterval)
+						client.SetIntervals(view.pduRefreshInterval, view.pduRetryInterval, view.pduExpireInterval)
+						client.SetWriteTimeout(view.writeTimeout)
+						client.SetSendQueueSize(view.sendQueueSize)
+						client.SetIdleTimeout(view.idleTimeout)
+						client.SetPDURate(view.pduRate, view.pduBurst)
 						client.Start()
 					} else {
 						cont = false
@@ -585,11 +1194,16 @@ func (s *Server) acceptClientSSH(tcpconn net.Conn) error {
 	return nil
 }
 
-type ClientCallback func(net.Conn) error
+// ClientCallback handles an accepted connection. logConnection is false when
+// the connection was not logged because of sampling.
+type ClientCallback func(tcpconn net.Conn, logConnection bool) error
 
 func (s *Server) loopTCP(tcplist net.Listener, logEnv string, clientCallback ClientCallback) error {
 	for {
 		tcpconn, err := tcplist.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
 		if err != nil {
 			if s.log != nil {
 				s.log.Errorf("Failed to accept %s connection: %s", logEnv, err)
@@ -599,17 +1213,18 @@ func (s *Server) loopTCP(tcplist net.Listener, logEnv string, clientCallback Cli
 
 		if s.maxconn > 0 && s.connected >= s.maxconn {
 			if s.log != nil {
-				s.log.Warnf("Could not accept %s connection from %v (not enough slots available: %d)", logEnv, tcpconn.RemoteAddr(), s.maxconn)
+				withFields(s.log, Fields{"client": tcpconn.RemoteAddr().String()}).Warnf("Could not accept %s connection from %v (not enough slots available: %d)", logEnv, tcpconn.RemoteAddr(), s.maxconn)
 			}
 			tcpconn.Close()
 		} else {
-			if s.log != nil {
-				s.log.Infof("Accepted %s connection from %v (%d/%d)", logEnv, tcpconn.RemoteAddr(), s.connected+1, s.maxconn)
+			logConnection := s.connLog.Sample(tcpconn.RemoteAddr(), time.Now())
+			if s.log != nil && logConnection {
+				withFields(s.log, Fields{"client": tcpconn.RemoteAddr().String()}).Infof("Accepted %s connection from %v (%d/%d)", logEnv, tcpconn.RemoteAddr(), s.connected+1, s.maxconn)
 			}
 			if clientCallback != nil {
-				err := clientCallback(tcpconn)
+				err := clientCallback(tcpconn, logConnection)
 				if err != nil && s.log != nil {
-					s.log.Errorf("Error with %s client %v: %v", logEnv, tcpconn.RemoteAddr(), err)
+					withFields(s.log, Fields{"client": tcpconn.RemoteAddr().String()}).Errorf("Error with %s client %v: %v", logEnv, tcpconn.RemoteAddr(), err)
 				}
 			}
 		}
@@ -621,16 +1236,40 @@ func (s *Server) StartSSH(bind string, config *ssh.ServerConfig) error {
 	if err != nil {
 		return err
 	}
+	return s.ServeSSH(tcplist, config)
+}
+
+// ServeSSH accepts RTR over SSH connections on a listener, until it is closed.
+func (s *Server) ServeSSH(tcplist net.Listener, config *ssh.ServerConfig) error {
+	s.SetSSHConfig(config)
+	return s.loopTCP(s.limitSources(tcplist, "ssh"), "ssh", s.acceptClientSSH)
+}
+
+// SetSSHConfig replaces the configuration of the SSH server (e.g. to change the host key),
+// the sessions already established are kept.
+func (s *Server) SetSSHConfig(config *ssh.ServerConfig) {
+	s.sshlock.Lock()
 	s.sshconfig = config
-	return s.loopTCP(tcplist, "ssh", s.acceptClientSSH)
+	s.sshlock.Unlock()
+}
+
+func (s *Server) getSSHConfig() *ssh.ServerConfig {
+	s.sshlock.RLock()
+	defer s.sshlock.RUnlock()
+	return s.sshconfig
 }
 
 func (s *Server) StartTLS(bind string, config *tls.Config) error {
-	tcplist, err := tls.Listen("tcp", bind, config)
+	tcplist, err := net.Listen("tcp", bind)
 	if err != nil {
 		return err
 	}
-	return s.loopTCP(tcplist, "tls", s.acceptClientTCP)
+	return s.ServeTLS(tcplist, config)
+}
+
+// ServeTLS accepts RTR over TLS connections on a TCP listener, until it is closed.
+func (s *Server) ServeTLS(tcplist net.Listener, config *tls.Config) error {
+	return s.loopTCP(tls.NewListener(s.limitSources(tcplist, "tls"), config), "tls", s.acceptClientTLS)
 }
 
 func (s *Server) GetClientList() []*Client {
@@ -648,11 +1287,50 @@ func (s *Server) NotifyClientsLatest() {
 	s.NotifyClients(serial)
 }
 
+// NotifyClients sends a Serial Notify to the clients that did not already receive the serial.
 func (s *Server) NotifyClients(serialNumber uint32) {
 	clients := s.GetClientList()
 	for _, c := range clients {
+		if c.IsSynced(serialNumber) {
+			atomic.AddUint64(&s.notifySkipped, 1)
+			continue
+		}
 		c.Notify(s.sessId, serialNumber)
 	}
+	s.NotifySubscribers(serialNumber)
+}
+
+// GetNotificationsSkipped returns the number of Serial Notify not sent because the client was up to date.
+func (s *Server) GetNotificationsSkipped() uint64 {
+	return atomic.LoadUint64(&s.notifySkipped)
+}
+
+// Subscribe returns a channel receiving the serial each time clients are notified.
+// A subscriber that is not ready to receive misses the serial: it is expected to
+// fetch the changes since the last one it has processed.
+func (s *Server) Subscribe() chan uint32 {
+	ch := make(chan uint32, 1)
+	s.clientlock.Lock()
+	s.subscribers[ch] = true
+	s.clientlock.Unlock()
+	return ch
+}
+
+func (s *Server) Unsubscribe(ch chan uint32) {
+	s.clientlock.Lock()
+	delete(s.subscribers, ch)
+	s.clientlock.Unlock()
+}
+
+func (s *Server) NotifySubscribers(serialNumber uint32) {
+	s.clientlock.RLock()
+	defer s.clientlock.RUnlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- serialNumber:
+		default:
+		}
+	}
 }
 
 func (s *Server) SendPDU(pdu PDU) {
@@ -662,14 +1340,22 @@ func (s *Server) SendPDU(pdu PDU) {
 }
 
 func ClientFromConn(tcpconn net.Conn, handler RTRServerEventHandler, simpleHandler RTREventHandler) *Client {
+	transport := TransportPlain
+	if _, ok := tcpconn.(*tls.Conn); ok {
+		transport = TransportTLS
+	}
 	return &Client{
 		tcpconn:       tcpconn,
+		transport:     transport,
 		rd:            tcpconn,
 		wr:            tcpconn,
 		handler:       handler,
 		simpleHandler: simpleHandler,
-		transmits:     make(chan PDU, 256),
-		quit:          make(chan bool),
+		transmits:     make(chan PDU, DefaultSendQueueSize),
+		done:          make(chan struct{}),
+		seriallock:    &sync.RWMutex{},
+		maxversion:    PROTOCOL_VERSION_1,
+		connectedAt:   time.Now(),
 	}
 }
 
@@ -677,22 +1363,58 @@ func ClientFromConnSSH(tcpconn net.Conn, channel ssh.Channel, handler RTRServerE
 	client := ClientFromConn(tcpconn, handler, simpleHandler)
 	client.rd = channel
 	client.wr = channel
+	client.transport = TransportSSH
 	return client
 }
 
+// Transports of the clients, see Client.GetTransport.
+const (
+	TransportPlain = "plain"
+	TransportTLS   = "tls"
+	TransportSSH   = "ssh"
+)
+
 type Client struct {
-	connected     bool
-	version       uint8
-	versionset    bool
+	// Accessed atomically, kept first for 64-bit alignment
+	pdusSent  uint64
+	bytesSent uint64
+
+	version    uint8
+	versionset bool
+	// Highest version supported by the server, the version of the clients is negotiated down to it
+	maxversion    uint8
 	tcpconn       net.Conn
+	transport     string
 	rd            io.Reader
 	wr            io.Writer
 	handler       RTRServerEventHandler
 	simpleHandler RTREventHandler
 	curserial     uint32
+	connectedAt   time.Time
+
+	// Serial of the last End of Data sent
+	seriallock   *sync.RWMutex
+	synced       bool
+	syncedSerial uint32
+
+	// Bounded queue of the PDUs to send, closing done stops the sending
+	transmits    chan PDU
+	done         chan struct{}
+	disconnected sync.Once
+	writeTimeout time.Duration
+
+	// Set by the receiving goroutine once the last PDU is queued, the PDUs received
+	// until the connection is closed are ignored
+	closing bool
 
-	transmits chan PDU
-	quit      chan bool
+	// Disconnects the client when it does not send any query for idleTimeout
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+
+	// Token bucket of the PDUs received, nil for no limit
+	pduRate   float64
+	pduBurst  int
+	pduBucket *sourceBucket
 
 	enforceVersion      bool
 	disableVersionCheck bool
@@ -702,6 +1424,8 @@ type Client struct {
 	expireInterval  uint32
 
 	log Logger
+	// Connection and disconnection are not logged
+	quiet bool
 }
 
 func (c *Client) String() string {
@@ -716,6 +1440,43 @@ func (c *Client) GetLocalAddress() net.Addr {
 	return c.tcpconn.LocalAddr()
 }
 
+// GetTransport returns how the client connected: TransportPlain, TransportTLS or TransportSSH.
+func (c *Client) GetTransport() string {
+	return c.transport
+}
+
+// GetConnectedAt returns when the connection of the client was accepted.
+func (c *Client) GetConnectedAt() time.Time {
+	return c.connectedAt
+}
+
+// GetSyncedSerial returns the serial of the last End of Data sent to the client, if any.
+func (c *Client) GetSyncedSerial() (uint32, bool) {
+	c.seriallock.RLock()
+	defer c.seriallock.RUnlock()
+	return c.syncedSerial, c.synced
+}
+
+// GetPDUsSent returns the number of PDUs sent to the client.
+func (c *Client) GetPDUsSent() uint64 {
+	return atomic.LoadUint64(&c.pdusSent)
+}
+
+// GetBytesSent returns the number of bytes sent to the client.
+func (c *Client) GetBytesSent() uint64 {
+	return atomic.LoadUint64(&c.bytesSent)
+}
+
+// GetTLSConnectionState returns the state of the TLS connection of the client (e.g. its
+// certificate), if it connected over TLS.
+func (c *Client) GetTLSConnectionState() (tls.ConnectionState, bool) {
+	tlsconn, ok := c.tcpconn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return tlsconn.ConnectionState(), true
+}
+
 func (c *Client) GetVersion() uint8 {
 	return c.version
 }
@@ -726,25 +1487,82 @@ func (c *Client) SetIntervals(refreshInterval uint32, retryInterval uint32, expi
 	c.expireInterval = expireInterval
 }
 
+// SetWriteTimeout sets the time a write can take before the client is disconnected, e.g.
+// a router that stopped reading (0 for no limit). It applies to the TCP and TLS connections:
+// the SSH channels have their own flow control.
+func (c *Client) SetWriteTimeout(timeout time.Duration) {
+	c.writeTimeout = timeout
+}
+
+// SetSendQueueSize sets the number of PDUs queued for the client (DefaultSendQueueSize if 0),
+// it must be called before Start. A client with a full queue when it is notified is
+// disconnected, the responses to its queries wait for room in the queue.
+func (c *Client) SetSendQueueSize(size int) {
+	if size <= 0 {
+		size = DefaultSendQueueSize
+	}
+	c.transmits = make(chan PDU, size)
+}
+
+// SetIdleTimeout sets the time after which the client is disconnected if it does not send any
+// Serial Query or Reset Query (0 for no limit), it must be called before Start. A router
+// queries at least once per refresh interval (RFC 8210, section 6): the timeout frees the
+// sessions of the routers that are gone without closing their connection.
+func (c *Client) SetIdleTimeout(timeout time.Duration) {
+	c.idleTimeout = timeout
+}
+
+// SetPDURate sets the PDUs the client can send per second (0 for no limit), with bursts of
+// burst, it must be called before Start. A client over the limit, e.g. sending hundreds of
+// Reset Queries per second, gets an Error Report and is disconnected.
+func (c *Client) SetPDURate(rate float64, burst int) {
+	if rate <= 0 {
+		c.pduBucket = nil
+		return
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	c.pduRate = rate
+	c.pduBurst = burst
+	c.pduBucket = &sourceBucket{tokens: float64(burst), last: time.Now()}
+}
+
+// idle disconnects the client once it did not query for the idle timeout.
+func (c *Client) idle() {
+	if c.log != nil {
+		c.log.Infof("%v: no query for %v, disconnecting", c.String(), c.idleTimeout)
+	}
+	c.Disconnect()
+}
+
 func (c *Client) SetVersion(newversion uint8) {
 	c.versionset = true
 	c.version = newversion
 }
 
+// SetMaxVersion sets the highest version accepted from the client.
+func (c *Client) SetMaxVersion(maxversion uint8) {
+	c.maxversion = maxversion
+}
+
 func (c *Client) SetDisableVersionCheck(disableCheck bool) {
 	c.disableVersionCheck = disableCheck
 }
 
-func (c *Client) checkVersion(newversion uint8) {
-	if (!c.versionset || newversion == c.version) && (newversion == PROTOCOL_VERSION_1 || newversion == PROTOCOL_VERSION_0) {
+// checkVersion negotiates the version with the first PDU of the client (RFC 8210, section 7).
+// A client using a newer version than supported receives an error with the highest version
+// supported, so that it can downgrade. The version cannot change afterwards.
+func (c *Client) checkVersion(newversion uint8) bool {
+	if (!c.versionset || newversion == c.version) && newversion <= c.maxversion {
 		c.SetVersion(newversion)
-	} else {
-		if c.log != nil {
-			c.log.Debugf("%v: has bad version (received: v%v, current: v%v) error", c.String(), newversion, c.version)
-		}
-		c.SendWrongVersionError()
-		c.Disconnect()
+		return true
+	}
+	if c.log != nil {
+		c.log.Debugf("%v: has bad version (received: v%v, current: v%v, supported: v%v) error", c.String(), newversion, c.version, c.maxversion)
 	}
+	c.SendWrongVersionError()
+	return false
 }
 
 func (c *Client) passSimpleHandler(pdu PDU) {
@@ -760,19 +1578,60 @@ func (c *Client) passSimpleHandler(pdu PDU) {
 	}
 }
 
+// closingPDU is the last PDU queued for a client (e.g. a fatal Error Report), the client
+// is disconnected once it is sent.
+type closingPDU struct {
+	PDU
+}
+
 func (c *Client) sendLoop() {
-	for c.connected {
+	for {
 		select {
 		case pdu := <-c.transmits:
-			c.wr.Write(pdu.Bytes())
-		case <-c.quit:
-			break
+			closing, isClosing := pdu.(*closingPDU)
+			if isClosing {
+				pdu = closing.PDU
+			}
+			if c.writeTimeout > 0 && c.tcpconn != nil {
+				c.tcpconn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			}
+			var err error
+			if batch, ok := pdu.(*PDUBatch); ok {
+				err = c.writeBatch(batch)
+			} else {
+				err = c.writePDU(pdu)
+			}
+			if err != nil {
+				if c.log != nil {
+					c.log.Debugf("%v: error sending %v: %v", c.String(), TypeToString(pdu.GetType()), err)
+				}
+				c.Disconnect()
+				return
+			}
+			if isClosing {
+				c.Disconnect()
+				return
+			}
+		case <-c.done:
+			return
 		}
 	}
 }
 
+func (c *Client) writePDU(pdu PDU) error {
+	n, err := writePDU(c.wr, pdu)
+	atomic.AddUint64(&c.pdusSent, 1)
+	atomic.AddUint64(&c.bytesSent, uint64(n))
+	if th, ok := c.handler.(RTRServerTrafficHandler); ok {
+		th.PDUSent(c, pdu, n)
+	}
+	return err
+}
+
 func (c *Client) Start() {
-	c.connected = true
+	if c.idleTimeout > 0 {
+		c.idleTimer = time.AfterFunc(c.idleTimeout, c.idle)
+	}
 	if c.handler != nil {
 		c.handler.ClientConnected(c)
 	}
@@ -780,7 +1639,7 @@ func (c *Client) Start() {
 	go c.sendLoop()
 
 	buf := make([]byte, 8000)
-	for c.connected {
+	for !c.isDisconnected() {
 		// Remove this?
 		length, err := c.rd.Read(buf)
 		if err != nil || length == 0 {
@@ -790,6 +1649,12 @@ func (c *Client) Start() {
 			c.Disconnect()
 			return
 		}
+		if th, ok := c.handler.(RTRServerTrafficHandler); ok {
+			th.BytesReceived(c, length)
+		}
+		if c.closing {
+			continue
+		}
 
 		pkt := buf[0:length]
 		dec, err := DecodeBytes(pkt)
@@ -800,11 +1665,21 @@ func (c *Client) Start() {
 			c.Disconnect()
 			continue
 		}
-		if !c.disableVersionCheck {
-			c.checkVersion(dec.GetVersion())
+		if c.pduBucket != nil && !c.pduBucket.take(time.Now(), c.pduRate, c.pduBurst) {
+			if c.log != nil {
+				c.log.Warnf("%v: more than %v PDUs per second, disconnecting", c.String(), c.pduRate)
+			}
+			c.SendTooManyPDUsError(dec)
+			if fh, ok := c.handler.(RTRServerFloodHandler); ok {
+				fh.PDUFlood(c, dec)
+			}
+			continue
+		}
+		if !c.disableVersionCheck && !c.checkVersion(dec.GetVersion()) {
+			continue
 		}
 		if c.log != nil {
-			c.log.Debugf("%v: Received %v", c.String(), dec)
+			withFields(c.log, Fields{"pdu": TypeToString(dec.GetType())}).Debugf("%v: Received %v", c.String(), dec)
 		}
 
 		if c.enforceVersion {
@@ -813,13 +1688,16 @@ func (c *Client) Start() {
 					c.log.Debugf("Bad version error")
 				}
 				c.SendWrongVersionError()
-				c.Disconnect()
+				continue
 			}
 		}
 
 		switch pduconv := dec.(type) {
 		case *PDUSerialQuery:
 			c.curserial = pduconv.SerialNumber
+			c.resetIdleTimer()
+		case *PDUResetQuery:
+			c.resetIdleTimer()
 		}
 
 		if c.handler != nil {
@@ -830,16 +1708,33 @@ func (c *Client) Start() {
 	}
 }
 
+func (c *Client) resetIdleTimer() {
+	if c.idleTimer != nil {
+		c.idleTimer.Reset(c.idleTimeout)
+	}
+}
+
+// Notify sends a Serial Notify without waiting for room in the queue of the client, so
+// that a slow client does not hold up the others: it is disconnected if its queue is full.
 func (c *Client) Notify(sessionId uint16, serialNumber uint32) {
 	pdu := &PDUSerialNotify{
+		Version:      c.version,
 		SessionId:    sessionId,
 		SerialNumber: serialNumber,
 	}
-	c.SendPDU(pdu)
+	select {
+	case c.transmits <- pdu:
+	case <-c.done:
+	default:
+		if c.log != nil {
+			c.log.Warnf("%v: send queue full (%d PDUs), disconnecting", c.String(), cap(c.transmits))
+		}
+		c.Disconnect()
+	}
 }
 
 type VRP struct {
-	Prefix net.IPNet
+	Prefix netip.Prefix
 	MaxLen uint8
 	ASN    uint32
 	Flags  uint8
@@ -850,24 +1745,84 @@ func (r VRP) String() string {
 }
 
 func (r1 VRP) Equals(r2 VRP) bool {
-	return r1.MaxLen == r2.MaxLen && r1.ASN == r2.ASN && bytes.Equal(r1.Prefix.IP, r2.Prefix.IP) && bytes.Equal(r1.Prefix.Mask, r2.Prefix.Mask)
+	return r1.MaxLen == r2.MaxLen && r1.ASN == r2.ASN && r1.Prefix == r2.Prefix
 }
 
 func (r1 VRP) Copy() VRP {
-	newprefix := net.IPNet{
-		IP:   make([]byte, len(r1.Prefix.IP)),
-		Mask: make([]byte, len(r1.Prefix.Mask)),
-	}
-	copy(newprefix.IP, r1.Prefix.IP)
-	copy(newprefix.Mask, r1.Prefix.Mask)
 	return VRP{
-		Prefix: newprefix,
+		Prefix: r1.Prefix,
 		ASN:    r1.ASN,
 		MaxLen: r1.MaxLen,
 		Flags:  r1.Flags}
 }
 
+// BgpsecKey is a BGPsec router key: the SKI, the ASN and the SubjectPublicKeyInfo
+// of a router certificate.
+type BgpsecKey struct {
+	ASN    uint32
+	SKI    [20]byte
+	Pubkey []byte
+	Flags  uint8
+}
+
+func (r BgpsecKey) String() string {
+	return fmt.Sprintf("Router Key AS%v -> SKI %x, Flags: %v", r.ASN, r.SKI, r.Flags)
+}
+
+func (r1 BgpsecKey) Equals(r2 BgpsecKey) bool {
+	return r1.ASN == r2.ASN && r1.SKI == r2.SKI && bytes.Equal(r1.Pubkey, r2.Pubkey)
+}
+
+func (r1 BgpsecKey) Copy() BgpsecKey {
+	pubkey := make([]byte, len(r1.Pubkey))
+	copy(pubkey, r1.Pubkey)
+	return BgpsecKey{
+		ASN:    r1.ASN,
+		SKI:    r1.SKI,
+		Pubkey: pubkey,
+		Flags:  r1.Flags}
+}
+
+// ASPA lists the providers of a customer AS. Flags is FLAG_REMOVED for a withdrawal.
+type ASPA struct {
+	CustomerASN uint32
+	Providers   []uint32
+	Flags       uint8
+}
+
+func (r ASPA) String() string {
+	return fmt.Sprintf("ASPA AS%v -> %v, Flags: %v", r.CustomerASN, r.Providers, r.Flags)
+}
+
+func (r1 ASPA) Equals(r2 ASPA) bool {
+	if r1.CustomerASN != r2.CustomerASN || len(r1.Providers) != len(r2.Providers) {
+		return false
+	}
+	for i := range r1.Providers {
+		if r1.Providers[i] != r2.Providers[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r1 ASPA) Copy() ASPA {
+	providers := make([]uint32, len(r1.Providers))
+	copy(providers, r1.Providers)
+	return ASPA{
+		CustomerASN: r1.CustomerASN,
+		Providers:   providers,
+		Flags:       r1.Flags}
+}
+
 func (c *Client) SendVRPs(sessionId uint16, serialNumber uint32, vrps []VRP) {
+	c.SendData(sessionId, serialNumber, vrps, nil, nil)
+}
+
+// SendData sends the VRPs, the router keys and the ASPAs between a Cache Response and
+// an End of Data. The router keys and the ASPAs are only sent to the clients using a
+// version supporting them.
+func (c *Client) SendData(sessionId uint16, serialNumber uint32, vrps []VRP, keys []BgpsecKey, aspas []ASPA) {
 	pduBegin := &PDUCacheResponse{
 		SessionId: sessionId,
 	}
@@ -875,6 +1830,17 @@ func (c *Client) SendVRPs(sessionId uint16, serialNumber uint32, vrps []VRP) {
 	for _, vrp := range vrps {
 		c.SendVRP(vrp)
 	}
+	for _, key := range keys {
+		c.SendBgpsecKey(key)
+	}
+	for _, aspa := range aspas {
+		c.SendASPA(aspa)
+	}
+	c.sendEndOfData(sessionId, serialNumber)
+}
+
+// sendEndOfData ends the data sent to the client, which is then synced to the serial.
+func (c *Client) sendEndOfData(sessionId uint16, serialNumber uint32) {
 	pduEnd := &PDUEndOfData{
 		SessionId:    sessionId,
 		SerialNumber: serialNumber,
@@ -884,6 +1850,18 @@ func (c *Client) SendVRPs(sessionId uint16, serialNumber uint32, vrps []VRP) {
 		ExpireInterval:  c.expireInterval,
 	}
 	c.SendPDU(pduEnd)
+
+	c.seriallock.Lock()
+	c.synced = true
+	c.syncedSerial = serialNumber
+	c.seriallock.Unlock()
+}
+
+// IsSynced returns whether the client received the data up to a serial.
+func (c *Client) IsSynced(serialNumber uint32) bool {
+	c.seriallock.RLock()
+	defer c.seriallock.RUnlock()
+	return c.synced && c.syncedSerial == serialNumber
 }
 
 func (c *Client) SendCacheReset() {
@@ -907,56 +1885,142 @@ func (c *Client) SendNoDataError() {
 	c.SendPDU(pdu)
 }
 
+// SendWrongVersionError reports the version of the session, or the highest version supported
+// before it is negotiated. The error is the last PDU sent: the client is disconnected once
+// it is written.
 func (c *Client) SendWrongVersionError() {
 	pdu := &PDUErrorReport{
 		ErrorCode: PDU_ERROR_BADPROTOVERSION,
 		ErrorMsg:  "Bad protocol version",
 	}
-	c.SendPDU(pdu)
+	version := c.version
+	if !c.versionset {
+		version = c.maxversion
+	}
+	pdu.SetVersion(version)
+	c.sendAndDisconnect(pdu)
+}
+
+// SendTooManyPDUsError reports a PDU over the rate allowed. The error is the last PDU
+// sent: the client is disconnected once it is written.
+func (c *Client) SendTooManyPDUsError(pdu PDU) {
+	errpdu := &PDUErrorReport{
+		Version:   c.version,
+		ErrorCode: PDU_ERROR_INVALIDREQUEST,
+		PDUCopy:   pdu.Bytes(),
+		ErrorMsg:  "Too many PDUs",
+	}
+	c.sendAndDisconnect(errpdu)
 }
 
 func (c *Client) SendVRP(vrp VRP) {
-	if vrp.Prefix.IP.To4() == nil && vrp.Prefix.IP.To16() != nil {
-		pdu := &PDUIPv6Prefix{
+	if pdu := vrpPDU(vrp); pdu != nil {
+		c.SendPDU(pdu)
+	}
+}
+
+// vrpPDU returns the IPv4 or IPv6 Prefix PDU of a VRP, nil if its prefix is not valid.
+func vrpPDU(vrp VRP) PDU {
+	if vrp.Prefix.Addr().Is6() {
+		return &PDUIPv6Prefix{
 			Flags:  vrp.Flags,
 			MaxLen: vrp.MaxLen,
 			ASN:    vrp.ASN,
 			Prefix: vrp.Prefix,
 		}
-		c.SendPDU(pdu)
-	} else if vrp.Prefix.IP.To4() != nil {
-		pdu := &PDUIPv4Prefix{
+	} else if vrp.Prefix.Addr().Is4() {
+		return &PDUIPv4Prefix{
 			Flags:  vrp.Flags,
 			MaxLen: vrp.MaxLen,
 			ASN:    vrp.ASN,
 			Prefix: vrp.Prefix,
 		}
-		c.SendPDU(pdu)
+	}
+	return nil
+}
+
+func (c *Client) SendBgpsecKey(key BgpsecKey) {
+	c.SendPDU(bgpsecKeyPDU(key))
+}
+
+func bgpsecKeyPDU(key BgpsecKey) PDU {
+	return &PDURouterKey{
+		Flags:                key.Flags,
+		SubjectKeyIdentifier: key.SKI,
+		ASN:                  key.ASN,
+		SubjectPublicKeyInfo: key.Pubkey,
 	}
 }
 
+func (c *Client) SendASPA(aspa ASPA) {
+	c.SendPDU(aspaPDU(aspa))
+}
+
+// aspaPDU returns the ASPA PDU of an ASPA, the providers are only sent when it is added.
+func aspaPDU(aspa ASPA) PDU {
+	pdu := &PDUASPA{
+		Flags:       aspa.Flags,
+		CustomerASN: aspa.CustomerASN,
+	}
+	if aspa.Flags == FLAG_ADDED {
+		pdu.Providers = aspa.Providers
+	}
+	return pdu
+}
+
+// SendRawPDU queues a PDU, waiting for room in the queue unless the client is disconnected.
 func (c *Client) SendRawPDU(pdu PDU) {
-	//c.tcpconn.Write(pdu.Bytes())
-	c.transmits <- pdu
+	select {
+	case c.transmits <- pdu:
+	case <-c.done:
+	}
+}
+
+// sendAndDisconnect queues the last PDU of the client, it is disconnected once the PDU is
+// sent. It is called by the receiving goroutine, which ignores the next PDUs.
+func (c *Client) sendAndDisconnect(pdu PDU) {
+	c.closing = true
+	c.SendRawPDU(&closingPDU{PDU: pdu})
 }
 
+// SendPDU sends a PDU with the version of the client. The types of PDU the version
+// does not support (Router Keys for version 0, ASPAs before version 2) are not sent.
 func (c *Client) SendPDU(pdu PDU) {
+	if !IsCorrectPDUVersion(pdu, c.version) {
+		return
+	}
 	pdu.SetVersion(c.version)
 	c.SendRawPDU(pdu)
 }
 
-func (c *Client) Disconnect() {
-	c.connected = false
-	if c.log != nil {
-		c.log.Infof("Disconnecting client %v", c.String())
-	}
-	if c.handler != nil {
-		c.handler.ClientDisconnected(c)
-	}
+// isDisconnected returns whether Disconnect was called, it is safe to call from any
+// goroutine (e.g. while the idle timer disconnects the client).
+func (c *Client) isDisconnected() bool {
 	select {
-	case c.quit <- true:
+	case <-c.done:
+		return true
 	default:
+		return false
 	}
+}
+
+// Disconnect closes the connection of the client, it can be called more than once (e.g. by
+// the sending and the receiving goroutines).
+func (c *Client) Disconnect() {
+	c.disconnected.Do(func() {
+		if c.log != nil && !c.quiet {
+			c.log.Infof("Disconnecting client %v", c.String())
+		}
+		if c.handler != nil {
+			c.handler.ClientDisconnected(c)
+		}
+		close(c.done)
+		if c.idleTimer != nil {
+			c.idleTimer.Stop()
+		}
 
-	c.tcpconn.Close()
+		if c.tcpconn != nil {
+			c.tcpconn.Close()
+		}
+	})
 }
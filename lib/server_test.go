@@ -1,9 +1,20 @@
 package rtrlib
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/binary"
+	"io"
+	"math/big"
 	"net"
+	"net/netip"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -11,13 +22,10 @@ import (
 func GenerateVrps(size uint32, offset uint32) []VRP {
 	vrps := make([]VRP, size)
 	for i := uint32(0); i < size; i++ {
-		ipFinal := make([]byte, 4)
-		binary.BigEndian.PutUint32(ipFinal, i+offset)
+		ip := [16]byte{0xfd}
+		binary.BigEndian.PutUint32(ip[12:], i+offset)
 		vrps[i] = VRP{
-			Prefix: net.IPNet{
-				IP:   net.IP(append([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, ipFinal...)),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16(ip), 128),
 			MaxLen: 128,
 			ASN:    64496,
 		}
@@ -48,36 +56,24 @@ func BenchmarkComputeDiff100000x1(b *testing.B) {
 func TestComputeDiff(t *testing.T) {
 	newVrps := []VRP{
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x3}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x3}), 128),
 			MaxLen: 128,
 			ASN:    65003,
 		},
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2}), 128),
 			MaxLen: 128,
 			ASN:    65002,
 		},
 	}
 	prevVrps := []VRP{
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1}), 128),
 			MaxLen: 128,
 			ASN:    65001,
 		},
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2}), 128),
 			MaxLen: 128,
 			ASN:    65002,
 		},
@@ -94,46 +90,31 @@ func TestComputeDiff(t *testing.T) {
 func TestApplyDiff(t *testing.T) {
 	diff := []VRP{
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x3}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x3}), 128),
 			MaxLen: 128,
 			ASN:    65003,
 			Flags:  FLAG_ADDED,
 		},
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2}), 128),
 			MaxLen: 128,
 			ASN:    65002,
 			Flags:  FLAG_REMOVED,
 		},
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x4}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x4}), 128),
 			MaxLen: 128,
 			ASN:    65004,
 			Flags:  FLAG_REMOVED,
 		},
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x6}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x6}), 128),
 			MaxLen: 128,
 			ASN:    65006,
 			Flags:  FLAG_REMOVED,
 		},
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x7}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x7}), 128),
 			MaxLen: 128,
 			ASN:    65007,
 			Flags:  FLAG_ADDED,
@@ -141,46 +122,31 @@ func TestApplyDiff(t *testing.T) {
 	}
 	prevVrps := []VRP{
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1}), 128),
 			MaxLen: 128,
 			ASN:    65001,
 			Flags:  FLAG_ADDED,
 		},
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x2}), 128),
 			MaxLen: 128,
 			ASN:    65002,
 			Flags:  FLAG_ADDED,
 		},
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x5}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x5}), 128),
 			MaxLen: 128,
 			ASN:    65005,
 			Flags:  FLAG_REMOVED,
 		},
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x6}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x6}), 128),
 			MaxLen: 128,
 			ASN:    65006,
 			Flags:  FLAG_REMOVED,
 		},
 		{
-			Prefix: net.IPNet{
-				IP:   net.IP([]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x7}),
-				Mask: net.IPMask([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}),
-			},
+			Prefix: netip.PrefixFrom(netip.AddrFrom16([16]byte{0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x7}), 128),
 			MaxLen: 128,
 			ASN:    65007,
 			Flags:  FLAG_REMOVED,
@@ -202,3 +168,961 @@ func TestApplyDiff(t *testing.T) {
 	assert.Equal(t, vrps[5].ASN, uint32(65007))
 	assert.Equal(t, vrps[5].Flags, uint8(FLAG_ADDED))
 }
+
+func TestConnLogSampler(t *testing.T) {
+	addr := func(s string) net.Addr {
+		a, _ := net.ResolveTCPAddr("tcp", s)
+		return a
+	}
+	now := time.Now()
+
+	var all *connLogSampler
+	assert.True(t, all.Sample(addr("192.0.2.1:1000"), now))
+
+	rate := newConnLogSampler(3, 0)
+	var logged int
+	for i := 0; i < 9; i++ {
+		if rate.Sample(addr("192.0.2.1:1000"), now) {
+			logged++
+		}
+	}
+	assert.Equal(t, 3, logged)
+
+	window := newConnLogSampler(0, time.Minute)
+	assert.True(t, window.Sample(addr("192.0.2.1:1000"), now))
+	assert.False(t, window.Sample(addr("192.0.2.1:1001"), now.Add(time.Second)))
+	assert.True(t, window.Sample(addr("192.0.2.2:1000"), now.Add(time.Second)))
+	assert.True(t, window.Sample(addr("192.0.2.1:1002"), now.Add(time.Minute)))
+}
+
+func TestSourceLimiter(t *testing.T) {
+	addr := func(s string) net.Addr {
+		a, _ := net.ResolveTCPAddr("tcp", s)
+		return a
+	}
+	now := time.Now()
+
+	assert.Nil(t, newSourceLimiter(SourceLimits{}))
+
+	l := newSourceLimiter(SourceLimits{MaxPerAddress: 2, MaxPerSubnet: 3})
+	assert.NoError(t, l.Acquire(addr("192.0.2.1:1000"), now))
+	assert.NoError(t, l.Acquire(addr("192.0.2.1:1001"), now))
+	assert.Error(t, l.Acquire(addr("192.0.2.1:1002"), now))
+	assert.NoError(t, l.Acquire(addr("192.0.2.2:1000"), now))
+	// The /24 is full
+	assert.Error(t, l.Acquire(addr("192.0.2.3:1000"), now))
+	assert.NoError(t, l.Acquire(addr("198.51.100.1:1000"), now))
+	l.Release(addr("192.0.2.1:1000"))
+	assert.NoError(t, l.Acquire(addr("192.0.2.3:1000"), now))
+	assert.NoError(t, l.Acquire(addr("[2001:db8::1]:1000"), now))
+	assert.NoError(t, l.Acquire(addr("[2001:db8::2]:1000"), now))
+	assert.NoError(t, l.Acquire(addr("[2001:db8::3]:1000"), now))
+	// The /48 is full
+	assert.Error(t, l.Acquire(addr("[2001:db8::4]:1000"), now))
+
+	rate := newSourceLimiter(SourceLimits{Rate: 1, Burst: 2})
+	assert.NoError(t, rate.Acquire(addr("192.0.2.1:1000"), now))
+	assert.NoError(t, rate.Acquire(addr("192.0.2.1:1001"), now))
+	assert.Error(t, rate.Acquire(addr("192.0.2.1:1002"), now))
+	assert.NoError(t, rate.Acquire(addr("192.0.2.2:1000"), now))
+	assert.NoError(t, rate.Acquire(addr("192.0.2.1:1003"), now.Add(time.Second)))
+	assert.Error(t, rate.Acquire(addr("192.0.2.1:1004"), now.Add(time.Second)))
+	// Pruned once refilled
+	rate.Acquire(addr("192.0.2.3:1000"), now.Add(time.Minute))
+	assert.Len(t, rate.buckets, 1)
+}
+
+func TestServeSourceLimits(t *testing.T) {
+	s := newTestServer(PROTOCOL_VERSION_1, GenerateVrps(3, 0))
+	s.sourceLimiter = newSourceLimiter(SourceLimits{MaxPerAddress: 1})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go s.Serve(listener)
+
+	query := func() (net.Conn, error) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		conn.Write((&PDUResetQuery{}).Bytes())
+		_, err = Decode(conn)
+		return conn, err
+	}
+
+	first, err := query()
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = query()
+	assert.Error(t, err)
+
+	// Released when the first client disconnects
+	first.Close()
+	assert.Eventually(t, func() bool {
+		conn, err := query()
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestGetDebugState(t *testing.T) {
+	s := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 10}, nil, nil)
+	vrps := GenerateVrps(3, 0)
+
+	s.AddVRPs(vrps[0:2])
+	s.AddVRPs(vrps[1:3])
+
+	state := s.GetDebugState()
+	assert.Equal(t, uint16(10), state.SessionId)
+	assert.Equal(t, uint32(1), state.Serial)
+	assert.True(t, state.Valid)
+	assert.Equal(t, []uint32{0}, state.Serials)
+
+	added := make(map[string]uint32)
+	for _, vrp := range state.VRPs {
+		added[vrp.HashKey()] = vrp.SerialAdded
+	}
+	assert.Equal(t, map[string]uint32{
+		vrps[1].HashKey(): 0,
+		vrps[2].HashKey(): 1,
+	}, added)
+}
+
+func TestKeepDifference(t *testing.T) {
+	// checkDiffs checks the serials the changes are kept from, and the changes from them
+	checkDiffs := func(s *Server, count uint32, serials []uint32) {
+		assert.Equal(t, serials, s.GetDebugState().Serials)
+		for _, serial := range serials {
+			diff, ok := s.GetVRPsSerialDiff(serial)
+			assert.True(t, ok)
+			// Each serial adds a VRP
+			assert.Len(t, diff, int(count-serial-1), serial)
+		}
+		_, ok := s.GetVRPsSerialDiff(serials[0] - 1)
+		assert.False(t, ok)
+	}
+
+	s := NewServer(ServerConfiguration{KeepDifference: 5, SessId: 10}, nil, nil)
+	for i := uint32(1); i <= 8; i++ {
+		s.AddVRPs(GenerateVrps(i, 0))
+	}
+	checkDiffs(s, 8, []uint32{2, 3, 4, 5, 6})
+
+	s.SetKeepDifference(2)
+	assert.Equal(t, 2, s.GetKeepDifference())
+	checkDiffs(s, 8, []uint32{5, 6})
+	s.AddVRPs(GenerateVrps(9, 0))
+	checkDiffs(s, 9, []uint32{6, 7})
+
+	s.SetKeepDifference(4)
+	s.AddVRPs(GenerateVrps(10, 0))
+	s.AddVRPs(GenerateVrps(11, 0))
+	s.AddVRPs(GenerateVrps(12, 0))
+	checkDiffs(s, 12, []uint32{7, 8, 9, 10})
+}
+
+func TestAddDataSerial(t *testing.T) {
+	vrps := GenerateVrps(3, 0)
+	s := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 10}, nil, nil)
+	s.AddDataSerial(vrps[0:1], nil, nil, 100)
+	serial, valid := s.GetCurrentSerial(10)
+	assert.True(t, valid)
+	assert.Equal(t, uint32(100), serial)
+
+	s.AddDataSerial(vrps, nil, nil, 105)
+	s.AddData(vrps[0:2], nil, nil)
+	assert.Equal(t, []uint32{0, 100, 105}, s.GetDebugState().Serials)
+	serial, _ = s.GetCurrentSerial(10)
+	assert.Equal(t, uint32(106), serial)
+	diff, ok := s.GetVRPsSerialDiff(100)
+	assert.True(t, ok)
+	assert.Len(t, diff, 1)
+	diff, ok = s.GetVRPsSerialDiff(105)
+	assert.True(t, ok)
+	assert.Len(t, diff, 1)
+	assert.Equal(t, uint8(FLAG_REMOVED), diff[0].Flags)
+}
+
+func TestGetDataSerialDiffCurrent(t *testing.T) {
+	vrps := GenerateVrps(2, 0)
+	aspas := []ASPA{{CustomerASN: 64496, Providers: []uint32{64500}}}
+	s := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 10}, nil, nil)
+	s.AddData(vrps[0:1], nil, nil)
+	first, _ := s.GetCurrentSerial(10)
+	s.AddData(vrps, nil, aspas)
+
+	curVrps, keys, curAspas, serial, valid := s.GetCurrentDataSerial()
+	assert.True(t, valid)
+	assert.Equal(t, first+1, serial)
+	assert.Len(t, curVrps, 2)
+	assert.Empty(t, keys)
+	assert.Equal(t, []ASPA{{CustomerASN: 64496, Providers: []uint32{64500}, Flags: FLAG_ADDED}}, curAspas)
+
+	diffVrps, diffKeys, diffAspas, current, ok := s.GetDataSerialDiffCurrent(first)
+	assert.True(t, ok)
+	assert.Equal(t, serial, current)
+	assert.Len(t, diffVrps, 1)
+	assert.Empty(t, diffKeys)
+	assert.Equal(t, []ASPA{{CustomerASN: 64496, Providers: []uint32{64500}, Flags: FLAG_ADDED}}, diffAspas)
+
+	_, _, diffAspas, _, ok = s.GetDataSerialDiffCurrent(serial)
+	assert.True(t, ok)
+	assert.Empty(t, diffAspas)
+	_, _, _, _, ok = s.GetDataSerialDiffCurrent(first + 10)
+	assert.False(t, ok)
+}
+
+func TestResumeSession(t *testing.T) {
+	vrps := GenerateVrps(3, 0)
+	aspas := []ASPA{{CustomerASN: 64496, Providers: []uint32{64501, 64500}}}
+	s := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 10}, nil, nil)
+	s.AddData(vrps[0:1], nil, nil)
+	s.AddData(vrps, nil, aspas)
+	serial, _ := s.GetCurrentSerial(10)
+	hash := s.GetDataHash()
+
+	// Identical data, in another order
+	same := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 20}, nil, nil)
+	same.ResumeSession(10, serial, hash)
+	same.AddData([]VRP{vrps[2], vrps[0], vrps[1]}, nil, []ASPA{{CustomerASN: 64496, Providers: []uint32{64500, 64501}}})
+	assert.Equal(t, uint16(10), same.GetSessionId())
+	resumed, valid := same.GetCurrentSerial(10)
+	assert.True(t, valid)
+	assert.Equal(t, serial, resumed)
+	same.AddData(vrps[0:2], nil, aspas)
+	resumed, _ = same.GetCurrentSerial(10)
+	assert.Equal(t, serial+1, resumed)
+
+	different := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 20}, nil, nil)
+	different.ResumeSession(10, serial, hash)
+	different.AddData(vrps, nil, nil)
+	resumed, _ = different.GetCurrentSerial(10)
+	assert.Equal(t, serial+1, resumed)
+}
+
+func TestNotifyClientsSkipsSynced(t *testing.T) {
+	s := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 10}, nil, nil)
+	s.AddVRPs(GenerateVrps(3, 0))
+
+	synced := ClientFromConn(nil, nil, nil)
+	behind := ClientFromConn(nil, nil, nil)
+	s.ClientConnected(synced)
+	s.ClientConnected(behind)
+
+	vrps, serial, _ := s.GetCurrentVRPsSerial()
+	synced.SendVRPs(10, serial, vrps)
+	assert.True(t, synced.IsSynced(serial))
+	assert.False(t, behind.IsSynced(serial))
+	for len(synced.transmits) > 0 {
+		<-synced.transmits
+	}
+
+	s.NotifyClients(serial)
+	assert.Len(t, synced.transmits, 0)
+	assert.Len(t, behind.transmits, 1)
+	assert.Equal(t, uint64(1), s.GetNotificationsSkipped())
+
+	s.AddVRPs(GenerateVrps(4, 0))
+	s.NotifyClientsLatest()
+	assert.Len(t, synced.transmits, 1)
+	assert.Equal(t, uint64(1), s.GetNotificationsSkipped())
+}
+
+// startTestServer accepts TCP connections on a local port until the end of the test.
+func startTestServer(t *testing.T, s *Server) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go s.loopTCP(listener, "tcp", s.acceptClientTCP)
+	return listener.Addr().String()
+}
+
+// exchange sends a query and returns the PDUs received until the End of Data, an error or the disconnection.
+func exchange(t *testing.T, addr string, query PDU) []PDU {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write(query.Bytes())
+
+	var pdus []PDU
+	for {
+		pdu, err := Decode(conn)
+		if err != nil {
+			return pdus
+		}
+		pdus = append(pdus, pdu)
+		switch pdu.(type) {
+		case *PDUEndOfData, *PDUErrorReport:
+			return pdus
+		}
+	}
+}
+
+func newTestServer(protocolVersion uint8, vrps []VRP) *Server {
+	deh := &DefaultRTREventHandler{}
+	s := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 10, ProtocolVersion: protocolVersion}, nil, deh)
+	deh.SetVRPManager(s)
+	s.AddVRPs(vrps)
+	return s
+}
+
+func TestViewSelector(t *testing.T) {
+	s := newTestServer(PROTOCOL_VERSION_1, GenerateVrps(3, 0))
+	view := newTestServer(PROTOCOL_VERSION_1, GenerateVrps(1, 0))
+	s.SetViewSelector(func(addr net.Addr) *Server {
+		if addr.(*net.TCPAddr).IP.IsLoopback() {
+			return view
+		}
+		return nil
+	})
+	addr := startTestServer(t, s)
+
+	var prefixes int
+	for _, pdu := range exchange(t, addr, &PDUResetQuery{}) {
+		if _, ok := pdu.(*PDUIPv6Prefix); ok {
+			prefixes++
+		}
+	}
+	assert.Equal(t, 1, prefixes)
+	assert.Len(t, view.GetClientList(), 1)
+	assert.Len(t, s.GetClientList(), 0)
+}
+
+func TestVersionNegotiation(t *testing.T) {
+	tests := []struct {
+		Name          string
+		ServerVersion uint8
+		ClientVersion uint8
+		// Version of the response, the End of Data or the error
+		Version uint8
+		Error   bool
+	}{
+		{Name: "v0 client, v1 server", ServerVersion: PROTOCOL_VERSION_1, ClientVersion: PROTOCOL_VERSION_0, Version: PROTOCOL_VERSION_0},
+		{Name: "v1 client, v1 server", ServerVersion: PROTOCOL_VERSION_1, ClientVersion: PROTOCOL_VERSION_1, Version: PROTOCOL_VERSION_1},
+		{Name: "v0 client, v0 server", ServerVersion: PROTOCOL_VERSION_0, ClientVersion: PROTOCOL_VERSION_0, Version: PROTOCOL_VERSION_0},
+		{Name: "v1 client, v0 server", ServerVersion: PROTOCOL_VERSION_0, ClientVersion: PROTOCOL_VERSION_1, Version: PROTOCOL_VERSION_0, Error: true},
+		{Name: "v2 client, v1 server", ServerVersion: PROTOCOL_VERSION_1, ClientVersion: PROTOCOL_VERSION_2, Version: PROTOCOL_VERSION_1, Error: true},
+		{Name: "v0 client, v2 server", ServerVersion: PROTOCOL_VERSION_2, ClientVersion: PROTOCOL_VERSION_0, Version: PROTOCOL_VERSION_0},
+		{Name: "v1 client, v2 server", ServerVersion: PROTOCOL_VERSION_2, ClientVersion: PROTOCOL_VERSION_1, Version: PROTOCOL_VERSION_1},
+		{Name: "v2 client, v2 server", ServerVersion: PROTOCOL_VERSION_2, ClientVersion: PROTOCOL_VERSION_2, Version: PROTOCOL_VERSION_2},
+		{Name: "v3 client, v2 server", ServerVersion: PROTOCOL_VERSION_2, ClientVersion: 3, Version: PROTOCOL_VERSION_2, Error: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			addr := startTestServer(t, newTestServer(test.ServerVersion, GenerateVrps(2, 0)))
+			pdus := exchange(t, addr, &PDUResetQuery{Version: test.ClientVersion})
+			if !assert.NotEmpty(t, pdus) {
+				return
+			}
+			last := pdus[len(pdus)-1]
+			if test.Error {
+				assert.IsType(t, &PDUErrorReport{}, last)
+				assert.Len(t, pdus, 1)
+			} else {
+				assert.IsType(t, &PDUEndOfData{}, last)
+				assert.Len(t, pdus, 4)
+			}
+			for _, pdu := range pdus {
+				assert.Equal(t, test.Version, pdu.GetVersion())
+			}
+		})
+	}
+}
+
+func TestMixedVersions(t *testing.T) {
+	addr := startTestServer(t, newTestServer(PROTOCOL_VERSION_2, GenerateVrps(2, 0)))
+
+	// Sessions of all the versions are served concurrently by the same server
+	var wg sync.WaitGroup
+	for i := 0; i < 9; i++ {
+		version := uint8(i % 3)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pdus := exchange(t, addr, &PDUResetQuery{Version: version})
+			if assert.Len(t, pdus, 4) {
+				assert.IsType(t, &PDUEndOfData{}, pdus[3])
+			}
+			for _, pdu := range pdus {
+				assert.Equal(t, version, pdu.GetVersion())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClientDowngrade(t *testing.T) {
+	addr := startTestServer(t, newTestServer(PROTOCOL_VERSION_0, GenerateVrps(1, 0)))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := NewClientSession(ClientConfiguration{ProtocolVersion: PROTOCOL_VERSION_2}, nil)
+	done := make(chan struct{})
+	go func() {
+		cs.StartWithConn(conn)
+		close(done)
+	}()
+	cs.SendResetQuery()
+	<-done
+	assert.Equal(t, uint8(PROTOCOL_VERSION_0), cs.version)
+}
+
+func TestVersionChange(t *testing.T) {
+	addr := startTestServer(t, newTestServer(PROTOCOL_VERSION_1, GenerateVrps(1, 0)))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write((&PDUResetQuery{Version: PROTOCOL_VERSION_0}).Bytes())
+	for {
+		pdu, err := Decode(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := pdu.(*PDUEndOfData); ok {
+			break
+		}
+	}
+
+	conn.Write((&PDUResetQuery{Version: PROTOCOL_VERSION_1}).Bytes())
+	pdu, err := Decode(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.IsType(t, &PDUErrorReport{}, pdu)
+	assert.Equal(t, uint8(PROTOCOL_VERSION_0), pdu.GetVersion())
+}
+
+func TestSendPDUVersion(t *testing.T) {
+	c := ClientFromConn(nil, nil, nil)
+	c.SetVersion(PROTOCOL_VERSION_0)
+	c.SendPDU(&PDURouterKey{ASN: 64496})
+	assert.Len(t, c.transmits, 0)
+
+	c.SetVersion(PROTOCOL_VERSION_1)
+	c.SendPDU(&PDURouterKey{ASN: 64496})
+	assert.Len(t, c.transmits, 1)
+}
+
+func TestClientSent(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c := ClientFromConn(conn, nil, nil)
+	c.SetVersion(PROTOCOL_VERSION_1)
+	go c.sendLoop()
+	defer c.Disconnect()
+
+	c.Notify(10, 1)
+	pdu, err := Decode(peer)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.IsType(t, &PDUSerialNotify{}, pdu)
+	assert.Eventually(t, func() bool { return c.GetPDUsSent() == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(len(pdu.Bytes())), c.GetBytesSent())
+}
+
+func TestNotifyQueueFull(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c := ClientFromConn(conn, nil, nil)
+	c.SetSendQueueSize(2)
+
+	c.Notify(10, 1)
+	c.Notify(10, 2)
+	assert.False(t, c.isDisconnected())
+	// The client is not reading: it is disconnected instead of blocking the notification
+	c.Notify(10, 3)
+	assert.True(t, c.isDisconnected())
+
+	// Nothing is queued anymore and Disconnect can be called again
+	c.SendPDU(&PDUCacheReset{})
+	c.Disconnect()
+	assert.Len(t, c.transmits, 2)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c := ClientFromConn(conn, nil, nil)
+	c.SetWriteTimeout(50 * time.Millisecond)
+	go c.sendLoop()
+
+	// The peer does not read the PDU
+	c.Notify(10, 1)
+	assert.Eventually(t, c.isDisconnected, time.Second, 10*time.Millisecond)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	deh := &DefaultRTREventHandler{}
+	s := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 10, ProtocolVersion: PROTOCOL_VERSION_1, IdleTimeout: 200 * time.Millisecond}, nil, deh)
+	deh.SetVRPManager(s)
+	s.AddVRPs(GenerateVrps(1, 0))
+	addr := startTestServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The queries keep the session open past the timeout
+	for i := 0; i < 3; i++ {
+		conn.Write((&PDUSerialQuery{Version: PROTOCOL_VERSION_1, SessionId: 10, SerialNumber: 0}).Bytes())
+		for {
+			pdu, err := Decode(conn)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if _, ok := pdu.(*PDUEndOfData); ok {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// The session is closed once the client stops querying
+	start := time.Now()
+	_, err = Decode(conn)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+// floodHandler counts the clients disconnected for sending too many PDUs.
+type floodHandler struct {
+	lock   sync.Mutex
+	floods int
+}
+
+func (h *floodHandler) ClientConnected(c *Client)    {}
+func (h *floodHandler) ClientDisconnected(c *Client) {}
+func (h *floodHandler) HandlePDU(c *Client, pdu PDU) {}
+
+func (h *floodHandler) PDUFlood(c *Client, pdu PDU) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.floods++
+}
+
+func TestPDUFlood(t *testing.T) {
+	handler := &floodHandler{}
+	deh := &DefaultRTREventHandler{}
+	s := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 10, ProtocolVersion: PROTOCOL_VERSION_1, PDURate: 0.1, PDUBurst: 2}, handler, deh)
+	deh.SetVRPManager(s)
+	s.AddVRPs(GenerateVrps(1, 0))
+	addr := startTestServer(t, s)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	query := &PDUResetQuery{Version: PROTOCOL_VERSION_1}
+	readResponse := func() PDU {
+		for {
+			pdu, err := Decode(conn)
+			if err != nil {
+				return nil
+			}
+			switch pdu.(type) {
+			case *PDUEndOfData, *PDUErrorReport:
+				return pdu
+			}
+		}
+	}
+	// The burst is answered, the query over it gets an error and the session is closed
+	for i := 0; i < 2; i++ {
+		conn.Write(query.Bytes())
+		assert.IsType(t, &PDUEndOfData{}, readResponse())
+	}
+	conn.Write(query.Bytes())
+	pdu := readResponse()
+	if assert.IsType(t, &PDUErrorReport{}, pdu) {
+		assert.Equal(t, uint16(PDU_ERROR_INVALIDREQUEST), pdu.(*PDUErrorReport).ErrorCode)
+		assert.Equal(t, query.Bytes(), pdu.(*PDUErrorReport).PDUCopy)
+	}
+	_, err = Decode(conn)
+	assert.Error(t, err)
+
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	assert.Equal(t, 1, handler.floods)
+}
+
+// trafficHandler counts the PDUs sent and the bytes received by the clients.
+type trafficHandler struct {
+	lock     sync.Mutex
+	sent     map[uint8]int
+	received int
+}
+
+func (h *trafficHandler) ClientConnected(c *Client)    {}
+func (h *trafficHandler) ClientDisconnected(c *Client) {}
+func (h *trafficHandler) HandlePDU(c *Client, pdu PDU) {}
+
+func (h *trafficHandler) PDUSent(c *Client, pdu PDU, length int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.sent[pdu.GetType()]++
+}
+
+func (h *trafficHandler) BytesReceived(c *Client, length int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.received += length
+}
+
+func TestTrafficHandler(t *testing.T) {
+	handler := &trafficHandler{sent: make(map[uint8]int)}
+	deh := &DefaultRTREventHandler{}
+	s := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 10, ProtocolVersion: PROTOCOL_VERSION_1}, handler, deh)
+	deh.SetVRPManager(s)
+	s.AddVRPs(GenerateVrps(2, 0))
+	addr := startTestServer(t, s)
+
+	query := &PDUResetQuery{Version: PROTOCOL_VERSION_1}
+	assert.Len(t, exchange(t, addr, query), 4)
+	assert.Eventually(t, func() bool {
+		handler.lock.Lock()
+		defer handler.lock.Unlock()
+		return handler.sent[PDU_ID_END_OF_DATA] == 1
+	}, time.Second, 10*time.Millisecond)
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	assert.Equal(t, map[uint8]int{PDU_ID_CACHE_RESPONSE: 1, PDU_ID_IPV6_PREFIX: 2, PDU_ID_END_OF_DATA: 1}, handler.sent)
+	assert.Equal(t, len(query.Bytes()), handler.received)
+}
+
+func TestComputeASPADiff(t *testing.T) {
+	prevAspas := []ASPA{
+		{CustomerASN: 64496, Providers: []uint32{64500}},
+		{CustomerASN: 64497, Providers: []uint32{64500}},
+		{CustomerASN: 64498, Providers: []uint32{64500, 64501}},
+	}
+	newAspas := []ASPA{
+		{CustomerASN: 64496, Providers: []uint32{64500}},
+		{CustomerASN: 64498, Providers: []uint32{64501}},
+		{CustomerASN: 64499, Providers: []uint32{64502}},
+	}
+	diff := ComputeASPADiff(newAspas, prevAspas)
+	assert.Equal(t, []ASPA{
+		{CustomerASN: 64498, Providers: []uint32{64501}, Flags: FLAG_ADDED},
+		{CustomerASN: 64499, Providers: []uint32{64502}, Flags: FLAG_ADDED},
+		{CustomerASN: 64497, Flags: FLAG_REMOVED},
+	}, diff)
+}
+
+func TestASPASerialDiff(t *testing.T) {
+	s := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 10}, nil, nil)
+	s.AddData(GenerateVrps(1, 0), nil, []ASPA{
+		{CustomerASN: 64496, Providers: []uint32{64501, 64500, 64501}},
+		{CustomerASN: 64497, Providers: []uint32{64500}},
+	})
+	assert.Equal(t, []ASPA{
+		{CustomerASN: 64496, Providers: []uint32{64500, 64501}, Flags: FLAG_ADDED},
+		{CustomerASN: 64497, Providers: []uint32{64500}, Flags: FLAG_ADDED},
+	}, s.GetCurrentASPAs())
+
+	// The ASPAs are kept when only the VRPs change
+	s.AddVRPs(GenerateVrps(2, 0))
+	diff, ok := s.GetASPAsSerialDiff(0)
+	assert.True(t, ok)
+	assert.Empty(t, diff)
+
+	s.AddData(GenerateVrps(2, 0), nil, []ASPA{{CustomerASN: 64496, Providers: []uint32{64502}}})
+	diff, ok = s.GetASPAsSerialDiff(0)
+	assert.True(t, ok)
+	assert.Equal(t, []ASPA{
+		{CustomerASN: 64496, Providers: []uint32{64502}, Flags: FLAG_ADDED},
+		{CustomerASN: 64497, Flags: FLAG_REMOVED},
+	}, diff)
+
+	diff, ok = s.GetASPAsSerialDiff(2)
+	assert.True(t, ok)
+	assert.Empty(t, diff)
+
+	_, ok = s.GetASPAsSerialDiff(5)
+	assert.False(t, ok)
+}
+
+func TestSendASPAs(t *testing.T) {
+	s := newTestServer(PROTOCOL_VERSION_2, nil)
+	s.AddData(GenerateVrps(1, 0), nil, []ASPA{{CustomerASN: 64496, Providers: []uint32{64500}}})
+	s.AddData(GenerateVrps(1, 0), nil, []ASPA{{CustomerASN: 64497, Providers: []uint32{64500, 64501}}})
+	addr := startTestServer(t, s)
+
+	aspas := func(pdus []PDU) []*PDUASPA {
+		var aspas []*PDUASPA
+		for _, pdu := range pdus {
+			if aspa, ok := pdu.(*PDUASPA); ok {
+				aspas = append(aspas, aspa)
+			}
+		}
+		return aspas
+	}
+
+	assert.Empty(t, aspas(exchange(t, addr, &PDUResetQuery{Version: PROTOCOL_VERSION_1})))
+	assert.Equal(t, []*PDUASPA{
+		{Version: PROTOCOL_VERSION_2, Flags: FLAG_ADDED, CustomerASN: 64497, Providers: []uint32{64500, 64501}},
+	}, aspas(exchange(t, addr, &PDUResetQuery{Version: PROTOCOL_VERSION_2})))
+	assert.Equal(t, []*PDUASPA{
+		{Version: PROTOCOL_VERSION_2, Flags: FLAG_ADDED, CustomerASN: 64497, Providers: []uint32{64500, 64501}},
+		{Version: PROTOCOL_VERSION_2, Flags: FLAG_REMOVED, CustomerASN: 64496, Providers: []uint32{}},
+	}, aspas(exchange(t, addr, &PDUSerialQuery{Version: PROTOCOL_VERSION_2, SessionId: 10, SerialNumber: 1})))
+}
+
+func TestSendBgpsecKeys(t *testing.T) {
+	keyA := BgpsecKey{ASN: 64496, SKI: [20]byte{1}, Pubkey: []byte{0x30, 1, 2, 3}}
+	keyB := BgpsecKey{ASN: 64497, SKI: [20]byte{2}, Pubkey: []byte{0x30, 4, 5, 6}}
+
+	s := newTestServer(PROTOCOL_VERSION_2, nil)
+	s.AddData(GenerateVrps(1, 0), []BgpsecKey{keyA, keyA}, nil)
+	s.AddData(GenerateVrps(1, 0), []BgpsecKey{keyB}, nil)
+
+	diff, ok := s.GetBgpsecKeysSerialDiff(1)
+	assert.True(t, ok)
+	assert.Len(t, diff, 2)
+
+	addr := startTestServer(t, s)
+	keys := func(pdus []PDU) []*PDURouterKey {
+		var keys []*PDURouterKey
+		for _, pdu := range pdus {
+			if key, ok := pdu.(*PDURouterKey); ok {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+
+	assert.Empty(t, keys(exchange(t, addr, &PDUResetQuery{Version: PROTOCOL_VERSION_0})))
+	assert.Equal(t, []*PDURouterKey{
+		{Version: PROTOCOL_VERSION_1, Flags: FLAG_ADDED, SubjectKeyIdentifier: keyB.SKI, ASN: 64497, SubjectPublicKeyInfo: keyB.Pubkey},
+	}, keys(exchange(t, addr, &PDUResetQuery{Version: PROTOCOL_VERSION_1})))
+	// The withdrawal carries the whole key
+	assert.Equal(t, []*PDURouterKey{
+		{Version: PROTOCOL_VERSION_1, Flags: FLAG_ADDED, SubjectKeyIdentifier: keyB.SKI, ASN: 64497, SubjectPublicKeyInfo: keyB.Pubkey},
+		{Version: PROTOCOL_VERSION_1, Flags: FLAG_REMOVED, SubjectKeyIdentifier: keyA.SKI, ASN: 64496, SubjectPublicKeyInfo: keyA.Pubkey},
+	}, keys(exchange(t, addr, &PDUSerialQuery{Version: PROTOCOL_VERSION_1, SessionId: 10, SerialNumber: 1})))
+}
+
+func TestPDUBatch(t *testing.T) {
+	vrps := GenerateVrps(2, 0)
+	keys := []BgpsecKey{{ASN: 64496, SKI: [20]byte{1}, Pubkey: []byte{0x30, 1, 2, 3}, Flags: FLAG_ADDED}}
+	aspas := []ASPA{{CustomerASN: 64496, Providers: []uint32{64500}, Flags: FLAG_ADDED}}
+
+	assert.Equal(t, map[uint8]int{PDU_ID_IPV6_PREFIX: 2}, NewPDUBatch(PROTOCOL_VERSION_0, vrps, keys, aspas).Types)
+	assert.Equal(t, map[uint8]int{PDU_ID_IPV6_PREFIX: 2, PDU_ID_ROUTER_KEY: 1}, NewPDUBatch(PROTOCOL_VERSION_1, vrps, keys, aspas).Types)
+
+	// The batch holds the same bytes as the PDUs sent one by one
+	batch := NewPDUBatch(PROTOCOL_VERSION_2, vrps, keys, aspas)
+	assert.Equal(t, 4, batch.Count)
+	var want []byte
+	for _, pdu := range []PDU{vrpPDU(vrps[0]), vrpPDU(vrps[1]), bgpsecKeyPDU(keys[0]), aspaPDU(aspas[0])} {
+		pdu.SetVersion(PROTOCOL_VERSION_2)
+		want = append(want, pdu.Bytes()...)
+	}
+	assert.Equal(t, want, batch.Bytes())
+
+	var types []uint8
+	batch.PDUs(func(pdu PDU, length int) {
+		types = append(types, pdu.GetType())
+		assert.Equal(t, len(pdu.Bytes()), length)
+	})
+	assert.Equal(t, []uint8{PDU_ID_IPV6_PREFIX, PDU_ID_IPV6_PREFIX, PDU_ID_ROUTER_KEY, PDU_ID_ASPA}, types)
+}
+
+func TestMaxDiffSize(t *testing.T) {
+	s := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 10, MaxDiffSize: 2}, nil, nil)
+	s.AddVRPs(GenerateVrps(1, 0))
+	s.AddVRPs(GenerateVrps(4, 0))
+	s.AddVRPs(GenerateVrps(5, 0))
+
+	// 4 changes since the serial 0, 1 since the serial 1
+	_, serial, ok := s.GetSerialDiffBatch(0, PROTOCOL_VERSION_1)
+	assert.False(t, ok)
+	assert.Equal(t, uint32(2), serial)
+	batch, _, ok := s.GetSerialDiffBatch(1, PROTOCOL_VERSION_1)
+	assert.True(t, ok)
+	assert.Equal(t, 1, batch.Count)
+	_, _, ok = s.GetSerialDiffBatch(0, PROTOCOL_VERSION_1)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(2), s.GetLargeDiffResets())
+
+	// The changes are still available to the other users of the server
+	diff, ok := s.GetVRPsSerialDiff(0)
+	assert.True(t, ok)
+	assert.Len(t, diff, 4)
+}
+
+func TestWritePDUAllocs(t *testing.T) {
+	pdu := &PDUIPv6Prefix{Version: PROTOCOL_VERSION_1, Prefix: GenerateVrps(1, 0)[0].Prefix, MaxLen: 128, ASN: 64496}
+	allocs := testing.AllocsPerRun(100, func() {
+		pdu.Write(io.Discard)
+	})
+	assert.Equal(t, 0.0, allocs)
+
+	// Encoding a batch does not allocate for each VRP
+	small, large := GenerateVrps(10, 0), GenerateVrps(1000, 0)
+	smallAllocs := testing.AllocsPerRun(10, func() {
+		NewPDUBatch(PROTOCOL_VERSION_1, small, nil, nil)
+	})
+	largeAllocs := testing.AllocsPerRun(10, func() {
+		NewPDUBatch(PROTOCOL_VERSION_1, large, nil, nil)
+	})
+	assert.Equal(t, smallAllocs, largeAllocs)
+}
+
+func BenchmarkNewPDUBatch100000(b *testing.B) {
+	vrps := GenerateVrps(100000, 0)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewPDUBatch(PROTOCOL_VERSION_1, vrps, nil, nil)
+	}
+}
+
+func TestServerBatches(t *testing.T) {
+	s := NewServer(ServerConfiguration{KeepDifference: 3, SessId: 10}, nil, nil)
+	_, _, valid := s.GetCurrentBatch(PROTOCOL_VERSION_1)
+	assert.False(t, valid)
+
+	s.AddVRPs(GenerateVrps(2, 0))
+	s.AddVRPs(GenerateVrps(3, 0))
+
+	// The batches are shared until the data changes
+	current, serial, valid := s.GetCurrentBatch(PROTOCOL_VERSION_1)
+	assert.True(t, valid)
+	assert.Equal(t, uint32(1), serial)
+	assert.Equal(t, 3, current.Count)
+	again, _, _ := s.GetCurrentBatch(PROTOCOL_VERSION_1)
+	assert.Same(t, current, again)
+	other, _, _ := s.GetCurrentBatch(PROTOCOL_VERSION_0)
+	assert.NotSame(t, current, other)
+
+	diff, serial, ok := s.GetSerialDiffBatch(0, PROTOCOL_VERSION_1)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1), serial)
+	assert.Equal(t, 1, diff.Count)
+	diff, _, ok = s.GetSerialDiffBatch(1, PROTOCOL_VERSION_1)
+	assert.True(t, ok)
+	assert.Equal(t, 0, diff.Count)
+	_, _, ok = s.GetSerialDiffBatch(5, PROTOCOL_VERSION_1)
+	assert.False(t, ok)
+
+	s.AddVRPs(GenerateVrps(4, 0))
+	again, _, _ = s.GetCurrentBatch(PROTOCOL_VERSION_1)
+	assert.NotSame(t, current, again)
+	assert.Equal(t, 4, again.Count)
+}
+
+// newTestCertificate returns a certificate signed by the parent, or self-signed if parent is nil.
+func newTestCertificate(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{name},
+		IsCA:                  parent == nil,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	ca := newTestCertificate(t, "ca", nil)
+	serverCert := newTestCertificate(t, "localhost", &ca)
+	clientCert := newTestCertificate(t, "router1", &ca)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+
+	s := newTestServer(PROTOCOL_VERSION_1, GenerateVrps(3, 0))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go s.ServeTLS(listener, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+
+	dial := func(certs []tls.Certificate) (*tls.Conn, error) {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+			RootCAs:      pool,
+			ServerName:   "localhost",
+			Certificates: certs,
+		})
+		if err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		// The server closes the connection if it refuses the certificate
+		conn.Write((&PDUResetQuery{}).Bytes())
+		_, err = Decode(conn)
+		return conn, err
+	}
+
+	_, err = dial(nil)
+	assert.Error(t, err)
+
+	conn, err := dial([]tls.Certificate{clientCert})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	clients := s.GetClientList()
+	if assert.Len(t, clients, 1) {
+		state, ok := clients[0].GetTLSConnectionState()
+		assert.True(t, ok)
+		if assert.Len(t, state.PeerCertificates, 1) {
+			assert.Equal(t, "router1", state.PeerCertificates[0].Subject.CommonName)
+		}
+	}
+}
+
+func TestClientTransport(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	assert.Equal(t, TransportPlain, ClientFromConn(conn, nil, nil).GetTransport())
+	assert.Equal(t, TransportTLS, ClientFromConn(tls.Server(conn, &tls.Config{}), nil, nil).GetTransport())
+	assert.Equal(t, TransportSSH, ClientFromConnSSH(conn, nil, nil, nil).GetTransport())
+}
@@ -0,0 +1,200 @@
+package rtrlib
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// SourceLimits are the limits of the connections per source, so that a client in a
+// reconnect loop cannot take all the connections allowed by MaxConn.
+type SourceLimits struct {
+	// Simultaneous connections from an address (0 for no limit)
+	MaxPerAddress int
+	// Simultaneous connections from a subnet of SubnetIPv4 or SubnetIPv6 bits (0 for no limit)
+	MaxPerSubnet int
+	SubnetIPv4   int
+	SubnetIPv6   int
+	// Connections an address can open per second (0 for no limit), with bursts of Burst
+	Rate  float64
+	Burst int
+}
+
+// sourceLimiter counts the connections of each address and subnet, and the rate at which
+// each address opens them (with a token bucket).
+type sourceLimiter struct {
+	limits SourceLimits
+
+	lock      *sync.Mutex
+	addresses map[string]int
+	subnets   map[string]int
+	buckets   map[string]*sourceBucket
+	lastPrune time.Time
+}
+
+type sourceBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket at rate tokens per second up to burst, and takes a token if there is one.
+func (b *sourceBucket) take(now time.Time, rate float64, burst int) bool {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// newSourceLimiter returns nil when there is no limit.
+func newSourceLimiter(limits SourceLimits) *sourceLimiter {
+	if limits.MaxPerAddress <= 0 && limits.MaxPerSubnet <= 0 && limits.Rate <= 0 {
+		return nil
+	}
+	if limits.SubnetIPv4 <= 0 || limits.SubnetIPv4 > 32 {
+		limits.SubnetIPv4 = 24
+	}
+	if limits.SubnetIPv6 <= 0 || limits.SubnetIPv6 > 128 {
+		limits.SubnetIPv6 = 48
+	}
+	if limits.Burst < 1 {
+		limits.Burst = 1
+	}
+	return &sourceLimiter{
+		limits:    limits,
+		lock:      &sync.Mutex{},
+		addresses: make(map[string]int),
+		subnets:   make(map[string]int),
+		buckets:   make(map[string]*sourceBucket),
+	}
+}
+
+// sourceKeys returns the address and the subnet of a client.
+func (l *sourceLimiter) sourceKeys(addr net.Addr) (string, string) {
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host, host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String(), ip4.Mask(net.CIDRMask(l.limits.SubnetIPv4, 32)).String()
+	}
+	return ip.String(), ip.Mask(net.CIDRMask(l.limits.SubnetIPv6, 128)).String()
+}
+
+// Acquire counts a connection from an address, or returns why it must be refused. An
+// accepted connection must be released once closed.
+func (l *sourceLimiter) Acquire(addr net.Addr, now time.Time) error {
+	address, subnet := l.sourceKeys(addr)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.limits.Rate > 0 {
+		l.prune(now)
+		bucket, ok := l.buckets[address]
+		if !ok {
+			bucket = &sourceBucket{tokens: float64(l.limits.Burst), last: now}
+			l.buckets[address] = bucket
+		}
+		if !bucket.take(now, l.limits.Rate, l.limits.Burst) {
+			return fmt.Errorf("more than %v connections per second from %v", l.limits.Rate, address)
+		}
+	}
+	if l.limits.MaxPerAddress > 0 && l.addresses[address] >= l.limits.MaxPerAddress {
+		return fmt.Errorf("%d connections from %v", l.addresses[address], address)
+	}
+	if l.limits.MaxPerSubnet > 0 && l.subnets[subnet] >= l.limits.MaxPerSubnet {
+		return fmt.Errorf("%d connections from the subnet of %v", l.subnets[subnet], address)
+	}
+	l.addresses[address]++
+	l.subnets[subnet]++
+	return nil
+}
+
+func (l *sourceLimiter) Release(addr net.Addr) {
+	address, subnet := l.sourceKeys(addr)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.addresses[address]--; l.addresses[address] <= 0 {
+		delete(l.addresses, address)
+	}
+	if l.subnets[subnet]--; l.subnets[subnet] <= 0 {
+		delete(l.subnets, subnet)
+	}
+}
+
+// prune removes the buckets of the addresses which have not connected for the time
+// their bucket takes to refill.
+func (l *sourceLimiter) prune(now time.Time) {
+	refill := time.Duration(float64(l.limits.Burst) / l.limits.Rate * float64(time.Second))
+	if now.Sub(l.lastPrune) < refill {
+		return
+	}
+	for address, bucket := range l.buckets {
+		if now.Sub(bucket.last) >= refill {
+			delete(l.buckets, address)
+		}
+	}
+	l.lastPrune = now
+}
+
+// sourceLimitListener refuses the connections over the limits, before any TLS or SSH
+// handshake. The connections accepted release their count when closed.
+type sourceLimitListener struct {
+	net.Listener
+	server  *Server
+	logEnv  string
+	limiter *sourceLimiter
+}
+
+func (l *sourceLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if conn.RemoteAddr().Network() != "tcp" {
+			// Local clients, e.g. on a unix socket
+			return conn, nil
+		}
+		now := time.Now()
+		if err := l.limiter.Acquire(conn.RemoteAddr(), now); err != nil {
+			if l.server.log != nil && l.server.connLog.Sample(conn.RemoteAddr(), now) {
+				l.server.log.Warnf("Could not accept %s connection from %v (%v)", l.logEnv, conn.RemoteAddr(), err)
+			}
+			conn.Close()
+			continue
+		}
+		return &sourceLimitConn{Conn: conn, limiter: l.limiter, once: &sync.Once{}}, nil
+	}
+}
+
+type sourceLimitConn struct {
+	net.Conn
+	limiter *sourceLimiter
+	once    *sync.Once
+}
+
+func (c *sourceLimitConn) Close() error {
+	c.once.Do(func() {
+		c.limiter.Release(c.Conn.RemoteAddr())
+	})
+	return c.Conn.Close()
+}
+
+// limitSources applies the limits per source to the connections of a listener.
+func (s *Server) limitSources(tcplist net.Listener, logEnv string) net.Listener {
+	if s.sourceLimiter == nil {
+		return tcplist
+	}
+	return &sourceLimitListener{Listener: tcplist, server: s, logEnv: logEnv, limiter: s.sourceLimiter}
+}
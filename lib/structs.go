@@ -7,7 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"net"
+	"net/netip"
+	"sync"
 )
 
 type Logger interface {
@@ -18,11 +19,29 @@ type Logger interface {
 	Infof(string, ...interface{})
 }
 
+// Fields are structured fields added to log messages, such as the client address.
+type Fields map[string]interface{}
+
+// A FieldLogger is a Logger which can add structured fields to the messages.
+type FieldLogger interface {
+	Logger
+	WithFields(Fields) Logger
+}
+
+// withFields adds fields to the messages of a logger, if it supports them.
+func withFields(l Logger, fields Fields) Logger {
+	if fl, ok := l.(FieldLogger); ok {
+		return fl.WithFields(fields)
+	}
+	return l
+}
+
 const (
 	messageMaxSize = 2048
 
 	PROTOCOL_VERSION_0 = 0
 	PROTOCOL_VERSION_1 = 1
+	PROTOCOL_VERSION_2 = 2
 
 	PDU_ID_SERIAL_NOTIFY  = 0
 	PDU_ID_SERIAL_QUERY   = 1
@@ -34,6 +53,10 @@ const (
 	PDU_ID_CACHE_RESET    = 8
 	PDU_ID_ROUTER_KEY     = 9
 	PDU_ID_ERROR_REPORT   = 10
+	PDU_ID_ASPA           = 11
+
+	// Reserved type, used for the PDUBatch queued to the clients and never sent as such
+	PDU_ID_BATCH = 255
 
 	FLAG_ADDED   = 1
 	FLAG_REMOVED = 0
@@ -56,6 +79,8 @@ const (
 type PDU interface {
 	Bytes() []byte
 	Write(io.Writer)
+	// Append appends the encoded PDU to a buffer and returns the extended buffer
+	Append([]byte) []byte
 	String() string
 	SetVersion(uint8)
 	GetVersion() uint8
@@ -84,24 +109,69 @@ func TypeToString(t uint8) string {
 		return "Router Key"
 	case PDU_ID_ERROR_REPORT:
 		return "Error Report"
+	case PDU_ID_ASPA:
+		return "ASPA"
+	case PDU_ID_BATCH:
+		return "PDU Batch"
 	default:
 		return fmt.Sprintf("Unknown type %d", t)
 	}
 }
 
 func IsCorrectPDUVersion(pdu PDU, version uint8) bool {
-	if version > 1 {
+	if version > PROTOCOL_VERSION_2 {
 		return false
 	}
 	switch pdu.(type) {
 	case *PDURouterKey:
-		if version == 0 {
+		if version == PROTOCOL_VERSION_0 {
+			return false
+		}
+	case *PDUASPA:
+		if version < PROTOCOL_VERSION_2 {
 			return false
 		}
 	}
 	return true
 }
 
+// pduBuffers are the buffers the PDUs are encoded into before being written, reused so
+// that writing a PDU does not allocate.
+var pduBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 64)
+		return &b
+	},
+}
+
+// writePDU encodes a PDU in a reusable buffer and writes it.
+func writePDU(wr io.Writer, pdu PDU) (int, error) {
+	buf := pduBuffers.Get().(*[]byte)
+	*buf = pdu.Append((*buf)[:0])
+	n, err := wr.Write(*buf)
+	// Large buffers (e.g. Router Keys with a long key) are not kept
+	if cap(*buf) <= 1024 {
+		pduBuffers.Put(buf)
+	}
+	return n, err
+}
+
+// appendHeader appends the header of a PDU: the version, the type, the field of 16 bits
+// depending on the type (e.g. the session ID) and the length of the PDU.
+func appendHeader(b []byte, version uint8, pduType uint8, field uint16, length uint32) []byte {
+	b = append(b, version, pduType)
+	b = appendUint16(b, field)
+	return appendUint32(b, length)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
 type PDUSerialNotify struct {
 	Version      uint8
 	SessionId    uint16
@@ -113,9 +183,7 @@ func (pdu *PDUSerialNotify) String() string {
 }
 
 func (pdu *PDUSerialNotify) Bytes() []byte {
-	b := bytes.NewBuffer([]byte{})
-	pdu.Write(b)
-	return b.Bytes()
+	return pdu.Append(nil)
 }
 
 func (pdu *PDUSerialNotify) SetVersion(version uint8) {
@@ -131,11 +199,12 @@ func (pdu *PDUSerialNotify) GetType() uint8 {
 }
 
 func (pdu *PDUSerialNotify) Write(wr io.Writer) {
-	binary.Write(wr, binary.BigEndian, uint8(pdu.Version))
-	binary.Write(wr, binary.BigEndian, uint8(PDU_ID_SERIAL_NOTIFY))
-	binary.Write(wr, binary.BigEndian, pdu.SessionId)
-	binary.Write(wr, binary.BigEndian, uint32(12))
-	binary.Write(wr, binary.BigEndian, uint32(pdu.SerialNumber))
+	writePDU(wr, pdu)
+}
+
+func (pdu *PDUSerialNotify) Append(b []byte) []byte {
+	b = appendHeader(b, pdu.Version, PDU_ID_SERIAL_NOTIFY, pdu.SessionId, 12)
+	return appendUint32(b, pdu.SerialNumber)
 }
 
 type PDUSerialQuery struct {
@@ -149,9 +218,7 @@ func (pdu *PDUSerialQuery) String() string {
 }
 
 func (pdu *PDUSerialQuery) Bytes() []byte {
-	b := bytes.NewBuffer([]byte{})
-	pdu.Write(b)
-	return b.Bytes()
+	return pdu.Append(nil)
 }
 
 func (pdu *PDUSerialQuery) SetVersion(version uint8) {
@@ -167,11 +234,12 @@ func (pdu *PDUSerialQuery) GetType() uint8 {
 }
 
 func (pdu *PDUSerialQuery) Write(wr io.Writer) {
-	binary.Write(wr, binary.BigEndian, uint8(pdu.Version))
-	binary.Write(wr, binary.BigEndian, uint8(PDU_ID_SERIAL_QUERY))
-	binary.Write(wr, binary.BigEndian, pdu.SessionId)
-	binary.Write(wr, binary.BigEndian, uint32(12))
-	binary.Write(wr, binary.BigEndian, uint32(pdu.SerialNumber))
+	writePDU(wr, pdu)
+}
+
+func (pdu *PDUSerialQuery) Append(b []byte) []byte {
+	b = appendHeader(b, pdu.Version, PDU_ID_SERIAL_QUERY, pdu.SessionId, 12)
+	return appendUint32(b, pdu.SerialNumber)
 }
 
 type PDUResetQuery struct {
@@ -183,9 +251,7 @@ func (pdu *PDUResetQuery) String() string {
 }
 
 func (pdu *PDUResetQuery) Bytes() []byte {
-	b := bytes.NewBuffer([]byte{})
-	pdu.Write(b)
-	return b.Bytes()
+	return pdu.Append(nil)
 }
 
 func (pdu *PDUResetQuery) SetVersion(version uint8) {
@@ -201,10 +267,11 @@ func (pdu *PDUResetQuery) GetType() uint8 {
 }
 
 func (pdu *PDUResetQuery) Write(wr io.Writer) {
-	binary.Write(wr, binary.BigEndian, uint8(pdu.Version))
-	binary.Write(wr, binary.BigEndian, uint8(PDU_ID_RESET_QUERY))
-	binary.Write(wr, binary.BigEndian, uint16(0))
-	binary.Write(wr, binary.BigEndian, uint32(8))
+	writePDU(wr, pdu)
+}
+
+func (pdu *PDUResetQuery) Append(b []byte) []byte {
+	return appendHeader(b, pdu.Version, PDU_ID_RESET_QUERY, 0, 8)
 }
 
 type PDUCacheResponse struct {
@@ -217,9 +284,7 @@ func (pdu *PDUCacheResponse) String() string {
 }
 
 func (pdu *PDUCacheResponse) Bytes() []byte {
-	b := bytes.NewBuffer([]byte{})
-	pdu.Write(b)
-	return b.Bytes()
+	return pdu.Append(nil)
 }
 
 func (pdu *PDUCacheResponse) SetVersion(version uint8) {
@@ -235,29 +300,27 @@ func (pdu *PDUCacheResponse) GetType() uint8 {
 }
 
 func (pdu *PDUCacheResponse) Write(wr io.Writer) {
-	binary.Write(wr, binary.BigEndian, uint8(pdu.Version))
-	binary.Write(wr, binary.BigEndian, uint8(PDU_ID_CACHE_RESPONSE))
-	binary.Write(wr, binary.BigEndian, pdu.SessionId)
-	binary.Write(wr, binary.BigEndian, uint32(8))
+	writePDU(wr, pdu)
+}
+
+func (pdu *PDUCacheResponse) Append(b []byte) []byte {
+	return appendHeader(b, pdu.Version, PDU_ID_CACHE_RESPONSE, pdu.SessionId, 8)
 }
 
 type PDUIPv4Prefix struct {
 	Version uint8
-	Prefix  net.IPNet
+	Prefix  netip.Prefix
 	MaxLen  uint8
 	ASN     uint32
 	Flags   uint8
 }
 
 func (pdu *PDUIPv4Prefix) String() string {
-	mask, _ := pdu.Prefix.Mask.Size()
-	return fmt.Sprintf("PDU IPv4 Prefix v%d %s/%d(->/%d), origin: AS%d, flags: %d", pdu.Version, pdu.Prefix.IP.String(), mask, pdu.MaxLen, pdu.ASN, pdu.Flags)
+	return fmt.Sprintf("PDU IPv4 Prefix v%d %s/%d(->/%d), origin: AS%d, flags: %d", pdu.Version, pdu.Prefix.Addr(), pdu.Prefix.Bits(), pdu.MaxLen, pdu.ASN, pdu.Flags)
 }
 
 func (pdu *PDUIPv4Prefix) Bytes() []byte {
-	b := bytes.NewBuffer([]byte{})
-	pdu.Write(b)
-	return b.Bytes()
+	return pdu.Append(nil)
 }
 
 func (pdu *PDUIPv4Prefix) SetVersion(version uint8) {
@@ -273,36 +336,31 @@ func (pdu *PDUIPv4Prefix) GetType() uint8 {
 }
 
 func (pdu *PDUIPv4Prefix) Write(wr io.Writer) {
-	mask, _ := pdu.Prefix.Mask.Size()
-	binary.Write(wr, binary.BigEndian, uint8(pdu.Version))
-	binary.Write(wr, binary.BigEndian, uint8(PDU_ID_IPV4_PREFIX))
-	binary.Write(wr, binary.BigEndian, uint16(0))
-	binary.Write(wr, binary.BigEndian, uint32(20))
-	binary.Write(wr, binary.BigEndian, pdu.Flags)
-	binary.Write(wr, binary.BigEndian, uint8(mask))
-	binary.Write(wr, binary.BigEndian, pdu.MaxLen)
-	binary.Write(wr, binary.BigEndian, uint8(0))
-	binary.Write(wr, binary.BigEndian, pdu.Prefix.IP.To4())
-	binary.Write(wr, binary.BigEndian, pdu.ASN)
+	writePDU(wr, pdu)
+}
+
+func (pdu *PDUIPv4Prefix) Append(b []byte) []byte {
+	b = appendHeader(b, pdu.Version, PDU_ID_IPV4_PREFIX, 0, 20)
+	b = append(b, pdu.Flags, uint8(pdu.Prefix.Bits()), pdu.MaxLen, 0)
+	ip := pdu.Prefix.Addr().As4()
+	b = append(b, ip[:]...)
+	return appendUint32(b, pdu.ASN)
 }
 
 type PDUIPv6Prefix struct {
 	Version uint8
-	Prefix  net.IPNet
+	Prefix  netip.Prefix
 	MaxLen  uint8
 	ASN     uint32
 	Flags   uint8
 }
 
 func (pdu *PDUIPv6Prefix) String() string {
-	mask, _ := pdu.Prefix.Mask.Size()
-	return fmt.Sprintf("PDU IPv6 Prefix v%d %s/%d(->/%d), origin: AS%d, flags: %d", pdu.Version, pdu.Prefix.IP.String(), mask, pdu.MaxLen, pdu.ASN, pdu.Flags)
+	return fmt.Sprintf("PDU IPv6 Prefix v%d %s/%d(->/%d), origin: AS%d, flags: %d", pdu.Version, pdu.Prefix.Addr(), pdu.Prefix.Bits(), pdu.MaxLen, pdu.ASN, pdu.Flags)
 }
 
 func (pdu *PDUIPv6Prefix) Bytes() []byte {
-	b := bytes.NewBuffer([]byte{})
-	pdu.Write(b)
-	return b.Bytes()
+	return pdu.Append(nil)
 }
 
 func (pdu *PDUIPv6Prefix) SetVersion(version uint8) {
@@ -318,17 +376,15 @@ func (pdu *PDUIPv6Prefix) GetType() uint8 {
 }
 
 func (pdu *PDUIPv6Prefix) Write(wr io.Writer) {
-	mask, _ := pdu.Prefix.Mask.Size()
-	binary.Write(wr, binary.BigEndian, uint8(pdu.Version))
-	binary.Write(wr, binary.BigEndian, uint8(PDU_ID_IPV6_PREFIX))
-	binary.Write(wr, binary.BigEndian, uint16(0))
-	binary.Write(wr, binary.BigEndian, uint32(32))
-	binary.Write(wr, binary.BigEndian, pdu.Flags)
-	binary.Write(wr, binary.BigEndian, uint8(mask))
-	binary.Write(wr, binary.BigEndian, pdu.MaxLen)
-	binary.Write(wr, binary.BigEndian, uint8(0))
-	binary.Write(wr, binary.BigEndian, pdu.Prefix.IP.To16())
-	binary.Write(wr, binary.BigEndian, pdu.ASN)
+	writePDU(wr, pdu)
+}
+
+func (pdu *PDUIPv6Prefix) Append(b []byte) []byte {
+	b = appendHeader(b, pdu.Version, PDU_ID_IPV6_PREFIX, 0, 32)
+	b = append(b, pdu.Flags, uint8(pdu.Prefix.Bits()), pdu.MaxLen, 0)
+	ip := pdu.Prefix.Addr().As16()
+	b = append(b, ip[:]...)
+	return appendUint32(b, pdu.ASN)
 }
 
 type PDUEndOfData struct {
@@ -347,9 +403,7 @@ func (pdu *PDUEndOfData) String() string {
 }
 
 func (pdu *PDUEndOfData) Bytes() []byte {
-	b := bytes.NewBuffer([]byte{})
-	pdu.Write(b)
-	return b.Bytes()
+	return pdu.Append(nil)
 }
 
 func (pdu *PDUEndOfData) SetVersion(version uint8) {
@@ -365,20 +419,19 @@ func (pdu *PDUEndOfData) GetType() uint8 {
 }
 
 func (pdu *PDUEndOfData) Write(wr io.Writer) {
-	binary.Write(wr, binary.BigEndian, uint8(pdu.Version))
-	binary.Write(wr, binary.BigEndian, uint8(PDU_ID_END_OF_DATA))
-	binary.Write(wr, binary.BigEndian, pdu.SessionId)
+	writePDU(wr, pdu)
+}
 
+func (pdu *PDUEndOfData) Append(b []byte) []byte {
 	if pdu.Version == PROTOCOL_VERSION_0 {
-		binary.Write(wr, binary.BigEndian, uint32(12))
-		binary.Write(wr, binary.BigEndian, pdu.SerialNumber)
-	} else if pdu.Version == PROTOCOL_VERSION_1 {
-		binary.Write(wr, binary.BigEndian, uint32(24))
-		binary.Write(wr, binary.BigEndian, pdu.SerialNumber)
-		binary.Write(wr, binary.BigEndian, pdu.RefreshInterval)
-		binary.Write(wr, binary.BigEndian, pdu.RetryInterval)
-		binary.Write(wr, binary.BigEndian, pdu.ExpireInterval)
+		b = appendHeader(b, pdu.Version, PDU_ID_END_OF_DATA, pdu.SessionId, 12)
+		return appendUint32(b, pdu.SerialNumber)
 	}
+	b = appendHeader(b, pdu.Version, PDU_ID_END_OF_DATA, pdu.SessionId, 24)
+	b = appendUint32(b, pdu.SerialNumber)
+	b = appendUint32(b, pdu.RefreshInterval)
+	b = appendUint32(b, pdu.RetryInterval)
+	return appendUint32(b, pdu.ExpireInterval)
 }
 
 type PDUCacheReset struct {
@@ -390,9 +443,7 @@ func (pdu *PDUCacheReset) String() string {
 }
 
 func (pdu *PDUCacheReset) Bytes() []byte {
-	b := bytes.NewBuffer([]byte{})
-	pdu.Write(b)
-	return b.Bytes()
+	return pdu.Append(nil)
 }
 
 func (pdu *PDUCacheReset) SetVersion(version uint8) {
@@ -408,10 +459,11 @@ func (pdu *PDUCacheReset) GetType() uint8 {
 }
 
 func (pdu *PDUCacheReset) Write(wr io.Writer) {
-	binary.Write(wr, binary.BigEndian, uint8(pdu.Version))
-	binary.Write(wr, binary.BigEndian, uint8(PDU_ID_CACHE_RESET))
-	binary.Write(wr, binary.BigEndian, uint16(0))
-	binary.Write(wr, binary.BigEndian, uint32(8))
+	writePDU(wr, pdu)
+}
+
+func (pdu *PDUCacheReset) Append(b []byte) []byte {
+	return appendHeader(b, pdu.Version, PDU_ID_CACHE_RESET, 0, 8)
 }
 
 type PDURouterKey struct {
@@ -419,17 +471,16 @@ type PDURouterKey struct {
 	Flags                uint8
 	SubjectKeyIdentifier [20]byte
 	ASN                  uint32
-	SubjectPublicKeyInfo uint32
+	SubjectPublicKeyInfo []byte
 }
 
 func (pdu *PDURouterKey) String() string {
-	return "PDU Router Key"
+	return fmt.Sprintf("PDU Router Key v%d (flags: %d): SKI %s, AS%d, SPKI (%d bytes)",
+		pdu.Version, pdu.Flags, hex.EncodeToString(pdu.SubjectKeyIdentifier[:]), pdu.ASN, len(pdu.SubjectPublicKeyInfo))
 }
 
 func (pdu *PDURouterKey) Bytes() []byte {
-	b := bytes.NewBuffer([]byte{})
-	pdu.Write(b)
-	return b.Bytes()
+	return pdu.Append(nil)
 }
 
 func (pdu *PDURouterKey) SetVersion(version uint8) {
@@ -445,14 +496,56 @@ func (pdu *PDURouterKey) GetType() uint8 {
 }
 
 func (pdu *PDURouterKey) Write(wr io.Writer) {
-	binary.Write(wr, binary.BigEndian, uint8(pdu.Version))
-	binary.Write(wr, binary.BigEndian, uint8(PDU_ID_ROUTER_KEY))
-	binary.Write(wr, binary.BigEndian, uint8(pdu.Flags))
-	binary.Write(wr, binary.BigEndian, uint8(0))
-	binary.Write(wr, binary.BigEndian, uint32(36))
-	binary.Write(wr, binary.BigEndian, pdu.SubjectKeyIdentifier)
-	binary.Write(wr, binary.BigEndian, pdu.ASN)
-	binary.Write(wr, binary.BigEndian, pdu.SubjectPublicKeyInfo)
+	writePDU(wr, pdu)
+}
+
+func (pdu *PDURouterKey) Append(b []byte) []byte {
+	b = appendHeader(b, pdu.Version, PDU_ID_ROUTER_KEY, uint16(pdu.Flags)<<8, uint32(32+len(pdu.SubjectPublicKeyInfo)))
+	b = append(b, pdu.SubjectKeyIdentifier[:]...)
+	b = appendUint32(b, pdu.ASN)
+	return append(b, pdu.SubjectPublicKeyInfo...)
+}
+
+// PDUASPA carries the providers of a customer AS (draft-ietf-sidrops-8210bis).
+// A withdrawal has no providers.
+type PDUASPA struct {
+	Version     uint8
+	Flags       uint8
+	CustomerASN uint32
+	Providers   []uint32
+}
+
+func (pdu *PDUASPA) String() string {
+	return fmt.Sprintf("PDU ASPA v%d (flags: %d): customer AS%d, providers: %v", pdu.Version, pdu.Flags, pdu.CustomerASN, pdu.Providers)
+}
+
+func (pdu *PDUASPA) Bytes() []byte {
+	return pdu.Append(nil)
+}
+
+func (pdu *PDUASPA) SetVersion(version uint8) {
+	pdu.Version = version
+}
+
+func (pdu *PDUASPA) GetVersion() uint8 {
+	return pdu.Version
+}
+
+func (pdu *PDUASPA) GetType() uint8 {
+	return PDU_ID_ASPA
+}
+
+func (pdu *PDUASPA) Write(wr io.Writer) {
+	writePDU(wr, pdu)
+}
+
+func (pdu *PDUASPA) Append(b []byte) []byte {
+	b = appendHeader(b, pdu.Version, PDU_ID_ASPA, uint16(pdu.Flags)<<8, uint32(12+4*len(pdu.Providers)))
+	b = appendUint32(b, pdu.CustomerASN)
+	for _, provider := range pdu.Providers {
+		b = appendUint32(b, provider)
+	}
+	return b
 }
 
 type PDUErrorReport struct {
@@ -467,9 +560,7 @@ func (pdu *PDUErrorReport) String() string {
 }
 
 func (pdu *PDUErrorReport) Bytes() []byte {
-	b := bytes.NewBuffer([]byte{})
-	pdu.Write(b)
-	return b.Bytes()
+	return pdu.Append(nil)
 }
 
 func (pdu *PDUErrorReport) SetVersion(version uint8) {
@@ -485,24 +576,26 @@ func (pdu *PDUErrorReport) GetType() uint8 {
 }
 
 func (pdu *PDUErrorReport) Write(wr io.Writer) {
+	writePDU(wr, pdu)
+}
+
+func (pdu *PDUErrorReport) Append(b []byte) []byte {
 	nonnull := (pdu.ErrorMsg != "")
 	addlen := 0
 	if nonnull {
 		addlen = 1
 	}
 
-	binary.Write(wr, binary.BigEndian, uint8(pdu.Version))
-	binary.Write(wr, binary.BigEndian, uint8(PDU_ID_ERROR_REPORT))
-	binary.Write(wr, binary.BigEndian, pdu.ErrorCode)
-	binary.Write(wr, binary.BigEndian, uint32(12+len(pdu.PDUCopy)+4+len(pdu.ErrorMsg)+addlen))
-	binary.Write(wr, binary.BigEndian, uint32(len(pdu.PDUCopy)))
-	binary.Write(wr, binary.BigEndian, pdu.PDUCopy)
-	binary.Write(wr, binary.BigEndian, uint32(len(pdu.ErrorMsg)+addlen))
+	b = appendHeader(b, pdu.Version, PDU_ID_ERROR_REPORT, pdu.ErrorCode, uint32(12+len(pdu.PDUCopy)+4+len(pdu.ErrorMsg)+addlen))
+	b = appendUint32(b, uint32(len(pdu.PDUCopy)))
+	b = append(b, pdu.PDUCopy...)
+	b = appendUint32(b, uint32(len(pdu.ErrorMsg)+addlen))
 	if nonnull {
-		binary.Write(wr, binary.BigEndian, []byte(pdu.ErrorMsg))
-		binary.Write(wr, binary.BigEndian, uint8(0))
+		b = append(b, pdu.ErrorMsg...)
 		// Some clients require null-terminated strings
+		b = append(b, 0)
 	}
+	return b
 }
 
 func DecodeBytes(b []byte) (PDU, error) {
@@ -588,36 +681,32 @@ func Decode(rdr io.Reader) (PDU, error) {
 			return nil, fmt.Errorf("Wrong length for IPv4 Prefix PDU: %d != 12", len(toread))
 		}
 		prefixLen := int(toread[1])
-		ip := toread[4:8]
-		ipnet := net.IPNet{
-			IP:   ip,
-			Mask: net.CIDRMask(prefixLen, 32),
-		}
+		var ip [4]byte
+		copy(ip[:], toread[4:8])
+		prefix := netip.PrefixFrom(netip.AddrFrom4(ip), prefixLen)
 		asn := binary.BigEndian.Uint32(toread[8:])
 		return &PDUIPv4Prefix{
 			Version: pver,
 			Flags:   uint8(toread[0]),
 			MaxLen:  uint8(toread[2]),
 			ASN:     asn,
-			Prefix:  ipnet,
+			Prefix:  prefix,
 		}, nil
 	case PDU_ID_IPV6_PREFIX:
 		if len(toread) != 24 {
 			return nil, fmt.Errorf("Wrong length for IPv6 Prefix PDU: %d != 24", len(toread))
 		}
 		prefixLen := int(toread[1])
-		ip := toread[4:20]
-		ipnet := net.IPNet{
-			IP:   ip,
-			Mask: net.CIDRMask(prefixLen, 128),
-		}
+		var ip [16]byte
+		copy(ip[:], toread[4:20])
+		prefix := netip.PrefixFrom(netip.AddrFrom16(ip), prefixLen)
 		asn := binary.BigEndian.Uint32(toread[20:])
 		return &PDUIPv6Prefix{
 			Version: pver,
 			Flags:   uint8(toread[0]),
 			MaxLen:  uint8(toread[2]),
 			ASN:     asn,
-			Prefix:  ipnet,
+			Prefix:  prefix,
 		}, nil
 	case PDU_ID_END_OF_DATA:
 		if len(toread) != 4 && len(toread) != 16 {
@@ -653,19 +742,34 @@ func Decode(rdr io.Reader) (PDU, error) {
 			Version: pver,
 		}, nil
 	case PDU_ID_ROUTER_KEY:
-		if len(toread) != 28 {
-			return nil, fmt.Errorf("Wrong length for Router Key PDU: %d < 8", len(toread))
+		if len(toread) < 24 {
+			return nil, fmt.Errorf("Wrong length for Router Key PDU: %d < 24", len(toread))
 		}
 		asn := binary.BigEndian.Uint32(toread[20:24])
-		spki := binary.BigEndian.Uint32(toread[24:28])
+		spki := toread[24:]
 		ski := [20]byte{}
 		copy(ski[:], toread[0:20])
 		return &PDURouterKey{
 			Version:              pver,
+			Flags:                uint8(sessionId >> 8),
 			SubjectKeyIdentifier: ski,
 			ASN:                  asn,
 			SubjectPublicKeyInfo: spki,
 		}, nil
+	case PDU_ID_ASPA:
+		if len(toread) < 4 || len(toread)%4 != 0 {
+			return nil, fmt.Errorf("Wrong length for ASPA PDU: %d", len(toread))
+		}
+		providers := make([]uint32, len(toread)/4-1)
+		for i := range providers {
+			providers[i] = binary.BigEndian.Uint32(toread[4+4*i:])
+		}
+		return &PDUASPA{
+			Version:     pver,
+			Flags:       uint8(sessionId >> 8),
+			CustomerASN: binary.BigEndian.Uint32(toread[0:4]),
+			Providers:   providers,
+		}, nil
 	case PDU_ID_ERROR_REPORT:
 		if len(toread) < 8 {
 			return nil, fmt.Errorf("Wrong length for Error Report PDU: %d < 8", len(toread))
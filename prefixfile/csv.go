@@ -0,0 +1,93 @@
+package prefixfile
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Columns of the CSV output of rpki-client (and Routinator, without Expires)
+const (
+	csvASN       = "asn"
+	csvPrefix    = "ip prefix"
+	csvMaxLength = "max length"
+	csvTA        = "trust anchor"
+	csvExpires   = "expires"
+)
+
+// IsCSV returns whether data looks like a CSV file of VRPs, which starts with a header
+// beginning with the ASN column.
+func IsCSV(data []byte) bool {
+	if len(data) > 64 {
+		data = data[:64]
+	}
+	header := strings.TrimLeft(string(data), "\ufeff \t\r\n")
+	return strings.HasPrefix(strings.ToLower(header), csvASN+",")
+}
+
+// DecodeCSV decodes the VRPs of a CSV file with a header, as written by rpki-client:
+//
+//	ASN,IP Prefix,Max Length,Trust Anchor,Expires
+//	AS13335,1.0.0.0/24,24,apnic,1690000000
+//
+// The Trust Anchor and Expires columns are optional. The CSV has no metadata: the
+// buildtime is left empty.
+func DecodeCSV(r io.Reader) (*VRPList, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("CSV header: %v", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range []string{csvASN, csvPrefix, csvMaxLength} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("CSV header without a %q column", name)
+		}
+	}
+	ta, hasTA := columns[csvTA]
+	expires, hasExpires := columns[csvExpires]
+
+	list := &VRPList{
+		Data: make([]VRPJson, 0),
+	}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		maxLength, err := strconv.ParseUint(strings.TrimSpace(record[columns[csvMaxLength]]), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid max length %q", line, record[columns[csvMaxLength]])
+		}
+		vrp := VRPJson{
+			ASN:    strings.TrimSpace(record[columns[csvASN]]),
+			Prefix: strings.TrimSpace(record[columns[csvPrefix]]),
+			Length: uint8(maxLength),
+		}
+		if _, err := vrp.GetASN2(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if hasTA {
+			vrp.TA = strings.TrimSpace(record[ta])
+		}
+		if hasExpires {
+			if vrp.Expires, err = strconv.Atoi(strings.TrimSpace(record[expires])); err != nil {
+				return nil, fmt.Errorf("line %d: invalid expires %q", line, record[expires])
+			}
+		}
+		list.Data = append(list.Data, vrp)
+	}
+	list.Metadata.Counts = len(list.Data)
+	return list, nil
+}
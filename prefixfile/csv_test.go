@@ -0,0 +1,37 @@
+package prefixfile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCSV(t *testing.T) {
+	data := "ASN,IP Prefix,Max Length,Trust Anchor,Expires\n" +
+		"AS13335,1.0.0.0/24,24,apnic,1690000000\n" +
+		"AS13335,2606:4700::/32,48,arin,1690000001\n"
+	assert.True(t, IsCSV([]byte(data)))
+	decoded, err := DecodeCSV(strings.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, decoded.Metadata.Counts)
+	assert.Equal(t, []VRPJson{
+		{ASN: "AS13335", Prefix: "1.0.0.0/24", Length: 24, TA: "apnic", Expires: 1690000000},
+		{ASN: "AS13335", Prefix: "2606:4700::/32", Length: 48, TA: "arin", Expires: 1690000001},
+	}, decoded.Data)
+	assert.Equal(t, uint32(13335), decoded.Data[0].GetASN())
+
+	// Routinator, without Expires
+	decoded, err = DecodeCSV(strings.NewReader("ASN,IP Prefix,Max Length,Trust Anchor\r\nAS64496,192.0.2.0/24,24,ripe\r\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []VRPJson{{ASN: "AS64496", Prefix: "192.0.2.0/24", Length: 24, TA: "ripe"}}, decoded.Data)
+
+	_, err = DecodeCSV(strings.NewReader("ASN,IP Prefix\nAS64496,192.0.2.0/24\n"))
+	assert.Error(t, err)
+	_, err = DecodeCSV(strings.NewReader("ASN,IP Prefix,Max Length\nAS64496,192.0.2.0/24,x\n"))
+	assert.EqualError(t, err, `line 2: invalid max length "x"`)
+	_, err = DecodeCSV(strings.NewReader("ASN,IP Prefix,Max Length\nASx,192.0.2.0/24,24\n"))
+	assert.Error(t, err)
+
+	assert.False(t, IsCSV([]byte(`{"roas": []}`)))
+}
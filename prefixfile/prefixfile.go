@@ -1,9 +1,11 @@
 package prefixfile
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"strconv"
 	"strings"
 )
@@ -14,16 +16,67 @@ type VRPJson struct {
 	ASN     interface{} `json:"asn"`
 	TA      string      `json:"ta,omitempty"`
 	Expires int         `json:"expires,omitempty"`
+
+	// Set when the maxLength was absent from the JSON: Length is then the prefix length.
+	NoMaxLength bool `json:"-"`
+	// Path of the cache or Slurm file the VRP was read from, not exported.
+	Source string `json:"-"`
+}
+
+func (vrp *VRPJson) UnmarshalJSON(data []byte) error {
+	type vrpJson VRPJson
+	decoded := struct {
+		*vrpJson
+		Length *uint8 `json:"maxLength"`
+	}{
+		vrpJson: (*vrpJson)(vrp),
+	}
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+	if decoded.Length != nil {
+		vrp.Length = *decoded.Length
+	} else {
+		// RFC 6482: when absent, the maximum length is the prefix length
+		vrp.NoMaxLength = true
+		vrp.Length = 0
+		if i := strings.LastIndexByte(vrp.Prefix, '/'); i >= 0 {
+			if plen, err := strconv.ParseUint(vrp.Prefix[i+1:], 10, 8); err == nil {
+				vrp.Length = uint8(plen)
+			}
+		}
+	}
+	return nil
+}
+
+// BgpsecKeyJson is a BGPsec router key in the rpki-client JSON output: the SKI is
+// hex encoded and the SubjectPublicKeyInfo base64 encoded.
+type BgpsecKeyJson struct {
+	ASN     uint32 `json:"asn"`
+	SKI     string `json:"ski"`
+	Pubkey  string `json:"pubkey"`
+	TA      string `json:"ta,omitempty"`
+	Expires int    `json:"expires,omitempty"`
+}
+
+// ASPAJson is an ASPA in the rpki-client JSON output.
+type ASPAJson struct {
+	CustomerASID uint32   `json:"customer_asid"`
+	Expires      int      `json:"expires,omitempty"`
+	Providers    []uint32 `json:"providers"`
 }
 
 type MetaData struct {
-	Counts        int    `json:"vrps"`
-	Buildtime     string `json:"buildtime,omitempty"`
+	Counts    int    `json:"vrps"`
+	Buildtime string `json:"buildtime,omitempty"`
 }
 
 type VRPList struct {
-	Metadata MetaData  `json:"metadata,omitempty"`
-	Data     []VRPJson `json:"roas"` // for historical reasons this is called 'roas', but should've been called vrps
+	Metadata   MetaData        `json:"metadata,omitempty"`
+	Data       []VRPJson       `json:"roas"` // for historical reasons this is called 'roas', but should've been called vrps
+	BgpsecKeys []BgpsecKeyJson `json:"bgpsec_keys,omitempty"`
+	ASPA       []ASPAJson      `json:"aspas,omitempty"`
 }
 
 func (vrp *VRPJson) GetASN2() (uint32, error) {
@@ -60,6 +113,16 @@ func (vrp *VRPJson) GetPrefix2() (*net.IPNet, error) {
 	return prefix, nil
 }
 
+// GetNetipPrefix returns the prefix as written in the JSON: it is not masked, so that
+// non-canonical prefixes can be detected.
+func (vrp *VRPJson) GetNetipPrefix() (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(vrp.Prefix)
+	if err != nil {
+		return netip.Prefix{}, errors.New(fmt.Sprintf("Could not decode prefix: %v", vrp.Prefix))
+	}
+	return prefix, nil
+}
+
 func (vrp *VRPJson) GetPrefix() *net.IPNet {
 	prefix, _ := vrp.GetPrefix2()
 	return prefix
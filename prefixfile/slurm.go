@@ -1,24 +1,33 @@
-// rfc8416
+// rfc8416, with the ASPA members of draft-ietf-sidrops-aspa-slurm (slurmVersion 2)
 
 package prefixfile
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net"
+	"strings"
 )
 
 type SlurmPrefixFilter struct {
-	Prefix  string
-	ASN     interface{}
-	Comment string
+	Prefix  string      `json:"prefix,omitempty"`
+	ASN     interface{} `json:"asn,omitempty"`
+	Comment string      `json:"comment,omitempty"`
 }
 
 func (pf *SlurmPrefixFilter) GetASN() (uint32, bool) {
-	if pf.ASN == nil {
+	return getFilterASN(pf.ASN)
+}
+
+// getFilterASN returns the optional asn of a filter and whether it is missing.
+func getFilterASN(filterASN interface{}) (uint32, bool) {
+	if filterASN == nil {
 		return 0, true
 	} else {
-		switch asn := pf.ASN.(type) {
+		switch asn := filterASN.(type) {
 		case json.Number:
 			c, _ := asn.Int64()
 			return uint32(c), false
@@ -37,15 +46,55 @@ func (pf *SlurmPrefixFilter) GetPrefix() *net.IPNet {
 	return prefix
 }
 
+type SlurmASPAFilter struct {
+	CustomerASID uint32 `json:"customerAsid"`
+	Comment      string `json:"comment,omitempty"`
+}
+
+// decodeSlurmBase64 decodes the SKI and the public key of the BGPsec entries. RFC 8416
+// uses the base64url encoding without padding, the standard encoding is also accepted.
+func decodeSlurmBase64(data string) ([]byte, error) {
+	data = strings.TrimRight(data, "=")
+	if strings.ContainsAny(data, "+/") {
+		return base64.RawStdEncoding.DecodeString(data)
+	}
+	return base64.RawURLEncoding.DecodeString(data)
+}
+
+type SlurmBgpsecFilter struct {
+	ASN     interface{} `json:"asn,omitempty"`
+	SKI     string      `json:"SKI,omitempty"`
+	Comment string      `json:"comment,omitempty"`
+}
+
+func (bf *SlurmBgpsecFilter) GetASN() (uint32, bool) {
+	return getFilterASN(bf.ASN)
+}
+
+// GetSKI returns the SKI of the filter hex encoded, like in the JSON of the router keys,
+// or an empty string if there is none.
+func (bf *SlurmBgpsecFilter) GetSKI() string {
+	if bf.SKI == "" {
+		return ""
+	}
+	ski, err := decodeSlurmBase64(bf.SKI)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(ski)
+}
+
 type SlurmValidationOutputFilters struct {
-	PrefixFilters []SlurmPrefixFilter
+	PrefixFilters []SlurmPrefixFilter `json:"prefixFilters"`
+	BgpsecFilters []SlurmBgpsecFilter `json:"bgpsecFilters"`
+	ASPAFilters   []SlurmASPAFilter   `json:"aspaFilters,omitempty"`
 }
 
 type SlurmPrefixAssertion struct {
-	Prefix          string
-	ASN             uint32
-	MaxPrefixLength int
-	Comment         string
+	Prefix          string `json:"prefix"`
+	ASN             uint32 `json:"asn"`
+	MaxPrefixLength int    `json:"maxPrefixLength,omitempty"`
+	Comment         string `json:"comment,omitempty"`
 }
 
 func (pa *SlurmPrefixAssertion) GetASN() uint32 {
@@ -61,14 +110,50 @@ func (pa *SlurmPrefixAssertion) GetMaxLen() int {
 	return pa.MaxPrefixLength
 }
 
+type SlurmASPAAssertion struct {
+	CustomerASID uint32   `json:"customerAsid"`
+	ProviderSet  []uint32 `json:"providerSet"`
+	Comment      string   `json:"comment,omitempty"`
+}
+
+type SlurmBgpsecAssertion struct {
+	ASN             uint32 `json:"asn"`
+	SKI             string `json:"SKI"`
+	RouterPublicKey string `json:"routerPublicKey"`
+	Comment         string `json:"comment,omitempty"`
+}
+
+// GetKey returns the asserted router key in the JSON format of the router keys.
+func (ba *SlurmBgpsecAssertion) GetKey() (BgpsecKeyJson, error) {
+	ski, err := decodeSlurmBase64(ba.SKI)
+	if err != nil {
+		return BgpsecKeyJson{}, fmt.Errorf("invalid SKI %q: %v", ba.SKI, err)
+	}
+	if len(ski) != 20 {
+		return BgpsecKeyJson{}, fmt.Errorf("invalid SKI %q: %d bytes instead of 20", ba.SKI, len(ski))
+	}
+	pubkey, err := decodeSlurmBase64(ba.RouterPublicKey)
+	if err != nil || len(pubkey) == 0 {
+		return BgpsecKeyJson{}, fmt.Errorf("invalid routerPublicKey %q", ba.RouterPublicKey)
+	}
+	return BgpsecKeyJson{
+		ASN:    ba.ASN,
+		SKI:    hex.EncodeToString(ski),
+		Pubkey: base64.StdEncoding.EncodeToString(pubkey),
+		TA:     ba.Comment,
+	}, nil
+}
+
 type SlurmLocallyAddedAssertions struct {
-	PrefixAssertions []SlurmPrefixAssertion
+	PrefixAssertions []SlurmPrefixAssertion `json:"prefixAssertions"`
+	BgpsecAssertions []SlurmBgpsecAssertion `json:"bgpsecAssertions"`
+	ASPAAssertions   []SlurmASPAAssertion   `json:"aspaAssertions,omitempty"`
 }
 
 type SlurmConfig struct {
-	SlurmVersion            int
-	ValidationOutputFilters SlurmValidationOutputFilters
-	LocallyAddedAssertions  SlurmLocallyAddedAssertions
+	SlurmVersion            int                          `json:"slurmVersion"`
+	ValidationOutputFilters SlurmValidationOutputFilters `json:"validationOutputFilters"`
+	LocallyAddedAssertions  SlurmLocallyAddedAssertions  `json:"locallyAddedAssertions"`
 }
 
 func DecodeJSONSlurm(buf io.Reader) (*SlurmConfig, error) {
@@ -90,30 +175,10 @@ func (s *SlurmValidationOutputFilters) FilterOnVRPs(vrps []VRPJson) ([]VRPJson,
 	}
 	for _, vrp := range vrps {
 		rPrefix := vrp.GetPrefix()
-		var rIPStart net.IP
-		var rIPEnd net.IP
-		if rPrefix != nil {
-			rIPStart = rPrefix.IP.To16()
-			rIPEnd = GetIPBroadcast(*rPrefix).To16()
-		}
 
 		var wasRemoved bool
 		for _, filter := range s.PrefixFilters {
-			fPrefix := filter.GetPrefix()
-			fASN, fASNEmpty := filter.GetASN()
-			match := true
-			if match && fPrefix != nil && rPrefix != nil {
-
-				if !(fPrefix.Contains(rIPStart) && fPrefix.Contains(rIPEnd)) {
-					match = false
-				}
-			}
-			if match && !fASNEmpty {
-				if vrp.GetASN() != fASN {
-					match = false
-				}
-			}
-			if match {
+			if filter.matches(rPrefix, vrp.GetASN()) {
 				removed = append(removed, vrp)
 				wasRemoved = true
 				break
@@ -131,6 +196,26 @@ func (s *SlurmConfig) FilterOnVRPs(vrps []VRPJson) ([]VRPJson, []VRPJson) {
 	return s.ValidationOutputFilters.FilterOnVRPs(vrps)
 }
 
+// matches returns whether a prefix filter removes the VRPs of a prefix and an ASN: the
+// prefix of the filter contains the prefix, and its ASN is the same, when they are set.
+func (pf *SlurmPrefixFilter) matches(prefix *net.IPNet, asn uint32) bool {
+	if fPrefix := pf.GetPrefix(); fPrefix != nil && prefix != nil && !prefixContains(fPrefix, prefix) {
+		return false
+	}
+	if fASN, empty := pf.GetASN(); !empty && asn != fASN {
+		return false
+	}
+	return true
+}
+
+// prefixContains returns whether a prefix contains all the addresses of another one, of
+// the same address family.
+func prefixContains(prefix *net.IPNet, other *net.IPNet) bool {
+	ones, bits := prefix.Mask.Size()
+	otherOnes, otherBits := other.Mask.Size()
+	return bits == otherBits && ones <= otherOnes && prefix.Contains(other.IP)
+}
+
 func (s *SlurmLocallyAddedAssertions) AssertVRPs() []VRPJson {
 	vrps := make([]VRPJson, 0)
 	if s.PrefixAssertions == nil || len(s.PrefixAssertions) == 0 {
@@ -160,8 +245,433 @@ func (s *SlurmConfig) AssertVRPs() []VRPJson {
 	return s.LocallyAddedAssertions.AssertVRPs()
 }
 
+// NewSlurmAssertions returns a Slurm file asserting the VRPs, to keep serving them
+// whatever the cache. The comment is set on each assertion.
+func NewSlurmAssertions(vrps []VRPJson, comment string) *SlurmConfig {
+	assertions := make([]SlurmPrefixAssertion, 0, len(vrps))
+	for _, vrp := range vrps {
+		assertions = append(assertions, SlurmPrefixAssertion{
+			Prefix:          vrp.Prefix,
+			ASN:             vrp.GetASN(),
+			MaxPrefixLength: int(vrp.Length),
+			Comment:         comment,
+		})
+	}
+	return &SlurmConfig{
+		SlurmVersion: 1,
+		ValidationOutputFilters: SlurmValidationOutputFilters{
+			PrefixFilters: []SlurmPrefixFilter{},
+			BgpsecFilters: []SlurmBgpsecFilter{},
+		},
+		LocallyAddedAssertions: SlurmLocallyAddedAssertions{
+			PrefixAssertions: assertions,
+			BgpsecAssertions: []SlurmBgpsecAssertion{},
+		},
+	}
+}
+
+// FilterOnBgpsecKeys removes the router keys matching the ASN and the SKI of a BGPsec filter.
+func (s *SlurmValidationOutputFilters) FilterOnBgpsecKeys(keys []BgpsecKeyJson) ([]BgpsecKeyJson, []BgpsecKeyJson) {
+	removed := make([]BgpsecKeyJson, 0)
+	if len(s.BgpsecFilters) == 0 {
+		return keys, removed
+	}
+	kept := make([]BgpsecKeyJson, 0, len(keys))
+	for _, key := range keys {
+		var wasRemoved bool
+		for _, filter := range s.BgpsecFilters {
+			fASN, fASNEmpty := filter.GetASN()
+			fSKI := filter.GetSKI()
+			if fASNEmpty && fSKI == "" {
+				continue
+			}
+			if (fASNEmpty || key.ASN == fASN) && (fSKI == "" || strings.ToLower(key.SKI) == fSKI) {
+				wasRemoved = true
+				break
+			}
+		}
+		if wasRemoved {
+			removed = append(removed, key)
+		} else {
+			kept = append(kept, key)
+		}
+	}
+	return kept, removed
+}
+
+func (s *SlurmConfig) FilterOnBgpsecKeys(keys []BgpsecKeyJson) ([]BgpsecKeyJson, []BgpsecKeyJson) {
+	return s.ValidationOutputFilters.FilterOnBgpsecKeys(keys)
+}
+
+// AssertBgpsecKeys returns the router keys of the BGPsec assertions, skipping the ones
+// that cannot be decoded (see Validate).
+func (s *SlurmLocallyAddedAssertions) AssertBgpsecKeys() []BgpsecKeyJson {
+	keys := make([]BgpsecKeyJson, 0, len(s.BgpsecAssertions))
+	for _, assertion := range s.BgpsecAssertions {
+		key, err := assertion.GetKey()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (s *SlurmConfig) AssertBgpsecKeys() []BgpsecKeyJson {
+	return s.LocallyAddedAssertions.AssertBgpsecKeys()
+}
+
+func (s *SlurmConfig) FilterAssertBgpsecKeys(keys []BgpsecKeyJson) []BgpsecKeyJson {
+	kept, _ := s.FilterOnBgpsecKeys(keys)
+	return append(kept, s.AssertBgpsecKeys()...)
+}
+
+// FilterOnASPAs removes the ASPAs of the customers of the ASPA filters.
+func (s *SlurmValidationOutputFilters) FilterOnASPAs(aspas []ASPAJson) ([]ASPAJson, []ASPAJson) {
+	removed := make([]ASPAJson, 0)
+	if len(s.ASPAFilters) == 0 {
+		return aspas, removed
+	}
+	customers := make(map[uint32]bool, len(s.ASPAFilters))
+	for _, filter := range s.ASPAFilters {
+		customers[filter.CustomerASID] = true
+	}
+	kept := make([]ASPAJson, 0, len(aspas))
+	for _, aspa := range aspas {
+		if customers[aspa.CustomerASID] {
+			removed = append(removed, aspa)
+		} else {
+			kept = append(kept, aspa)
+		}
+	}
+	return kept, removed
+}
+
+func (s *SlurmConfig) FilterOnASPAs(aspas []ASPAJson) ([]ASPAJson, []ASPAJson) {
+	return s.ValidationOutputFilters.FilterOnASPAs(aspas)
+}
+
+func (s *SlurmLocallyAddedAssertions) AssertASPAs() []ASPAJson {
+	aspas := make([]ASPAJson, 0, len(s.ASPAAssertions))
+	for _, assertion := range s.ASPAAssertions {
+		providers := make([]uint32, len(assertion.ProviderSet))
+		copy(providers, assertion.ProviderSet)
+		aspas = append(aspas, ASPAJson{
+			CustomerASID: assertion.CustomerASID,
+			Providers:    providers,
+		})
+	}
+	return aspas
+}
+
+func (s *SlurmConfig) AssertASPAs() []ASPAJson {
+	return s.LocallyAddedAssertions.AssertASPAs()
+}
+
+// FilterAssertASPAs filters the ASPAs and adds the asserted ones. There is a single
+// ASPA per customer: an asserted ASPA replaces the one of the same customer.
+func (s *SlurmConfig) FilterAssertASPAs(aspas []ASPAJson) []ASPAJson {
+	kept, _ := s.FilterOnASPAs(aspas)
+	asserted := s.AssertASPAs()
+	if len(asserted) == 0 {
+		return kept
+	}
+	customers := make(map[uint32]bool, len(asserted))
+	for _, aspa := range asserted {
+		customers[aspa.CustomerASID] = true
+	}
+	result := make([]ASPAJson, 0, len(kept)+len(asserted))
+	for _, aspa := range kept {
+		if !customers[aspa.CustomerASID] {
+			result = append(result, aspa)
+		}
+	}
+	return append(result, asserted...)
+}
+
+// Validate checks the version and that the filters and assertions are well-formed.
+// A filter with a prefix that cannot be parsed would otherwise match all the VRPs and
+// an assertion with an invalid prefix would be ignored.
+func (s *SlurmConfig) Validate() error {
+	if s.SlurmVersion != 1 && s.SlurmVersion != 2 {
+		return fmt.Errorf("unsupported slurmVersion %d", s.SlurmVersion)
+	}
+	if s.SlurmVersion == 1 && (len(s.ValidationOutputFilters.ASPAFilters) > 0 || len(s.LocallyAddedAssertions.ASPAAssertions) > 0) {
+		return fmt.Errorf("ASPA filters and assertions require slurmVersion 2")
+	}
+	for i, filter := range s.ValidationOutputFilters.PrefixFilters {
+		if filter.Prefix == "" && filter.ASN == nil {
+			return fmt.Errorf("prefix filter %d: no prefix nor asn", i)
+		}
+		if filter.Prefix != "" && filter.GetPrefix() == nil {
+			return fmt.Errorf("prefix filter %d: invalid prefix %q", i, filter.Prefix)
+		}
+		if _, empty := filter.GetASN(); filter.ASN != nil && empty {
+			return fmt.Errorf("prefix filter %d: invalid asn %v", i, filter.ASN)
+		}
+	}
+	for i, assertion := range s.LocallyAddedAssertions.PrefixAssertions {
+		prefix := assertion.GetPrefix()
+		if prefix == nil {
+			return fmt.Errorf("prefix assertion %d: invalid prefix %q", i, assertion.Prefix)
+		}
+		size, bits := prefix.Mask.Size()
+		if assertion.MaxPrefixLength != 0 && (assertion.MaxPrefixLength < size || assertion.MaxPrefixLength > bits) {
+			return fmt.Errorf("prefix assertion %d: invalid maxPrefixLength %d for %v", i, assertion.MaxPrefixLength, prefix)
+		}
+	}
+	for i, filter := range s.ValidationOutputFilters.BgpsecFilters {
+		if filter.SKI == "" && filter.ASN == nil {
+			return fmt.Errorf("BGPsec filter %d: no SKI nor asn", i)
+		}
+		if _, empty := filter.GetASN(); filter.ASN != nil && empty {
+			return fmt.Errorf("BGPsec filter %d: invalid asn %v", i, filter.ASN)
+		}
+		if filter.SKI != "" {
+			if ski, err := decodeSlurmBase64(filter.SKI); err != nil || len(ski) != 20 {
+				return fmt.Errorf("BGPsec filter %d: invalid SKI %q", i, filter.SKI)
+			}
+		}
+	}
+	for i, assertion := range s.LocallyAddedAssertions.BgpsecAssertions {
+		if assertion.ASN == 0 {
+			return fmt.Errorf("BGPsec assertion %d: no asn", i)
+		}
+		if _, err := assertion.GetKey(); err != nil {
+			return fmt.Errorf("BGPsec assertion %d: %v", i, err)
+		}
+	}
+	for i, filter := range s.ValidationOutputFilters.ASPAFilters {
+		if filter.CustomerASID == 0 {
+			return fmt.Errorf("ASPA filter %d: no customerAsid", i)
+		}
+	}
+	customers := make(map[uint32]bool, len(s.LocallyAddedAssertions.ASPAAssertions))
+	for i, assertion := range s.LocallyAddedAssertions.ASPAAssertions {
+		if assertion.CustomerASID == 0 {
+			return fmt.Errorf("ASPA assertion %d: no customerAsid", i)
+		}
+		if customers[assertion.CustomerASID] {
+			return fmt.Errorf("ASPA assertion %d: AS%d is already asserted", i, assertion.CustomerASID)
+		}
+		customers[assertion.CustomerASID] = true
+		for _, provider := range assertion.ProviderSet {
+			if provider == assertion.CustomerASID {
+				return fmt.Errorf("ASPA assertion %d: AS%d is its own provider", i, provider)
+			}
+		}
+	}
+	return nil
+}
+
+// A SlurmConflict is a prefix assertion that is also matched by a prefix filter,
+// which RFC 8416 does not allow.
+type SlurmConflict struct {
+	Filter    SlurmPrefixFilter
+	Assertion SlurmPrefixAssertion
+
+	assertionIndex int
+}
+
+func (c SlurmConflict) String() string {
+	return fmt.Sprintf("assertion %v/%v AS%v (%v) is matched by filter %v AS%v (%v)",
+		c.Assertion.Prefix, c.Assertion.MaxPrefixLength, c.Assertion.ASN, c.Assertion.Comment,
+		c.Filter.Prefix, c.Filter.ASN, c.Filter.Comment)
+}
+
+// FindConflicts returns the prefix assertions that would be removed by one of
+// the prefix filters. Only the first matching filter is reported per assertion.
+func (s *SlurmConfig) FindConflicts() []SlurmConflict {
+	conflicts := make([]SlurmConflict, 0)
+	for i, assertion := range s.LocallyAddedAssertions.PrefixAssertions {
+		prefix := assertion.GetPrefix()
+		for _, filter := range s.ValidationOutputFilters.PrefixFilters {
+			if filter.matches(prefix, assertion.ASN) {
+				conflicts = append(conflicts, SlurmConflict{
+					Filter:         filter,
+					Assertion:      assertion,
+					assertionIndex: i,
+				})
+				break
+			}
+		}
+	}
+	return conflicts
+}
+
+// RemoveAssertions drops the assertions of the given conflicts (as returned by
+// FindConflicts on the same configuration), letting the filters win.
+func (s *SlurmConfig) RemoveAssertions(conflicts []SlurmConflict) {
+	toRemove := make(map[int]bool, len(conflicts))
+	for _, c := range conflicts {
+		toRemove[c.assertionIndex] = true
+	}
+	kept := make([]SlurmPrefixAssertion, 0, len(s.LocallyAddedAssertions.PrefixAssertions))
+	for i, assertion := range s.LocallyAddedAssertions.PrefixAssertions {
+		if !toRemove[i] {
+			kept = append(kept, assertion)
+		}
+	}
+	s.LocallyAddedAssertions.PrefixAssertions = kept
+}
+
 func (s *SlurmConfig) FilterAssert(vrps []VRPJson) []VRPJson {
 	a, _ := s.FilterOnVRPs(vrps)
 	b := s.AssertVRPs()
 	return append(a, b...)
 }
+
+// A SlurmOverlap is an entry of a Slurm file overlapping with an entry of another file.
+// RFC 8416 (section 4.2) does not allow the prefixes of the files used together to
+// overlap. The customers of the ASPA filters and assertions are checked the same way,
+// and the ASN and the SKI of the BGPsec filters and assertions.
+type SlurmOverlap struct {
+	File       int
+	Entry      string
+	OtherFile  int
+	OtherEntry string
+}
+
+func (o SlurmOverlap) String() string {
+	return fmt.Sprintf("%v overlaps with %v", o.Entry, o.OtherEntry)
+}
+
+type slurmEntry struct {
+	description string
+	prefix      *net.IPNet
+	customer    uint32
+	// BGPsec entries: a missing ASN or SKI matches any
+	bgpsec bool
+	asn    uint32
+	anyASN bool
+	ski    string
+}
+
+// describeEntry names an entry of a Slurm file in the messages, with its comment if any.
+func describeEntry(comment string, format string, a ...interface{}) string {
+	description := fmt.Sprintf(format, a...)
+	if comment != "" {
+		description += fmt.Sprintf(" (%v)", comment)
+	}
+	return description
+}
+
+func (s *SlurmConfig) entries() []slurmEntry {
+	entries := make([]slurmEntry, 0)
+	for _, filter := range s.ValidationOutputFilters.PrefixFilters {
+		if prefix := filter.GetPrefix(); prefix != nil {
+			description := describeEntry(filter.Comment, "prefix filter %v", filter.Prefix)
+			if asn, empty := filter.GetASN(); !empty {
+				description = describeEntry(filter.Comment, "prefix filter %v AS%v", filter.Prefix, asn)
+			}
+			entries = append(entries, slurmEntry{
+				description: description,
+				prefix:      prefix,
+			})
+		}
+	}
+	for _, assertion := range s.LocallyAddedAssertions.PrefixAssertions {
+		if prefix := assertion.GetPrefix(); prefix != nil {
+			entries = append(entries, slurmEntry{
+				description: describeEntry(assertion.Comment, "prefix assertion %v AS%v", assertion.Prefix, assertion.ASN),
+				prefix:      prefix,
+			})
+		}
+	}
+	for _, filter := range s.ValidationOutputFilters.BgpsecFilters {
+		asn, empty := filter.GetASN()
+		description := "BGPsec filter"
+		if !empty {
+			description += fmt.Sprintf(" AS%v", asn)
+		}
+		if filter.SKI != "" {
+			description += fmt.Sprintf(" SKI %v", filter.SKI)
+		}
+		entries = append(entries, slurmEntry{
+			description: describeEntry(filter.Comment, "%v", description),
+			bgpsec:      true,
+			asn:         asn,
+			anyASN:      empty,
+			ski:         filter.GetSKI(),
+		})
+	}
+	for _, assertion := range s.LocallyAddedAssertions.BgpsecAssertions {
+		key, _ := assertion.GetKey()
+		entries = append(entries, slurmEntry{
+			description: describeEntry(assertion.Comment, "BGPsec assertion AS%v SKI %v", assertion.ASN, assertion.SKI),
+			bgpsec:      true,
+			asn:         assertion.ASN,
+			ski:         key.SKI,
+		})
+	}
+	for _, filter := range s.ValidationOutputFilters.ASPAFilters {
+		entries = append(entries, slurmEntry{
+			description: describeEntry(filter.Comment, "ASPA filter AS%v", filter.CustomerASID),
+			customer:    filter.CustomerASID,
+		})
+	}
+	for _, assertion := range s.LocallyAddedAssertions.ASPAAssertions {
+		entries = append(entries, slurmEntry{
+			description: describeEntry(assertion.Comment, "ASPA assertion AS%v", assertion.CustomerASID),
+			customer:    assertion.CustomerASID,
+		})
+	}
+	return entries
+}
+
+func (e slurmEntry) overlaps(other slurmEntry) bool {
+	if e.prefix != nil && other.prefix != nil {
+		return prefixContains(e.prefix, other.prefix) || prefixContains(other.prefix, e.prefix)
+	}
+	if e.bgpsec && other.bgpsec {
+		return (e.anyASN || other.anyASN || e.asn == other.asn) && (e.ski == "" || other.ski == "" || e.ski == other.ski)
+	}
+	return e.customer != 0 && e.customer == other.customer
+}
+
+// FindOverlaps returns the entries of each Slurm file overlapping with the ones of
+// a following file.
+func FindOverlaps(configs []*SlurmConfig) []SlurmOverlap {
+	overlaps := make([]SlurmOverlap, 0)
+	entries := make([][]slurmEntry, len(configs))
+	for i, config := range configs {
+		entries[i] = config.entries()
+	}
+	for i := range entries {
+		for j := i + 1; j < len(entries); j++ {
+			for _, entry := range entries[i] {
+				for _, other := range entries[j] {
+					if entry.overlaps(other) {
+						overlaps = append(overlaps, SlurmOverlap{
+							File:       i,
+							Entry:      entry.description,
+							OtherFile:  j,
+							OtherEntry: other.description,
+						})
+					}
+				}
+			}
+		}
+	}
+	return overlaps
+}
+
+// MergeSlurm returns the filters and assertions of all the Slurm files in a single
+// configuration, of the highest version.
+func MergeSlurm(configs []*SlurmConfig) *SlurmConfig {
+	merged := &SlurmConfig{}
+	for _, config := range configs {
+		if config.SlurmVersion > merged.SlurmVersion {
+			merged.SlurmVersion = config.SlurmVersion
+		}
+		filters := &merged.ValidationOutputFilters
+		filters.PrefixFilters = append(filters.PrefixFilters, config.ValidationOutputFilters.PrefixFilters...)
+		filters.BgpsecFilters = append(filters.BgpsecFilters, config.ValidationOutputFilters.BgpsecFilters...)
+		filters.ASPAFilters = append(filters.ASPAFilters, config.ValidationOutputFilters.ASPAFilters...)
+		assertions := &merged.LocallyAddedAssertions
+		assertions.PrefixAssertions = append(assertions.PrefixAssertions, config.LocallyAddedAssertions.PrefixAssertions...)
+		assertions.BgpsecAssertions = append(assertions.BgpsecAssertions, config.LocallyAddedAssertions.BgpsecAssertions...)
+		assertions.ASPAAssertions = append(assertions.ASPAAssertions, config.LocallyAddedAssertions.ASPAAssertions...)
+	}
+	return merged
+}
@@ -1,7 +1,10 @@
 package prefixfile
 
 import (
+	"encoding/json"
+	"net"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -96,3 +99,210 @@ func TestAssertVRPs(t *testing.T) {
 	vrps := slurm.AssertVRPs()
 	assert.Len(t, vrps, 3)
 }
+
+func TestFindConflicts(t *testing.T) {
+	slurm := &SlurmConfig{
+		ValidationOutputFilters: SlurmValidationOutputFilters{
+			PrefixFilters: []SlurmPrefixFilter{
+				{
+					Prefix: "10.0.0.0/8",
+				},
+				{
+					ASN:    uint32(65002),
+					Prefix: "192.168.0.0/16",
+				},
+			},
+		},
+		LocallyAddedAssertions: SlurmLocallyAddedAssertions{
+			PrefixAssertions: []SlurmPrefixAssertion{
+				{
+					ASN:    uint32(65001),
+					Prefix: "10.1.0.0/16",
+				},
+				{
+					ASN:    uint32(65001),
+					Prefix: "192.168.0.0/24",
+				},
+				{
+					ASN:    uint32(65002),
+					Prefix: "192.168.1.0/24",
+				},
+			},
+		},
+	}
+	conflicts := slurm.FindConflicts()
+	assert.Len(t, conflicts, 2)
+	assert.Equal(t, "10.1.0.0/16", conflicts[0].Assertion.Prefix)
+	assert.Equal(t, "10.0.0.0/8", conflicts[0].Filter.Prefix)
+	assert.Equal(t, "192.168.1.0/24", conflicts[1].Assertion.Prefix)
+
+	slurm.RemoveAssertions(conflicts)
+	assert.Len(t, slurm.LocallyAddedAssertions.PrefixAssertions, 1)
+	assert.Equal(t, "192.168.0.0/24", slurm.LocallyAddedAssertions.PrefixAssertions[0].Prefix)
+	assert.Len(t, slurm.FindConflicts(), 0)
+}
+
+func TestPrefixContains(t *testing.T) {
+	parse := func(s string) *net.IPNet {
+		_, prefix, _ := net.ParseCIDR(s)
+		return prefix
+	}
+	assert.True(t, prefixContains(parse("10.0.0.0/8"), parse("10.1.0.0/16")))
+	assert.True(t, prefixContains(parse("10.0.0.0/8"), parse("10.0.0.0/8")))
+	assert.False(t, prefixContains(parse("10.1.0.0/16"), parse("10.0.0.0/8")))
+	assert.False(t, prefixContains(parse("10.0.0.0/8"), parse("192.168.0.0/16")))
+	// An IPv6 prefix does not contain the IPv4 prefixes
+	assert.False(t, prefixContains(parse("::/0"), parse("10.0.0.0/8")))
+	assert.False(t, prefixContains(parse("::ffff:0:0/96"), parse("10.0.0.0/8")))
+}
+
+func TestValidateSlurm(t *testing.T) {
+	json, err := os.Open("slurm.json")
+	if err != nil {
+		panic(err)
+	}
+	decoded, err := DecodeJSONSlurm(json)
+	assert.Nil(t, err)
+	assert.Nil(t, decoded.Validate())
+
+	invalid := map[string]string{
+		"version":          `{"slurmVersion": 3}`,
+		"aspa version":     `{"slurmVersion": 1, "validationOutputFilters": {"aspaFilters": [{"customerAsid": 64496}]}}`,
+		"aspa filter":      `{"slurmVersion": 2, "validationOutputFilters": {"aspaFilters": [{"comment": "nothing"}]}}`,
+		"aspa customer":    `{"slurmVersion": 2, "locallyAddedAssertions": {"aspaAssertions": [{"providerSet": [64497]}]}}`,
+		"aspa duplicate":   `{"slurmVersion": 2, "locallyAddedAssertions": {"aspaAssertions": [{"customerAsid": 64496, "providerSet": [64497]}, {"customerAsid": 64496, "providerSet": [64498]}]}}`,
+		"aspa provider":    `{"slurmVersion": 2, "locallyAddedAssertions": {"aspaAssertions": [{"customerAsid": 64496, "providerSet": [64496, 64497]}]}}`,
+		"empty filter":     `{"slurmVersion": 1, "validationOutputFilters": {"prefixFilters": [{"comment": "nothing"}]}}`,
+		"filter prefix":    `{"slurmVersion": 1, "validationOutputFilters": {"prefixFilters": [{"prefix": "192.0.2/24"}]}}`,
+		"filter asn":       `{"slurmVersion": 1, "validationOutputFilters": {"prefixFilters": [{"asn": "AS64496"}]}}`,
+		"assertion prefix": `{"slurmVersion": 1, "locallyAddedAssertions": {"prefixAssertions": [{"asn": 64496, "prefix": "198.51.100.0/33"}]}}`,
+		"assertion short":  `{"slurmVersion": 1, "locallyAddedAssertions": {"prefixAssertions": [{"asn": 64496, "prefix": "198.51.100.0/24", "maxPrefixLength": 16}]}}`,
+		"assertion long":   `{"slurmVersion": 1, "locallyAddedAssertions": {"prefixAssertions": [{"asn": 64496, "prefix": "2001:db8::/32", "maxPrefixLength": 129}]}}`,
+		"bgpsec filter":    `{"slurmVersion": 1, "validationOutputFilters": {"bgpsecFilters": [{"comment": "nothing"}]}}`,
+		"bgpsec ski":       `{"slurmVersion": 1, "validationOutputFilters": {"bgpsecFilters": [{"SKI": "Zm9v"}]}}`,
+		"bgpsec asn":       `{"slurmVersion": 1, "locallyAddedAssertions": {"bgpsecAssertions": [{"SKI": "C-7Hteo_D9vJXQ3UfzxbwnXaijM", "routerPublicKey": "AQID"}]}}`,
+		"bgpsec key":       `{"slurmVersion": 1, "locallyAddedAssertions": {"bgpsecAssertions": [{"asn": 64496, "SKI": "C-7Hteo_D9vJXQ3UfzxbwnXaijM", "routerPublicKey": "not base64"}]}}`,
+	}
+	for name, data := range invalid {
+		decoded, err := DecodeJSONSlurm(strings.NewReader(data))
+		assert.Nil(t, err, name)
+		assert.NotNil(t, decoded.Validate(), name)
+	}
+}
+
+func TestFilterAssertASPAs(t *testing.T) {
+	slurm, err := DecodeJSONSlurm(strings.NewReader(`{
+  "slurmVersion": 2,
+  "validationOutputFilters": {
+    "prefixFilters": [],
+    "aspaFilters": [
+      {"customerAsid": 64496, "comment": "All ASPAs of AS64496"}
+    ]
+  },
+  "locallyAddedAssertions": {
+    "prefixAssertions": [],
+    "aspaAssertions": [
+      {"customerAsid": 64497, "providerSet": [64510, 64511], "comment": "Local providers"},
+      {"customerAsid": 64499, "providerSet": [], "comment": "No providers"}
+    ]
+  }
+}`))
+	assert.Nil(t, err)
+	assert.Nil(t, slurm.Validate())
+
+	aspas := []ASPAJson{
+		{CustomerASID: 64496, Providers: []uint32{64500}},
+		{CustomerASID: 64497, Providers: []uint32{64501}},
+		{CustomerASID: 64498, Providers: []uint32{64502}},
+	}
+	kept, removed := slurm.FilterOnASPAs(aspas)
+	assert.Equal(t, aspas[1:], kept)
+	assert.Equal(t, aspas[:1], removed)
+
+	assert.Equal(t, []ASPAJson{
+		{CustomerASID: 64498, Providers: []uint32{64502}},
+		{CustomerASID: 64497, Providers: []uint32{64510, 64511}},
+		{CustomerASID: 64499, Providers: []uint32{}},
+	}, slurm.FilterAssertASPAs(aspas))
+}
+
+func TestFilterAssertBgpsecKeys(t *testing.T) {
+	json, err := os.Open("slurm.json")
+	if err != nil {
+		panic(err)
+	}
+	slurm, err := DecodeJSONSlurm(json)
+	assert.Nil(t, err)
+
+	keys := []BgpsecKeyJson{
+		{ASN: 64496, SKI: "62cdb7020ff920e5aa642c3d4066950dd1f01f4d", Pubkey: "AQID"},
+		{ASN: 64498, SKI: "0BEEC7B5EA3F0FDBC95D0DD47F3C5BC275DA8A33", Pubkey: "AQID"},
+		{ASN: 64497, SKI: "62cdb7020ff920e5aa642c3d4066950dd1f01f4d", Pubkey: "AQID"},
+		{ASN: 64498, SKI: "62cdb7020ff920e5aa642c3d4066950dd1f01f4d", Pubkey: "AQID"},
+	}
+	kept, removed := slurm.FilterOnBgpsecKeys(keys)
+	assert.Equal(t, keys[3:], kept)
+	assert.Equal(t, keys[:3], removed)
+
+	assert.Equal(t, []BgpsecKeyJson{
+		keys[3],
+		{
+			ASN:    64496,
+			SKI:    "8034c43d15bb7ef42e2cc24255217acfc5f4b2f4",
+			Pubkey: "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE",
+			TA:     "My known key for my important ASN",
+		},
+	}, slurm.FilterAssertBgpsecKeys(keys))
+}
+
+func TestFindOverlaps(t *testing.T) {
+	decode := func(data string) *SlurmConfig {
+		slurm, err := DecodeJSONSlurm(strings.NewReader(data))
+		assert.Nil(t, err)
+		return slurm
+	}
+	site := decode(`{"slurmVersion": 2, "validationOutputFilters": {"prefixFilters": [{"prefix": "192.0.2.0/24", "comment": "site"}, {"asn": 64496}], "aspaFilters": [{"customerAsid": 64500}]}}`)
+	team := decode(`{"slurmVersion": 1, "locallyAddedAssertions": {"prefixAssertions": [{"asn": 64496, "prefix": "198.51.100.0/24"}, {"asn": 64497, "prefix": "2001:db8::/32"}]}}`)
+	other := decode(`{"slurmVersion": 2, "locallyAddedAssertions": {"prefixAssertions": [{"asn": 64498, "prefix": "192.0.2.128/25", "comment": "other"}], "aspaAssertions": [{"customerAsid": 64500, "providerSet": [64501]}]}}`)
+
+	assert.Empty(t, FindOverlaps([]*SlurmConfig{site, team}))
+	overlaps := FindOverlaps([]*SlurmConfig{site, team, other})
+	assert.Equal(t, []SlurmOverlap{
+		{File: 0, Entry: "prefix filter 192.0.2.0/24 (site)", OtherFile: 2, OtherEntry: "prefix assertion 192.0.2.128/25 AS64498 (other)"},
+		{File: 0, Entry: "ASPA filter AS64500", OtherFile: 2, OtherEntry: "ASPA assertion AS64500"},
+	}, overlaps)
+
+	merged := MergeSlurm([]*SlurmConfig{site, team})
+	assert.Equal(t, 2, merged.SlurmVersion)
+	assert.Len(t, merged.ValidationOutputFilters.PrefixFilters, 2)
+	assert.Len(t, merged.ValidationOutputFilters.ASPAFilters, 1)
+	assert.Len(t, merged.LocallyAddedAssertions.PrefixAssertions, 2)
+
+	keys := decode(`{"slurmVersion": 1, "validationOutputFilters": {"bgpsecFilters": [{"asn": 64496}]}}`)
+	router := decode(`{"slurmVersion": 1, "locallyAddedAssertions": {"bgpsecAssertions": [{"asn": 64496, "SKI": "C-7Hteo_D9vJXQ3UfzxbwnXaijM", "routerPublicKey": "AQID", "comment": "router"}, {"asn": 64497, "SKI": "C-7Hteo_D9vJXQ3UfzxbwnXaijM", "routerPublicKey": "AQID"}]}}`)
+	assert.Equal(t, []SlurmOverlap{
+		{File: 0, Entry: "BGPsec filter AS64496", OtherFile: 1, OtherEntry: "BGPsec assertion AS64496 SKI C-7Hteo_D9vJXQ3UfzxbwnXaijM (router)"},
+	}, FindOverlaps([]*SlurmConfig{keys, router}))
+	assert.Len(t, MergeSlurm([]*SlurmConfig{keys, router}).LocallyAddedAssertions.BgpsecAssertions, 2)
+}
+
+func TestNewSlurmAssertions(t *testing.T) {
+	vrps := []VRPJson{
+		{Prefix: "192.0.2.0/24", Length: 24, ASN: uint32(64496)},
+		{Prefix: "2001:db8::/32", Length: 48, ASN: "AS64497"},
+	}
+	slurm := NewSlurmAssertions(vrps, "frozen")
+	assert.Nil(t, slurm.Validate())
+	assert.Equal(t, vrps[0].Prefix, slurm.AssertVRPs()[0].Prefix)
+
+	var out strings.Builder
+	assert.Nil(t, json.NewEncoder(&out).Encode(slurm))
+	assert.Equal(t, `{"slurmVersion":1,"validationOutputFilters":{"prefixFilters":[],"bgpsecFilters":[]},`+
+		`"locallyAddedAssertions":{"prefixAssertions":[`+
+		`{"prefix":"192.0.2.0/24","asn":64496,"maxPrefixLength":24,"comment":"frozen"},`+
+		`{"prefix":"2001:db8::/32","asn":64497,"maxPrefixLength":48,"comment":"frozen"}],"bgpsecAssertions":[]}}`+"\n", out.String())
+
+	decoded, err := DecodeJSONSlurm(strings.NewReader(out.String()))
+	assert.Nil(t, err)
+	assert.Equal(t, slurm.LocallyAddedAssertions.PrefixAssertions, decoded.LocallyAddedAssertions.PrefixAssertions)
+}
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Maximum number of entries read from an archive
+const maxArchiveEntries = 1024
+
+type archiveMember struct {
+	Name string
+	Data []byte
+}
+
+// extractArchive returns the JSON member of a tar.gz or zip archive, after checking it
+// against a bundled SHA-256 checksum file if there is one. A gzipped file that is not
+// a tar archive is decompressed. Other data is returned as is.
+// At most MaxBytes are decompressed.
+func (c *FetchConfig) extractArchive(file string, data []byte) ([]byte, error) {
+	var members []archiveMember
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		var decompressed []byte
+		decompressed, err = c.gunzip(file, data)
+		if err != nil {
+			return nil, err
+		}
+		if !isTar(decompressed) {
+			return decompressed, nil
+		}
+		members, err = c.readTar(file, decompressed)
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		members, err = c.readZip(file, data)
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("archive %s: %v", file, err)
+	}
+
+	member, err := c.selectArchiveMember(members)
+	if err != nil {
+		return nil, fmt.Errorf("archive %s: %v", file, err)
+	}
+	if err := checkArchiveChecksum(member, members); err != nil {
+		return nil, fmt.Errorf("archive %s: %v", file, err)
+	}
+	return member.Data, nil
+}
+
+func (c *FetchConfig) gunzip(file string, data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	buf := bytes.NewBuffer(nil)
+	err = c.copyLimited(buf, zr, file)
+	return buf.Bytes(), err
+}
+
+func isTar(data []byte) bool {
+	return len(data) >= 262 && string(data[257:262]) == "ustar"
+}
+
+func (c *FetchConfig) readTar(file string, data []byte) ([]archiveMember, error) {
+	var members []archiveMember
+	var total int64
+	tr := tar.NewReader(bytes.NewReader(data))
+	for entries := 0; ; entries++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entries >= maxArchiveEntries {
+			return nil, fmt.Errorf("more than %d entries", maxArchiveEntries)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		buf := bytes.NewBuffer(nil)
+		if err := c.copyLimited(buf, tr, file); err != nil {
+			return nil, err
+		}
+		total += int64(buf.Len())
+		if c.MaxBytes > 0 && total > c.MaxBytes {
+			return nil, FileTooLarge{File: file, MaxBytes: c.MaxBytes}
+		}
+		members = append(members, archiveMember{
+			Name: hdr.Name,
+			Data: buf.Bytes(),
+		})
+	}
+}
+
+func (c *FetchConfig) readZip(file string, data []byte) ([]archiveMember, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	if len(zr.File) > maxArchiveEntries {
+		return nil, fmt.Errorf("more than %d entries", maxArchiveEntries)
+	}
+	var members []archiveMember
+	var total int64
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			continue
+		}
+		f, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		// The uncompressed size in the header cannot be trusted
+		buf := bytes.NewBuffer(nil)
+		err = c.copyLimited(buf, f, file)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		total += int64(buf.Len())
+		if c.MaxBytes > 0 && total > c.MaxBytes {
+			return nil, FileTooLarge{File: file, MaxBytes: c.MaxBytes}
+		}
+		members = append(members, archiveMember{
+			Name: zf.Name,
+			Data: buf.Bytes(),
+		})
+	}
+	return members, nil
+}
+
+// selectArchiveMember returns the member named ArchiveMember (full path or base name)
+// or the only .json member if it is not set.
+func (c *FetchConfig) selectArchiveMember(members []archiveMember) (archiveMember, error) {
+	var found []archiveMember
+	for _, member := range members {
+		if c.ArchiveMember != "" {
+			if member.Name == c.ArchiveMember || path.Base(member.Name) == c.ArchiveMember {
+				found = append(found, member)
+			}
+		} else if strings.HasSuffix(strings.ToLower(member.Name), ".json") {
+			found = append(found, member)
+		}
+	}
+	switch {
+	case len(found) == 0 && c.ArchiveMember != "":
+		return archiveMember{}, fmt.Errorf("no member %s", c.ArchiveMember)
+	case len(found) == 0:
+		return archiveMember{}, errors.New("no JSON member")
+	case len(found) > 1:
+		return archiveMember{}, fmt.Errorf("%d members match, select one", len(found))
+	}
+	return found[0], nil
+}
+
+// checkArchiveChecksum looks for the SHA-256 of the member in the checksum files of the archive:
+// files named SHA256SUMS or ending with .sha256 containing "<hash>  <name>" lines, or only the
+// hash in <member>.sha256.
+func checkArchiveChecksum(member archiveMember, members []archiveMember) error {
+	base := path.Base(member.Name)
+	for _, sums := range members {
+		sumsBase := path.Base(sums.Name)
+		if !strings.EqualFold(sumsBase, "SHA256SUMS") && !strings.HasSuffix(strings.ToLower(sumsBase), ".sha256") {
+			continue
+		}
+		for _, line := range strings.Split(string(sums.Data), "\n") {
+			fields := strings.Fields(line)
+			var expected string
+			switch {
+			case len(fields) == 1 && sumsBase == base+".sha256":
+				expected = fields[0]
+			case len(fields) == 2 && path.Base(strings.TrimPrefix(fields[1], "*")) == base:
+				expected = fields[0]
+			default:
+				continue
+			}
+			hash := sha256.Sum256(member.Data)
+			if !strings.EqualFold(hex.EncodeToString(hash[:]), expected) {
+				return fmt.Errorf("SHA-256 of %s does not match %s", member.Name, sums.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTarGz(t *testing.T, files map[string][]byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	zw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(zw)
+	for name, data := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}))
+		tw.Write(data)
+	}
+	tw.Close()
+	zw.Close()
+	return buf.Bytes()
+}
+
+func makeZip(t *testing.T, files map[string][]byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(buf)
+	for name, data := range files {
+		w, err := zw.Create(name)
+		assert.NoError(t, err)
+		w.Write(data)
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestFetchFileArchive(t *testing.T) {
+	content := []byte(`{"roas": []}`)
+	hash := sha256.Sum256(content)
+	sum := hex.EncodeToString(hash[:])
+
+	gzipped := bytes.NewBuffer(nil)
+	zw := gzip.NewWriter(gzipped)
+	zw.Write(content)
+	zw.Close()
+
+	tests := []struct {
+		name   string
+		data   []byte
+		member string
+		err    bool
+	}{
+		{name: "plain", data: content},
+		{name: "gzip", data: gzipped.Bytes()},
+		{name: "tar.gz", data: makeTarGz(t, map[string][]byte{"output/vrps.json": content})},
+		{name: "tar.gz with SHA256SUMS", data: makeTarGz(t, map[string][]byte{
+			"output/vrps.json": content,
+			"SHA256SUMS":       []byte(sum + "  output/vrps.json\n"),
+		})},
+		{name: "tar.gz with a wrong checksum", data: makeTarGz(t, map[string][]byte{
+			"vrps.json":        content,
+			"vrps.json.sha256": []byte("0000\n"),
+		}), err: true},
+		{name: "zip with a checksum", data: makeZip(t, map[string][]byte{
+			"vrps.json":        content,
+			"vrps.json.sha256": []byte(sum + "\n"),
+		})},
+		{name: "zip with several JSON members", data: makeZip(t, map[string][]byte{
+			"vrps.json":  content,
+			"other.json": []byte("{}"),
+		}), err: true},
+		{name: "zip with a selected member", data: makeZip(t, map[string][]byte{
+			"vrps.json":  content,
+			"other.json": []byte("{}"),
+		}), member: "vrps.json"},
+		{name: "zip without JSON", data: makeZip(t, map[string][]byte{
+			"README": content,
+		}), err: true},
+		{name: "zip bomb", data: makeZip(t, map[string][]byte{
+			"vrps.json": bytes.Repeat([]byte(" "), 1<<20),
+		}), err: true},
+	}
+
+	dir := t.TempDir()
+	for _, tc := range tests {
+		file := filepath.Join(dir, "cache")
+		assert.NoError(t, os.WriteFile(file, tc.data, 0644))
+
+		fc := NewFetchConfig()
+		fc.MaxBytes = 1 << 16
+		fc.ArchiveMember = tc.member
+		data, _, _, err := fc.FetchFile(file)
+		if tc.err {
+			assert.Error(t, err, tc.name)
+		} else if assert.NoError(t, err, tc.name) {
+			assert.Equal(t, content, data, tc.name)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Address families of the connections of the downloads, see FetchConfig.AddressFamily.
+const (
+	// Addresses in the order of the resolver (usually IPv6 first), the other family is
+	// tried after FallbackDelay
+	FamilyAny        = ""
+	FamilyPreferIPv6 = "prefer-ipv6"
+	FamilyPreferIPv4 = "prefer-ipv4"
+	FamilyIPv6Only   = "ipv6-only"
+	FamilyIPv4Only   = "ipv4-only"
+)
+
+// Delay before connecting with the other address family, as in the net package (RFC 6555).
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// CheckAddressFamily returns an error if a family is not one of the Family constants.
+func CheckAddressFamily(family string) error {
+	switch family {
+	case FamilyAny, FamilyPreferIPv6, FamilyPreferIPv4, FamilyIPv6Only, FamilyIPv4Only:
+		return nil
+	}
+	return fmt.Errorf("unknown address family %q (prefer-ipv6, prefer-ipv4, ipv6-only or ipv4-only)", family)
+}
+
+// dialContext connects to the servers with the AddressFamily. The host is resolved at each
+// connection: as the transport is not kept between the downloads, a daemon follows the
+// changes of the addresses of the servers.
+func (c *FetchConfig) dialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:       30 * time.Second,
+		KeepAlive:     30 * time.Second,
+		FallbackDelay: c.FallbackDelay,
+	}
+	switch c.AddressFamily {
+	case FamilyIPv6Only:
+		return dialer.DialContext(ctx, "tcp6", address)
+	case FamilyIPv4Only:
+		return dialer.DialContext(ctx, "tcp4", address)
+	case FamilyPreferIPv6, FamilyPreferIPv4:
+		// The addresses of the preferred family are tried first, below
+	default:
+		// The net package tries the family of the first address first
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	primaries, fallbacks := partitionAddrs(addrs, c.AddressFamily == FamilyPreferIPv6)
+	delay := c.FallbackDelay
+	if delay <= 0 {
+		delay = defaultFallbackDelay
+	}
+	return dialParallel(ctx, dialer, primaries, fallbacks, port, delay)
+}
+
+// partitionAddrs splits addresses into those of the preferred family and the others,
+// keeping the order of the resolver. When there is none of the preferred family, the
+// others are the primaries.
+func partitionAddrs(addrs []net.IPAddr, preferIPv6 bool) ([]net.IPAddr, []net.IPAddr) {
+	var primaries, fallbacks []net.IPAddr
+	for _, addr := range addrs {
+		if (addr.IP.To4() == nil) == preferIPv6 {
+			primaries = append(primaries, addr)
+		} else {
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+	if len(primaries) == 0 {
+		return fallbacks, nil
+	}
+	return primaries, fallbacks
+}
+
+// dialSerial connects to the addresses in turn, returning the first error if none answers.
+func dialSerial(ctx context.Context, dialer *net.Dialer, addrs []net.IPAddr, port string) (net.Conn, error) {
+	var firstErr error
+	for _, addr := range addrs {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = &net.AddrError{Err: "no suitable address found", Addr: port}
+	}
+	return nil, firstErr
+}
+
+// dialParallel connects to the primary addresses and, if they did not answer after the
+// delay or failed, races the fallbacks (Happy Eyeballs, RFC 8305). The first connection
+// established is returned, the other one is closed.
+func dialParallel(ctx context.Context, dialer *net.Dialer, primaries []net.IPAddr, fallbacks []net.IPAddr, port string, delay time.Duration) (net.Conn, error) {
+	if len(fallbacks) == 0 {
+		return dialSerial(ctx, dialer, primaries, port)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type dialResult struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+	results := make(chan dialResult, 2)
+	start := func(addrs []net.IPAddr, primary bool) {
+		go func() {
+			conn, err := dialSerial(ctx, dialer, addrs, port)
+			results <- dialResult{conn: conn, err: err, primary: primary}
+		}()
+	}
+
+	start(primaries, true)
+	pending := 1
+	fallbackStarted := false
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var primaryErr, fallbackErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				start(fallbacks, false)
+				pending++
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				if pending > 0 {
+					// The other attempt is canceled, it is closed if it connected anyway
+					go func() {
+						if other := <-results; other.conn != nil {
+							other.conn.Close()
+						}
+					}()
+				}
+				return res.conn, nil
+			}
+			if res.primary {
+				primaryErr = res.err
+			} else {
+				fallbackErr = res.err
+			}
+			if !fallbackStarted {
+				fallbackStarted = true
+				start(fallbacks, false)
+				pending++
+			}
+			if pending == 0 {
+				if primaryErr != nil {
+					return nil, primaryErr
+				}
+				return nil, fallbackErr
+			}
+		}
+	}
+}
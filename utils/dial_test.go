@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPartitionAddrs(t *testing.T) {
+	v4a := net.IPAddr{IP: net.ParseIP("192.0.2.1")}
+	v4b := net.IPAddr{IP: net.ParseIP("192.0.2.2")}
+	v6 := net.IPAddr{IP: net.ParseIP("2001:db8::1")}
+	addrs := []net.IPAddr{v6, v4a, v4b}
+
+	primaries, fallbacks := partitionAddrs(addrs, false)
+	if len(primaries) != 2 || !primaries[0].IP.Equal(v4a.IP) || !primaries[1].IP.Equal(v4b.IP) {
+		t.Errorf("IPv4 first: primaries %v", primaries)
+	}
+	if len(fallbacks) != 1 || !fallbacks[0].IP.Equal(v6.IP) {
+		t.Errorf("IPv4 first: fallbacks %v", fallbacks)
+	}
+
+	primaries, fallbacks = partitionAddrs([]net.IPAddr{v4a}, true)
+	if len(primaries) != 1 || len(fallbacks) != 0 {
+		t.Errorf("IPv6 first without IPv6: %v, %v", primaries, fallbacks)
+	}
+}
+
+func TestDialParallel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	dialer := &net.Dialer{Timeout: time.Second}
+	// Nothing listens on 127.0.0.2: the fallback is tried without waiting for the delay
+	refused := []net.IPAddr{{IP: net.ParseIP("127.0.0.2")}}
+	listening := []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}
+
+	start := time.Now()
+	conn, err := dialParallel(context.Background(), dialer, refused, listening, port, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("fallback waited for the delay")
+	}
+
+	if _, err := dialParallel(context.Background(), dialer, refused, refused, port, time.Minute); err == nil {
+		t.Errorf("no error when no address answers")
+	}
+}
+
+func TestFetchFileAddressFamily(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	for _, family := range []string{FamilyPreferIPv6, FamilyPreferIPv4, FamilyIPv4Only} {
+		fc := NewFetchConfig()
+		fc.AddressFamily = family
+		if data, _, _, err := fc.FetchFile(ts.URL); err != nil || string(data) != "{}" {
+			t.Errorf("%q: %q, %v", family, data, err)
+		}
+	}
+	fc := NewFetchConfig()
+	fc.AddressFamily = FamilyIPv6Only
+	if _, _, _, err := fc.FetchFile(ts.URL); err == nil {
+		t.Errorf("IPv4 server fetched with IPv6 only")
+	}
+}
+
+func TestCheckAddressFamily(t *testing.T) {
+	for _, family := range []string{FamilyAny, FamilyPreferIPv6, FamilyIPv4Only} {
+		if err := CheckAddressFamily(family); err != nil {
+			t.Errorf("%q: %v", family, err)
+		}
+	}
+	if err := CheckAddressFamily("ipv5"); err == nil {
+		t.Errorf("no error for an unknown family")
+	}
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// diskCacheEntry is the metadata of a download saved in the CacheDir, next to its body.
+type diskCacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+}
+
+// diskCachePath returns the path of the files of a URL in the CacheDir, without extension.
+func (c *FetchConfig) diskCachePath(file string) string {
+	hash := sha256.Sum256([]byte(file))
+	return filepath.Join(c.CacheDir, hex.EncodeToString(hash[:]))
+}
+
+// writeFileAtomic writes to a temporary file renamed over the file, so that a crash
+// cannot leave a truncated file behind.
+func writeFileAtomic(file string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// saveDiskCache saves the body of a download with the validators of the response. The
+// body is written first: the metadata never refers to a body that is not saved.
+func (c *FetchConfig) saveDiskCache(file string, data []byte, resp *http.Response) error {
+	if err := os.MkdirAll(c.CacheDir, 0755); err != nil {
+		return err
+	}
+	path := c.diskCachePath(file)
+	// The metadata of the previous body is no longer valid
+	os.Remove(path + ".json")
+	if err := writeFileAtomic(path+".body", data); err != nil {
+		return err
+	}
+	mediaType := c.ContentType(file)
+	entry := diskCacheEntry{
+		URL:          file,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  mediaType,
+	}
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path+".json", meta)
+}
+
+// restoreDiskCache loads the validators saved by a previous run the first time a URL is
+// fetched, so that the request is conditional. The saved body is then used if the server
+// answers that it was not modified.
+func (c *FetchConfig) restoreDiskCache(file string) {
+	c.conditionalRequestLock.Lock()
+	defer c.conditionalRequestLock.Unlock()
+	if c.CacheDir == "" || c.diskCacheChecked[file] {
+		return
+	}
+	c.diskCacheChecked[file] = true
+
+	meta, err := os.ReadFile(c.diskCachePath(file) + ".json")
+	if err != nil {
+		return
+	}
+	var entry diskCacheEntry
+	if err := json.Unmarshal(meta, &entry); err != nil || entry.URL != file {
+		return
+	}
+	restored := false
+	if c.EnableEtags && entry.ETag != "" {
+		c.etags[file] = entry.ETag
+		restored = true
+	}
+	if lastModified, err := http.ParseTime(entry.LastModified); c.EnableLastModified && err == nil {
+		c.lastModified[file] = lastModified
+		restored = true
+	}
+	if restored {
+		c.contentTypes[file] = entry.ContentType
+		c.diskCacheRestored[file] = true
+		if c.Log != nil {
+			c.Log.Debugf("Restored the ETag %s and Last-Modified %s of %s", entry.ETag, entry.LastModified, file)
+		}
+	}
+}
+
+// takeDiskCacheRestored returns whether the validators of a URL were restored from the
+// CacheDir and not used since, and marks them as used.
+func (c *FetchConfig) takeDiskCacheRestored(file string) bool {
+	c.conditionalRequestLock.Lock()
+	defer c.conditionalRequestLock.Unlock()
+	restored := c.diskCacheRestored[file]
+	delete(c.diskCacheRestored, file)
+	return restored
+}
+
+// loadDiskCache reads the body saved for a URL.
+func (c *FetchConfig) loadDiskCache(file string) ([]byte, error) {
+	return os.ReadFile(c.diskCachePath(file) + ".body")
+}
+
+// lastModifiedTime is the time of the files saved in the CacheDir, for the logs.
+func lastModifiedTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content codings requested from the servers, in order of preference
+const acceptEncoding = "br, gzip"
+
+// contentEncoding returns the Content-Encoding of a response ("" for none), or an error
+// if it cannot be decoded.
+func contentEncoding(header http.Header) (string, error) {
+	switch encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return "", nil
+	case "gzip", "x-gzip", "br":
+		return encoding, nil
+	default:
+		return "", fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+}
+
+// decodeContent decodes a body received with a Content-Encoding, like the transport does
+// for gzip. It is decoded once received in full, so that the ranges of a resumed download
+// and the Digest apply to the encoded body sent by the server.
+func (c *FetchConfig) decodeContent(data []byte, encoding string, file string) ([]byte, error) {
+	var reader io.Reader
+	switch encoding {
+	case "":
+		return data, nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("gzip Content-Encoding: %v", err)
+		}
+		reader = gz
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := c.copyLimited(buf, reader, file); err != nil {
+		if _, ok := err.(FileTooLarge); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%s Content-Encoding: %v", encoding, err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Logger is used to report the redirects followed.
+type Logger interface {
+	Debugf(string, ...interface{})
+	Warnf(string, ...interface{})
+}
+
+// normalizeHost returns the lowercase ASCII (punycode) form of an internationalized host name.
+func normalizeHost(host string) string {
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		ascii = host
+	}
+	return strings.ToLower(strings.TrimSuffix(ascii, "."))
+}
+
+// allowedHost returns whether a host is in the allowlist. Entries starting with
+// a dot also match any subdomain.
+func allowedHost(host string, allowlist []string) bool {
+	host = normalizeHost(host)
+	for _, allowed := range allowlist {
+		if strings.HasPrefix(allowed, ".") {
+			suffix := "." + normalizeHost(strings.TrimPrefix(allowed, "."))
+			if host == suffix[1:] || strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == normalizeHost(allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRedirect enforces MaxRedirects and AllowedHosts on the redirects followed by the HTTP client.
+func (c *FetchConfig) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > c.MaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", c.MaxRedirects)
+	}
+	if c.Log != nil {
+		c.Log.Debugf("Redirected from %s to %s", via[len(via)-1].URL.Redacted(), req.URL.Redacted())
+	}
+	if len(c.AllowedHosts) > 0 && !allowedHost(req.URL.Hostname(), c.AllowedHosts) {
+		return fmt.Errorf("redirect to %s: host %s is not allowed", req.URL.Redacted(), req.URL.Hostname())
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowedHost(t *testing.T) {
+	allowlist := []string{"Bücher.example", ".example.net"}
+	tests := []struct {
+		host    string
+		allowed bool
+	}{
+		{"xn--bcher-kva.example", true},
+		{"bücher.example.", true},
+		{"example.net", true},
+		{"rpki.example.net", true},
+		{"badexample.net", false},
+		{"example.com", false},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.allowed, allowedHost(tc.host, allowlist), tc.host)
+	}
+}
+
+func TestFetchFileRedirects(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// /redirect/<n> redirects n times, through localhost
+		if strings.HasPrefix(r.URL.Path, "/redirect/") {
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/redirect/"))
+			target := "/vrps.json"
+			if n > 1 {
+				target = fmt.Sprintf("/redirect/%d", n-1)
+			}
+			http.Redirect(w, r, strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)+target, http.StatusFound)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path         string
+		maxRedirects int
+		allowedHosts []string
+		err          bool
+	}{
+		{path: "/redirect/1", maxRedirects: 10},
+		{path: "/redirect/3", maxRedirects: 2, err: true},
+		{path: "/redirect/1", maxRedirects: 0, err: true},
+		{path: "/vrps.json", maxRedirects: 0, allowedHosts: []string{"example.com"}},
+		{path: "/redirect/1", maxRedirects: 10, allowedHosts: []string{"127.0.0.1"}, err: true},
+		{path: "/redirect/2", maxRedirects: 10, allowedHosts: []string{"localhost"}},
+	}
+	for _, tc := range tests {
+		fc := NewFetchConfig()
+		fc.MaxRedirects = tc.maxRedirects
+		fc.AllowedHosts = tc.allowedHosts
+		_, _, _, err := fc.FetchFile(ts.URL + tc.path)
+		if tc.err {
+			assert.Error(t, err, tc.path)
+		} else {
+			assert.NoError(t, err, tc.path)
+		}
+	}
+}
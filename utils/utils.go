@@ -1,13 +1,24 @@
 package utils
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
-	"net"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/net/http/httpproxy"
 )
 
 type FetchConfig struct {
@@ -16,17 +27,73 @@ type FetchConfig struct {
 
 	etags                  map[string]string
 	lastModified           map[string]time.Time
+	contentTypes           map[string]string
+	diskCacheChecked       map[string]bool
+	diskCacheRestored      map[string]bool
 	conditionalRequestLock *sync.RWMutex
 	EnableEtags            bool
 	EnableLastModified     bool
+
+	// Number of times an interrupted download is resumed using a Range request (0 to disable)
+	RangeResume int
+	// Maximum size of a fetched file in bytes (0 to disable)
+	MaxBytes int64
+	// Do not request gzip or br encoded responses
+	DisableCompression bool
+	// Member to extract from a tar.gz or zip archive (if empty: the only .json member)
+	ArchiveMember string
+	// Directory where the last download of each URL is saved with its ETag and Last-Modified
+	// (if empty: disabled). After a restart, the first request is conditional and the saved
+	// body is returned if the server answers that it was not modified.
+	CacheDir string
+
+	// Maximum number of redirects followed (0 to refuse redirects)
+	MaxRedirects int
+	// Hosts redirects may lead to (if empty: any host), ".example.com" also allows the subdomains
+	AllowedHosts []string
+
+	// PEM file of the CA certificates verifying the servers (if empty: the system roots)
+	CAFile string
+	// Certificate and key presented to the servers which request one (PEM files)
+	CertFile string
+	KeyFile  string
+
+	// Address family of the connections to the servers, one of the Family constants
+	AddressFamily string
+	// Delay before connecting with the other address family when the first addresses do
+	// not answer (0 for 300ms)
+	FallbackDelay time.Duration
+
+	// Proxy the servers are reached through, e.g. http://proxy.example.com:3128 (if empty:
+	// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables)
+	Proxy string
+	// Comma-separated hosts and domains not reached through the Proxy, as NO_PROXY
+	NoProxy string
+
+	// Credentials of the servers: Basic authentication when Username is set, else a
+	// Bearer token when BearerToken is set. They are not sent after a redirect to
+	// another domain.
+	Username    string
+	Password    string
+	BearerToken string
+
+	// Called with the size of each file read or downloaded, before extracting an archive.
+	// Responses compressed with a Content-Encoding are counted after decompression.
+	Fetched func(file string, size int)
+
+	Log Logger
 }
 
 func NewFetchConfig() *FetchConfig {
 	return &FetchConfig{
 		etags:                  make(map[string]string),
 		lastModified:           make(map[string]time.Time),
+		contentTypes:           make(map[string]string),
+		diskCacheChecked:       make(map[string]bool),
+		diskCacheRestored:      make(map[string]bool),
 		conditionalRequestLock: &sync.RWMutex{},
 		Mime:                   "application/json",
+		MaxRedirects:           10,
 	}
 }
 
@@ -47,30 +114,144 @@ func (e IdenticalEtag) Error() string {
 	return fmt.Sprintf("File %s is identical according to Etag: %s", e.File, e.Etag)
 }
 
+type FileTooLarge struct {
+	File     string
+	MaxBytes int64
+}
+
+func (e FileTooLarge) Error() string {
+	return fmt.Sprintf("File %s is larger than %d bytes", e.File, e.MaxBytes)
+}
+
+// copyLimited appends src to dst, failing with FileTooLarge when dst would exceed MaxBytes.
+func (c *FetchConfig) copyLimited(dst *bytes.Buffer, src io.Reader, file string) error {
+	if c.MaxBytes <= 0 {
+		_, err := io.Copy(dst, src)
+		return err
+	}
+	_, err := io.Copy(dst, io.LimitReader(src, c.MaxBytes-int64(dst.Len())+1))
+	if err == nil && int64(dst.Len()) > c.MaxBytes {
+		return FileTooLarge{
+			File:     file,
+			MaxBytes: c.MaxBytes,
+		}
+	}
+	return err
+}
+
+// FetchFile reads a local file or downloads it. When the file is a tar.gz or zip
+// archive, the JSON member is extracted (see extractArchive).
 func (c *FetchConfig) FetchFile(file string) ([]byte, int, bool, error) {
-	var f io.Reader
-	var err error
+	data, code, lastrefresh, err := c.fetchFile(file)
+	if err != nil {
+		return data, code, lastrefresh, err
+	}
+	if c.Fetched != nil {
+		c.Fetched(file, len(data))
+	}
+	data, err = c.extractArchive(file, data)
+	if err != nil {
+		// Do not let a conditional request skip the next download
+		c.Forget(file)
+		return nil, code, lastrefresh, err
+	}
+	return data, code, lastrefresh, nil
+}
+
+// TLSConfig returns the TLS configuration of the CAFile, CertFile and KeyFile, or nil
+// if none is set. The files are read at each download so that renewed certificates
+// are used without a restart.
+func (c *FetchConfig) TLSConfig() (*tls.Config, error) {
+	if c.CAFile == "" && c.CertFile == "" && c.KeyFile == "" {
+		return nil, nil
+	}
+	config := &tls.Config{}
+	if c.CAFile != "" {
+		data, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %v", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("a client certificate requires both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// ProxyURL returns the proxy a request is sent through, or nil if it is sent directly.
+// Without a Proxy, it is read from the environment. As with NO_PROXY, the requests to
+// localhost are never proxied.
+func (c *FetchConfig) ProxyURL(req *http.Request) (*url.URL, error) {
+	if c.Proxy == "" {
+		return http.ProxyFromEnvironment(req)
+	}
+	config := &httpproxy.Config{
+		HTTPProxy:  c.Proxy,
+		HTTPSProxy: c.Proxy,
+		NoProxy:    c.NoProxy,
+	}
+	return config.ProxyFunc()(req.URL)
+}
+
+// ContentType returns the media type of the last file downloaded from a URL, without
+// its parameters, or an empty string if unknown.
+func (c *FetchConfig) ContentType(file string) string {
+	c.conditionalRequestLock.RLock()
+	defer c.conditionalRequestLock.RUnlock()
+	return c.contentTypes[file]
+}
+
+// Forget drops the ETag and Last-Modified of a file, so that it is downloaded in full
+// the next time instead of being reported as not modified.
+func (c *FetchConfig) Forget(file string) {
+	c.conditionalRequestLock.Lock()
+	delete(c.etags, file)
+	delete(c.lastModified, file)
+	delete(c.diskCacheRestored, file)
+	c.conditionalRequestLock.Unlock()
+}
+
+func (c *FetchConfig) fetchFile(file string) ([]byte, int, bool, error) {
 	if len(file) > 8 && (file[0:7] == "http://" || file[0:8] == "https://") {
 
 		// Copying base of DefaultTransport from https://golang.org/src/net/http/transport.go
 		// There is a proposal for a Clone of
 		tr := &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-				DualStack: true,
-			}).DialContext,
+			// Chosen for each request, including the redirects
+			Proxy:                 c.ProxyURL,
+			DialContext:           c.dialContext,
 			MaxIdleConns:          100,
 			IdleConnTimeout:       90 * time.Second,
 			TLSHandshakeTimeout:   10 * time.Second,
 			ExpectContinueTimeout: 1 * time.Second,
 			ProxyConnectHeader:    map[string][]string{},
+			// The encodings are requested and decoded by decodeContent
+			DisableCompression: true,
 		}
 		// Keep User-Agent in proxy request
 		tr.ProxyConnectHeader.Set("User-Agent", c.UserAgent)
+		tlsConfig, err := c.TLSConfig()
+		if err != nil {
+			return nil, -1, false, err
+		}
+		tr.TLSClientConfig = tlsConfig
 
-		client := &http.Client{Transport: tr}
+		client := &http.Client{
+			Transport:     tr,
+			CheckRedirect: c.checkRedirect,
+		}
 		req, err := http.NewRequest("GET", file, nil)
 		if err != nil {
 			return nil, -1, false, err
@@ -80,7 +261,16 @@ func (c *FetchConfig) FetchFile(file string) ([]byte, int, bool, error) {
 		if c.Mime != "" {
 			req.Header.Set("Accept", c.Mime)
 		}
+		if !c.DisableCompression {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		if c.Username != "" {
+			req.SetBasicAuth(c.Username, c.Password)
+		} else if c.BearerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+		}
 
+		c.restoreDiskCache(file)
 		c.conditionalRequestLock.RLock()
 		if c.EnableEtags {
 			etag, ok := c.etags[file]
@@ -96,17 +286,6 @@ func (c *FetchConfig) FetchFile(file string) ([]byte, int, bool, error) {
 		}
 		c.conditionalRequestLock.RUnlock()
 
-		proxyurl, err := http.ProxyFromEnvironment(req)
-		if err != nil {
-			return nil, -1, false, err
-		}
-		proxyreq := http.ProxyURL(proxyurl)
-		tr.Proxy = proxyreq
-
-		if err != nil {
-			return nil, -1, false, err
-		}
-
 		fhttp, err := client.Do(req)
 		if err != nil {
 			return nil, -1, false, err
@@ -117,8 +296,23 @@ func (c *FetchConfig) FetchFile(file string) ([]byte, int, bool, error) {
 		defer client.CloseIdleConnections()
 		//RefreshStatusCode.WithLabelValues(file, fmt.Sprintf("%d", fhttp.StatusCode)).Inc()
 
+		// The validators restored from the CacheDir are only used once: the file has not
+		// been returned since the start
+		restored := c.takeDiskCacheRestored(file)
 		if fhttp.StatusCode == 304 {
 			//LastRefresh.WithLabelValues(file).Set(float64(s.lastts.UnixNano() / 1e9))
+			if restored {
+				data, err := c.loadDiskCache(file)
+				if err == nil {
+					if c.Log != nil {
+						c.Log.Debugf("%s not modified, using the copy saved on %v", file, lastModifiedTime(c.diskCachePath(file)+".body"))
+					}
+					return data, fhttp.StatusCode, true, nil
+				}
+				// Downloaded in full the next time
+				c.Forget(file)
+				return nil, fhttp.StatusCode, true, fmt.Errorf("HTTP 304 Not modified for %s but the saved copy cannot be read: %v", file, err)
+			}
 			return nil, fhttp.StatusCode, true, HttpNotModified{
 				File: file,
 			}
@@ -131,11 +325,14 @@ func (c *FetchConfig) FetchFile(file string) ([]byte, int, bool, error) {
 		}
 		//LastRefresh.WithLabelValues(file).Set(float64(s.lastts.UnixNano() / 1e9))
 
-		f = fhttp.Body
+		mediaType, _, _ := mime.ParseMediaType(fhttp.Header.Get("Content-Type"))
+		c.conditionalRequestLock.Lock()
+		c.contentTypes[file] = mediaType
+		c.conditionalRequestLock.Unlock()
 
 		newEtag := fhttp.Header.Get("ETag")
 
-		if !c.EnableEtags || newEtag == "" || newEtag != c.etags[file] { // check lock here
+		if !c.EnableEtags || newEtag == "" || newEtag != c.etags[file] || restored { // check lock here
 			c.conditionalRequestLock.Lock()
 			c.etags[file] = newEtag
 			c.conditionalRequestLock.Unlock()
@@ -158,15 +355,156 @@ func (c *FetchConfig) FetchFile(file string) ([]byte, int, bool, error) {
 			}
 			c.conditionalRequestLock.Unlock()
 		}
-	} else {
-		f, err = os.Open(file)
+
+		var data []byte
+		if c.MaxBytes > 0 && fhttp.ContentLength > c.MaxBytes {
+			err = FileTooLarge{
+				File:     file,
+				MaxBytes: c.MaxBytes,
+			}
+		} else {
+			data, err = c.readBody(client, req, fhttp)
+		}
 		if err != nil {
+			// Do not let a conditional request skip the next download
+			c.conditionalRequestLock.Lock()
+			delete(c.etags, file)
+			delete(c.lastModified, file)
+			c.conditionalRequestLock.Unlock()
 			return nil, -1, false, err
 		}
+		if c.CacheDir != "" {
+			if err := c.saveDiskCache(file, data, fhttp); err != nil && c.Log != nil {
+				c.Log.Warnf("Could not save %s to %s: %v", file, c.CacheDir, err)
+			}
+		}
+		return data, -1, false, nil
 	}
-	data, err := io.ReadAll(f)
+
+	f, err := os.Open(file)
 	if err != nil {
 		return nil, -1, false, err
 	}
-	return data, -1, false, nil
+	defer f.Close()
+	buf := bytes.NewBuffer(nil)
+	err = c.copyLimited(buf, f, file)
+	if err != nil {
+		return nil, -1, false, err
+	}
+	return buf.Bytes(), -1, false, nil
+}
+
+// readBody reads the body of a successful response. If the transfer is interrupted and
+// RangeResume is set, the remainder is requested with a Range request. The server may
+// answer with the full file instead, which replaces what was already received. The body
+// is decoded once complete and its Digest verified.
+func (c *FetchConfig) readBody(client *http.Client, req *http.Request, resp *http.Response) ([]byte, error) {
+	file := req.URL.String()
+	encoding, err := contentEncoding(resp.Header)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(nil)
+	err = c.copyLimited(buf, resp.Body, file)
+
+	total := resp.ContentLength
+	digest := resp.Header.Get("Digest")
+	// The ranges apply to the encoded body, requested with the same Accept-Encoding
+	rangeable := true
+	// If-Range requires a strong validator
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+
+	for attempt := 0; err != nil && attempt < c.RangeResume; attempt++ {
+		if _, ok := err.(FileTooLarge); ok {
+			break
+		}
+		rreq := req.Clone(req.Context())
+		rreq.Header.Del("If-None-Match")
+		rreq.Header.Del("If-Modified-Since")
+		if rangeable && validator != "" && buf.Len() > 0 {
+			rreq.Header.Set("Range", fmt.Sprintf("bytes=%d-", buf.Len()))
+			rreq.Header.Set("If-Range", validator)
+		}
+
+		rresp, rerr := client.Do(rreq)
+		if rerr != nil {
+			err = rerr
+			continue
+		}
+		rencoding, rerr := contentEncoding(rresp.Header)
+		switch {
+		case rerr != nil:
+			err = rerr
+		case rresp.StatusCode == http.StatusPartialContent:
+			start, size, perr := parseContentRange(rresp.Header.Get("Content-Range"))
+			if rencoding != encoding {
+				err = fmt.Errorf("unexpected Content-Encoding %q when resuming", rresp.Header.Get("Content-Encoding"))
+				rangeable = false
+			} else if perr != nil || start != int64(buf.Len()) || (total >= 0 && size >= 0 && size != total) {
+				err = fmt.Errorf("unexpected Content-Range %q when resuming at %d", rresp.Header.Get("Content-Range"), buf.Len())
+				rangeable = false
+			} else {
+				total = size
+				err = c.copyLimited(buf, rresp.Body, file)
+			}
+		case rresp.StatusCode == http.StatusOK:
+			// Ranges not supported or the file changed: start over
+			buf.Reset()
+			total = rresp.ContentLength
+			digest = rresp.Header.Get("Digest")
+			encoding = rencoding
+			rangeable = true
+			err = c.copyLimited(buf, rresp.Body, file)
+		default:
+			err = fmt.Errorf("HTTP %s when resuming", rresp.Status)
+		}
+		rresp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if total >= 0 && int64(buf.Len()) != total {
+		return nil, fmt.Errorf("received %d bytes, expected %d", buf.Len(), total)
+	}
+	// The Digest is computed on the encoded body
+	if err := checkDigest(buf.Bytes(), digest); err != nil {
+		return nil, err
+	}
+	return c.decodeContent(buf.Bytes(), encoding, file)
+}
+
+// parseContentRange returns the first byte position and the complete length
+// (-1 if unknown) of a "bytes first-last/length" Content-Range.
+func parseContentRange(contentRange string) (int64, int64, error) {
+	var first, last int64
+	var length string
+	_, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &first, &last, &length)
+	if err != nil {
+		return 0, 0, err
+	}
+	if length == "*" {
+		return first, -1, nil
+	}
+	size, err := strconv.ParseInt(length, 10, 64)
+	return first, size, err
+}
+
+// checkDigest verifies the SHA-256 value of a Digest header (RFC 3230) when there is one,
+// over the body as sent (with its Content-Encoding).
+func checkDigest(data []byte, digest string) error {
+	for _, value := range strings.Split(digest, ",") {
+		kv := strings.SplitN(strings.TrimSpace(value), "=", 2)
+		if len(kv) != 2 || strings.ToLower(kv[0]) != "sha-256" {
+			continue
+		}
+		hash := sha256.Sum256(data)
+		if base64.StdEncoding.EncodeToString(hash[:]) != kv[1] {
+			return fmt.Errorf("SHA-256 digest mismatch: expected %s", kv[1])
+		}
+	}
+	return nil
 }
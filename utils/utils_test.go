@@ -0,0 +1,498 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// interruptedServer aborts the first response halfway and then serves the content normally.
+func interruptedServer(content []byte, ranges bool) (*httptest.Server, *[]string) {
+	modtime := time.Date(2021, 7, 27, 18, 56, 2, 0, time.UTC)
+	hash := sha256.Sum256(content)
+	requestedRanges := make([]string, 0)
+	first := true
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(hash[:]))
+		if first {
+			first = false
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		requestedRanges = append(requestedRanges, r.Header.Get("Range"))
+		if !ranges {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "vrps.json", modtime, bytes.NewReader(content))
+	})), &requestedRanges
+}
+
+func TestFetchFileRangeResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+
+	for _, ranges := range []bool{true, false} {
+		ts, requestedRanges := interruptedServer(content, ranges)
+
+		fc := NewFetchConfig()
+		fc.RangeResume = 1
+		data, _, _, err := fc.FetchFile(ts.URL)
+		ts.Close()
+		if err != nil {
+			t.Fatalf("Ranges supported %v: unexpected error %v", ranges, err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Errorf("Ranges supported %v: got %d bytes, wanted %d", ranges, len(data), len(content))
+		}
+		if len(*requestedRanges) != 1 || (*requestedRanges)[0] != "bytes=5000-" {
+			t.Errorf("Ranges supported %v: unexpected ranges requested %v", ranges, *requestedRanges)
+		}
+	}
+}
+
+func TestFetchFileRangeResumeEncoded(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	gzipped := bytes.NewBuffer(nil)
+	gz := gzip.NewWriter(gzipped)
+	gz.Write(content)
+	gz.Close()
+	encoded := gzipped.Bytes()
+	// The Digest is computed on the encoded body
+	hash := sha256.Sum256(encoded)
+
+	modtime := time.Date(2021, 7, 27, 18, 56, 2, 0, time.UTC)
+	var requestedRanges []string
+	first := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1-gzip"`)
+		w.Header().Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(hash[:]))
+		w.Header().Set("Content-Encoding", "gzip")
+		if first {
+			first = false
+			w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+			w.Write(encoded[:len(encoded)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		requestedRanges = append(requestedRanges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "vrps.json", modtime, bytes.NewReader(encoded))
+	}))
+	defer ts.Close()
+
+	fc := NewFetchConfig()
+	fc.RangeResume = 1
+	data, _, _, err := fc.FetchFile(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("got %d bytes, wanted %d", len(data), len(content))
+	}
+	if want := fmt.Sprintf("bytes=%d-", len(encoded)/2); len(requestedRanges) != 1 || requestedRanges[0] != want {
+		t.Errorf("unexpected ranges requested %v, wanted %v", requestedRanges, want)
+	}
+}
+
+func TestFetchFileNoRangeResume(t *testing.T) {
+	ts, _ := interruptedServer([]byte("0123456789"), true)
+	defer ts.Close()
+
+	fc := NewFetchConfig()
+	_, _, _, err := fc.FetchFile(ts.URL)
+	if err == nil {
+		t.Errorf("Expected an error for an interrupted download")
+	}
+	if _, ok := fc.etags[ts.URL]; ok {
+		t.Errorf("The Etag of an interrupted download should not be kept")
+	}
+}
+
+func TestCheckDigest(t *testing.T) {
+	hash := sha256.Sum256([]byte("data"))
+	digest := "SHA-256=" + base64.StdEncoding.EncodeToString(hash[:])
+	if err := checkDigest([]byte("data"), "md5=abc, "+digest); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := checkDigest([]byte("other"), digest); err == nil {
+		t.Errorf("Expected a digest mismatch")
+	}
+	if err := checkDigest([]byte("other"), ""); err != nil {
+		t.Errorf("Unexpected error without digest: %v", err)
+	}
+}
+
+func TestFetchFileMaxBytes(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			// No Content-Length
+			w.(http.Flusher).Flush()
+		}
+		w.Write(content)
+	}))
+	defer ts.Close()
+
+	file := filepath.Join(t.TempDir(), "vrps.json")
+	if err := os.WriteFile(file, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{ts.URL + "/", ts.URL + "/chunked", file} {
+		fc := NewFetchConfig()
+		fc.MaxBytes = int64(len(content))
+		data, _, _, err := fc.FetchFile(path)
+		if err != nil || !bytes.Equal(data, content) {
+			t.Errorf("%v: unexpected error %v (%d bytes)", path, err, len(data))
+		}
+
+		fc.MaxBytes = int64(len(content)) - 1
+		_, _, _, err = fc.FetchFile(path)
+		if _, ok := err.(FileTooLarge); !ok {
+			t.Errorf("%v: wanted FileTooLarge, got %v", path, err)
+		}
+	}
+}
+
+func TestFetchFileFetched(t *testing.T) {
+	content := []byte(`{"roas": []}`)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(content)
+	}))
+	defer ts.Close()
+
+	fetched := make(map[string]int)
+	fc := NewFetchConfig()
+	fc.EnableEtags = true
+	fc.Fetched = func(file string, size int) {
+		fetched[file] += size
+	}
+
+	if _, _, _, err := fc.FetchFile(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := fc.FetchFile(ts.URL); err == nil {
+		t.Error("wanted HttpNotModified")
+	}
+	if fetched[ts.URL] != len(content) {
+		t.Errorf("counted %d bytes, wanted %d", fetched[ts.URL], len(content))
+	}
+}
+
+func TestFetchFileContentEncoding(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	encodings := make([]string, 0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Accept-Encoding"))
+		encoding := r.URL.Query().Get("encoding")
+		if r.Header.Get("Accept-Encoding") == "" {
+			encoding = ""
+		}
+		buf := bytes.NewBuffer(nil)
+		switch encoding {
+		case "gzip":
+			gz := gzip.NewWriter(buf)
+			gz.Write(content)
+			gz.Close()
+		case "br":
+			br := brotli.NewWriter(buf)
+			br.Write(content)
+			br.Close()
+		default:
+			buf.Write(content)
+		}
+		if encoding != "" {
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Write(buf.Bytes())
+	}))
+	defer ts.Close()
+
+	for _, encoding := range []string{"gzip", "br", ""} {
+		fetched := 0
+		fc := NewFetchConfig()
+		fc.Fetched = func(file string, size int) {
+			fetched += size
+		}
+		data, _, _, err := fc.FetchFile(ts.URL + "/?encoding=" + encoding)
+		if err != nil || !bytes.Equal(data, content) {
+			t.Errorf("%q: unexpected error %v (%d bytes)", encoding, err, len(data))
+		}
+		if fetched != len(content) {
+			t.Errorf("%q: counted %d bytes, wanted %d", encoding, fetched, len(content))
+		}
+
+		// The limit applies to the decoded content
+		fc.MaxBytes = int64(len(content)) - 1
+		_, _, _, err = fc.FetchFile(ts.URL + "/?encoding=" + encoding)
+		if _, ok := err.(FileTooLarge); !ok {
+			t.Errorf("%q: wanted FileTooLarge, got %v", encoding, err)
+		}
+	}
+	if encodings[0] != acceptEncoding {
+		t.Errorf("requested %q, wanted %q", encodings[0], acceptEncoding)
+	}
+
+	fc := NewFetchConfig()
+	fc.DisableCompression = true
+	encodings = encodings[:0]
+	data, _, _, err := fc.FetchFile(ts.URL + "/?encoding=br")
+	if err != nil || !bytes.Equal(data, content) || encodings[0] != "" {
+		t.Errorf("without compression: unexpected error %v (%d bytes, Accept-Encoding %q)", err, len(data), encodings[0])
+	}
+
+	if _, _, _, err := NewFetchConfig().FetchFile(ts.URL + "/?encoding=compress"); err == nil {
+		t.Error("wanted an error for an unsupported Content-Encoding")
+	}
+}
+
+// writeClientCertificate writes a self-signed client certificate and its key to dir.
+func writeClientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return cert, certFile, keyFile
+}
+
+func TestFetchFileTLS(t *testing.T) {
+	dir := t.TempDir()
+	client, certFile, keyFile := writeClientCertificate(t, dir)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	ts.TLS.ClientCAs.AddCert(client)
+	ts.StartTLS()
+	defer ts.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600)
+
+	fc := NewFetchConfig()
+	fc.CAFile = caFile
+	fc.CertFile = certFile
+	fc.KeyFile = keyFile
+	data, _, _, err := fc.FetchFile(ts.URL)
+	if err != nil || string(data) != "client" {
+		t.Errorf("unexpected error %v (%q)", err, data)
+	}
+
+	// Without a client certificate
+	fc.CertFile, fc.KeyFile = "", ""
+	if _, _, _, err := fc.FetchFile(ts.URL); err == nil {
+		t.Error("wanted an error without a client certificate")
+	}
+	// Without the CA of the server
+	fc.CAFile, fc.CertFile, fc.KeyFile = "", certFile, keyFile
+	if _, _, _, err := fc.FetchFile(ts.URL); err == nil {
+		t.Error("wanted an error without the CA of the server")
+	}
+
+	fc.CAFile, fc.KeyFile = caFile, ""
+	if _, err := fc.TLSConfig(); err == nil {
+		t.Error("wanted an error for a certificate without a key")
+	}
+	fc.CAFile = keyFile
+	if _, err := fc.TLSConfig(); err == nil {
+		t.Error("wanted an error for a CA file without certificates")
+	}
+}
+
+func TestFetchFileAuthentication(t *testing.T) {
+	var authorization string
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			// To another host
+			http.Redirect(w, r, strings.Replace(ts.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+			return
+		}
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	fc := NewFetchConfig()
+	fc.FetchFile(ts.URL)
+	if authorization != "" {
+		t.Errorf("sent %q without credentials", authorization)
+	}
+
+	fc.Username, fc.Password = "user", "secret"
+	fc.FetchFile(ts.URL)
+	if authorization != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("sent %q, wanted Basic authentication", authorization)
+	}
+
+	fc.Username, fc.BearerToken = "", "token"
+	fc.FetchFile(ts.URL)
+	if authorization != "Bearer token" {
+		t.Errorf("sent %q, wanted a Bearer token", authorization)
+	}
+
+	if _, _, _, err := fc.FetchFile(ts.URL + "/redirect"); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" {
+		t.Errorf("sent %q after a redirect to another host", authorization)
+	}
+}
+
+func TestFetchFileContentType(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Write([]byte("ASN,IP Prefix,Max Length\n"))
+	}))
+	defer ts.Close()
+
+	fc := NewFetchConfig()
+	if _, _, _, err := fc.FetchFile(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	if contentType := fc.ContentType(ts.URL); contentType != "text/csv" {
+		t.Errorf("got %q, wanted text/csv", contentType)
+	}
+	if contentType := fc.ContentType("vrps.json"); contentType != "" {
+		t.Errorf("got %q for an unknown file", contentType)
+	}
+}
+
+func TestFetchFileCacheDir(t *testing.T) {
+	content := []byte(`{"roas":[]}`)
+	requests := 0
+	conditional := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(content)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	newConfig := func() *FetchConfig {
+		fc := NewFetchConfig()
+		fc.EnableEtags = true
+		fc.CacheDir = dir
+		return fc
+	}
+
+	fc := newConfig()
+	if _, _, _, err := fc.FetchFile(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := fc.FetchFile(ts.URL); err == nil {
+		t.Error("expected the file not to be modified")
+	}
+
+	// After a restart, the first request is conditional and returns the saved copy
+	fc = newConfig()
+	data, code, _, err := fc.FetchFile(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusNotModified || !bytes.Equal(data, content) {
+		t.Errorf("got %d %q, wanted 304 %q", code, data, content)
+	}
+	if _, _, _, err := fc.FetchFile(ts.URL); err == nil {
+		t.Error("expected the file not to be modified")
+	}
+	if requests != 4 || conditional != 3 {
+		t.Errorf("got %d requests, %d conditional, wanted 4 and 3", requests, conditional)
+	}
+
+	// The saved copy is gone: the file is downloaded in full the next time
+	files, _ := filepath.Glob(filepath.Join(dir, "*.body"))
+	for _, file := range files {
+		os.Remove(file)
+	}
+	fc = newConfig()
+	if _, _, _, err := fc.FetchFile(ts.URL); err == nil {
+		t.Error("expected an error without the saved copy")
+	}
+	data, _, _, err = fc.FetchFile(ts.URL)
+	if err != nil || !bytes.Equal(data, content) {
+		t.Errorf("got %q, %v, wanted %q", data, err, content)
+	}
+}
+
+func TestFetchFileProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.Write([]byte("{}"))
+	}))
+	defer proxy.Close()
+
+	fc := NewFetchConfig()
+	fc.Proxy = proxy.URL
+	data, _, _, err := fc.FetchFile("http://rpki.example/vrps.json")
+	if err != nil || string(data) != "{}" {
+		t.Fatalf("%q, %v", data, err)
+	}
+	if len(proxied) != 1 || proxied[0] != "http://rpki.example/vrps.json" {
+		t.Errorf("proxied requests: %v", proxied)
+	}
+
+	// Not resolved: fails without the proxy
+	fc.NoProxy = ".example"
+	if _, _, _, err := fc.FetchFile("http://rpki.example/vrps.json"); err == nil {
+		t.Errorf("host in NoProxy fetched through the proxy")
+	}
+	if len(proxied) != 1 {
+		t.Errorf("proxied requests: %v", proxied)
+	}
+}